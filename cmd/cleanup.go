@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/spf13/cobra"
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Remove temporary containers, volumes and services left behind by docker-sync",
+	Long:  "List the containers, volumes and services labeled by docker-sync and remove the stale ones: those created by docker-sync processes on this machine that are gone and, with --older-than, those created longer ago than that",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			fatal(err)
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			fatal(err)
+		}
+		olderThan, err := cmd.Flags().GetDuration("older-than")
+		if err != nil {
+			fatal(err)
+		}
+
+		options, err := hostOptions(cmd, cfg)
+		if err != nil {
+			fatal(err)
+		}
+
+		dockerSyncer, err := connectHost(cmd.Context(), options)
+		if err != nil {
+			fatal(err)
+		}
+
+		resources, err := dockerSyncer.FindResources(cmd.Context(), olderThan)
+		if err != nil {
+			fatal(err)
+		}
+
+		removed, failed := 0, false
+		for _, resource := range resources {
+			status := "kept"
+			if resource.Stale {
+				status = "stale"
+				if !dryRun {
+					err := dockerSyncer.RemoveResource(cmd.Context(), resource)
+					if err != nil {
+						log.Error("{error}", "error", err)
+						failed = true
+						continue
+					}
+					status = "removed"
+					removed++
+				}
+			}
+			fmt.Printf("%-8s %-10s %s (%s)\n", status, resource.Kind, resource.Name, describeResource(resource))
+		}
+
+		if dryRun {
+			log.Info("Found {count} resources of docker-sync, run without --dry-run to remove the stale ones", "count", len(resources))
+		} else {
+			log.Info("Removed {removed} of {count} resources of docker-sync", "removed", removed, "count", len(resources))
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// describeResource tells how old the resource is and who created it
+func describeResource(resource syncer.Resource) string {
+	var details []string
+	if !resource.Created.IsZero() {
+		details = append(details, "created "+time.Since(resource.Created).Round(time.Second).String()+" ago")
+	}
+	if resource.Owner != "" {
+		details = append(details, "by "+resource.Owner)
+	}
+	if len(details) == 0 {
+		return "unknown origin"
+	}
+	return strings.Join(details, " ")
+}
+
+// connectHost connects to the Docker host of the options without looking up a target
+func connectHost(ctx context.Context, options syncer.Options) (*syncer.Syncer, error) {
+	options.Logger = log
+	options.Identifier = "docker-sync"
+
+	dockerSyncer, err := syncer.New(options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dockerSyncer.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dockerSyncer, nil
+}
+
+// removeStaleResources removes the resources left behind by docker-sync processes on this machine
+// that were killed before cleaning up, except the ones to keep by their names or IDs.
+// Failing to do so doesn't stop syncing
+func removeStaleResources(ctx context.Context, syncs []dockersync.Options, keep []string) {
+	for _, options := range syncs {
+		if !slices.ContainsFunc(options.DestinationList(), isDockerDestination) {
+			continue
+		}
+
+		// Syncs share the connection settings, so checking the host of one of them is enough
+		dockerSyncer, err := connectHost(ctx, syncer.Options{
+			Engine:     options.Engine,
+			Host:       options.Host,
+			TLS:        options.TLS,
+			SSHFlags:   options.SSHFlags,
+			SSHBastion: options.SSHBastion,
+			Insecure:   options.Insecure,
+			Trace:      options.Trace,
+		})
+		if err != nil {
+			log.Debug("Failed to look for stale resources: {error}", "error", err)
+			return
+		}
+
+		resources, err := dockerSyncer.FindResources(ctx, 0)
+		if err != nil {
+			log.Debug("Failed to look for stale resources: {error}", "error", err)
+			return
+		}
+		for _, resource := range resources {
+			if !resource.Stale || slices.Contains(keep, resource.ID) || slices.Contains(keep, resource.Name) {
+				continue
+			}
+			err := dockerSyncer.RemoveResource(ctx, resource)
+			if err != nil {
+				log.Warn("Failed to remove {kind} {name} left behind by a previous run: {error}", "kind", resource.Kind, "name", resource.Name, "error", err)
+				continue
+			}
+			log.Info("Removed {kind} {name} left behind by a previous run", "kind", resource.Kind, "name", resource.Name)
+		}
+		return
+	}
+}
+
+func init() {
+	cleanupCmd.Flags().Bool("dry-run", false, "List the resources without removing any")
+	cleanupCmd.Flags().Duration("older-than", 0, "Also remove resources created longer ago than this, e.g. 24h, whichever process created them")
+	rootCmd.AddCommand(cleanupCmd)
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/spf13/cobra"
+)
+
+// completionTimeout is how long completing a target may take to query the Docker host
+const completionTimeout = 3 * time.Second
+
+// completeSyncArgs completes the sources of syncs as files and the destination with the targets
+// of the Docker host, falling back to files when no target matches, since there can be several sources
+func completeSyncArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	targets, directive := completeTargets(cmd, toComplete)
+	if len(targets) == 0 && !strings.Contains(toComplete, ":") {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return targets, directive
+}
+
+// completePullArgs completes the target to pull from and then the local directory
+func completePullArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return completeTargets(cmd, toComplete)
+	case 1:
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	default:
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeTargets returns the names of the containers and services of the Docker host, or its volumes
+// after volume://, followed by the colon before the path. Paths inside targets aren't completed
+func completeTargets(cmd *cobra.Command, toComplete string) ([]string, cobra.ShellCompDirective) {
+	prefix := ""
+	name := toComplete
+	if rest, ok := strings.CutPrefix(toComplete, syncer.VolumeScheme); ok {
+		prefix, name = syncer.VolumeScheme, rest
+	} else if strings.Contains(toComplete, "://") {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	} else {
+		for _, kind := range []string{syncer.KindContainer, syncer.KindService} {
+			if rest, ok := strings.CutPrefix(toComplete, kind+":"); ok {
+				prefix, name = kind+":", rest
+				break
+			}
+		}
+	}
+	if strings.Contains(name, ":") {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	names, err := targetNames(cmd, prefix)
+	if err != nil {
+		cobra.CompDebugln("failed to list targets: "+err.Error(), true)
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var completions []string
+	for _, target := range names {
+		if strings.HasPrefix(target, name) {
+			completions = append(completions, prefix+target+":")
+		}
+	}
+	return completions, cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+}
+
+// targetNames queries the Docker host given by the flags and the config for the names of the targets
+// of the kind the prefix stands for: volumes, containers, services or both of the latter
+func targetNames(cmd *cobra.Command, prefix string) ([]string, error) {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	options, err := hostOptions(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	dockerSyncer, err := connectHost(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	client := dockerSyncer.Client()
+	defer client.Close()
+
+	var names []string
+	if prefix == syncer.VolumeScheme {
+		volumes, err := client.VolumeList(ctx, volume.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, volume := range volumes.Volumes {
+			names = append(names, volume.Name)
+		}
+		return names, nil
+	}
+
+	if prefix != syncer.KindService+":" {
+		containers, err := client.ContainerList(ctx, container.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, container := range containers {
+			for _, name := range container.Names {
+				names = append(names, strings.TrimPrefix(name, "/"))
+			}
+		}
+	}
+
+	if prefix != syncer.KindContainer+":" {
+		// Hosts that aren't Swarm managers have no services to list
+		services, err := client.ServiceList(ctx, types.ServiceListOptions{})
+		if err == nil {
+			for _, service := range services {
+				names = append(names, service.Spec.Name)
+			}
+		}
+	}
+	return names, nil
+}
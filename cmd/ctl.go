@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/axtgr/docker-sync/daemon"
+	"github.com/axtgr/docker-sync/hostpath"
+	"github.com/spf13/cobra"
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Manage the syncs of a running docker-sync daemon",
+}
+
+var ctlListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the syncs of the daemon",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		statuses, err := newDaemonClient(cmd).List(cmd.Context())
+		if err != nil {
+			fatal(err)
+		}
+		printStatuses(cmd, statuses)
+	},
+}
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status <id>",
+	Short: "Show the status of a sync",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := newDaemonClient(cmd).Status(cmd.Context(), args[0])
+		if err != nil {
+			fatal(err)
+		}
+		printStatus(cmd, status)
+	},
+}
+
+var ctlAddCmd = &cobra.Command{
+	Use:               "add <source> <destination>",
+	Short:             "Start syncing a local directory with a container/service",
+	Long:              "Start syncing a local directory with a container/service. Settings that aren't given are taken from the daemon",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeSyncArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		request, err := addRequest(cmd, args[0], args[1])
+		if err != nil {
+			fatal(err)
+		}
+
+		status, err := newDaemonClient(cmd).Add(cmd.Context(), request)
+		if err != nil {
+			fatal(err)
+		}
+		printStatus(cmd, status)
+	},
+}
+
+var ctlRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Stop a sync and remove it from the daemon",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := newDaemonClient(cmd).Remove(cmd.Context(), args[0])
+		if err != nil {
+			fatal(err)
+		}
+	},
+}
+
+var ctlPauseCmd = &cobra.Command{
+	Use:   "pause <id>",
+	Short: "Stop syncing until resumed",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := newDaemonClient(cmd).Pause(cmd.Context(), args[0])
+		if err != nil {
+			fatal(err)
+		}
+		printStatus(cmd, status)
+	},
+}
+
+var ctlResumeCmd = &cobra.Command{
+	Use:   "resume <id>",
+	Short: "Resume a paused sync",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := newDaemonClient(cmd).Resume(cmd.Context(), args[0])
+		if err != nil {
+			fatal(err)
+		}
+		printStatus(cmd, status)
+	},
+}
+
+// newDaemonClient returns a client of the daemon at the address given with --address,
+// authenticated with the token of --token-file
+func newDaemonClient(cmd *cobra.Command) *daemon.Client {
+	address, err := cmd.Flags().GetString("address")
+	if err != nil {
+		fatal(err)
+	}
+
+	token, err := readToken(cmd)
+	if err != nil {
+		fatal(err)
+	}
+
+	client, err := daemon.NewClient(address, token)
+	if err != nil {
+		fatal(err)
+	}
+	return client
+}
+
+// addRequest returns a request to sync the source to the destination with the settings
+// given in the flags, leaving the rest to the daemon
+func addRequest(cmd *cobra.Command, source, destination string) (daemon.AddRequest, error) {
+	absoluteSourcePath, err := hostpath.Abs(source)
+	if err != nil {
+		return daemon.AddRequest{}, err
+	}
+	request := daemon.AddRequest{Source: absoluteSourcePath, Destination: destination}
+
+	if cmd.Flags().Changed("restart") {
+		restart, err := cmd.Flags().GetBool("restart")
+		if err != nil {
+			return daemon.AddRequest{}, err
+		}
+		request.Restart = &restart
+	}
+
+	for flag, value := range map[string]*string{
+		"restart-signal": &request.RestartSignal,
+		"restart-mode":   &request.RestartMode,
+		"exec-before":    &request.ExecBefore,
+		"exec-after":     &request.ExecAfter,
+	} {
+		*value, err = cmd.Flags().GetString(flag)
+		if err != nil {
+			return daemon.AddRequest{}, err
+		}
+	}
+
+	request.Exclude, err = cmd.Flags().GetStringArray("exclude")
+	if err != nil {
+		return daemon.AddRequest{}, err
+	}
+
+	request.Labels, err = cmd.Flags().GetStringArray("label")
+	if err != nil {
+		return daemon.AddRequest{}, err
+	}
+
+	return request, nil
+}
+
+// printStatuses prints the statuses as a table, or as JSON with --json
+func printStatuses(cmd *cobra.Command, statuses []daemon.Status) {
+	printResult(cmd, statuses, statuses)
+}
+
+// printStatus prints the status as a table, or as JSON with --json
+func printStatus(cmd *cobra.Command, status daemon.Status) {
+	printResult(cmd, status, []daemon.Status{status})
+}
+
+// printResult prints the result as JSON with --json, otherwise the statuses as a table
+func printResult(cmd *cobra.Command, result any, statuses []daemon.Status) {
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		fatal(err)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSOURCE\tDESTINATION\tSTATE\tLAST SYNC\tCOPIED\tLAST ERROR")
+	for _, status := range statuses {
+		lastSync := "-"
+		if status.LastSync != nil {
+			lastSync = status.LastSync.Local().Format(time.TimeOnly)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", status.ID, status.Source, status.Destination, status.State, lastSync, status.Copied, status.LastError)
+	}
+	w.Flush()
+}
+
+func init() {
+	ctlCmd.PersistentFlags().String("address", daemon.DefaultAddress(), "Address of the daemon: unix://<path> or tcp://<host>:<port>")
+	ctlCmd.PersistentFlags().String("token-file", "", "File with the token of the daemon, if it requires one")
+	ctlCmd.PersistentFlags().Bool("json", false, "Print the response of the daemon as JSON")
+	ctlCmd.AddCommand(ctlListCmd, ctlStatusCmd, ctlAddCmd, ctlRemoveCmd, ctlPauseCmd, ctlResumeCmd)
+	rootCmd.AddCommand(ctlCmd)
+}
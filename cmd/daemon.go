@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/axtgr/docker-sync/config"
+	"github.com/axtgr/docker-sync/daemon"
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run syncs in the background, controlled over a local HTTP API",
+	Long:  "Run syncs from the config file and the ones added with docker-sync ctl, exposing an HTTP API on a Unix socket or a TCP address to manage them",
+	Args:  cobra.NoArgs,
+	Run:   runDaemon,
+}
+
+func runDaemon(cmd *cobra.Command, args []string) {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		fatal(err)
+	}
+
+	address, err := cmd.Flags().GetString("address")
+	if err != nil {
+		fatal(err)
+	}
+
+	handlerOptions, err := resolveHandlerOptions(cmd)
+	if err != nil {
+		fatal(err)
+	}
+
+	var resolveMu sync.Mutex
+	// Sessions share the workers, so that operations on a container targeted by several of them don't overlap,
+	// and the bandwidth limit
+	var workers *syncer.Workers
+	var bandwidth *syncer.Bandwidth
+	resolve := func(sync config.Sync) (dockersync.Options, error) {
+		resolveMu.Lock()
+		defer resolveMu.Unlock()
+
+		options, err := resolveSyncs(cmd, cfg, []config.Sync{sync})
+		if err != nil {
+			return dockersync.Options{}, err
+		}
+		if workers == nil {
+			workers = options[0].Workers
+			bandwidth = options[0].Bandwidth
+		}
+		options[0].Workers = workers
+		options[0].Bandwidth = bandwidth
+		// Progress bars would interleave with the logs of other sessions
+		options[0].OnProgress = nil
+		options[0].CleanupContext = cleanupContext
+		if _, err := checkSources(options[0]); err != nil {
+			return dockersync.Options{}, err
+		}
+		return options[0], nil
+	}
+
+	var loggersMu sync.Mutex
+	loggers := make(map[string]*eventLogger)
+	manager := daemon.NewManager(cmd.Context(), daemon.ManagerOptions{
+		OnEvent: func(status daemon.Status, event dockersync.Event) {
+			loggersMu.Lock()
+			defer loggersMu.Unlock()
+			l, ok := loggers[status.ID]
+			if !ok {
+				l = &eventLogger{source: status.Source, destination: status.Destination, logger: log}
+				loggers[status.ID] = l
+			}
+			l.log(event)
+		},
+	})
+
+	listener, err := daemon.Listen(address, handlerOptions.Token != "")
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, sync := range cfg.Syncs {
+		options, err := resolve(sync)
+		if err == nil {
+			_, err = manager.Add(options)
+		}
+		if err != nil {
+			listener.Close()
+			manager.Close()
+			fatal(err)
+		}
+	}
+
+	// Settings of the request take precedence over the flags of the daemon
+	handler := daemon.NewHandler(manager, func(request daemon.AddRequest) (dockersync.Options, error) {
+		options, err := resolve(defaultSync(cfg, request.Source, request.Destination))
+		if err != nil {
+			return dockersync.Options{}, err
+		}
+		if request.Restart != nil {
+			options.Restart = *request.Restart
+		}
+		if request.RestartSignal != "" {
+			options.RestartSignal = request.RestartSignal
+		}
+		if request.RestartMode != "" {
+			options.RestartMode = request.RestartMode
+		}
+		options.Excludes = append(options.Excludes, request.Exclude...)
+		if len(request.Labels) > 0 {
+			options.Labels = request.Labels
+		}
+		if request.ExecBefore != "" {
+			options.ExecBefore = request.ExecBefore
+		}
+		if request.ExecAfter != "" {
+			options.ExecAfter = request.ExecAfter
+		}
+		return options, nil
+	}, handlerOptions)
+
+	log.Info("Listening on {address}", "address", address)
+	err = daemon.Serve(cmd.Context(), listener, handler, cleanupContext)
+	manager.Close()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal(fmt.Errorf("failed to serve the API: %w", err))
+	}
+}
+
+// resolveHandlerOptions returns what clients of the API are allowed to do from the flags.
+// Sources have to be in the home directory unless other directories are given
+func resolveHandlerOptions(cmd *cobra.Command) (daemon.HandlerOptions, error) {
+	token, err := readToken(cmd)
+	if err != nil {
+		return daemon.HandlerOptions{}, err
+	}
+
+	allowExec, err := cmd.Flags().GetBool("allow-exec")
+	if err != nil {
+		return daemon.HandlerOptions{}, err
+	}
+
+	roots, err := cmd.Flags().GetStringArray("allow-source")
+	if err != nil {
+		return daemon.HandlerOptions{}, err
+	}
+	if len(roots) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return daemon.HandlerOptions{}, fmt.Errorf("failed to find the home directory, give the directories to sync from with --allow-source: %w", err)
+		}
+		roots = []string{home}
+	}
+
+	return daemon.HandlerOptions{Token: token, AllowExec: allowExec, SourceRoots: roots}, nil
+}
+
+// readToken returns the token of the API from the file given with --token-file, if any
+func readToken(cmd *cobra.Command) (string, error) {
+	tokenFile, err := cmd.Flags().GetString("token-file")
+	if err != nil || tokenFile == "" {
+		return "", err
+	}
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", tokenFile)
+	}
+	return token, nil
+}
+
+func init() {
+	daemonCmd.Flags().String("address", daemon.DefaultAddress(), "Address to serve the API on: unix://<path> or tcp://<host>:<port>")
+	daemonCmd.Flags().String("token-file", "", "File with a token that clients have to send with every request, required to listen on a TCP address other than a loopback one")
+	daemonCmd.Flags().Bool("allow-exec", false, "Let syncs added through the API run commands in their targets with exec_before and exec_after")
+	daemonCmd.Flags().StringArray("allow-source", nil, "Directory that syncs added through the API can sync from (default: the home directory)")
+	rootCmd.AddCommand(daemonCmd)
+}
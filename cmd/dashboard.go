@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/syncer"
+	"golang.org/x/term"
+)
+
+const (
+	// dashboardInterval is how often the dashboard is redrawn
+	dashboardInterval = 250 * time.Millisecond
+	// dashboardErrors and dashboardLogLines are how many recent errors and log lines are shown
+	dashboardErrors   = 5
+	dashboardLogLines = 10
+)
+
+// dashboard takes over the terminal to show the state of the syncs and control them with keys
+type dashboard struct {
+	in  *os.File
+	out *os.File
+
+	mu       sync.Mutex
+	rows     []*dashboardRow
+	paused   bool
+	errors   []dashboardError
+	logLines []string
+	partial  []byte
+	// active is set between start and stop, outside of them log output goes to out
+	active   bool
+	oldState *term.State
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// dashboardRow is the state of a single sync
+type dashboardRow struct {
+	source      string
+	destination string
+	syncer      dockersync.Syncer
+	copying     bool
+	stopped     bool
+	lastSync    time.Time
+	// rate is the transfer rate of the last upload in bytes per second, throttled while
+	// the bandwidth limit holds it back
+	rate      float64
+	throttled bool
+}
+
+type dashboardError struct {
+	time        time.Time
+	destination string
+	err         error
+}
+
+// newDashboard returns a dashboard reading keys from in and drawing on out, which have to be a terminal
+func newDashboard(in, out *os.File) (*dashboard, error) {
+	if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		return nil, fmt.Errorf("--tui requires an interactive terminal")
+	}
+
+	return &dashboard{
+		in:      in,
+		out:     out,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}, nil
+}
+
+// add adds a row for a sync, whose syncer has to be set before the dashboard is started
+func (d *dashboard) add(source, destination string) *dashboardRow {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	row := &dashboardRow{source: source, destination: destination}
+	d.rows = append(d.rows, row)
+	return row
+}
+
+// progress returns a progress reporter updating the transfer rate of the row
+func (d *dashboard) progress(row *dashboardRow) syncer.ProgressFunc {
+	return func(progress syncer.Progress) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if progress.Rate > 0 {
+			row.rate = progress.Rate
+		}
+		row.throttled = progress.Throttled && !progress.Done
+	}
+}
+
+// handle updates the row from an event of its syncer
+func (d *dashboard) handle(row *dashboardRow, event dockersync.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch event.Type {
+	case dockersync.Copying:
+		row.copying = true
+	case dockersync.Copied:
+		row.copying = false
+		row.lastSync = time.Now()
+	case dockersync.TargetStopped:
+		row.stopped = true
+	case dockersync.TargetStarted:
+		row.stopped = false
+	case dockersync.Error:
+		row.copying = false
+		d.errors = append(d.errors, dashboardError{time: time.Now(), destination: row.destination, err: event.Err})
+		if len(d.errors) > dashboardErrors {
+			d.errors = d.errors[len(d.errors)-dashboardErrors:]
+		}
+	}
+}
+
+// Write collects log output to show it on the dashboard
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.active {
+		return d.out.Write(p)
+	}
+
+	d.partial = append(d.partial, p...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		d.logLines = append(d.logLines, string(d.partial[:i]))
+		d.partial = d.partial[i+1:]
+	}
+	if len(d.logLines) > dashboardLogLines {
+		d.logLines = d.logLines[len(d.logLines)-dashboardLogLines:]
+	}
+	return len(p), nil
+}
+
+// start takes over the terminal until stop is called. Quitting with q or Ctrl+C calls cancel
+func (d *dashboard) start(cancel context.CancelFunc) error {
+	oldState, err := term.MakeRaw(int(d.in.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to set up the terminal: %w", err)
+	}
+
+	d.mu.Lock()
+	d.oldState = oldState
+	d.active = true
+	d.mu.Unlock()
+
+	// Switch to the alternate screen and hide the cursor
+	fmt.Fprint(d.out, "\033[?1049h\033[?25l")
+
+	go d.readKeys(cancel)
+	go func() {
+		defer close(d.stopped)
+		ticker := time.NewTicker(dashboardInterval)
+		defer ticker.Stop()
+		for {
+			d.render()
+			select {
+			case <-d.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// stop restores the terminal and prints the log lines that were shown on the dashboard
+func (d *dashboard) stop() {
+	close(d.done)
+	<-d.stopped
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprint(d.out, "\033[?25h\033[?1049l")
+	term.Restore(int(d.in.Fd()), d.oldState)
+	d.active = false
+
+	for _, line := range d.logLines {
+		fmt.Fprintln(d.out, line)
+	}
+	d.out.Write(d.partial)
+}
+
+// readKeys handles key presses: p pauses and resumes syncing, s copies the whole sources,
+// q and Ctrl+C quit
+func (d *dashboard) readKeys(cancel context.CancelFunc) {
+	buf := make([]byte, 1)
+	for {
+		n, err := d.in.Read(buf)
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		switch buf[0] {
+		case 'p', 'P':
+			d.mu.Lock()
+			d.paused = !d.paused
+			for _, row := range d.rows {
+				if d.paused {
+					row.syncer.Pause()
+				} else {
+					row.syncer.Resume()
+				}
+			}
+			paused := d.paused
+			d.mu.Unlock()
+			if paused {
+				log.Info("Paused syncing, changes are queued until resumed")
+			} else {
+				log.Info("Resumed syncing")
+			}
+		case 's', 'S':
+			d.mu.Lock()
+			for _, row := range d.rows {
+				row.syncer.CopyAll()
+			}
+			d.mu.Unlock()
+			log.Info("Syncing everything...")
+		case 'q', 'Q', 3:
+			cancel()
+			return
+		}
+		d.render()
+	}
+}
+
+// render draws the dashboard over the whole screen
+func (d *dashboard) render() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	width, _, err := term.GetSize(int(d.out.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+
+	var screen bytes.Buffer
+	state := "syncing"
+	if d.paused {
+		state = "paused"
+	}
+	fmt.Fprintf(&screen, "docker-sync: %s\n", state)
+	fmt.Fprintln(&screen, "p pause/resume · s sync everything · q quit")
+	fmt.Fprintln(&screen)
+
+	table := tabwriter.NewWriter(&screen, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "SOURCE\tDESTINATION\tSTATE\tLAST SYNC\tPENDING\tTHROUGHPUT")
+	for _, row := range d.rows {
+		rowState := "watching"
+		if row.copying {
+			rowState = "copying"
+		} else if row.stopped {
+			rowState = "target stopped"
+		} else if d.paused {
+			rowState = "paused"
+		}
+
+		lastSync := "-"
+		if !row.lastSync.IsZero() {
+			lastSync = row.lastSync.Format(time.TimeOnly)
+		}
+
+		rate := "-"
+		if row.rate > 0 {
+			rate = formatBytes(int64(row.rate)) + "/s"
+			if row.throttled {
+				rate += " (throttled)"
+			}
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%s\n", row.source, row.destination, rowState, lastSync, row.syncer.Pending(), rate)
+	}
+	table.Flush()
+
+	fmt.Fprintln(&screen)
+	fmt.Fprintln(&screen, "Recent errors:")
+	if len(d.errors) == 0 {
+		fmt.Fprintln(&screen, "  none")
+	}
+	for _, e := range d.errors {
+		fmt.Fprintf(&screen, "  %s %s: %s\n", e.time.Format(time.TimeOnly), e.destination, e.err)
+	}
+
+	fmt.Fprintln(&screen)
+	fmt.Fprintln(&screen, "Log:")
+	for _, line := range d.logLines {
+		fmt.Fprintf(&screen, "  %s\n", line)
+	}
+
+	// Raw mode doesn't return the carriage on new lines, and long lines would wrap
+	lines := strings.Split(strings.TrimSuffix(screen.String(), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = truncateLine(line, width)
+	}
+	fmt.Fprint(d.out, "\033[H\033[2J"+strings.Join(lines, "\r\n"))
+}
+
+// truncateLine cuts a line to the width, not counting escape sequences
+func truncateLine(line string, width int) string {
+	var result strings.Builder
+	visible := 0
+	escape := false
+	for _, r := range line {
+		switch {
+		case r == '\033':
+			escape = true
+		case escape:
+			if r >= '@' && r <= '~' && r != '[' {
+				escape = false
+			}
+		case visible >= width:
+			continue
+		default:
+			visible++
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
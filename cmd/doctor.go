@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/filewatcher"
+	"github.com/axtgr/docker-sync/hostpath"
+	"github.com/axtgr/docker-sync/ignore"
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/spf13/cobra"
+)
+
+// slowLatency is the latency of the daemon above which syncs are noticeably slowed down
+const slowLatency = 100 * time.Millisecond
+
+var doctorCmd = &cobra.Command{
+	Use:               "doctor [<source>... <destination>...]",
+	Short:             "Check what could keep changes from being synced",
+	Long:              "Report the connection to the Docker host, how the sources are watched and whether files can be written to the destinations, listing the problems found. Exits with a non-zero code if there are any",
+	Args:              syncArgs,
+	ValidArgsFunction: completeSyncArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		syncs, err := loadSyncs(cmd, args)
+		if err != nil {
+			fatal(err)
+		}
+
+		report := &doctorReport{}
+		for _, options := range syncs {
+			for _, part := range options.Split() {
+				report.diagnose(cmd.Context(), part)
+			}
+		}
+		report.watchLimit()
+
+		if len(report.problems) == 0 {
+			fmt.Println("\nNo problems found")
+			return
+		}
+		if len(report.problems) == 1 {
+			fmt.Println("\n1 problem found:")
+		} else {
+			fmt.Printf("\n%d problems found:\n", len(report.problems))
+		}
+		for _, problem := range report.problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+		os.Exit(1)
+	},
+}
+
+// doctorReport prints what's checked and collects the problems found
+type doctorReport struct {
+	problems []string
+	// hosts and sources are the ones already reported
+	hosts   map[string]bool
+	sources map[string]bool
+	// directories is how many directories of the sources are watched one by one
+	directories int
+}
+
+func (report *doctorReport) problem(format string, args ...any) {
+	report.problems = append(report.problems, fmt.Sprintf(format, args...))
+}
+
+// section prints the title and the rows of a part of the report
+func (report *doctorReport) section(title string, rows [][2]string) {
+	fmt.Printf("\n%s\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(w, "  %s\t%s\n", row[0], row[1])
+	}
+	w.Flush()
+}
+
+// diagnose reports the Docker host, the source and the destination of the sync
+func (report *doctorReport) diagnose(ctx context.Context, options dockersync.Options) {
+	if isDockerDestination(options.Destination) {
+		report.host(ctx, options)
+	}
+	report.source(options)
+	report.target(ctx, options)
+}
+
+// host reports the connection to the Docker host once for every host
+func (report *doctorReport) host(ctx context.Context, options dockersync.Options) {
+	if report.hosts == nil {
+		report.hosts = make(map[string]bool)
+	}
+	if report.hosts[options.Host] {
+		return
+	}
+	report.hosts[options.Host] = true
+
+	name := options.Host
+	if name == "" {
+		name = "default"
+	}
+	rows := [][2]string{{"host", name}}
+
+	dockerSyncer, err := connectHost(ctx, syncer.Options{
+		Engine:     options.Engine,
+		Host:       options.Host,
+		TLS:        options.TLS,
+		SSHFlags:   options.SSHFlags,
+		SSHBastion: options.SSHBastion,
+		Insecure:   options.Insecure,
+		Trace:      options.Trace,
+	})
+	if err != nil {
+		report.section("Docker", append(rows, [2]string{"connected", "no"}))
+		report.problem("failed to connect to Docker host %s: %s", name, err)
+		return
+	}
+
+	version, err := dockerSyncer.Client().ServerVersion(ctx)
+	if err != nil {
+		report.section("Docker", append(rows, [2]string{"connected", "no"}))
+		report.problem("failed to connect to Docker host %s: %s", name, err)
+		return
+	}
+	rows = append(rows,
+		[2]string{"connected", "yes"},
+		[2]string{"server", fmt.Sprintf("%s %s (%s/%s)", platformName(version.Platform.Name), version.Version, version.Os, version.Arch)},
+		[2]string{"API version", fmt.Sprintf("%s (server supports %s to %s)", dockerSyncer.Client().ClientVersion(), version.MinAPIVersion, version.APIVersion)},
+	)
+
+	latency, err := dockerSyncer.Latency(ctx)
+	if err != nil {
+		report.problem("%s: %s", name, err)
+	} else {
+		rows = append(rows, [2]string{"latency", latency.Round(10 * time.Microsecond).String()})
+		if latency > slowLatency {
+			report.problem("Docker host %s takes %s to respond, every sync makes several requests to it", name, latency.Round(time.Millisecond))
+		}
+	}
+	report.section("Docker", rows)
+}
+
+// platformName returns the name of the platform of the daemon, e.g. Docker Engine - Community
+func platformName(name string) string {
+	if name == "" {
+		return "Docker"
+	}
+	return name
+}
+
+// source reports what is watched in the source and how, once for every source
+func (report *doctorReport) source(options dockersync.Options) {
+	source, err := hostpath.Abs(options.Source)
+	if err != nil {
+		report.problem("%s: %s", options.Source, err)
+		return
+	}
+	if report.sources == nil {
+		report.sources = make(map[string]bool)
+	}
+	if report.sources[source] {
+		return
+	}
+	report.sources[source] = true
+
+	directories, files, err := countWatched(source, options)
+	if err != nil {
+		report.problem("%s: %s", source, err)
+		return
+	}
+
+	mode := options.WatchMode
+	if mode == "" {
+		mode = filewatcher.ModeNotify
+	}
+	debounce := options.Debounce
+	if debounce <= 0 {
+		debounce = filewatcher.DefaultDebounce
+	}
+	batchInterval := options.BatchInterval
+	if batchInterval <= 0 {
+		batchInterval = dockersync.DefaultBatchInterval
+	}
+
+	rows := [][2]string{
+		{"directories", fmt.Sprint(directories)},
+		{"files", fmt.Sprint(files)},
+		{"watch mode", mode},
+		{"debounce", debounce.String()},
+		{"batch interval", batchInterval.String()},
+	}
+	if options.Settle > 0 {
+		rows = append(rows, [2]string{"settle", options.Settle.String()})
+	}
+	if mode == filewatcher.ModePoll || options.PollFallback {
+		pollInterval := options.PollInterval
+		if pollInterval <= 0 {
+			pollInterval = filewatcher.DefaultPollInterval
+		}
+		rows = append(rows, [2]string{"poll interval", pollInterval.String()})
+	}
+	report.section("Source "+source, rows)
+
+	// Windows watches every source as a whole, other systems watch every directory
+	if mode == filewatcher.ModeNotify && runtime.GOOS != "windows" && !options.PollFallback {
+		report.directories += directories
+	}
+}
+
+// countWatched counts the directories and files of the source the watcher sees,
+// leaving out the excluded ones like it does
+func countWatched(source string, options dockersync.Options) (int, int, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !info.IsDir() {
+		return 0, 1, nil
+	}
+
+	matcher, err := ignore.Load(source, ignore.Options{Exclude: options.Excludes, RespectGitignore: options.RespectGitignore})
+	if err != nil {
+		return 0, 0, err
+	}
+	directories, files := 0, 0
+	err = filepath.WalkDir(source, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories can't be watched either
+			return nil
+		}
+		if filePath != source && matcher.Match(filePath, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			directories++
+		} else {
+			files++
+		}
+		return nil
+	})
+	return directories, files, err
+}
+
+// watchLimit reports the inotify watches needed by all the sources against the limit
+func (report *doctorReport) watchLimit() {
+	limit, ok := filewatcher.WatchLimit()
+	if !ok || report.directories == 0 {
+		return
+	}
+	report.section("Inotify", [][2]string{
+		{"watches needed", fmt.Sprint(report.directories)},
+		{"watch limit", fmt.Sprintf("%d (fs.inotify.max_user_watches)", limit)},
+	})
+	// Editors and other programs take watches from the same limit
+	if report.directories > limit/2 {
+		report.problem("the sources need %d of the %d inotify watches allowed, raise the limit with sudo sysctl fs.inotify.max_user_watches=%d, exclude large directories or use --poll-fallback", report.directories, limit, 2*(limit+report.directories))
+	}
+}
+
+// target reports whether the destination is found and files can be written to it
+func (report *doctorReport) target(ctx context.Context, options dockersync.Options) {
+	// Nothing is copied, so the target doesn't have to be prepared for restarts
+	options.Restart = false
+	options.RestartSignal = ""
+	options.Rules = nil
+	options.Agent = false
+
+	dockerSyncer, _, err := dockersync.Connect(ctx, options)
+	if err != nil {
+		report.section("Destination "+options.Destination, [][2]string{{"found", "no"}})
+		report.problem("%s: %s", options.Destination, err)
+		return
+	}
+	defer dockerSyncer.Cleanup(ctx)
+
+	writable := "yes"
+	err = dockerSyncer.CheckWritable(ctx)
+	if errors.Is(err, syncer.ErrNotChecked) {
+		writable = "not checked"
+	} else if err != nil {
+		writable = "no"
+		report.problem("%s: %s", options.Destination, err)
+	}
+	report.section("Destination "+options.Destination, [][2]string{
+		{"found", "yes"},
+		{"writable", writable},
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
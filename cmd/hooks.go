@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/axtgr/docker-sync/dockersync"
+)
+
+// hookQueueSize is how many hook commands wait to be run before new ones are dropped
+const hookQueueSize = 100
+
+// hookRun is a hook command to run for an event of a sync
+type hookRun struct {
+	command string
+	env     []string
+	event   string
+}
+
+// hooks runs commands on the host after syncs, errors and restarts, with environment variables
+// describing the event. They're run in the background, one at a time, so that slow commands
+// don't hold up syncing
+type hooks struct {
+	onSync    string
+	onError   string
+	onRestart string
+	queue     chan hookRun
+	done      chan struct{}
+}
+
+// newHooks starts running the commands of the hooks, or returns nil if none are given
+func newHooks(onSync, onError, onRestart string) *hooks {
+	if onSync == "" && onError == "" && onRestart == "" {
+		return nil
+	}
+	h := &hooks{
+		onSync:    onSync,
+		onError:   onError,
+		onRestart: onRestart,
+		queue:     make(chan hookRun, hookQueueSize),
+		done:      make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+// track returns onEvent also running the hooks of the events of the sync of the source
+// to the destination
+func (h *hooks) track(source, destination string, onEvent func(dockersync.Event)) func(dockersync.Event) {
+	return func(event dockersync.Event) {
+		command, name := "", ""
+		switch event.Type {
+		case dockersync.Copied:
+			command, name = h.onSync, "sync"
+		case dockersync.Error:
+			command, name = h.onError, "error"
+		case dockersync.Restarted:
+			command, name = h.onRestart, "restart"
+		}
+
+		if command != "" {
+			env := []string{
+				"DOCKER_SYNC_EVENT=" + name,
+				"DOCKER_SYNC_SOURCE=" + source,
+				"DOCKER_SYNC_DESTINATION=" + destination,
+				"DOCKER_SYNC_FILES=" + strings.Join(event.Paths, "\n"),
+			}
+			if event.Err != nil {
+				env = append(env, "DOCKER_SYNC_ERROR="+event.Err.Error())
+			}
+			select {
+			case h.queue <- hookRun{command: command, env: env, event: name}:
+			default:
+				log.Warn("Dropping the {event} hook, the previous ones are too slow to keep up", "event", name)
+			}
+		}
+
+		if onEvent != nil {
+			onEvent(event)
+		}
+	}
+}
+
+// close runs the queued hooks and stops running them
+func (h *hooks) close() {
+	close(h.queue)
+	<-h.done
+}
+
+func (h *hooks) run() {
+	defer close(h.done)
+	for run := range h.queue {
+		cmd := hookCommand(run.command)
+		cmd.Env = append(os.Environ(), run.env...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		// The dashboard shows the output of hooks along with the log
+		if logOutput != nil {
+			cmd.Stdout, cmd.Stderr = logOutput, logOutput
+		}
+		err := cmd.Run()
+		if err != nil {
+			log.Warn("The {event} hook {command} failed: {error}", "event", run.event, "command", run.command, "error", err)
+		}
+	}
+}
+
+// hookCommand returns the command running the hook with the shell of the host
+func hookCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
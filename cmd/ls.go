@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the running containers and services files can be synced into",
+	Long:  "List the running containers and the services of the Docker host along with the paths their volumes are mounted at, which keep synced files when they're recreated",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			fatal(err)
+		}
+
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			fatal(err)
+		}
+
+		options, err := hostOptions(cmd, cfg)
+		if err != nil {
+			fatal(err)
+		}
+
+		dockerSyncer, err := connectHost(cmd.Context(), options)
+		if err != nil {
+			fatal(err)
+		}
+
+		targets, err := dockerSyncer.FindTargets(cmd.Context())
+		if err != nil {
+			fatal(err)
+		}
+
+		if asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(targets); err != nil {
+				fatal(err)
+			}
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tKIND\tIMAGE\tMOUNTS")
+		for _, target := range targets {
+			mounts := strings.Join(target.Mounts, ", ")
+			if mounts == "" {
+				mounts = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", target.Name, target.Kind, target.Image, mounts)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	lsCmd.Flags().Bool("json", false, "Print the targets as JSON")
+	rootCmd.AddCommand(lsCmd)
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/livereload"
+)
+
+const (
+	// notifyTimeout is how long sending a notification to one endpoint may take
+	notifyTimeout = 5 * time.Second
+	// notifyQueueSize is how many notifications wait to be sent before new ones are dropped
+	notifyQueueSize = 100
+)
+
+// notification is the JSON sent to the endpoints for an event of a sync
+type notification struct {
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Files       []string  `json:"files,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// notifier sends the lifecycle events of syncs to webhooks, which get them POSTed as JSON,
+// and Unix sockets, which get them as lines of JSON. Notifications are sent in the background,
+// one at a time, so that slow endpoints don't hold up syncing
+type notifier struct {
+	endpoints []string
+	client    *http.Client
+	queue     chan notification
+	done      chan struct{}
+}
+
+// newNotifier checks the endpoints, which are http:// or https:// URLs or unix://<path>
+func newNotifier(endpoints []string) (*notifier, error) {
+	for _, endpoint := range endpoints {
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") && !strings.HasPrefix(endpoint, "unix://") {
+			return nil, fmt.Errorf("notification endpoint %s must be an http://, https:// or unix:// URL", endpoint)
+		}
+	}
+
+	n := &notifier{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: notifyTimeout},
+		queue:     make(chan notification, notifyQueueSize),
+		done:      make(chan struct{}),
+	}
+	go n.send()
+	return n, nil
+}
+
+// track returns onEvent also notifying the endpoints of the events of the sync of the source
+// to the destination: sync-start, sync-complete, restart and error
+func (n *notifier) track(source, destination string, onEvent func(dockersync.Event)) func(dockersync.Event) {
+	return func(event dockersync.Event) {
+		name := ""
+		switch event.Type {
+		case dockersync.Copying:
+			name = "sync-start"
+		case dockersync.Copied:
+			name = "sync-complete"
+		case dockersync.Restarted:
+			name = "restart"
+		case dockersync.Error:
+			name = "error"
+		}
+
+		if name != "" {
+			message := notification{
+				Event:       name,
+				Time:        time.Now(),
+				Source:      source,
+				Destination: destination,
+				Files:       event.Paths,
+			}
+			if event.Err != nil {
+				message.Error = event.Err.Error()
+			}
+			select {
+			case n.queue <- message:
+			default:
+				log.Warn("Dropping the {event} notification, the endpoints are too slow to keep up", "event", name)
+			}
+		}
+
+		if onEvent != nil {
+			onEvent(event)
+		}
+	}
+}
+
+// close sends the queued notifications and stops sending
+func (n *notifier) close() {
+	close(n.queue)
+	<-n.done
+}
+
+func (n *notifier) send() {
+	defer close(n.done)
+	for message := range n.queue {
+		body, err := json.Marshal(message)
+		if err != nil {
+			log.Debug("Failed to encode the {event} notification: {error}", "event", message.Event, "error", err)
+			continue
+		}
+		for _, endpoint := range n.endpoints {
+			err := n.sendTo(endpoint, body)
+			if err != nil {
+				log.Warn("Failed to notify {endpoint} of {event}: {error}", "endpoint", endpoint, "event", message.Event, "error", err)
+			}
+		}
+	}
+}
+
+func (n *notifier) sendTo(endpoint string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	if socketPath, ok := strings.CutPrefix(endpoint, "unix://"); ok {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "unix", socketPath)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetWriteDeadline(time.Now().Add(notifyTimeout))
+		_, err = conn.Write(append(body, '\n'))
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+// reloadBrowsers returns onEvent also reloading the browsers connected to the LiveReload server
+// after copies and restarts
+func reloadBrowsers(server *livereload.Server, onEvent func(dockersync.Event)) func(dockersync.Event) {
+	return func(event dockersync.Event) {
+		switch event.Type {
+		case dockersync.Copied:
+			server.Reload(event.Paths)
+		case dockersync.Restarted:
+			server.Reload(nil)
+		}
+		if onEvent != nil {
+			onEvent(event)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/filewatcher"
+	"github.com/axtgr/docker-sync/hostpath"
+)
+
+// pipeline is a started syncer of a single source and destination, logging its events
+type pipeline struct {
+	syncer dockersync.Syncer
+	events <-chan dockersync.Event
+	log    *eventLogger
+}
+
+func newPipeline(ctx context.Context, options dockersync.Options) (*pipeline, error) {
+	sources, err := checkSources(options)
+	if err != nil {
+		return nil, err
+	}
+
+	options.CleanupContext = cleanupContext
+	dockerSyncer, err := dockersync.New(options)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := dockerSyncer.Start(ctx)
+	if errors.Is(err, filewatcher.ErrWatchLimit) {
+		return nil, fmt.Errorf("%w. With --poll-fallback, these directories are polled instead, and --watch-mode poll polls everything", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipeline{
+		syncer: dockerSyncer,
+		events: events,
+		log: &eventLogger{
+			source:      sources,
+			destination: strings.Join(options.DestinationList(), ", "),
+			logger:      options.Logger,
+		},
+	}, nil
+}
+
+// run logs the events of the syncer, also passing them to onEvent if set, until it's done,
+// which happens after the context it was started with is canceled
+func (p *pipeline) run(onEvent func(dockersync.Event)) {
+	for event := range p.events {
+		p.log.log(event)
+		if onEvent != nil {
+			onEvent(event)
+		}
+	}
+}
+
+// checkSources returns the absolute paths of the sources joined by commas, warning when
+// changes to them can go unnoticed
+func checkSources(options dockersync.Options) (string, error) {
+	var absoluteSourcePaths []string
+	for _, source := range options.SourcePaths() {
+		absoluteSourcePath, err := hostpath.Abs(source)
+		if err != nil {
+			return "", err
+		}
+		absoluteSourcePaths = append(absoluteSourcePaths, absoluteSourcePath)
+
+		// Polling doesn't depend on change notifications
+		if options.WatchMode == filewatcher.ModePoll {
+			continue
+		}
+		if hostpath.IsWSL(absoluteSourcePath) {
+			options.Logger.Warn("The source {source} is inside a WSL distribution, change notifications over \\\\wsl$ can be delayed or missed. Running docker-sync inside WSL or using --watch-mode poll is more reliable", "source", absoluteSourcePath)
+		} else if hostpath.IsWindowsMount(absoluteSourcePath) {
+			options.Logger.Warn("The source {source} is on a Windows drive mounted into WSL, changes made by Windows programs are not reported to docker-sync unless --watch-mode poll is used", "source", absoluteSourcePath)
+		} else if hostpath.IsUNC(absoluteSourcePath) {
+			options.Logger.Warn("The source {source} is on a network share, changes made by other machines are not reported to docker-sync unless --watch-mode poll is used", "source", absoluteSourcePath)
+		}
+	}
+
+	return strings.Join(absoluteSourcePaths, ", "), nil
+}
+
+// warnOverlappingSyncs warns about syncs whose changes are copied twice, because their sources
+// overlap and they have a destination in common
+func warnOverlappingSyncs(syncs []dockersync.Options) {
+	for i, options := range syncs {
+		for _, other := range syncs[:i] {
+			if options.Overlaps(other) {
+				log.Warn("The sources {source} and {other} overlap and are synced to the same destination, changes inside both are copied twice", "source", strings.Join(options.SourcePaths(), ", "), "other", strings.Join(other.SourcePaths(), ", "))
+			}
+		}
+	}
+}
+
+// shortId shortens a container ID the way Docker shows it
+func shortId(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// eventLogger logs the events of a syncer of the source to the destination
+type eventLogger struct {
+	source      string
+	destination string
+	logger      *slog.Logger
+	started     bool
+}
+
+func (l *eventLogger) log(event dockersync.Event) {
+	description := ""
+	if len(event.Paths) == 1 {
+		description = event.Paths[0]
+	} else if len(event.Paths) > 1 {
+		description = fmt.Sprintf("%d files", len(event.Paths))
+	}
+
+	switch event.Type {
+	case dockersync.Connected:
+		// Reconnecting is logged by the syncer
+		if !l.started {
+			l.logger.Info("Syncing {source} to {destination}", "source", l.source, "destination", l.destination)
+			l.started = true
+		}
+	case dockersync.Copying:
+		l.logger.Info("Copying {files} to {destination}...", "files", description, "destination", l.destination)
+	case dockersync.Copied:
+		l.logger.Info("Copied {files} to {destination}", "files", description, "destination", l.destination)
+	case dockersync.Restarted:
+		l.logger.Debug("Restarted {destination}", "destination", l.destination)
+	case dockersync.TargetStopped:
+		switch event.Target.Action {
+		case "die":
+			l.logger.Warn("Container {container} of {destination} exited with code {code}", "container", shortId(event.Target.Container), "destination", l.destination, "code", event.Target.ExitCode)
+		case "destroy":
+			l.logger.Warn("Container {container} of {destination} was removed", "container", shortId(event.Target.Container), "destination", l.destination)
+		default:
+			l.logger.Debug("Container {container} of {destination} stopped", "container", shortId(event.Target.Container), "destination", l.destination)
+		}
+	case dockersync.TargetStarted:
+		l.logger.Info("Container {container} of {destination} was started outside of docker-sync", "container", shortId(event.Target.Container), "destination", l.destination)
+	case dockersync.Error:
+		if description != "" {
+			l.logger.Error("Failed to copy {files} to {destination}: {error}", "files", description, "destination", l.destination, "error", event.Err)
+		} else {
+			l.logger.Error("{error}", "error", event.Err)
+		}
+	}
+}
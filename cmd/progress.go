@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+const (
+	progressBarWidth = 30
+	// Uploads smaller than this finish too quickly for a progress bar to be useful
+	minProgressBarSize = 1 << 20
+)
+
+// progressBarMutex keeps progress bars of concurrent syncs from interleaving
+var progressBarMutex sync.Mutex
+
+// newProgressBar returns a progress reporter that renders a single-line
+// progress bar, or nil if out is not a terminal
+func newProgressBar(out *os.File, label string) syncer.ProgressFunc {
+	info, err := out.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+
+	return func(progress syncer.Progress) {
+		if progress.Total < minProgressBarSize {
+			return
+		}
+
+		progressBarMutex.Lock()
+		defer progressBarMutex.Unlock()
+
+		if progress.Done {
+			fmt.Fprint(out, "\r\033[K")
+			return
+		}
+
+		renderProgressBar(out, label, progress)
+	}
+}
+
+func renderProgressBar(out io.Writer, label string, progress syncer.Progress) {
+	ratio := float64(progress.Bytes) / float64(progress.Total)
+	filled := int(ratio * progressBarWidth)
+
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+
+	file := ""
+	if progress.File != "" {
+		file = fmt.Sprintf(" %s (%s/%s)", filepath.Base(progress.File), formatBytes(progress.FileBytes), formatBytes(progress.FileSize))
+	}
+
+	// Under a bandwidth limit, the rate of sending is what's held to it
+	limit := ""
+	if progress.Limit > 0 {
+		limit = fmt.Sprintf(" sending %s/s of %s/s", formatBytes(int64(progress.SendRate)), formatBytes(int64(progress.Limit)))
+		if progress.Throttled {
+			limit += " (throttled)"
+		}
+	}
+
+	fmt.Fprintf(out, "\r\033[K%s [%s] %3.0f%% %s/%s %s/s%s%s",
+		label, bar, ratio*100, formatBytes(progress.Bytes), formatBytes(progress.Total), formatBytes(int64(progress.Rate)), limit, file)
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes)
+	exponent := 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exponent-1])
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/spf13/cobra"
+)
+
+var pullCmd = &cobra.Command{
+	Use:               "pull <container or service>:<path> <local directory>",
+	Short:             "Download files from a container/service into a local directory",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completePullArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			fatal(err)
+		}
+
+		retries, err := cmd.Flags().GetInt("retries")
+		if err != nil {
+			fatal(err)
+		}
+
+		trace, err := resolveTrace(cmd, cfg)
+		if err != nil {
+			fatal(err)
+		}
+
+		retryDelay, err := cmd.Flags().GetDuration("retry-delay")
+		if err != nil {
+			fatal(err)
+		}
+
+		syncerOptions := syncer.Options{
+			Engine:     resolveEngine(cmd, cfg),
+			Logger:     log,
+			Identifier: "docker-sync",
+			Trace:      trace,
+			Retries:    retries,
+			RetryDelay: retryDelay,
+		}
+		syncerOptions.Labels, err = cmd.Flags().GetStringArray("label")
+		if err != nil {
+			fatal(err)
+		}
+		if !cmd.Flags().Changed("label") {
+			syncerOptions.Labels = cfg.Labels
+		}
+
+		syncerOptions.TaskSlot, syncerOptions.Node, err = resolveTaskSelection(cmd, cfg)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = dockersync.ParseTarget(args[0], &syncerOptions)
+		if err != nil {
+			fatal(err)
+		}
+
+		if syncerOptions.Kube == nil {
+			syncerOptions.Host, syncerOptions.TLS, err = resolveHost(cmd, cfg)
+			if err != nil {
+				fatal(err)
+			}
+			syncerOptions.SSHFlags, err = resolveSSHFlags(cmd, cfg)
+			if err != nil {
+				fatal(err)
+			}
+			syncerOptions.SSHBastion, syncerOptions.Insecure, err = resolveTunnel(cmd, cfg)
+			if err != nil {
+				fatal(err)
+			}
+		}
+
+		dockerSyncer, err := syncer.New(syncerOptions)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = dockerSyncer.Connect(cmd.Context())
+		if err != nil {
+			fatal(err)
+		}
+
+		err = dockerSyncer.Init(cmd.Context())
+		if err != nil {
+			fatal(err)
+		}
+
+		log.Info("Pulling {source} to {destination}...", "source", args[0], "destination", args[1])
+		err = dockerSyncer.Pull(cmd.Context(), args[1])
+		// Volumes are read through a helper container, which is removed afterwards
+		if syncerOptions.Volume != nil {
+			if cleanupErr := dockerSyncer.Cleanup(context.WithoutCancel(cmd.Context())); cleanupErr != nil {
+				log.Warn("Failed to clean up: {error}", "error", cleanupErr)
+			}
+		}
+		if err != nil {
+			fatal(err)
+		}
+		log.Info("Pulled {source} to {destination}", "source", args[0], "destination", args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pullCmd)
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of push telling failures apart, the code of the first failed sync is used.
+// Other failures, e.g. of invalid arguments or config, exit with 1
+const (
+	// exitConnectFailed is used when the destination can't be reached or prepared
+	exitConnectFailed = 2
+	// exitCopyFailed is used when copying fails
+	exitCopyFailed = 3
+	// exitRestartFailed is used when the files are copied, but restarting the target fails
+	exitRestartFailed = 4
+)
+
+// Outcomes of restarting the target after a push
+const (
+	restartNone    = "none"
+	restartDone    = "done"
+	restartFailed  = "failed"
+	restartSkipped = "skipped"
+)
+
+// pushResult is the outcome of pushing a sync
+type pushResult struct {
+	Sources     []string `json:"sources"`
+	Destination string   `json:"destination"`
+	Files       int      `json:"files"`
+	Bytes       int64    `json:"bytes"`
+	// Duration is in seconds
+	Duration float64 `json:"duration"`
+	// Restart is none when the target isn't restarted, and skipped when copying failed before it
+	Restart  string `json:"restart"`
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// pushSummary is printed with --output json
+type pushSummary struct {
+	Status   string       `json:"status"`
+	ExitCode int          `json:"exit_code"`
+	Files    int          `json:"files"`
+	Bytes    int64        `json:"bytes"`
+	Duration float64      `json:"duration"`
+	Syncs    []pushResult `json:"syncs"`
+	Error    string       `json:"error,omitempty"`
+}
+
+var pushCmd = &cobra.Command{
+	Use:   "push [<source>... <destination>...]",
+	Short: "Copy a local directory to a container/service once and exit",
+	Long: `Copy a local directory to a container/service once and exit with a non-zero code on failure:
+2 if the destination can't be reached, 3 if copying fails, 4 if restarting the target fails and 1 otherwise.
+With --output json, a summary of the push is printed to stdout, while the log goes to stderr`,
+	Args:              syncArgs,
+	ValidArgsFunction: completeSyncArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			fatal(err)
+		}
+		if output != "text" && output != "json" {
+			fatal(fmt.Errorf("unknown output %q, expected text or json", output))
+		}
+		asJSON := output == "json"
+		if asJSON {
+			// Stdout is left to the summary
+			logOutput = os.Stderr
+		}
+
+		started := time.Now()
+		syncs, err := loadSyncs(cmd, args)
+		if err != nil {
+			if asJSON {
+				printPushSummary(pushSummary{Status: "failed", ExitCode: 1, Syncs: []pushResult{}, Error: err.Error()})
+			}
+			fatal(err)
+		}
+
+		// Every destination is pushed to on its own and reported separately
+		var parts []dockersync.Options
+		for _, options := range syncs {
+			parts = append(parts, options.PerDestination()...)
+		}
+
+		// Syncs are pushed concurrently, the workers they share limit how many containers are copied to at once
+		results := make([]pushResult, len(parts))
+		var wg sync.WaitGroup
+		for i, options := range parts {
+			if asJSON {
+				options.OnProgress = nil
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = push(cmd.Context(), options)
+				if results[i].Error != "" {
+					log.Error("Failed to push {source} to {destination}: {error}", "source", strings.Join(options.SourcePaths(), ", "), "destination", options.Destination, "error", results[i].Error)
+				}
+			}()
+		}
+		wg.Wait()
+
+		summary := pushSummary{Status: "ok", Duration: time.Since(started).Seconds(), Syncs: results}
+		for _, result := range results {
+			summary.Files += result.Files
+			summary.Bytes += result.Bytes
+			if result.ExitCode != 0 && summary.ExitCode == 0 {
+				summary.Status = "failed"
+				summary.ExitCode = result.ExitCode
+			}
+		}
+		if asJSON {
+			printPushSummary(summary)
+		}
+		if summary.ExitCode != 0 {
+			os.Exit(summary.ExitCode)
+		}
+	},
+}
+
+// printPushSummary prints the summary as JSON to stdout
+func printPushSummary(summary pushSummary) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(summary); err != nil {
+		log.Error("Failed to print the summary: {error}", "error", err)
+	}
+}
+
+// push copies the whole sources to the destination, restarting the target afterwards
+// if requested. Unlike watching, it leaves no temporary resources behind
+func push(ctx context.Context, options dockersync.Options) pushResult {
+	started := time.Now()
+	result := pushResult{
+		Sources:     options.SourcePaths(),
+		Destination: options.Destination,
+		Restart:     restartNone,
+	}
+	if options.Restart {
+		result.Restart = restartSkipped
+	}
+	fail := func(exitCode int, err error) pushResult {
+		result.Duration = time.Since(started).Seconds()
+		result.ExitCode = exitCode
+		result.Error = err.Error()
+		return result
+	}
+
+	// With a restart signal, the target is signaled right after the copy of the last source
+	signal := options.RestartSignal != "" || options.RestartMode == syncer.RestartModeSignal
+	restart := options.Restart && !signal
+
+	parts := options.Split()
+	var dockerSyncer *syncer.Syncer
+	for i, part := range parts {
+		part.Restart = signal && options.Restart && i == len(parts)-1
+
+		var source string
+		var err error
+		dockerSyncer, source, err = dockersync.Connect(ctx, part)
+		if err != nil {
+			return fail(exitConnectFailed, err)
+		}
+
+		_, err = dockersync.LoadIndex(part, dockerSyncer)
+		if err != nil {
+			return fail(1, err)
+		}
+
+		log.Info("Pushing {source} to {destination}...", "source", source, "destination", part.Destination)
+		err = dockerSyncer.CopyBatch(ctx, []string{source})
+		// What was copied is cached even if copying failed half-way
+		if cacheErr := dockersync.SaveIndex(part, dockerSyncer); cacheErr != nil {
+			log.Warn("Failed to cache the index of {destination}: {error}", "destination", part.Destination, "error", cacheErr)
+		}
+		stats := dockerSyncer.Stats()
+		result.Files += stats.Files
+		result.Bytes += stats.Bytes
+		if err != nil {
+			return fail(exitCopyFailed, err)
+		}
+	}
+	if signal && options.Restart {
+		result.Restart = restartDone
+	}
+
+	if restart {
+		log.Info("Restarting {destination}...", "destination", options.Destination)
+		err := dockerSyncer.Restart(ctx)
+		if err != nil {
+			result.Restart = restartFailed
+			return fail(exitRestartFailed, err)
+		}
+		result.Restart = restartDone
+	}
+
+	log.Info("Pushed {source} to {destination}", "source", strings.Join(options.SourcePaths(), ", "), "destination", options.Destination)
+	result.Duration = time.Since(started).Seconds()
+	return result
+}
+
+func init() {
+	pushCmd.Flags().String("output", "text", "Output format: text, or json to print a summary of the push to stdout")
+	rootCmd.AddCommand(pushCmd)
+}
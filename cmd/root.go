@@ -1,185 +1,294 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/axtgr/docker-sync/dockercontext"
+	"github.com/axtgr/docker-sync/dockersync"
 	"github.com/axtgr/docker-sync/filewatcher"
+	"github.com/axtgr/docker-sync/hostpath"
+	"github.com/axtgr/docker-sync/logger"
 	"github.com/axtgr/docker-sync/syncer"
 	"github.com/spf13/cobra"
 )
 
-const (
-	ColorReset = "\033[0m"
-	ColorBlue  = "\033[34m"
-)
-
 var rootCmd = &cobra.Command{
-	Use:   "docker-sync <source> <destination>",
-	Short: "Sync files with a remote Docker container/service",
-	Long:  "Watch a local directory and sync its contents with a remote Docker container or service",
-	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
-		absoluteSourcePath, err := filepath.Abs(args[0])
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
-		}
+	Use:               "docker-sync [<source>... <destination>...]",
+	Short:             "Sync files with a remote Docker container/service",
+	Long:              "Watch a local directory and sync its contents with a remote Docker container or service",
+	Args:              syncArgs,
+	ValidArgsFunction: completeSyncArgs,
+	Run:               runWatch,
+}
 
-		destination := args[1]
-		destinationSegments := strings.Split(destination, ":")
+// syncArgs accepts either sources and destinations or no arguments, in which case
+// they are taken from the config file. Sources can also be given with --source
+func syncArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 && cmd.Flags().Changed("source") {
+		return fmt.Errorf("--source requires a destination argument")
+	}
+	if len(args) == 1 && !cmd.Flags().Changed("source") {
+		return fmt.Errorf("accepts either sources and a destination or no arguments with a config file, received 1")
+	}
+	return nil
+}
 
-		if len(destinationSegments) < 2 || destinationSegments[0] == "" || destinationSegments[1] == "" {
-			fmt.Fprintln(os.Stderr, "Destination must be in the following format: <container>:<path>")
-			os.Exit(1)
-		}
+// log is the logger of the CLI, configured from the flags and the config file
+var log, _ = logger.New(logger.Options{})
 
-		destinationTarget := destinationSegments[0]
-		destinationPath := destinationSegments[1]
+// logOutput receives the output of log instead of stdout and stderr when set,
+// e.g. while the terminal is taken by the dashboard
+var logOutput io.Writer
 
-		verbose, err := cmd.Flags().GetBool("verbose")
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
-		}
+func setupLogger(format, level string) error {
+	parsedLevel, err := logger.ParseLevel(level)
+	if err != nil {
+		return err
+	}
 
-		var verboseLogger *log.Logger
-		if verbose {
-			verboseLogger = log.New(os.Stdout, "", 0)
-		} else {
-			verboseLogger = log.New(io.Discard, "", 0)
-		}
+	newLog, err := logger.New(logger.Options{
+		Format: format,
+		Level:  parsedLevel,
+		Stdout: logOutput,
+		Stderr: logOutput,
+	})
+	if err != nil {
+		return err
+	}
 
-		restart, err := cmd.Flags().GetBool("restart")
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+	log = newLog
+	return nil
+}
+
+// fatal logs the error and exits
+func fatal(err error) {
+	log.Error("{error}", "error", err)
+	os.Exit(1)
+}
+
+// loadDockerContext returns the endpoint of the named Docker context, or the current one
+// if the name is empty, reading them from the context store
+func loadDockerContext(name string) (*dockercontext.Endpoint, error) {
+	if name == "" {
+		// Inside WSL without the Docker Desktop integration, only the Windows CLI knows the current context
+		if useWindowsDockerCLI() {
+			host, err := getWindowsContextHost()
+			if err != nil {
+				return nil, err
+			}
+			return &dockercontext.Endpoint{Host: host}, nil
 		}
 
-		dockerHost, err := cmd.Flags().GetString("host")
+		var err error
+		name, err = dockercontext.Current()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+			return nil, err
 		}
+	}
 
-		if dockerHost == "" {
-			cmd := exec.Command("docker", "context", "inspect")
-			output, err := cmd.Output()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Error:", err)
-				os.Exit(1)
-			}
+	return dockercontext.Load(name)
+}
 
-			var contextInfo []struct {
-				Name      string `json:"Name"`
-				Endpoints struct {
-					Docker struct {
-						Host string `json:"Host"`
-					} `json:"docker"`
-				} `json:"Endpoints"`
-			}
-			if err := json.Unmarshal(output, &contextInfo); err != nil {
-				err = fmt.Errorf("failed to parse Docker context: %w", err)
-				fmt.Fprintln(os.Stderr, "Error:", err)
-				os.Exit(1)
-				return
-			}
+// getWindowsContextHost returns the Docker host of the current context of the Windows Docker CLI
+func getWindowsContextHost() (string, error) {
+	cmd := exec.Command("docker.exe", "context", "inspect")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
 
-			if len(contextInfo) == 0 {
-				fmt.Fprintln(os.Stderr, "Error: no Docker context found")
-				os.Exit(1)
-			}
+	var contextInfo []struct {
+		Name      string `json:"Name"`
+		Endpoints struct {
+			Docker struct {
+				Host string `json:"Host"`
+			} `json:"docker"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(output, &contextInfo); err != nil {
+		return "", fmt.Errorf("failed to parse Docker context: %w", err)
+	}
 
-			dockerHost = contextInfo[0].Endpoints.Docker.Host
-		}
+	if len(contextInfo) == 0 {
+		return "", fmt.Errorf("no Docker context found")
+	}
 
-		dockerSyncer, err := syncer.New(syncer.Options{
-			Target:        destinationTarget,
-			TargetPath:    destinationPath,
-			RestartTarget: restart,
-			Host:          dockerHost,
-			Logger:        verboseLogger,
-			Identifier:    "docker-sync",
-		})
+	return contextInfo[0].Endpoints.Docker.Host, nil
+}
 
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+// getPodmanHost returns the host of Podman from CONTAINER_HOST, the default connection
+// of podman-remote or the socket of the local Podman service, preferring the rootless one
+func getPodmanHost() (string, error) {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host, nil
+	}
+
+	if output, err := exec.Command("podman", "system", "connection", "list", "--format", "json").Output(); err == nil {
+		var connections []struct {
+			URI     string `json:"URI"`
+			Default bool   `json:"Default"`
 		}
-		err = dockerSyncer.Connect()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+		if err := json.Unmarshal(output, &connections); err != nil {
+			return "", fmt.Errorf("failed to parse Podman connections: %w", err)
 		}
-		err = dockerSyncer.Init()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
+		for _, connection := range connections {
+			if connection.Default {
+				return connection.URI, nil
+			}
 		}
-		defer dockerSyncer.Cleanup()
+	}
 
-		signals := make(chan os.Signal, 1)
-		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		socket := filepath.Join(runtimeDir, "podman", "podman.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket, nil
+		}
+	}
 
-		go func() {
-			<-signals
-			err := dockerSyncer.Cleanup()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Error while cleaning up:", err)
-			}
-			os.Exit(0)
-		}()
+	return "unix:///run/podman/podman.sock", nil
+}
 
-		fw, err := filewatcher.NewFileWatcher()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
-		}
-		defer fw.Close()
+// useWindowsDockerCLI reports whether docker-sync runs inside WSL where only the Windows
+// Docker CLI is available, whose contexts are stored on the Windows side
+func useWindowsDockerCLI() bool {
+	if _, err := exec.LookPath("docker"); err == nil || !hostpath.RunningInWSL() {
+		return false
+	}
+	_, err := exec.LookPath("docker.exe")
+	return err == nil
+}
 
-		err = fw.AddWatch(absoluteSourcePath)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
-		}
+// checkHostReachable reports hosts that can't be reached across the Windows/WSL boundary
+func checkHostReachable(host string) error {
+	if runtime.GOOS == "windows" && strings.HasPrefix(host, "unix://") {
+		return fmt.Errorf("the Docker host %s is a Unix socket, which is not reachable from Windows. If the daemon runs inside WSL, expose it over tcp:// or run docker-sync inside WSL", host)
+	}
+	if hostpath.RunningInWSL() && strings.HasPrefix(host, "npipe://") {
+		return fmt.Errorf("the Docker host %s is a Windows named pipe, which is not reachable from WSL. Enable the WSL integration of Docker Desktop or pass a tcp:// host with --host", host)
+	}
+	return nil
+}
 
-		fmt.Printf("Syncing %s%s%s to %s%s%s\n", ColorBlue, absoluteSourcePath, ColorReset, ColorBlue, destination, ColorReset)
-
-		for {
-			select {
-			case event := <-fw.Events:
-				if event.Has(filewatcher.Create) || event.Has(filewatcher.Write) {
-					fmt.Printf("Copying %s to %s...\n", event.Name, destinationPath)
-					err := dockerSyncer.Copy(event.Name, event.Op)
-					fmt.Printf("Copied %s to %s\n", event.Name, destinationPath)
-					if err != nil {
-						fmt.Fprintln(os.Stderr, "Error:", err)
-					}
-				}
-			case err := <-fw.Errors:
-				fmt.Fprintln(os.Stderr, "Error:", err)
-			}
-		}
-	},
+// cleanupTimeout limits how long cleaning up after an interrupt can take
+const cleanupTimeout = 30 * time.Second
+
+// cleanupContext returns a context for cleaning up once the main one is canceled.
+// It expires after cleanupTimeout or when the process is interrupted again
+func cleanupContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
 }
 
+// Execute runs the CLI. Interrupting the process cancels the context of the command,
+// aborting the operations in progress
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	sessionArgs = os.Args[1:]
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func init() {
-	rootCmd.Flags().BoolP("restart", "r", false, "Restart container/service on changes")
-	rootCmd.Flags().Bool("verbose", false, "Log every interaction with Docker")
-	rootCmd.Flags().StringP("host", "H", "", "Docker host to use")
+	rootCmd.Flags().Bool("tui", false, tuiUsage)
+	rootCmd.Flags().String("session", "", sessionUsage)
+	rootCmd.Flags().StringArray("notify", nil, notifyUsage)
+	rootCmd.Flags().String("on-sync", "", onSyncUsage)
+	rootCmd.Flags().String("on-error", "", onErrorUsage)
+	rootCmd.Flags().String("on-restart", "", onRestartUsage)
+	rootCmd.Flags().Int("livereload-port", 0, liveReloadUsage)
+	rootCmd.Flags().Bool("stdin-trigger", false, stdinTriggerUsage)
+	rootCmd.Flags().Bool("no-watch", false, noWatchUsage)
+	rootCmd.Flags().Bool("exit-after-sync", false, exitAfterSyncUsage)
+	rootCmd.Flags().Bool("logs", false, logsUsage)
+	rootCmd.PersistentFlags().BoolP("restart", "r", false, "Restart container/service on changes")
+	rootCmd.PersistentFlags().String("restart-signal", "", "Restart container/service on changes by sending it this signal (e.g. SIGHUP) instead of recreating it")
+	rootCmd.PersistentFlags().String("restart-mode", "", "How to restart: recreate the containers, restart the container in place keeping its ID, or signal it (SIGHUP unless --restart-signal is given) (default: signal with --restart-signal, otherwise recreate)")
+	rootCmd.PersistentFlags().String("restart-method", "", "How services are made to replace their tasks: force-update, env-bump (sets DOCKER_SYNC_TS in their environment) or image-label (sets a label of their containers) (default: force-update)")
+	rootCmd.PersistentFlags().String("strategy", "", "How files get into the target: direct-copy, copy+recreate, volume+service-update or exec-extract (default: picked by the target and --restart)")
+	rootCmd.PersistentFlags().String("transport", "api", "How archives get into containers: through the archive API of the daemon, or streamed into tar run in the containers with exec, for daemons whose copy endpoints are disabled (same as --strategy exec-extract)")
+	rootCmd.PersistentFlags().Bool("verbose", false, "Log every interaction with Docker (same as --log-level debug)")
+	rootCmd.PersistentFlags().Bool("trace", false, "Log every request to the Docker API with its status and how long it took, along with what --verbose logs")
+	rootCmd.PersistentFlags().String("log-level", "info", "Minimum level of logged messages: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", logger.FormatText, "Format of logged messages: text or json")
+	rootCmd.PersistentFlags().StringP("host", "H", "", "Docker host to use")
+	rootCmd.PersistentFlags().String("context", "", "Docker context to use instead of the current one")
+	rootCmd.PersistentFlags().Bool("tlsverify", false, "Use TLS and verify the certificate of the host")
+	rootCmd.PersistentFlags().String("tlscacert", "", "Trust certs signed only by this CA (default: $DOCKER_CERT_PATH/ca.pem)")
+	rootCmd.PersistentFlags().String("tlscert", "", "Path to TLS certificate file (default: $DOCKER_CERT_PATH/cert.pem)")
+	rootCmd.PersistentFlags().String("tlskey", "", "Path to TLS key file (default: $DOCKER_CERT_PATH/key.pem)")
+	rootCmd.PersistentFlags().String("ssh-identity", "", "Private key to authenticate with on ssh:// hosts")
+	rootCmd.PersistentFlags().StringArray("ssh-option", nil, "Option to pass to ssh for ssh:// hosts, e.g. ProxyJump=bastion (can be repeated)")
+	rootCmd.PersistentFlags().String("ssh-bastion", "", "Reach a tcp:// host through this SSH server, given as [user@]host[:port]")
+	rootCmd.PersistentFlags().Bool("insecure", false, "Allow sending files to a tcp:// host unencrypted, without TLS or --ssh-bastion")
+	rootCmd.PersistentFlags().Bool("ssh-multiplex", false, "Share one SSH connection between all requests to an ssh:// host")
+	rootCmd.PersistentFlags().String("engine", string(syncer.Docker), "Container engine running the target: docker or podman")
+	rootCmd.PersistentFlags().Duration("batch-interval", 200*time.Millisecond, "Wait this long for more changes before syncing them together")
+	rootCmd.PersistentFlags().Duration("shutdown-timeout", 10*time.Second, "On exit, wait this long for the sync in progress and the pending changes to finish before cleaning up (0 drops them)")
+	rootCmd.PersistentFlags().Duration("debounce", filewatcher.DefaultDebounce, "Wait until a file hasn't changed for this long before reporting the change")
+	rootCmd.PersistentFlags().Duration("settle", 0, "Hold back all changes until the source hasn't changed for this long, e.g. during builds")
+	rootCmd.PersistentFlags().String("watch-mode", filewatcher.ModeNotify, "How to detect changes: notify or poll, for file systems without change notifications")
+	rootCmd.PersistentFlags().Duration("poll-interval", filewatcher.DefaultPollInterval, "How often to scan the source with --watch-mode poll")
+	rootCmd.PersistentFlags().Bool("poll-fallback", false, "Poll the directories that can't be watched once the inotify watch limit is reached, instead of failing")
+	rootCmd.PersistentFlags().String("exec-before", "", "Shell command to run in the target container before each sync")
+	rootCmd.PersistentFlags().String("exec-after", "", "Shell command to run in the target container after each sync")
+	rootCmd.PersistentFlags().Int("retries", 5, "How many times to retry an operation when Docker is unreachable")
+	rootCmd.PersistentFlags().Duration("retry-delay", time.Second, "Delay before the first retry, doubled for every next one")
+	rootCmd.PersistentFlags().Bool("progress", true, "Show a progress bar for large uploads when running in a terminal")
+	rootCmd.PersistentFlags().StringP("config", "c", "", "Path to a YAML or TOML config file (default: docker-sync.yml, docker-sync.yaml or docker-sync.toml in the working directory)")
+	rootCmd.PersistentFlags().String("links", syncer.LinksPreserve, "How to copy symlinks: preserve, follow (copy what they point to) or skip")
+	rootCmd.PersistentFlags().String("case-collisions", syncer.CollisionsWarn, "What to do with paths differing from others only in case or Unicode normalization: warn, skip or error")
+	rootCmd.PersistentFlags().String("normalize", syncer.NormalizeNone, "Unicode normalization of file names in the target: none, nfc or nfd")
+	rootCmd.PersistentFlags().String("chown", "", "Make synced files owned by this user[:group] in the target (names or IDs), or auto for the user the target runs as")
+	rootCmd.PersistentFlags().String("chmod", "", "Set permissions of synced files, e.g. D755,F644 for directories and files or 644 for both")
+	rootCmd.PersistentFlags().String("umask", "", "Umask of the users of the target, e.g. 022: synced files and directories others can't read (like 0700) get its permissions, and so do their parent directories")
+	rootCmd.PersistentFlags().String("bwlimit", "0", "Limit how fast all the syncs upload together, e.g. 5MB/s, 0 for no limit")
+	rootCmd.PersistentFlags().String("chunk-size", "0", "Upload archives larger than this, e.g. 64MB, in chunks that are resumed after a lost connection instead of sent again, 0 to never split them")
+	rootCmd.PersistentFlags().String("compress", syncer.CompressNone, "Compress uploads with none, gzip or zstd, which speeds up syncing over slow connections")
+	rootCmd.PersistentFlags().Int("parallel", syncer.DefaultParallel, "How many containers to copy to, restart or run commands in at once, across all syncs")
+	rootCmd.PersistentFlags().Bool("respect-gitignore", false, "Exclude paths ignored by .gitignore files in the source")
+	rootCmd.PersistentFlags().Bool("atomic-files", false, "Upload files into a staging directory in the target and move each into place once it's complete, so that no file is seen half-written (the files are replaced one by one, not as a tree)")
+	rootCmd.PersistentFlags().Bool("xattrs", false, "Copy extended attributes of files, such as file capabilities and ACLs, which takes a few more system calls per file")
+	rootCmd.PersistentFlags().Bool("flatten", false, "Copy files directly into the destination path, leaving out the subdirectories of the source they're in")
+	rootCmd.PersistentFlags().String("stopped-target", syncer.StoppedTargetQueue, "What to do with changes while the target container is stopped: queue them until it starts again, start it, or copy them into it anyway")
+	rootCmd.PersistentFlags().Bool("mkdir", false, "Create the destination path when it doesn't exist in the target, instead of failing")
+	rootCmd.PersistentFlags().String("max-file-size", "0", "Abort (or warn, see --limit-action) when a file to sync is larger than this, e.g. 100MB, 0 for no limit")
+	rootCmd.PersistentFlags().String("max-total-size", "2GB", "Abort (or warn) when the files to sync take more than this in total, 0 for no limit")
+	rootCmd.PersistentFlags().Int("max-files", 100000, "Abort (or warn) when there are more files than this to sync, 0 for no limit")
+	rootCmd.PersistentFlags().String("limit-action", syncer.LimitAbort, "What to do when a limit is exceeded: abort or warn")
+	rootCmd.PersistentFlags().Bool("index-cache", false, "Keep the checksums of copied files under ~/.docker-sync between runs, and on start copy only the files that changed since the last run")
+	rootCmd.PersistentFlags().Bool("resync-on-start", false, "Sync everything again when the target is started outside of docker-sync, e.g. after it crashed")
+	rootCmd.PersistentFlags().String("on-rebuild", "", "Recreate the target container and sync everything again when this image is rebuilt (auto for the image of the target)")
+	rootCmd.PersistentFlags().Int("task-slot", 0, "Copy into the replica of a service in this slot instead of the first running one, same as <service>.<slot> as the destination")
+	rootCmd.PersistentFlags().String("node", "", "Copy into a replica of a service running on this node (hostname or ID)")
+	rootCmd.PersistentFlags().Bool("agent", false, "Copy to a service through agents deployed on every node of the swarm, so that replicas on all nodes are synced")
+	rootCmd.PersistentFlags().String("agent-image", syncer.DefaultAgentImage, "Image of the agents, it needs sh, tar and nc with -e")
+	rootCmd.PersistentFlags().Int("agent-port", syncer.DefaultAgentPort, "Port the agents are published on on every node")
+	rootCmd.PersistentFlags().String("volume-driver", "", "Driver of the temporary volumes files are copied into, e.g. for NFS (default: local)")
+	rootCmd.PersistentFlags().StringArray("volume-opt", nil, "Option of the driver of the temporary volumes, e.g. type=tmpfs (can be repeated)")
+	rootCmd.PersistentFlags().String("helper-image", syncer.TemporaryContainerImage, "Image of the helper containers files are copied through for services restarted with --restart and volumes, it's never started")
+	rootCmd.PersistentFlags().String("helper-pull", syncer.PullMissing, "When to pull the helper image: missing, always or never (for hosts without access to a registry)")
+	rootCmd.PersistentFlags().String("helper-platform", "", "Platform to pull the helper image for, e.g. linux/arm64 (default: the platform of the daemon)")
+	rootCmd.PersistentFlags().StringArray("label", nil, "Sync to every running container with this label (key or key=value, can be repeated), the destination is then just a path")
+	rootCmd.PersistentFlags().StringArray("source", nil, "Directory to sync along with the source arguments (can be repeated). Several sources are synced into subdirectories of the destination named after them")
+	rootCmd.PersistentFlags().StringArrayP("exclude", "e", nil, "Exclude paths matching a gitignore-style pattern (can be repeated)")
+	rootCmd.PersistentFlags().StringArray("include", nil, "Only sync changes to paths matching a gitignore-style pattern (can be repeated), the initial sync still copies everything")
+	rootCmd.PersistentFlags().StringSlice("events", []string{dockersync.EventCreate, dockersync.EventWrite, dockersync.EventChmod}, "Kinds of changes that trigger syncing: create, write, remove and chmod")
 }
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/spf13/cobra"
+)
+
+// sessionSaveDelay is how long changes to the state of a session are collected before saving it,
+// so that a busy sync doesn't write the file after every copy
+const sessionSaveDelay = time.Second
+
+// sessionNamePattern is what names of sessions look like, since they name their state files
+var sessionNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// sessionArgs are the arguments docker-sync runs with, saved in the state of sessions to resume them with
+var sessionArgs []string
+
+// sessionState is saved while a named session runs, so that resume can continue it
+// after docker-sync crashed or the machine rebooted
+type sessionState struct {
+	// Args are the arguments docker-sync was run with in the working directory Dir
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+	// Owner is the <hostname>:<pid> of the process running the session
+	Owner string    `json:"owner"`
+	Saved time.Time `json:"saved"`
+	// Syncs are the states of the syncers by their source and destination
+	Syncs map[string]syncer.State `json:"syncs"`
+}
+
+// resources returns the names and IDs of the temporary containers and volumes of the session
+func (state *sessionState) resources() []string {
+	var resources []string
+	for _, sync := range state.Syncs {
+		if sync.TemporaryContainer != "" {
+			resources = append(resources, sync.TemporaryContainer)
+		}
+		if sync.TemporaryVolume != "" {
+			resources = append(resources, sync.TemporaryVolume)
+		}
+	}
+	return resources
+}
+
+// sessionsDir returns the directory of the state files of sessions
+func sessionsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the home directory: %w", err)
+	}
+	return filepath.Join(home, ".docker-sync", "sessions"), nil
+}
+
+// sessionPath returns the path of the state file of the session
+func sessionPath(name string) (string, error) {
+	if !sessionNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid session name %q, use letters, digits, dots, dashes and underscores", name)
+	}
+	dir, err := sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// loadSession reads the saved state of the session, returning nil if there's none
+func loadSession(name string) (*sessionState, error) {
+	path, err := sessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %w", name, err)
+	}
+
+	var state sessionState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse session %s in %s: %w", name, path, err)
+	}
+	return &state, nil
+}
+
+// listSessions returns the names of the saved sessions
+func listSessions() ([]string, error) {
+	dir, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// savedResources returns the temporary resources of all saved sessions, which are left behind
+// by crashed runs on purpose
+func savedResources() []string {
+	names, err := listSessions()
+	if err != nil {
+		log.Debug("Failed to list sessions: {error}", "error", err)
+		return nil
+	}
+
+	var resources []string
+	for _, name := range names {
+		state, err := loadSession(name)
+		if err != nil || state == nil {
+			log.Debug("Failed to load session {session}: {error}", "session", name, "error", err)
+			continue
+		}
+		resources = append(resources, state.resources()...)
+	}
+	return resources
+}
+
+// checkSessionIdle fails if the process that saved the session may still be running it
+func checkSessionIdle(name string, state *sessionState) error {
+	if !syncer.OwnerGone(state.Owner) {
+		return fmt.Errorf("session %s is run by %s, which is still running or on another machine", name, state.Owner)
+	}
+	return nil
+}
+
+// session saves the state of the syncs of a named session as they go,
+// and removes it once they're done
+type session struct {
+	name  string
+	path  string
+	state sessionState
+
+	mu      sync.Mutex
+	syncers []dockersync.Syncer
+	timer   *time.Timer
+	// removed is set once the state file is removed, so that it's not saved again
+	removed bool
+}
+
+// newSession starts saving the state of the session
+func newSession(name string) (*session, error) {
+	path, err := sessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the directory of sessions: %w", err)
+	}
+
+	return &session{
+		name: name,
+		path: path,
+		state: sessionState{
+			Args:  sessionArgs,
+			Dir:   dir,
+			Owner: syncer.ProcessOwner(),
+		},
+	}, nil
+}
+
+// add saves the state of the syncer along with the others
+func (s *session) add(dockerSyncer dockersync.Syncer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncers = append(s.syncers, dockerSyncer)
+}
+
+// track returns onEvent calling changed after copies and restarts, which change the state
+func (s *session) track(onEvent func(dockersync.Event)) func(dockersync.Event) {
+	return func(event dockersync.Event) {
+		if event.Type == dockersync.Copied || event.Type == dockersync.Restarted {
+			s.changed()
+		}
+		if onEvent != nil {
+			onEvent(event)
+		}
+	}
+}
+
+// changed saves the state soon, along with the other changes until then
+func (s *session) changed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		return
+	}
+	s.timer = time.AfterFunc(sessionSaveDelay, func() {
+		s.mu.Lock()
+		s.timer = nil
+		s.mu.Unlock()
+		s.save()
+	})
+}
+
+// save writes the state of the syncs to the state file, replacing it at once
+// so that a crash while writing doesn't corrupt it
+func (s *session) save() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.removed {
+		return
+	}
+
+	s.state.Saved = time.Now()
+	s.state.Syncs = make(map[string]syncer.State)
+	for _, dockerSyncer := range s.syncers {
+		for key, state := range dockerSyncer.State() {
+			s.state.Syncs[key] = state
+		}
+	}
+
+	data, err := json.Marshal(s.state)
+	if err == nil {
+		temporaryPath := s.path + ".tmp"
+		err = os.WriteFile(temporaryPath, data, 0o600)
+		if err == nil {
+			err = os.Rename(temporaryPath, s.path)
+		}
+	}
+	if err != nil {
+		log.Warn("Failed to save session {session}: {error}", "session", s.name, "error", err)
+	}
+}
+
+// remove stops saving the state and removes the state file, once the syncs cleaned up after themselves
+func (s *session) remove() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.removed = true
+	err := os.Remove(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn("Failed to remove session {session}: {error}", "session", s.name, "error", err)
+	}
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume <session>",
+	Short: "Continue a named session after docker-sync crashed or the machine rebooted",
+	Long: `Run docker-sync again with the arguments and in the directory of a session started with --session,
+reusing its temporary resources and copying only the files that changed since. Without a session, lists the saved ones`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			names, err := listSessions()
+			if err != nil {
+				fatal(err)
+			}
+			for _, name := range names {
+				fmt.Println(name)
+			}
+			return
+		}
+
+		state, err := loadSession(args[0])
+		if err != nil {
+			fatal(err)
+		}
+		if state == nil {
+			fatal(fmt.Errorf("there's no saved session %s", args[0]))
+		}
+		err = checkSessionIdle(args[0], state)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = os.Chdir(state.Dir)
+		if err != nil {
+			fatal(fmt.Errorf("failed to change to the directory of session %s: %w", args[0], err))
+		}
+
+		// The arguments include --session, so the run picks up the saved state
+		sessionArgs = state.Args
+		rootCmd.SetArgs(state.Args)
+		err = rootCmd.ExecuteContext(cmd.Context())
+		if err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resumeCmd)
+}
@@ -0,0 +1,1205 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/axtgr/docker-sync/config"
+	"github.com/axtgr/docker-sync/dockercontext"
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/logger"
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/spf13/cobra"
+)
+
+// loadConfig loads the config file and sets up the logger. The config is empty if there is no file
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	logFormat, err := cmd.Flags().GetString("log-format")
+	if err != nil {
+		return nil, err
+	}
+
+	logLevel, err := cmd.Flags().GetString("log-level")
+	if err != nil {
+		return nil, err
+	}
+
+	err = setupLogger(logFormat, logLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg *config.Config
+	if configPath != "" {
+		cfg, err = config.Load(configPath)
+	} else {
+		cfg, err = config.LoadDefault(".")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+
+	verbose, err := cmd.Flags().GetBool("verbose")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("verbose") {
+		verbose = cfg.Verbose
+	}
+	trace, err := resolveTrace(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logFormat = resolveLogFormat(cmd, cfg)
+	if !cmd.Flags().Changed("log-level") && cfg.LogLevel != "" {
+		logLevel = cfg.LogLevel
+	}
+	if verbose || trace {
+		logLevel = "debug"
+	}
+
+	err = setupLogger(logFormat, logLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// resolveTrace reports whether requests to the Docker API are logged
+func resolveTrace(cmd *cobra.Command, cfg *config.Config) (bool, error) {
+	trace, err := cmd.Flags().GetBool("trace")
+	if err != nil {
+		return false, err
+	}
+	if !cmd.Flags().Changed("trace") {
+		trace = cfg.Trace
+	}
+	return trace, nil
+}
+
+// resolveLogFormat returns the log format from the flags or the config file
+func resolveLogFormat(cmd *cobra.Command, cfg *config.Config) string {
+	logFormat, _ := cmd.Flags().GetString("log-format")
+	if !cmd.Flags().Changed("log-format") && cfg.LogFormat != "" {
+		logFormat = cfg.LogFormat
+	}
+	return logFormat
+}
+
+// resolveEngine returns the container engine from the flags or the config file
+func resolveEngine(cmd *cobra.Command, cfg *config.Config) syncer.Engine {
+	engine, _ := cmd.Flags().GetString("engine")
+	if !cmd.Flags().Changed("engine") && cfg.Engine != "" {
+		engine = cfg.Engine
+	}
+	return syncer.Engine(engine)
+}
+
+// resolveHost returns the host of the container engine and its TLS config from the flags,
+// the config file, the selected or current Docker context or the Podman connections
+func resolveHost(cmd *cobra.Command, cfg *config.Config) (string, *syncer.TLSConfig, error) {
+	dockerHost, err := cmd.Flags().GetString("host")
+	if err != nil {
+		return "", nil, err
+	}
+	if !cmd.Flags().Changed("host") {
+		dockerHost = cfg.Host
+	}
+
+	contextName, err := cmd.Flags().GetString("context")
+	if err != nil {
+		return "", nil, err
+	}
+	if !cmd.Flags().Changed("context") {
+		contextName = cfg.Context
+	}
+	if dockerHost != "" && contextName != "" {
+		return "", nil, fmt.Errorf("a host and a Docker context can't be used together")
+	}
+
+	var tlsConfig *syncer.TLSConfig
+	// The TLS flags apply to hosts given directly and to DOCKER_HOST, as with the Docker CLI
+	applyTLSFlags := dockerHost != ""
+	if dockerHost == "" && contextName == "" && resolveEngine(cmd, cfg) == syncer.Podman {
+		dockerHost, err = getPodmanHost()
+		if err != nil {
+			return "", nil, err
+		}
+	} else if dockerHost == "" {
+		endpoint, err := loadDockerContext(contextName)
+		if err != nil {
+			return "", nil, err
+		}
+		dockerHost = endpoint.Host
+		if endpoint.HasTLS() {
+			tlsConfig = &syncer.TLSConfig{
+				CAFile:     endpoint.CAFile,
+				CertFile:   endpoint.CertFile,
+				KeyFile:    endpoint.KeyFile,
+				SkipVerify: endpoint.SkipTLSVerify,
+			}
+		}
+		applyTLSFlags = endpoint.Name == dockercontext.DefaultName
+	}
+
+	if applyTLSFlags {
+		flagsTLSConfig, err := resolveTLS(cmd, cfg)
+		if err != nil {
+			return "", nil, err
+		}
+		if flagsTLSConfig != nil {
+			tlsConfig = flagsTLSConfig
+		}
+	}
+
+	if err := checkHostReachable(dockerHost); err != nil {
+		return "", nil, err
+	}
+
+	return dockerHost, tlsConfig, nil
+}
+
+// resolveTLS returns the TLS config from the flags, the config file and the DOCKER_TLS_VERIFY
+// and DOCKER_CERT_PATH variables, or nil if TLS isn't used. As with the Docker CLI, certificates
+// are taken from DOCKER_CERT_PATH (~/.docker by default) unless given, and without --tlsverify
+// the certificate of the host isn't verified
+func resolveTLS(cmd *cobra.Command, cfg *config.Config) (*syncer.TLSConfig, error) {
+	tlsVerify, err := cmd.Flags().GetBool("tlsverify")
+	if err != nil {
+		return nil, err
+	}
+	useTLS := cmd.Flags().Changed("tlsverify")
+	if !useTLS && cfg.TLSVerify != nil {
+		tlsVerify = *cfg.TLSVerify
+		useTLS = true
+	}
+	if !useTLS && os.Getenv("DOCKER_TLS_VERIFY") != "" {
+		tlsVerify = true
+		useTLS = true
+	}
+
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	if certPath == "" {
+		certPath, err = dockercontext.ConfigDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig := &syncer.TLSConfig{SkipVerify: !tlsVerify}
+	for _, file := range []struct {
+		flag       string
+		configured string
+		name       string
+		path       *string
+	}{
+		{"tlscacert", cfg.TLSCACert, "ca.pem", &tlsConfig.CAFile},
+		{"tlscert", cfg.TLSCert, "cert.pem", &tlsConfig.CertFile},
+		{"tlskey", cfg.TLSKey, "key.pem", &tlsConfig.KeyFile},
+	} {
+		path, err := cmd.Flags().GetString(file.flag)
+		if err != nil {
+			return nil, err
+		}
+		if !cmd.Flags().Changed(file.flag) {
+			path = file.configured
+		}
+		if path != "" {
+			// Giving a certificate enables TLS as well
+			useTLS = true
+		} else if _, err := os.Stat(filepath.Join(certPath, file.name)); err == nil {
+			path = filepath.Join(certPath, file.name)
+		}
+		*file.path = path
+	}
+
+	if !useTLS {
+		return nil, nil
+	}
+	return tlsConfig, nil
+}
+
+// resolveTaskSelection returns the slot and the node that replicas of services
+// are picked from, from the flags and the config
+func resolveTaskSelection(cmd *cobra.Command, cfg *config.Config) (int, string, error) {
+	taskSlot, err := cmd.Flags().GetInt("task-slot")
+	if err != nil {
+		return 0, "", err
+	}
+	if !cmd.Flags().Changed("task-slot") && cfg.TaskSlot != nil {
+		taskSlot = *cfg.TaskSlot
+	}
+	if taskSlot < 0 {
+		return 0, "", fmt.Errorf("--task-slot must be positive, got %d", taskSlot)
+	}
+
+	node, err := cmd.Flags().GetString("node")
+	if err != nil {
+		return 0, "", err
+	}
+	if !cmd.Flags().Changed("node") {
+		node = cfg.Node
+	}
+	return taskSlot, node, nil
+}
+
+// agentSettings are how files are copied to services through agents
+type agentSettings struct {
+	enabled bool
+	image   string
+	port    int
+}
+
+// resolveAgent returns the agent settings from the flags and the config
+func resolveAgent(cmd *cobra.Command, cfg *config.Config) (agentSettings, error) {
+	enabled, err := cmd.Flags().GetBool("agent")
+	if err != nil {
+		return agentSettings{}, err
+	}
+	if !cmd.Flags().Changed("agent") && cfg.Agent != nil {
+		enabled = *cfg.Agent
+	}
+
+	image, err := cmd.Flags().GetString("agent-image")
+	if err != nil {
+		return agentSettings{}, err
+	}
+	if !cmd.Flags().Changed("agent-image") && cfg.AgentImage != "" {
+		image = cfg.AgentImage
+	}
+
+	port, err := cmd.Flags().GetInt("agent-port")
+	if err != nil {
+		return agentSettings{}, err
+	}
+	if !cmd.Flags().Changed("agent-port") && cfg.AgentPort != nil {
+		port = *cfg.AgentPort
+	}
+	if port <= 0 || port > 65535 {
+		return agentSettings{}, fmt.Errorf("--agent-port must be between 1 and 65535, got %d", port)
+	}
+
+	return agentSettings{enabled: enabled, image: image, port: port}, nil
+}
+
+// resolveStrategy returns the strategy given with --strategy or implied by --transport,
+// and whether either was given
+func resolveStrategy(cmd *cobra.Command) (string, bool, error) {
+	strategy, err := cmd.Flags().GetString("strategy")
+	if err != nil {
+		return "", false, err
+	}
+
+	transport, err := cmd.Flags().GetString("transport")
+	if err != nil {
+		return "", false, err
+	}
+	switch transport {
+	case "api":
+		if cmd.Flags().Changed("transport") && strategy == syncer.StrategyExecExtract {
+			return "", false, fmt.Errorf("the %s strategy doesn't use the archive API, it can't be combined with --transport api", strategy)
+		}
+	case "exec":
+		// Archives are streamed into tar in the containers, which the exec-extract strategy does
+		if cmd.Flags().Changed("strategy") && strategy != syncer.StrategyExecExtract {
+			return "", false, fmt.Errorf("the %s strategy copies through the archive API, it can't be combined with --transport exec", strategy)
+		}
+		return syncer.StrategyExecExtract, true, nil
+	default:
+		return "", false, fmt.Errorf("unknown transport %s, expected api or exec", transport)
+	}
+	return strategy, cmd.Flags().Changed("strategy"), nil
+}
+
+// resolveLimits returns the limits of what syncs can copy from the flags and the config
+func resolveLimits(cmd *cobra.Command, cfg *config.Config) (syncer.Limits, error) {
+	maxFileSize, err := resolveSize(cmd, "max-file-size", cfg.MaxFileSize)
+	if err != nil {
+		return syncer.Limits{}, err
+	}
+
+	maxTotalSize, err := resolveSize(cmd, "max-total-size", cfg.MaxTotalSize)
+	if err != nil {
+		return syncer.Limits{}, err
+	}
+
+	maxFiles, err := cmd.Flags().GetInt("max-files")
+	if err != nil {
+		return syncer.Limits{}, err
+	}
+	if !cmd.Flags().Changed("max-files") && cfg.MaxFiles != nil {
+		maxFiles = *cfg.MaxFiles
+	}
+	if maxFiles < 0 {
+		return syncer.Limits{}, fmt.Errorf("--max-files can't be negative, got %d", maxFiles)
+	}
+
+	action, err := cmd.Flags().GetString("limit-action")
+	if err != nil {
+		return syncer.Limits{}, err
+	}
+	if !cmd.Flags().Changed("limit-action") && cfg.LimitAction != "" {
+		action = cfg.LimitAction
+	}
+	if action != syncer.LimitAbort && action != syncer.LimitWarn {
+		return syncer.Limits{}, fmt.Errorf("unknown limit action %s, expected %s or %s", action, syncer.LimitAbort, syncer.LimitWarn)
+	}
+
+	return syncer.Limits{
+		MaxFileSize:  maxFileSize,
+		MaxTotalSize: maxTotalSize,
+		MaxFiles:     maxFiles,
+		Action:       action,
+	}, nil
+}
+
+// resolveSize returns the number of bytes of a size flag, or of the config value if the flag isn't set
+func resolveSize(cmd *cobra.Command, flag string, configValue *config.Size) (int64, error) {
+	if !cmd.Flags().Changed(flag) && configValue != nil {
+		return int64(*configValue), nil
+	}
+
+	value, err := cmd.Flags().GetString(flag)
+	if err != nil {
+		return 0, err
+	}
+	size, err := config.ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("--%s: %w", flag, err)
+	}
+	return size, nil
+}
+
+// helperSettings are the image of helper containers and how it's pulled
+type helperSettings struct {
+	image    string
+	pull     string
+	platform string
+}
+
+// resolveHelper returns the helper image settings from the flags and the config
+func resolveHelper(cmd *cobra.Command, cfg *config.Config) (helperSettings, error) {
+	image, err := cmd.Flags().GetString("helper-image")
+	if err != nil {
+		return helperSettings{}, err
+	}
+	if !cmd.Flags().Changed("helper-image") && cfg.HelperImage != "" {
+		image = cfg.HelperImage
+	}
+
+	pull, err := cmd.Flags().GetString("helper-pull")
+	if err != nil {
+		return helperSettings{}, err
+	}
+	if !cmd.Flags().Changed("helper-pull") && cfg.HelperPull != "" {
+		pull = cfg.HelperPull
+	}
+	if pull != syncer.PullMissing && pull != syncer.PullAlways && pull != syncer.PullNever {
+		return helperSettings{}, fmt.Errorf("unknown pull policy %s, expected %s, %s or %s", pull, syncer.PullMissing, syncer.PullAlways, syncer.PullNever)
+	}
+
+	platform, err := cmd.Flags().GetString("helper-platform")
+	if err != nil {
+		return helperSettings{}, err
+	}
+	if !cmd.Flags().Changed("helper-platform") && cfg.HelperPlatform != "" {
+		platform = cfg.HelperPlatform
+	}
+
+	return helperSettings{image: image, pull: pull, platform: platform}, nil
+}
+
+// resolveVolume returns the driver and the options of temporary volumes from the flags and the config
+func resolveVolume(cmd *cobra.Command, cfg *config.Config) (string, map[string]string, error) {
+	driver, err := cmd.Flags().GetString("volume-driver")
+	if err != nil {
+		return "", nil, err
+	}
+	if !cmd.Flags().Changed("volume-driver") && cfg.VolumeDriver != "" {
+		driver = cfg.VolumeDriver
+	}
+
+	if !cmd.Flags().Changed("volume-opt") {
+		return driver, cfg.VolumeOpts, nil
+	}
+	pairs, err := cmd.Flags().GetStringArray("volume-opt")
+	if err != nil {
+		return "", nil, err
+	}
+	options := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return "", nil, fmt.Errorf("volume option %s must be in the following format: <key>=<value>", pair)
+		}
+		options[key] = value
+	}
+	return driver, options, nil
+}
+
+// sshControlPath is where the master connection is shared with --ssh-multiplex. ssh expands
+// ~ and %C (a hash of the connection), keeping the socket path short
+const sshControlPath = "~/.ssh/docker-sync-%C"
+
+// resolveSSHFlags returns the flags to pass to ssh for ssh:// hosts from the flags and the config
+func resolveSSHFlags(cmd *cobra.Command, cfg *config.Config) ([]string, error) {
+	identity, err := cmd.Flags().GetString("ssh-identity")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("ssh-identity") {
+		identity = cfg.SSHIdentity
+	}
+
+	options, err := cmd.Flags().GetStringArray("ssh-option")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("ssh-option") {
+		options = cfg.SSHOptions
+	}
+
+	multiplex, err := cmd.Flags().GetBool("ssh-multiplex")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("ssh-multiplex") && cfg.SSHMultiplex != nil {
+		multiplex = *cfg.SSHMultiplex
+	}
+
+	var flags []string
+	if identity != "" {
+		flags = append(flags, "-i", identity)
+	}
+	for _, option := range options {
+		if !strings.Contains(option, "=") {
+			return nil, fmt.Errorf("ssh option %s must be in the following format: <key>=<value>", option)
+		}
+		flags = append(flags, "-o", option)
+	}
+	if multiplex {
+		// OpenSSH for Windows can't share connections
+		if runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("--ssh-multiplex is not supported on Windows")
+		}
+		flags = append(flags,
+			"-o", "ControlMaster=auto",
+			"-o", "ControlPath="+sshControlPath,
+			"-o", "ControlPersist=60s",
+		)
+	}
+	return flags, nil
+}
+
+// resolveTunnel returns the SSH bastion tcp:// hosts are reached through and whether
+// they may be connected to unencrypted, from the flags and the config
+func resolveTunnel(cmd *cobra.Command, cfg *config.Config) (string, bool, error) {
+	bastion, err := cmd.Flags().GetString("ssh-bastion")
+	if err != nil {
+		return "", false, err
+	}
+	if !cmd.Flags().Changed("ssh-bastion") {
+		bastion = cfg.SSHBastion
+	}
+
+	insecure, err := cmd.Flags().GetBool("insecure")
+	if err != nil {
+		return "", false, err
+	}
+	if !cmd.Flags().Changed("insecure") && cfg.Insecure != nil {
+		insecure = *cfg.Insecure
+	}
+	return bastion, insecure, nil
+}
+
+// resolveNotify returns the endpoints notified of the events of syncs
+func resolveNotify(cmd *cobra.Command) ([]string, error) {
+	endpoints, err := cmd.Flags().GetStringArray("notify")
+	if err != nil {
+		return nil, err
+	}
+	if cmd.Flags().Changed("notify") {
+		return endpoints, nil
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Notify, nil
+}
+
+// resolveHooks returns the hooks run after the events of syncs, nil if there are none
+func resolveHooks(cmd *cobra.Command) (*hooks, error) {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	commands := map[string]*string{"on-sync": &cfg.OnSync, "on-error": &cfg.OnError, "on-restart": &cfg.OnRestart}
+	for flag, command := range commands {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		*command, err = cmd.Flags().GetString(flag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newHooks(cfg.OnSync, cfg.OnError, cfg.OnRestart), nil
+}
+
+// resolveLiveReloadPort returns the port of the LiveReload server, 0 if there's none
+func resolveLiveReloadPort(cmd *cobra.Command) (int, error) {
+	port, err := cmd.Flags().GetInt("livereload-port")
+	if err != nil {
+		return 0, err
+	}
+	if !cmd.Flags().Changed("livereload-port") {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			return 0, err
+		}
+		port = cfg.LiveReloadPort
+	}
+	if port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid LiveReload port %d", port)
+	}
+	return port, nil
+}
+
+// hostOptions returns the options of a syncer connecting to the Docker host given by the flags and the config
+func hostOptions(cmd *cobra.Command, cfg *config.Config) (syncer.Options, error) {
+	options := syncer.Options{Engine: resolveEngine(cmd, cfg)}
+	var err error
+	options.Trace, err = resolveTrace(cmd, cfg)
+	if err != nil {
+		return syncer.Options{}, err
+	}
+	options.Host, options.TLS, err = resolveHost(cmd, cfg)
+	if err != nil {
+		return syncer.Options{}, err
+	}
+	options.SSHFlags, err = resolveSSHFlags(cmd, cfg)
+	if err != nil {
+		return syncer.Options{}, err
+	}
+	options.SSHBastion, options.Insecure, err = resolveTunnel(cmd, cfg)
+	if err != nil {
+		return syncer.Options{}, err
+	}
+	return options, nil
+}
+
+// loadSyncs resolves what to sync from the arguments, the flags and the config file.
+// Arguments and flags take precedence over the file
+func loadSyncs(cmd *cobra.Command, args []string) ([]dockersync.Options, error) {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := cmd.Flags().GetStringArray("source")
+	if err != nil {
+		return nil, err
+	}
+
+	syncs := cfg.Syncs
+	if len(args) > 0 {
+		argSources, destinations := splitArgs(args, len(sources) > 0)
+		sources = append(argSources, sources...)
+		sync := defaultSync(cfg, sources[0], destinations[0])
+		if len(sources) > 1 {
+			sync.Source = ""
+			sync.Sources = sources
+		}
+		if len(destinations) > 1 {
+			sync.Destination = ""
+			sync.Destinations = destinations
+		}
+		syncs = []config.Sync{sync}
+	}
+	if len(syncs) == 0 {
+		return nil, config.ErrNoSyncs
+	}
+
+	return resolveSyncs(cmd, cfg, syncs)
+}
+
+// splitArgs splits the arguments into sources and destinations. The last argument is a destination,
+// and so are the ones right before it that look like destinations rather than local paths.
+// The first argument is a source unless sources are given with --source
+func splitArgs(args []string, sourcesGiven bool) ([]string, []string) {
+	first := 1
+	if sourcesGiven {
+		first = 0
+	}
+
+	i := len(args) - 1
+	for i > first && isDestinationArg(args[i-1]) {
+		i--
+	}
+	return args[:i:i], args[i:]
+}
+
+// isDestinationArg reports whether the argument is a destination, which has a target before a colon,
+// rather than a local path, which exists or has no colon other than the one of a Windows drive
+func isDestinationArg(arg string) bool {
+	if !strings.Contains(strings.TrimPrefix(arg, filepath.VolumeName(arg)), ":") {
+		return false
+	}
+	_, err := os.Stat(arg)
+	return err != nil
+}
+
+// resolveIndexCache returns the directory of the index cache if it's enabled, or an empty string
+func resolveIndexCache(cmd *cobra.Command, cfg *config.Config) (string, error) {
+	enabled, err := cmd.Flags().GetBool("index-cache")
+	if err != nil {
+		return "", err
+	}
+	if !cmd.Flags().Changed("index-cache") {
+		enabled = cfg.IndexCache
+	}
+	if !enabled {
+		return "", nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the home directory for the index cache: %w", err)
+	}
+	return filepath.Join(home, ".docker-sync", "index"), nil
+}
+
+// isDockerDestination reports whether the destination is reached through a Docker host,
+// which Kubernetes destinations and those of registered backends aren't
+func isDockerDestination(destination string) bool {
+	scheme, _, found := strings.Cut(destination, "://")
+	return !strings.HasPrefix(destination, syncer.KubeScheme) && !(found && slices.Contains(syncer.Backends(), scheme))
+}
+
+// defaultSync returns a sync of the source to the destination with the top-level settings of the config
+func defaultSync(cfg *config.Config, source, destination string) config.Sync {
+	return config.Sync{
+		Source:        source,
+		Destination:   destination,
+		Restart:       cfg.Restart,
+		RestartSignal: cfg.RestartSignal,
+		RestartMode:   cfg.RestartMode,
+		Strategy:      cfg.Strategy,
+		Exclude:       cfg.Exclude,
+		Include:       cfg.Include,
+		Labels:        cfg.Labels,
+		Chown:         cfg.Chown,
+		Chmod:         cfg.Chmod,
+		ExecBefore:    cfg.ExecBefore,
+		ExecAfter:     cfg.ExecAfter,
+		Rules:         cfg.Rules,
+		Dependents:    cfg.Dependents,
+		Transforms:    cfg.Transforms,
+	}
+}
+
+// resolveSyncs returns the options of the syncs, applying the flags over their settings
+func resolveSyncs(cmd *cobra.Command, cfg *config.Config, syncs []config.Sync) ([]dockersync.Options, error) {
+	restart, err := cmd.Flags().GetBool("restart")
+	if err != nil {
+		return nil, err
+	}
+
+	trace, err := resolveTrace(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	restartSignal, err := cmd.Flags().GetString("restart-signal")
+	if err != nil {
+		return nil, err
+	}
+
+	restartMode, err := cmd.Flags().GetString("restart-mode")
+	if err != nil {
+		return nil, err
+	}
+
+	strategy, strategyGiven, err := resolveStrategy(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	chown, err := cmd.Flags().GetString("chown")
+	if err != nil {
+		return nil, err
+	}
+
+	chmod, err := cmd.Flags().GetString("chmod")
+	if err != nil {
+		return nil, err
+	}
+
+	excludes, err := cmd.Flags().GetStringArray("exclude")
+	if err != nil {
+		return nil, err
+	}
+
+	includes, err := cmd.Flags().GetStringArray("include")
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := cmd.Flags().GetStringSlice("events")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("events") && len(cfg.Events) > 0 {
+		events = cfg.Events
+	}
+
+	labels, err := cmd.Flags().GetStringArray("label")
+	if err != nil {
+		return nil, err
+	}
+
+	// Kubernetes destinations don't need a Docker host
+	var dockerHost string
+	var tlsConfig *syncer.TLSConfig
+	var sshFlags []string
+	var sshBastion string
+	var insecure bool
+	if slices.ContainsFunc(syncs, func(sync config.Sync) bool {
+		return (sync.Destination != "" && isDockerDestination(sync.Destination)) || slices.ContainsFunc(sync.Destinations, isDockerDestination)
+	}) {
+		dockerHost, tlsConfig, err = resolveHost(cmd, cfg)
+		if err != nil {
+			return nil, err
+		}
+		sshFlags, err = resolveSSHFlags(cmd, cfg)
+		if err != nil {
+			return nil, err
+		}
+		sshBastion, insecure, err = resolveTunnel(cmd, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	respectGitignore, err := cmd.Flags().GetBool("respect-gitignore")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("respect-gitignore") {
+		respectGitignore = cfg.RespectGitignore
+	}
+
+	atomicFiles, err := cmd.Flags().GetBool("atomic-files")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("atomic-files") {
+		atomicFiles = cfg.AtomicFiles
+	}
+
+	xattrs, err := cmd.Flags().GetBool("xattrs")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("xattrs") {
+		xattrs = cfg.Xattrs
+	}
+
+	flatten, err := cmd.Flags().GetBool("flatten")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("flatten") {
+		flatten = cfg.Flatten
+	}
+
+	stoppedTarget, err := cmd.Flags().GetString("stopped-target")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("stopped-target") && cfg.StoppedTarget != "" {
+		stoppedTarget = cfg.StoppedTarget
+	}
+
+	mkdir, err := cmd.Flags().GetBool("mkdir")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("mkdir") {
+		mkdir = cfg.Mkdir
+	}
+
+	limits, err := resolveLimits(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	indexCache, err := resolveIndexCache(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	resyncOnStart, err := cmd.Flags().GetBool("resync-on-start")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("resync-on-start") {
+		resyncOnStart = cfg.ResyncOnStart
+	}
+
+	onRebuild, err := cmd.Flags().GetString("on-rebuild")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("on-rebuild") && cfg.OnRebuild != "" {
+		onRebuild = cfg.OnRebuild
+	}
+
+	links, err := cmd.Flags().GetString("links")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("links") && cfg.Links != "" {
+		links = cfg.Links
+	}
+
+	caseCollisions, err := cmd.Flags().GetString("case-collisions")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("case-collisions") && cfg.CaseCollisions != "" {
+		caseCollisions = cfg.CaseCollisions
+	}
+
+	umask, err := cmd.Flags().GetString("umask")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("umask") {
+		umask = cfg.Umask
+	}
+
+	restartMethod, err := cmd.Flags().GetString("restart-method")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("restart-method") && cfg.RestartMethod != "" {
+		restartMethod = cfg.RestartMethod
+	}
+
+	normalize, err := cmd.Flags().GetString("normalize")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("normalize") && cfg.Normalize != "" {
+		normalize = cfg.Normalize
+	}
+
+	compress, err := cmd.Flags().GetString("compress")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("compress") && cfg.Compress != "" {
+		compress = cfg.Compress
+	}
+
+	parallel, err := cmd.Flags().GetInt("parallel")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("parallel") && cfg.Parallel != nil {
+		parallel = *cfg.Parallel
+	}
+	if parallel < 1 {
+		return nil, fmt.Errorf("--parallel must be at least 1, got %d", parallel)
+	}
+	// Syncs share the workers, so that operations on a container targeted by several of them don't overlap
+	workers := syncer.NewWorkers(parallel)
+
+	bwlimit, err := cmd.Flags().GetString("bwlimit")
+	if err != nil {
+		return nil, err
+	}
+	limit := int64(0)
+	if !cmd.Flags().Changed("bwlimit") && cfg.BWLimit != nil {
+		limit = int64(*cfg.BWLimit)
+	} else if limit, err = config.ParseRate(bwlimit); err != nil {
+		return nil, fmt.Errorf("--bwlimit: %w", err)
+	}
+	// The limit applies to all the syncs together, like the workers
+	bandwidth := syncer.NewBandwidth(limit)
+
+	chunkSize, err := resolveSize(cmd, "chunk-size", cfg.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	batchInterval, err := cmd.Flags().GetDuration("batch-interval")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("batch-interval") && cfg.BatchInterval != nil {
+		batchInterval = time.Duration(*cfg.BatchInterval)
+	}
+
+	shutdownTimeout, err := cmd.Flags().GetDuration("shutdown-timeout")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("shutdown-timeout") && cfg.ShutdownTimeout != nil {
+		shutdownTimeout = time.Duration(*cfg.ShutdownTimeout)
+	}
+
+	debounce, err := cmd.Flags().GetDuration("debounce")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("debounce") && cfg.Debounce != nil {
+		debounce = time.Duration(*cfg.Debounce)
+	}
+
+	settle, err := cmd.Flags().GetDuration("settle")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("settle") && cfg.Settle != nil {
+		settle = time.Duration(*cfg.Settle)
+	}
+
+	watchMode, err := cmd.Flags().GetString("watch-mode")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("watch-mode") && cfg.WatchMode != "" {
+		watchMode = cfg.WatchMode
+	}
+
+	pollInterval, err := cmd.Flags().GetDuration("poll-interval")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("poll-interval") && cfg.PollInterval != nil {
+		pollInterval = time.Duration(*cfg.PollInterval)
+	}
+
+	pollFallback, err := cmd.Flags().GetBool("poll-fallback")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("poll-fallback") {
+		pollFallback = cfg.PollFallback
+	}
+
+	execBefore, err := cmd.Flags().GetString("exec-before")
+	if err != nil {
+		return nil, err
+	}
+
+	execAfter, err := cmd.Flags().GetString("exec-after")
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := cmd.Flags().GetInt("retries")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("retries") && cfg.Retries != nil {
+		retries = *cfg.Retries
+	}
+
+	retryDelay, err := cmd.Flags().GetDuration("retry-delay")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("retry-delay") && cfg.RetryDelay != nil {
+		retryDelay = time.Duration(*cfg.RetryDelay)
+	}
+
+	taskSlot, node, err := resolveTaskSelection(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	agent, err := resolveAgent(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeDriver, volumeOptions, err := resolveVolume(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	helper, err := resolveHelper(cmd, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	showProgress, err := cmd.Flags().GetBool("progress")
+	if err != nil {
+		return nil, err
+	}
+
+	var options []dockersync.Options
+	for _, sync := range syncs {
+		syncRestart := sync.ShouldRestart()
+		if cmd.Flags().Changed("restart") {
+			syncRestart = restart
+		}
+
+		syncRestartSignal := sync.RestartSignal
+		if cmd.Flags().Changed("restart-signal") {
+			syncRestartSignal = restartSignal
+		}
+
+		syncRestartMode := sync.RestartMode
+		if cmd.Flags().Changed("restart-mode") {
+			syncRestartMode = restartMode
+		}
+
+		syncStrategy := sync.Strategy
+		if strategyGiven {
+			syncStrategy = strategy
+		}
+
+		syncLabels := sync.Labels
+		if cmd.Flags().Changed("label") {
+			syncLabels = labels
+		}
+
+		syncChown := sync.Chown
+		if cmd.Flags().Changed("chown") {
+			syncChown = chown
+		}
+
+		syncChmod := sync.Chmod
+		if cmd.Flags().Changed("chmod") {
+			syncChmod = chmod
+		}
+
+		syncExecBefore := sync.ExecBefore
+		if cmd.Flags().Changed("exec-before") {
+			syncExecBefore = execBefore
+		}
+
+		syncExecAfter := sync.ExecAfter
+		if cmd.Flags().Changed("exec-after") {
+			syncExecAfter = execAfter
+		}
+
+		var rules []syncer.Rule
+		for _, rule := range sync.Rules {
+			rules = append(rules, syncer.Rule{Match: rule.Match, Action: rule.Action, Exec: rule.Exec})
+		}
+		var dependents []syncer.Dependent
+		for _, dependent := range sync.Dependents {
+			var timeout time.Duration
+			if dependent.Timeout != nil {
+				timeout = time.Duration(*dependent.Timeout)
+			}
+			dependents = append(dependents, syncer.Dependent{Name: dependent.Name, Wait: dependent.Wait, Timeout: timeout})
+		}
+		var transforms []syncer.Transform
+		for _, transform := range sync.Transforms {
+			transforms = append(transforms, syncer.Transform{Match: transform.Match, Filters: transform.Filters})
+		}
+
+		var onProgress syncer.ProgressFunc
+		if showProgress && resolveLogFormat(cmd, cfg) == logger.FormatText {
+			label := sync.Destination
+			if len(sync.Destinations) > 0 {
+				label = strings.Join(sync.Destinations, ", ")
+			}
+			onProgress = newProgressBar(os.Stdout, label)
+		}
+
+		options = append(options, dockersync.Options{
+			Source:           sync.Source,
+			Sources:          sync.Sources,
+			Destination:      sync.Destination,
+			Destinations:     sync.Destinations,
+			Restart:          syncRestart,
+			RestartSignal:    syncRestartSignal,
+			RestartMode:      syncRestartMode,
+			RestartMethod:    restartMethod,
+			Strategy:         syncStrategy,
+			Excludes:         append(sync.Exclude, excludes...),
+			RespectGitignore: respectGitignore,
+			Includes:         append(sync.Include, includes...),
+			Events:           events,
+			Links:            links,
+			CaseCollisions:   caseCollisions,
+			Normalize:        normalize,
+			Chown:            syncChown,
+			Chmod:            syncChmod,
+			Umask:            umask,
+			Compress:         compress,
+			Workers:          workers,
+			Bandwidth:        bandwidth,
+			ChunkSize:        chunkSize,
+			Host:             dockerHost,
+			TLS:              tlsConfig,
+			SSHFlags:         sshFlags,
+			SSHBastion:       sshBastion,
+			Insecure:         insecure,
+			Engine:           resolveEngine(cmd, cfg),
+			Labels:           syncLabels,
+			TaskSlot:         taskSlot,
+			Node:             node,
+			Agent:            agent.enabled,
+			AgentImage:       agent.image,
+			AgentPort:        agent.port,
+			VolumeDriver:     volumeDriver,
+			VolumeOptions:    volumeOptions,
+			HelperImage:      helper.image,
+			HelperPull:       helper.pull,
+			HelperPlatform:   helper.platform,
+			Logger:           log,
+			Trace:            trace,
+			BatchInterval:    batchInterval,
+			ShutdownTimeout:  shutdownTimeout,
+			Debounce:         debounce,
+			Settle:           settle,
+			WatchMode:        watchMode,
+			PollFallback:     pollFallback,
+			PollInterval:     pollInterval,
+			ExecBefore:       syncExecBefore,
+			ExecAfter:        syncExecAfter,
+			Rules:            rules,
+			Dependents:       dependents,
+			Transforms:       transforms,
+			Retries:          retries,
+			RetryDelay:       retryDelay,
+			OnProgress:       onProgress,
+			AtomicFiles:      atomicFiles,
+			Xattrs:           xattrs,
+			Flatten:          flatten,
+			StoppedTarget:    stoppedTarget,
+			Mkdir:            mkdir,
+			ResyncOnStart:    resyncOnStart,
+			IndexCache:       indexCache,
+			OnRebuild:        onRebuild,
+			Limits:           limits,
+		})
+	}
+
+	return options, nil
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/axtgr/docker-sync/dockersync"
+)
+
+// triggerAll is the line of stdin triggering a full sync
+const triggerAll = "ALL"
+
+// readTriggers syncs the path on every line read from r with the syncers whose sources contain it,
+// or everything on a line of ALL, until r ends or ctx is canceled
+func readTriggers(ctx context.Context, r io.Reader, syncers []dockersync.Syncer) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if line == triggerAll {
+			log.Debug("Syncing everything as requested on stdin")
+			for _, dockerSyncer := range syncers {
+				dockerSyncer.CopyAll()
+			}
+			continue
+		}
+
+		var err error
+		triggered := false
+		for _, dockerSyncer := range syncers {
+			if triggerErr := dockerSyncer.Trigger(line); triggerErr != nil {
+				err = triggerErr
+				continue
+			}
+			triggered = true
+		}
+		if !triggered {
+			log.Warn("Not syncing {path} from stdin: {error}", "path", line, "error", err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Error("Failed to read triggers from stdin: {error}", "error", err)
+		return
+	}
+	log.Debug("Stdin is closed, no more syncs are triggered through it")
+}
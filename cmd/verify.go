@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/syncer"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:               "verify [<source>... <destination>...]",
+	Short:             "Check that a container/service has the same files as a local directory",
+	Long:              "Compare the checksums of the files in a local directory with the ones in a container/service and list differing, missing and extra files. Exits with a non-zero code if they don't match",
+	Args:              syncArgs,
+	ValidArgsFunction: completeSyncArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		syncs, err := loadSyncs(cmd, args)
+		if err != nil {
+			fatal(err)
+		}
+
+		matching := true
+		for _, options := range syncs {
+			// Every source is compared with its own subdirectory of the destination
+			for _, part := range options.Split() {
+				ok, err := verify(cmd.Context(), part)
+				if err != nil {
+					log.Error("Failed to verify {destination}: {error}", "destination", part.Destination, "error", err)
+					matching = false
+					continue
+				}
+				matching = matching && ok
+			}
+		}
+
+		if !matching {
+			os.Exit(1)
+		}
+	},
+}
+
+// verify compares the source with the destination, printing the files that don't match,
+// and reports whether they all do
+func verify(ctx context.Context, options dockersync.Options) (bool, error) {
+	if strings.HasPrefix(options.Destination, syncer.VolumeScheme) {
+		return false, fmt.Errorf("volumes have no running containers to compute checksums in")
+	}
+
+	// Nothing is copied, so the target doesn't have to be prepared for restarts
+	options.Restart = false
+	options.RestartSignal = ""
+	options.Rules = nil
+	options.Agent = false
+
+	dockerSyncer, source, err := dockersync.Connect(ctx, options)
+	if err != nil {
+		return false, err
+	}
+
+	log.Info("Verifying {destination} against {source}...", "source", source, "destination", options.Destination)
+	verification, err := dockerSyncer.Verify(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, filePath := range verification.Differing {
+		fmt.Printf("differs  %s\n", filePath)
+	}
+	for _, filePath := range verification.Missing {
+		fmt.Printf("missing  %s\n", filePath)
+	}
+	for _, filePath := range verification.Extra {
+		fmt.Printf("extra    %s\n", filePath)
+	}
+
+	if verification.OK() {
+		log.Info("All {count} files of {source} match {destination}", "count", verification.Checked, "source", source, "destination", options.Destination)
+		return true, nil
+	}
+	log.Warn("{destination} doesn't match {source}: {differing} differing, {missing} missing and {extra} extra files", "source", source, "destination", options.Destination, "differing", len(verification.Differing), "missing", len(verification.Missing), "extra", len(verification.Extra))
+	return false, nil
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/livereload"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:               "watch [<source>... <destination>...]",
+	Short:             "Watch a local directory and continuously sync it with a container/service",
+	Args:              syncArgs,
+	ValidArgsFunction: completeSyncArgs,
+	Run:               runWatch,
+}
+
+func runWatch(cmd *cobra.Command, args []string) {
+	tui, err := cmd.Flags().GetBool("tui")
+	if err != nil {
+		fatal(err)
+	}
+
+	stdinTrigger, err := cmd.Flags().GetBool("stdin-trigger")
+	if err != nil {
+		fatal(err)
+	}
+	noWatch, err := cmd.Flags().GetBool("no-watch")
+	if err != nil {
+		fatal(err)
+	}
+	if stdinTrigger && tui {
+		fatal(errors.New("--stdin-trigger can't be used with --tui, which reads keys from stdin"))
+	}
+	exitAfterSync, err := cmd.Flags().GetBool("exit-after-sync")
+	if err != nil {
+		fatal(err)
+	}
+	if exitAfterSync && tui {
+		fatal(errors.New("--exit-after-sync can't be used with --tui"))
+	}
+	logs, err := cmd.Flags().GetBool("logs")
+	if err != nil {
+		fatal(err)
+	}
+	if logs && tui {
+		fatal(errors.New("--logs can't be used with --tui, which shows the log of docker-sync instead"))
+	}
+	if noWatch && !stdinTrigger {
+		fatal(errors.New("--no-watch requires --stdin-trigger, otherwise nothing would be synced"))
+	}
+
+	var d *dashboard
+	if tui {
+		d, err = newDashboard(os.Stdin, os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		// The dashboard shows the log while it's running
+		logOutput = d
+	}
+
+	sessionName, err := cmd.Flags().GetString("session")
+	if err != nil {
+		fatal(err)
+	}
+
+	syncs, err := loadSyncs(cmd, args)
+	if err != nil {
+		fatal(err)
+	}
+	warnOverlappingSyncs(syncs)
+	for i := range syncs {
+		syncs[i].NoWatch = noWatch
+		syncs[i].Logs = logs
+	}
+
+	endpoints, err := resolveNotify(cmd)
+	if err != nil {
+		fatal(err)
+	}
+	var n *notifier
+	if len(endpoints) > 0 {
+		n, err = newNotifier(endpoints)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	h, err := resolveHooks(cmd)
+	if err != nil {
+		fatal(err)
+	}
+
+	liveReloadPort, err := resolveLiveReloadPort(cmd)
+	if err != nil {
+		fatal(err)
+	}
+
+	var current *session
+	if sessionName != "" {
+		state, err := loadSession(sessionName)
+		if err != nil {
+			fatal(err)
+		}
+		if state != nil {
+			err = checkSessionIdle(sessionName, state)
+			if err != nil {
+				fatal(err)
+			}
+			log.Info("Resuming session {session} saved at {saved}", "session", sessionName, "saved", state.Saved.Format(time.DateTime))
+			for i := range syncs {
+				syncs[i].Resume = state.Syncs
+			}
+		}
+
+		current, err = newSession(sessionName)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	// Crashed sessions leave their temporary resources behind to be resumed with
+	removeStaleResources(cmd.Context(), syncs, savedResources())
+
+	// Canceling the context stops the pipelines, which clean up before closing their events
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	var reloader *livereload.Server
+	if liveReloadPort != 0 {
+		reloader = livereload.New(liveReloadPort, log)
+		go func() {
+			err := reloader.ListenAndServe(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Error("LiveReload server stopped: {error}", "error", err)
+			}
+		}()
+		log.Info("Reloading browsers connected to port {port} after every sync", "port", liveReloadPort)
+	}
+
+	var once *oneShot
+	if exitAfterSync {
+		once = &oneShot{cancel: cancel}
+		log.Info("Waiting for changes to sync once, then exiting")
+	}
+
+	var wg sync.WaitGroup
+	var syncers []dockersync.Syncer
+	for _, options := range syncs {
+		var row *dashboardRow
+		if d != nil {
+			row = d.add(strings.Join(options.SourcePaths(), ", "), strings.Join(options.DestinationList(), ", "))
+			options.OnProgress = d.progress(row)
+		}
+
+		p, err := newPipeline(ctx, options)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			fatal(err)
+		}
+
+		var onEvent func(dockersync.Event)
+		if row != nil {
+			row.syncer = p.syncer
+			onEvent = func(event dockersync.Event) {
+				d.handle(row, event)
+			}
+		}
+		if current != nil {
+			current.add(p.syncer)
+			onEvent = current.track(onEvent)
+		}
+		if n != nil {
+			onEvent = n.track(p.log.source, p.log.destination, onEvent)
+		}
+		if h != nil {
+			onEvent = h.track(p.log.source, p.log.destination, onEvent)
+		}
+		if reloader != nil {
+			onEvent = reloadBrowsers(reloader, onEvent)
+		}
+		if once != nil {
+			onEvent = once.track(onEvent)
+		}
+
+		syncers = append(syncers, p.syncer)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.run(onEvent)
+		}()
+	}
+
+	if stdinTrigger {
+		go readTriggers(ctx, os.Stdin, syncers)
+	}
+
+	if current != nil {
+		current.save()
+	}
+
+	if d != nil {
+		err := d.start(cancel)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			fatal(err)
+		}
+	}
+	wg.Wait()
+	if n != nil {
+		n.close()
+	}
+	if h != nil {
+		h.close()
+	}
+	if current != nil {
+		current.remove()
+	}
+	if d != nil {
+		d.stop()
+	}
+	if once != nil && once.failed.Load() {
+		os.Exit(1)
+	}
+}
+
+// oneShot stops syncing once the first sync is done, for --exit-after-sync
+type oneShot struct {
+	cancel context.CancelFunc
+	done   atomic.Bool
+	failed atomic.Bool
+}
+
+// track returns onEvent also stopping every sync after the first copy of any of them,
+// which failed if it ended with an error
+func (o *oneShot) track(onEvent func(dockersync.Event)) func(dockersync.Event) {
+	return func(event dockersync.Event) {
+		if onEvent != nil {
+			onEvent(event)
+		}
+
+		// Errors without paths, like those of watching, don't end a copy
+		copyFailed := event.Type == dockersync.Error && len(event.Paths) > 0
+		if event.Type != dockersync.Copied && !copyFailed {
+			return
+		}
+		if o.done.Swap(true) {
+			return
+		}
+		o.failed.Store(copyFailed)
+		o.cancel()
+	}
+}
+
+const tuiUsage = "Show a dashboard of the syncs instead of the log, with keys to pause syncing and sync everything"
+
+const notifyUsage = "Send the start and the end of every sync, restarts and errors as JSON to an http(s):// webhook or a unix://<path> socket (can be repeated)"
+
+const onSyncUsage = "Run this command on the host after every sync, with DOCKER_SYNC_EVENT, DOCKER_SYNC_SOURCE, DOCKER_SYNC_DESTINATION and DOCKER_SYNC_FILES (one per line) in its environment"
+
+const onErrorUsage = "Run this command on the host after every error, with DOCKER_SYNC_ERROR and the variables of --on-sync in its environment"
+
+const onRestartUsage = "Run this command on the host after every restart, with the variables of --on-sync in its environment"
+
+const liveReloadUsage = "Run a LiveReload server on this port (usually 35729) and reload the connected browsers after every sync and restart"
+
+const stdinTriggerUsage = "Sync the path on every line of stdin, or everything on a line of ALL, e.g. to sync after a build step instead of on every change"
+
+const exitAfterSyncUsage = "Wait for the first change, sync it and exit, with status 1 if syncing it failed"
+
+const noWatchUsage = "Don't watch the sources, syncing only what is triggered with --stdin-trigger"
+
+const logsUsage = "Follow the logs of the containers of the target after every restart, each line prefixed with the name of its container"
+
+const sessionUsage = "Name the session and save its state under ~/.docker-sync while it runs, to continue it with docker-sync resume after a crash"
+
+func init() {
+	watchCmd.Flags().Bool("tui", false, tuiUsage)
+	watchCmd.Flags().String("session", "", sessionUsage)
+	watchCmd.Flags().StringArray("notify", nil, notifyUsage)
+	watchCmd.Flags().String("on-sync", "", onSyncUsage)
+	watchCmd.Flags().String("on-error", "", onErrorUsage)
+	watchCmd.Flags().String("on-restart", "", onRestartUsage)
+	watchCmd.Flags().Int("livereload-port", 0, liveReloadUsage)
+	watchCmd.Flags().Bool("stdin-trigger", false, stdinTriggerUsage)
+	watchCmd.Flags().Bool("no-watch", false, noWatchUsage)
+	watchCmd.Flags().Bool("exit-after-sync", false, exitAfterSyncUsage)
+	watchCmd.Flags().Bool("logs", false, logsUsage)
+	rootCmd.AddCommand(watchCmd)
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/axtgr/docker-sync/hostpath"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFileNames are looked up in the working directory when no config file is specified
+var DefaultFileNames = []string{"docker-sync.yml", "docker-sync.yaml", "docker-sync.toml"}
+
+// Config describes a set of sources to watch and the destinations to sync them to.
+// Top-level settings apply to every sync unless the sync overrides them
+type Config struct {
+	Host string `yaml:"host" toml:"host"`
+	// Context is the Docker context to connect to instead of the current one
+	Context string `yaml:"context" toml:"context"`
+	// TLS settings for a tcp:// Host, as with the tlsverify, tlscacert, tlscert and tlskey flags
+	TLSVerify *bool  `yaml:"tls_verify" toml:"tls_verify"`
+	TLSCACert string `yaml:"tls_ca_cert" toml:"tls_ca_cert"`
+	TLSCert   string `yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey    string `yaml:"tls_key" toml:"tls_key"`
+	// SSH settings for an ssh:// Host, as with the ssh-identity, ssh-option and ssh-multiplex flags
+	SSHIdentity  string   `yaml:"ssh_identity" toml:"ssh_identity"`
+	SSHOptions   []string `yaml:"ssh_options" toml:"ssh_options"`
+	SSHMultiplex *bool    `yaml:"ssh_multiplex" toml:"ssh_multiplex"`
+	// SSHBastion is the [user@]host[:port] of an SSH server a tcp:// Host is reached through.
+	// Insecure allows a tcp:// Host without TLS or a bastion
+	SSHBastion string `yaml:"ssh_bastion" toml:"ssh_bastion"`
+	Insecure   *bool  `yaml:"insecure" toml:"insecure"`
+	// Engine is the container engine running the targets, docker or podman
+	Engine  string `yaml:"engine" toml:"engine"`
+	Verbose bool   `yaml:"verbose" toml:"verbose"`
+	// Trace logs every request to the Docker API with its status and duration
+	Trace bool `yaml:"trace" toml:"trace"`
+	// LogLevel is one of debug, info, warn or error, LogFormat is text or json
+	LogLevel  string `yaml:"log_level" toml:"log_level"`
+	LogFormat string `yaml:"log_format" toml:"log_format"`
+	Restart   *bool  `yaml:"restart" toml:"restart"`
+	// RestartSignal restarts the targets by sending them a signal instead of recreating them
+	RestartSignal string `yaml:"restart_signal" toml:"restart_signal"`
+	// RestartMode is recreate, restart (in place) or signal
+	RestartMode string `yaml:"restart_mode" toml:"restart_mode"`
+	// Strategy is how files get into the targets: direct-copy, copy+recreate,
+	// volume+service-update or exec-extract, picked by the target by default
+	Strategy string   `yaml:"strategy" toml:"strategy"`
+	Exclude  []string `yaml:"exclude" toml:"exclude"`
+	// Include limits the changes that trigger syncing to matching paths, Events to create, write, remove and chmod
+	Include []string `yaml:"include" toml:"include"`
+	Events  []string `yaml:"events" toml:"events"`
+	// Labels select the target containers by their labels, the destinations are then paths
+	Labels []string `yaml:"labels" toml:"labels"`
+	// TaskSlot and Node pick the replica of service targets to copy into
+	TaskSlot *int   `yaml:"task_slot" toml:"task_slot"`
+	Node     string `yaml:"node" toml:"node"`
+	// Agent copies to services through agents on every node, running AgentImage and listening on AgentPort
+	Agent      *bool  `yaml:"agent" toml:"agent"`
+	AgentImage string `yaml:"agent_image" toml:"agent_image"`
+	AgentPort  *int   `yaml:"agent_port" toml:"agent_port"`
+	// VolumeDriver and VolumeOpts create the temporary volumes, e.g. local with type: tmpfs
+	VolumeDriver string            `yaml:"volume_driver" toml:"volume_driver"`
+	VolumeOpts   map[string]string `yaml:"volume_opts" toml:"volume_opts"`
+	// HelperImage is the image of helper containers, pulled according to HelperPull
+	// (missing, always or never) for HelperPlatform
+	HelperImage    string `yaml:"helper_image" toml:"helper_image"`
+	HelperPull     string `yaml:"helper_pull" toml:"helper_pull"`
+	HelperPlatform string `yaml:"helper_platform" toml:"helper_platform"`
+	// Links is how symlinks are copied: preserve, follow or skip
+	Links string `yaml:"links" toml:"links"`
+	// CaseCollisions is what to do with paths differing from others only in case or Unicode
+	// normalization: warn, skip or error. Normalize is the normalization of names in the targets:
+	// none, nfc or nfd
+	CaseCollisions string `yaml:"case_collisions" toml:"case_collisions"`
+	Normalize      string `yaml:"normalize" toml:"normalize"`
+	// Chown is the user[:group] owning the synced files in the targets, or auto for the user
+	// the target runs as. Chmod sets their permissions, e.g. D755,F644
+	Chown string `yaml:"chown" toml:"chown"`
+	Chmod string `yaml:"chmod" toml:"chmod"`
+	// Umask is the umask of the users of the targets, e.g. 022, giving its permissions to files
+	// and directories that don't let everyone read them
+	Umask string `yaml:"umask" toml:"umask"`
+	// RestartMethod is how services are made to replace their tasks: force-update, env-bump or image-label
+	RestartMethod string `yaml:"restart_method" toml:"restart_method"`
+	// Notify are the http(s):// webhooks and unix:// sockets getting the events of syncs as JSON
+	Notify []string `yaml:"notify" toml:"notify"`
+	// OnSync, OnError and OnRestart are commands run on the host after syncs, errors and restarts
+	OnSync    string `yaml:"on_sync" toml:"on_sync"`
+	OnError   string `yaml:"on_error" toml:"on_error"`
+	OnRestart string `yaml:"on_restart" toml:"on_restart"`
+	// LiveReloadPort runs a LiveReload server on the port, reloading browsers after every sync
+	LiveReloadPort int `yaml:"livereload_port" toml:"livereload_port"`
+	// BWLimit limits how many bytes per second all the syncs upload together, e.g. 5MB/s
+	BWLimit *Rate `yaml:"bwlimit" toml:"bwlimit"`
+	// ChunkSize uploads archives larger than this in chunks that survive lost connections, e.g. 64MB
+	ChunkSize *Size `yaml:"chunk_size" toml:"chunk_size"`
+	// Compress is none, gzip or zstd
+	Compress string `yaml:"compress" toml:"compress"`
+	// Parallel is how many containers are synced at once, across all syncs
+	Parallel *int `yaml:"parallel" toml:"parallel"`
+	// RespectGitignore excludes everything ignored by .gitignore files in the sources
+	RespectGitignore bool `yaml:"respect_gitignore" toml:"respect_gitignore"`
+	// AtomicFiles moves each synced file into place only once it's fully uploaded
+	AtomicFiles bool `yaml:"atomic_files" toml:"atomic_files"`
+	// Xattrs copies the extended attributes of files, e.g. file capabilities
+	Xattrs bool `yaml:"xattrs" toml:"xattrs"`
+	// Flatten copies files directly into the destination path without their subdirectories
+	Flatten bool `yaml:"flatten" toml:"flatten"`
+	// StoppedTarget is what to do with changes while a target container is stopped: queue, start or copy
+	StoppedTarget string `yaml:"stopped_target" toml:"stopped_target"`
+	// Mkdir creates destination paths missing in the targets
+	Mkdir bool `yaml:"mkdir" toml:"mkdir"`
+	// IndexCache keeps the checksums of copied files between runs, to copy only the changed ones on start
+	IndexCache bool `yaml:"index_cache" toml:"index_cache"`
+	// ResyncOnStart copies the whole sources when their targets are started outside of docker-sync
+	ResyncOnStart bool `yaml:"resync_on_start" toml:"resync_on_start"`
+	// OnRebuild is an image, or auto for the image of the target, whose rebuilds
+	// recreate the target containers and copy the whole sources into them
+	OnRebuild string `yaml:"on_rebuild" toml:"on_rebuild"`
+	// MaxFileSize, MaxTotalSize and MaxFiles limit what a sync can copy, 0 disables a limit.
+	// LimitAction is abort or warn
+	MaxFileSize  *Size  `yaml:"max_file_size" toml:"max_file_size"`
+	MaxTotalSize *Size  `yaml:"max_total_size" toml:"max_total_size"`
+	MaxFiles     *int   `yaml:"max_files" toml:"max_files"`
+	LimitAction  string `yaml:"limit_action" toml:"limit_action"`
+	// BatchInterval is how long to wait for more changes before syncing them together
+	BatchInterval *Duration `yaml:"batch_interval" toml:"batch_interval"`
+	// ShutdownTimeout is how long to wait for pending changes to be synced on exit
+	ShutdownTimeout *Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// Debounce is how long a file has to go without changes before it's synced,
+	// Settle is how long the whole source has to
+	Debounce *Duration `yaml:"debounce" toml:"debounce"`
+	Settle   *Duration `yaml:"settle" toml:"settle"`
+	// WatchMode is notify or poll, PollInterval is how often to scan the sources when polling
+	WatchMode    string    `yaml:"watch_mode" toml:"watch_mode"`
+	PollInterval *Duration `yaml:"poll_interval" toml:"poll_interval"`
+	// PollFallback polls the directories that can't be watched once the inotify limit is reached
+	PollFallback bool   `yaml:"poll_fallback" toml:"poll_fallback"`
+	ExecBefore   string `yaml:"exec_before" toml:"exec_before"`
+	ExecAfter    string `yaml:"exec_after" toml:"exec_after"`
+	// Rules decide what happens after matching paths are copied, the first matching rule applies
+	Rules []Rule `yaml:"rules" toml:"rules"`
+	// Dependents are containers or services restarted one after another once the target restarts
+	Dependents []Dependent `yaml:"dependents" toml:"dependents"`
+	// Transforms change the contents of matching files before they're copied, the first matching one applies
+	Transforms []Transform `yaml:"transforms" toml:"transforms"`
+	// Retries is how many times to retry when Docker is unreachable, starting after RetryDelay
+	Retries     *int      `yaml:"retries" toml:"retries"`
+	RetryDelay  *Duration `yaml:"retry_delay" toml:"retry_delay"`
+	Source      string    `yaml:"source" toml:"source"`
+	Destination string    `yaml:"destination" toml:"destination"`
+	Syncs       []Sync    `yaml:"syncs" toml:"syncs"`
+}
+
+type Sync struct {
+	Source string `yaml:"source" toml:"source"`
+	// Sources are synced instead of Source, each into the subdirectory of the destination named after it
+	Sources     []string `yaml:"sources" toml:"sources"`
+	Destination string   `yaml:"destination" toml:"destination"`
+	// Destinations are synced to instead of Destination, each on its own
+	Destinations  []string    `yaml:"destinations" toml:"destinations"`
+	Restart       *bool       `yaml:"restart" toml:"restart"`
+	RestartSignal string      `yaml:"restart_signal" toml:"restart_signal"`
+	RestartMode   string      `yaml:"restart_mode" toml:"restart_mode"`
+	Strategy      string      `yaml:"strategy" toml:"strategy"`
+	Exclude       []string    `yaml:"exclude" toml:"exclude"`
+	Include       []string    `yaml:"include" toml:"include"`
+	Labels        []string    `yaml:"labels" toml:"labels"`
+	Chown         string      `yaml:"chown" toml:"chown"`
+	Chmod         string      `yaml:"chmod" toml:"chmod"`
+	ExecBefore    string      `yaml:"exec_before" toml:"exec_before"`
+	ExecAfter     string      `yaml:"exec_after" toml:"exec_after"`
+	Rules         []Rule      `yaml:"rules" toml:"rules"`
+	Dependents    []Dependent `yaml:"dependents" toml:"dependents"`
+	Transforms    []Transform `yaml:"transforms" toml:"transforms"`
+}
+
+// Rule maps gitignore-style patterns to an action: copy (only), restart, exec, which runs
+// the Exec command in the target, or build, which runs it before restarting the target
+type Rule struct {
+	Match  []string `yaml:"match" toml:"match"`
+	Action string   `yaml:"action" toml:"action"`
+	Exec   string   `yaml:"exec" toml:"exec"`
+}
+
+// Dependent is a container or a service restarted after the target. Wait is what to wait for
+// before restarting the next one: running, healthy, none or a duration
+type Dependent struct {
+	Name    string    `yaml:"name" toml:"name"`
+	Wait    string    `yaml:"wait" toml:"wait"`
+	Timeout *Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// Transform passes the contents of files matching gitignore-style patterns through
+// a chain of filters: envsubst, crlf, lf or strip-source-maps
+type Transform struct {
+	Match   []string `yaml:"match" toml:"match"`
+	Filters []string `yaml:"filters" toml:"filters"`
+}
+
+// resolveSource returns the source relative to the directory of the config file as an absolute path
+func resolveSource(configDir, source string) string {
+	if source == "" || filepath.IsAbs(source) || hostpath.IsUNC(source) {
+		return source
+	}
+	return filepath.Join(configDir, source)
+}
+
+// Find returns the path of the first default config file existing in dir
+func Find(dir string) (string, bool) {
+	for _, name := range DefaultFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Load reads a YAML or TOML config file depending on its extension.
+// Relative sources are resolved against the directory of the file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	config := &Config{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, config)
+	} else {
+		err = yaml.Unmarshal(data, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	configDir, err := hostpath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Source != "" || config.Destination != "" {
+		config.Syncs = append([]Sync{{Source: config.Source, Destination: config.Destination}}, config.Syncs...)
+		config.Source = ""
+		config.Destination = ""
+	}
+
+	for i, sync := range config.Syncs {
+		if (sync.Source == "" && len(sync.Sources) == 0) || (sync.Destination == "" && len(sync.Destinations) == 0) {
+			return nil, fmt.Errorf("sync #%d in %s must have a source and a destination", i+1, path)
+		}
+		if sync.Source != "" && len(sync.Sources) > 0 {
+			return nil, fmt.Errorf("sync #%d in %s must have either a source or sources", i+1, path)
+		}
+		if sync.Destination != "" && len(sync.Destinations) > 0 {
+			return nil, fmt.Errorf("sync #%d in %s must have either a destination or destinations", i+1, path)
+		}
+		config.Syncs[i].Source = resolveSource(configDir, sync.Source)
+		for j, source := range sync.Sources {
+			config.Syncs[i].Sources[j] = resolveSource(configDir, source)
+		}
+		if sync.Restart == nil {
+			config.Syncs[i].Restart = config.Restart
+		}
+		if sync.RestartSignal == "" {
+			config.Syncs[i].RestartSignal = config.RestartSignal
+		}
+		if sync.RestartMode == "" {
+			config.Syncs[i].RestartMode = config.RestartMode
+		}
+		if sync.Strategy == "" {
+			config.Syncs[i].Strategy = config.Strategy
+		}
+		if len(sync.Labels) == 0 {
+			config.Syncs[i].Labels = config.Labels
+		}
+		if sync.Chown == "" {
+			config.Syncs[i].Chown = config.Chown
+		}
+		if sync.Chmod == "" {
+			config.Syncs[i].Chmod = config.Chmod
+		}
+		if sync.ExecBefore == "" {
+			config.Syncs[i].ExecBefore = config.ExecBefore
+		}
+		if sync.ExecAfter == "" {
+			config.Syncs[i].ExecAfter = config.ExecAfter
+		}
+		if len(sync.Rules) == 0 {
+			config.Syncs[i].Rules = config.Rules
+		}
+		if len(sync.Dependents) == 0 {
+			config.Syncs[i].Dependents = config.Dependents
+		}
+		if len(sync.Transforms) == 0 {
+			config.Syncs[i].Transforms = config.Transforms
+		}
+		config.Syncs[i].Exclude = append(append([]string{}, config.Exclude...), sync.Exclude...)
+		config.Syncs[i].Include = append(append([]string{}, config.Include...), sync.Include...)
+	}
+
+	return config, nil
+}
+
+// LoadDefault loads the default config file from dir. It returns nil without
+// an error when there is none
+func LoadDefault(dir string) (*Config, error) {
+	path, ok := Find(dir)
+	if !ok {
+		return nil, nil
+	}
+	return Load(path)
+}
+
+// ErrNoSyncs is returned when neither the arguments nor the config describe what to sync
+var ErrNoSyncs = errors.New("no source and destination given and no syncs found in the config file")
+
+// ShouldRestart reports whether the target of the sync should be restarted on changes
+func (sync Sync) ShouldRestart() bool {
+	return sync.Restart != nil && *sync.Restart
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the config file with the name into a new directory and returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAppliesTopLevelSettingsToSyncs(t *testing.T) {
+	apiSource := t.TempDir()
+	path := writeConfig(t, "docker-sync.yml", `
+restart: true
+restart_signal: SIGUSR1
+exec_after: nginx -s reload
+exclude: [node_modules]
+syncs:
+  - source: ./web
+    destination: web:/app
+    exclude: ["*.log"]
+  - source: '`+apiSource+`'
+    destination: api:/app
+    restart: false
+    exec_after: kill -HUP 1
+`)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if len(config.Syncs) != 2 {
+		t.Fatalf("loaded %d syncs, want 2", len(config.Syncs))
+	}
+
+	web, api := config.Syncs[0], config.Syncs[1]
+	if want := filepath.Join(filepath.Dir(path), "web"); web.Source != want {
+		t.Errorf("relative source = %s, want %s next to the config file", web.Source, want)
+	}
+	if api.Source != apiSource {
+		t.Errorf("absolute source = %s, want %s", api.Source, apiSource)
+	}
+	if !web.ShouldRestart() || web.RestartSignal != "SIGUSR1" || web.ExecAfter != "nginx -s reload" {
+		t.Errorf("sync without settings of its own = %+v, want the top-level ones", web)
+	}
+	if api.ShouldRestart() || api.RestartSignal != "SIGUSR1" || api.ExecAfter != "kill -HUP 1" {
+		t.Errorf("sync with settings of its own = %+v, want them to override the top-level ones", api)
+	}
+	// Excludes add up instead of overriding each other
+	if want := []string{"node_modules", "*.log"}; !slices.Equal(web.Exclude, want) {
+		t.Errorf("excludes = %q, want %q", web.Exclude, want)
+	}
+	if want := []string{"node_modules"}; !slices.Equal(api.Exclude, want) {
+		t.Errorf("excludes = %q, want %q", api.Exclude, want)
+	}
+}
+
+func TestLoadTurnsTopLevelSourceIntoFirstSync(t *testing.T) {
+	path := writeConfig(t, "docker-sync.yml", `
+source: /src
+destination: web:/app
+syncs:
+  - source: /lib
+    destination: web:/lib
+`)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if len(config.Syncs) != 2 || config.Syncs[0].Source != "/src" || config.Syncs[0].Destination != "web:/app" || config.Syncs[1].Source != "/lib" {
+		t.Errorf("syncs = %+v, want /src to web:/app first, then /lib", config.Syncs)
+	}
+	if config.Source != "" || config.Destination != "" {
+		t.Errorf("top-level source and destination = %q and %q, want them moved into the syncs", config.Source, config.Destination)
+	}
+}
+
+func TestLoadRequiresSourceAndDestination(t *testing.T) {
+	for _, sync := range []string{"source: /src", "destination: web:/app"} {
+		path := writeConfig(t, "docker-sync.yml", "syncs:\n  - "+sync+"\n")
+		_, err := Load(path)
+		if err == nil || !strings.Contains(err.Error(), "sync #1") {
+			t.Errorf("Load() of a sync with only %q = %v, want an error about sync #1", sync, err)
+		}
+	}
+}
+
+func TestLoadReadsYAMLAndTOML(t *testing.T) {
+	yamlPath := writeConfig(t, "docker-sync.yml", `
+host: ssh://deploy@build
+restart: true
+syncs:
+  - source: ./src
+    destination: web:/app
+    labels: [app=web]
+`)
+	tomlPath := writeConfig(t, "Docker-Sync.TOML", `
+host = "ssh://deploy@build"
+restart = true
+
+[[syncs]]
+source = "./src"
+destination = "web:/app"
+labels = ["app=web"]
+`)
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		config, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load(%s) failed: %v", filepath.Base(path), err)
+		}
+		if config.Host != "ssh://deploy@build" || len(config.Syncs) != 1 {
+			t.Fatalf("Load(%s) = %+v, want the host and a sync", filepath.Base(path), config)
+		}
+		sync := config.Syncs[0]
+		if sync.Destination != "web:/app" || !sync.ShouldRestart() || !slices.Equal(sync.Labels, []string{"app=web"}) {
+			t.Errorf("Load(%s) sync = %+v, want it restarted into web:/app by label", filepath.Base(path), sync)
+		}
+	}
+}
+
+func TestLoadFailsOnInvalidFile(t *testing.T) {
+	path := writeConfig(t, "docker-sync.yml", "syncs: [")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "failed to parse config file") {
+		t.Errorf("Load() = %v, want a parse error", err)
+	}
+}
+
+func TestLoadDefaultFindsFirstDefaultFile(t *testing.T) {
+	dir := t.TempDir()
+	config, err := LoadDefault(dir)
+	if config != nil || err != nil {
+		t.Fatalf("LoadDefault() without a config file = %v, %v, want nothing", config, err)
+	}
+
+	for _, name := range []string{"docker-sync.toml", "docker-sync.yml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte{}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// docker-sync.yml comes first among the default file names
+	if path, ok := Find(dir); !ok || filepath.Base(path) != "docker-sync.yml" {
+		t.Errorf("Find() = %s, %v, want docker-sync.yml", path, ok)
+	}
+	if _, err := LoadDefault(dir); err != nil {
+		t.Errorf("LoadDefault() of an empty file failed: %v", err)
+	}
+}
+
+func TestLoadResolvesSources(t *testing.T) {
+	path := writeConfig(t, "docker-sync.yml", `
+syncs:
+  - sources: [./web, ./api]
+    destinations: [web:/app, worker:/app]
+`)
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	dir := filepath.Dir(path)
+	if want := []string{filepath.Join(dir, "web"), filepath.Join(dir, "api")}; !slices.Equal(config.Syncs[0].Sources, want) {
+		t.Errorf("sources = %q, want %q", config.Syncs[0].Sources, want)
+	}
+	if want := []string{"web:/app", "worker:/app"}; !slices.Equal(config.Syncs[0].Destinations, want) {
+		t.Errorf("destinations = %q, want %q", config.Syncs[0].Destinations, want)
+	}
+}
+
+func TestLoadRejectsBothSourceAndSources(t *testing.T) {
+	tests := []struct {
+		sync string
+		want string
+	}{
+		{"source: ./web\n    sources: [./api]\n    destination: web:/app", "either a source or sources"},
+		{"source: ./web\n    destination: web:/app\n    destinations: [api:/app]", "either a destination or destinations"},
+	}
+	for _, test := range tests {
+		path := writeConfig(t, "docker-sync.yml", "syncs:\n  - "+test.sync+"\n")
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Load() = %v, want an error about %s", err, test.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDurationText(t *testing.T) {
+	var config struct {
+		Debounce Duration `yaml:"debounce"`
+	}
+	if err := yaml.Unmarshal([]byte("debounce: 1m30s"), &config); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if time.Duration(config.Debounce) != 90*time.Second {
+		t.Errorf("debounce = %s, want 1m30s", time.Duration(config.Debounce))
+	}
+
+	text, err := config.Debounce.MarshalText()
+	if err != nil || string(text) != "1m30s" {
+		t.Errorf("MarshalText() = %q, %v, want %q", text, err, "1m30s")
+	}
+
+	for _, invalid := range []string{"500", "fast", ""} {
+		var d Duration
+		if err := d.UnmarshalText([]byte(invalid)); err == nil {
+			t.Errorf("UnmarshalText(%q) = %s, want an error", invalid, time.Duration(d))
+		}
+	}
+}
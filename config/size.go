@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes written as a string like "500MB" or "2GiB" in config files
+type Size int64
+
+func (s *Size) UnmarshalText(text []byte) error {
+	parsed, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = Size(parsed)
+	return nil
+}
+
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(s), 10)), nil
+}
+
+// sizeUnits are the suffixes of sizes, KB and KiB both being 1024 bytes
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a number of bytes with an optional unit, e.g. 1024, 500MB or 2GiB
+func ParseSize(size string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) || math.IsNaN(number) {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes like 500MB or 2GB", size)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, which doesn't fit in an int64 anymore
+	bytes := number * float64(multiplier)
+	if bytes >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("size %q is too large", size)
+	}
+	return int64(bytes), nil
+}
+
+// Rate is a number of bytes per second written as a string like "5MB/s" in config files
+type Rate int64
+
+func (r *Rate) UnmarshalText(text []byte) error {
+	parsed, err := ParseRate(string(text))
+	if err != nil {
+		return err
+	}
+	*r = Rate(parsed)
+	return nil
+}
+
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(r), 10) + "/s"), nil
+}
+
+// ParseRate parses a number of bytes per second like 5MB/s, the /s being optional
+func ParseRate(rate string) (int64, error) {
+	value := strings.TrimSpace(rate)
+	if strings.HasSuffix(strings.ToLower(value), "/s") {
+		value = value[:len(value)-2]
+	}
+	parsed, err := ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, expected a number of bytes per second like 5MB/s", rate)
+	}
+	return parsed, nil
+}
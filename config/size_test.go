@@ -0,0 +1,65 @@
+package config
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"0", 0, false},
+		{"500MB", 500 << 20, false},
+		{"2GiB", 2 << 30, false},
+		{" 1.5 k ", 1536, false},
+		{"10b", 10, false},
+		{"8TB", 8 << 40, false},
+		{strconv.FormatInt(1<<62, 10), 1 << 62, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"-1", 0, true},
+		{"ten", 0, true},
+		{"inf", 0, true},
+		{"+Inf", 0, true},
+		{"infinityMB", 0, true},
+		{"NaN", 0, true},
+		{strconv.FormatUint(math.MaxInt64, 10), 0, true},
+		{"1e19", 0, true},
+		{"9000000TB", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseSize(test.size)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d and an error: %v", test.size, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate    string
+		want    int64
+		wantErr bool
+	}{
+		{"5MB/s", 5 << 20, false},
+		{"5mb/S", 5 << 20, false},
+		{"512K", 512 << 10, false},
+		{"100", 100, false},
+		{"/s", 0, true},
+		{"inf", 0, true},
+		{"inf/s", 0, true},
+		{"-5MB/s", 0, true},
+		{"1e30/s", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseRate(test.rate)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseRate(%q) = %d, %v, want %d and an error: %v", test.rate, got, err, test.want, test.wantErr)
+		}
+	}
+}
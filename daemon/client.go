@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Client talks to a daemon over its HTTP API
+type Client struct {
+	http    *http.Client
+	baseURL string
+	token   string
+}
+
+// NewClient creates a client of the daemon listening on the address,
+// unix://<path> or tcp://<host>:<port>, which sends the token with its requests if it's given
+func NewClient(address, token string) (*Client, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{}
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, addr)
+				},
+			},
+		},
+		// The host is ignored by the dialer
+		baseURL: "http://docker-sync",
+		token:   token,
+	}, nil
+}
+
+// List returns the statuses of all sessions
+func (c *Client) List(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := c.do(ctx, http.MethodGet, "/sessions", nil, &statuses)
+	return statuses, err
+}
+
+// Add adds a session
+func (c *Client) Add(ctx context.Context, request AddRequest) (Status, error) {
+	var status Status
+	err := c.do(ctx, http.MethodPost, "/sessions", request, &status)
+	return status, err
+}
+
+// Status returns the status of a session
+func (c *Client) Status(ctx context.Context, id string) (Status, error) {
+	var status Status
+	err := c.do(ctx, http.MethodGet, "/sessions/"+url.PathEscape(id), nil, &status)
+	return status, err
+}
+
+// Remove removes a session
+func (c *Client) Remove(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(id), nil, nil)
+}
+
+// Pause pauses a session
+func (c *Client) Pause(ctx context.Context, id string) (Status, error) {
+	var status Status
+	err := c.do(ctx, http.MethodPost, "/sessions/"+url.PathEscape(id)+"/pause", nil, &status)
+	return status, err
+}
+
+// Resume resumes a paused session
+func (c *Client) Resume(ctx context.Context, id string) (Status, error) {
+	var status Status
+	err := c.do(ctx, http.MethodPost, "/sessions/"+url.PathEscape(id)+"/resume", nil, &status)
+	return status, err
+}
+
+// do sends a request with the body encoded as JSON and decodes the response into result
+func (c *Client) do(ctx context.Context, method, path string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the daemon: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var failure errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return fmt.Errorf("daemon responded with %s", resp.Status)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return ErrNotFound
+		}
+		return errors.New(failure.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse the response of the daemon: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+// Package daemon manages sync sessions in a long-running process and exposes them
+// over a local HTTP API, so that IDE plugins and scripts can control them
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+)
+
+// ErrNotFound is returned for sessions that don't exist
+var ErrNotFound = errors.New("session not found")
+
+// State is what a session is doing
+type State string
+
+const (
+	// Running sessions watch their source and sync its changes
+	Running State = "running"
+	// Paused sessions are stopped until resumed, without changes being synced
+	Paused State = "paused"
+)
+
+// Status describes a session
+type Status struct {
+	ID          string `json:"id"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	State       State  `json:"state"`
+	// LastSync is when changes were last copied, Copied is how many paths were copied in total
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	Copied   int        `json:"copied"`
+	// LastError is the last error of the session, if any
+	LastError string `json:"last_error,omitempty"`
+}
+
+// EventFunc receives the events of a session along with its status
+type EventFunc func(status Status, event dockersync.Event)
+
+type ManagerOptions struct {
+	// OnEvent receives the events of every session
+	OnEvent EventFunc
+}
+
+// Manager runs sync sessions until they are removed or the manager is closed
+type Manager struct {
+	ctx     context.Context
+	onEvent EventFunc
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	lastId   int
+}
+
+// session is a syncer that can be stopped and started again
+type session struct {
+	options dockersync.Options
+
+	mu     sync.Mutex
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewManager creates a manager whose sessions stop once ctx is canceled
+func NewManager(ctx context.Context, options ManagerOptions) *Manager {
+	return &Manager{
+		ctx:      ctx,
+		onEvent:  options.OnEvent,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Add starts a session syncing with the options
+func (manager *Manager) Add(options dockersync.Options) (Status, error) {
+	manager.mu.Lock()
+	manager.lastId++
+	id := strconv.Itoa(manager.lastId)
+	manager.mu.Unlock()
+
+	s := &session{
+		options: options,
+		status: Status{
+			ID:          id,
+			Source:      strings.Join(options.SourcePaths(), ", "),
+			Destination: strings.Join(options.DestinationList(), ", "),
+			State:       Paused,
+		},
+	}
+
+	err := manager.start(s)
+	if err != nil {
+		return Status{}, err
+	}
+
+	manager.mu.Lock()
+	manager.sessions[id] = s
+	manager.mu.Unlock()
+	return s.snapshot(), nil
+}
+
+// Remove stops the session, cleaning up after it, and forgets it
+func (manager *Manager) Remove(id string) error {
+	manager.mu.Lock()
+	s, ok := manager.sessions[id]
+	delete(manager.sessions, id)
+	manager.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+
+	s.stop()
+	return nil
+}
+
+// Pause stops the session until it's resumed
+func (manager *Manager) Pause(id string) (Status, error) {
+	s, err := manager.get(id)
+	if err != nil {
+		return Status{}, err
+	}
+
+	s.stop()
+	return s.snapshot(), nil
+}
+
+// Resume starts a paused session again
+func (manager *Manager) Resume(id string) (Status, error) {
+	s, err := manager.get(id)
+	if err != nil {
+		return Status{}, err
+	}
+
+	err = manager.start(s)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.snapshot(), nil
+}
+
+// Status returns the status of the session
+func (manager *Manager) Status(id string) (Status, error) {
+	s, err := manager.get(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.snapshot(), nil
+}
+
+// List returns the statuses of all sessions in the order they were added
+func (manager *Manager) List() []Status {
+	manager.mu.Lock()
+	sessions := make([]*session, 0, len(manager.sessions))
+	for _, s := range manager.sessions {
+		sessions = append(sessions, s)
+	}
+	manager.mu.Unlock()
+
+	statuses := make([]Status, 0, len(sessions))
+	for _, s := range sessions {
+		statuses = append(statuses, s.snapshot())
+	}
+	slices.SortFunc(statuses, func(a, b Status) int {
+		idA, _ := strconv.Atoi(a.ID)
+		idB, _ := strconv.Atoi(b.ID)
+		return idA - idB
+	})
+	return statuses
+}
+
+// Close stops all sessions, waiting for them to clean up
+func (manager *Manager) Close() {
+	manager.mu.Lock()
+	sessions := manager.sessions
+	manager.sessions = make(map[string]*session)
+	manager.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+func (manager *Manager) get(id string) (*session, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	s, ok := manager.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s, nil
+}
+
+// start starts the syncer of a paused session and follows its events until it's stopped
+func (manager *Manager) start(s *session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.State == Running {
+		return nil
+	}
+
+	dockerSyncer, err := dockersync.New(s.options)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(manager.ctx)
+	events, err := dockerSyncer.Start(ctx)
+	if err != nil {
+		cancel()
+		s.status.LastError = err.Error()
+		return fmt.Errorf("failed to start syncing %s to %s: %w", s.status.Source, s.status.Destination, err)
+	}
+
+	s.status.State = Running
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go manager.follow(s, events, s.done)
+	return nil
+}
+
+// follow updates the status of the session from its events until they are closed
+func (manager *Manager) follow(s *session, events <-chan dockersync.Event, done chan struct{}) {
+	defer close(done)
+	for event := range events {
+		s.mu.Lock()
+		switch event.Type {
+		case dockersync.Copied:
+			now := time.Now()
+			s.status.LastSync = &now
+			s.status.Copied += len(event.Paths)
+		case dockersync.Error:
+			s.status.LastError = event.Err.Error()
+		}
+		status := s.status
+		s.mu.Unlock()
+
+		if manager.onEvent != nil {
+			manager.onEvent(status, event)
+		}
+	}
+}
+
+// stop stops the syncer of the session, waiting for it to clean up
+func (s *session) stop() {
+	s.mu.Lock()
+	if s.status.State != Running {
+		s.mu.Unlock()
+		return
+	}
+	s.status.State = Paused
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+
+	cancel()
+	<-done
+}
+
+func (s *session) snapshot() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+// testBackend counts the archives copied into targets of the test scheme, without Docker
+type testBackend struct {
+	mu     sync.Mutex
+	copies int
+}
+
+func (b *testBackend) Resolve(ctx context.Context) error { return nil }
+
+func (b *testBackend) Copy(ctx context.Context, archive io.Reader) error {
+	if _, err := io.Copy(io.Discard, archive); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.copies++
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *testBackend) Delete(ctx context.Context, paths []string) error { return nil }
+func (b *testBackend) Restart(ctx context.Context) error                { return nil }
+func (b *testBackend) Cleanup(ctx context.Context) error                { return nil }
+
+var backend = &testBackend{}
+
+func init() {
+	syncer.RegisterBackend("daemontest", func(target string) (syncer.Backend, error) {
+		return backend, nil
+	})
+}
+
+// waitForStatus waits until the status of the session satisfies the condition
+func waitForStatus(t *testing.T, manager *Manager, id string, condition func(Status) bool) Status {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, err := manager.Status(id)
+		if err != nil {
+			t.Fatalf("Status(%s) failed: %v", id, err)
+		}
+		if condition(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status of session %s = %+v, which never changed as expected", id, status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestManagerSessions(t *testing.T) {
+	manager := NewManager(context.Background(), ManagerOptions{})
+	defer manager.Close()
+
+	source := t.TempDir()
+	status, err := manager.Add(dockersync.Options{Source: source, Destination: "daemontest://web:/app"})
+	if err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	if status.ID != "1" || status.State != Running || status.Source != source {
+		t.Fatalf("Add() = %+v, want session 1 running from %s", status, source)
+	}
+
+	if err := os.WriteFile(filepath.Join(source, "index.html"), []byte("<h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status = waitForStatus(t, manager, status.ID, func(status Status) bool { return status.Copied > 0 })
+	if status.LastSync == nil {
+		t.Errorf("status after a copy = %+v, want the time of the last sync", status)
+	}
+	backend.mu.Lock()
+	copies := backend.copies
+	backend.mu.Unlock()
+	if copies == 0 {
+		t.Error("nothing was copied into the target")
+	}
+
+	if status, err := manager.Pause(status.ID); err != nil || status.State != Paused {
+		t.Errorf("Pause() = %+v, %v, want the session paused", status, err)
+	}
+	if status, err := manager.Resume(status.ID); err != nil || status.State != Running {
+		t.Errorf("Resume() = %+v, %v, want the session running", status, err)
+	}
+
+	other, err := manager.Add(dockersync.Options{Source: t.TempDir(), Destination: "daemontest://worker:/app"})
+	if err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	if list := manager.List(); len(list) != 2 || list[0].ID != status.ID || list[1].ID != other.ID {
+		t.Errorf("List() = %+v, want sessions %s and %s in order", list, status.ID, other.ID)
+	}
+
+	if err := manager.Remove(status.ID); err != nil {
+		t.Errorf("Remove() failed: %v", err)
+	}
+	for _, err := range []error{
+		manager.Remove(status.ID),
+		func() error { _, err := manager.Status(status.ID); return err }(),
+		func() error { _, err := manager.Pause(status.ID); return err }(),
+		func() error { _, err := manager.Resume(status.ID); return err }(),
+	} {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("removed session = %v, want %v", err, ErrNotFound)
+		}
+	}
+}
+
+func TestManagerAddFailsWithoutStarting(t *testing.T) {
+	manager := NewManager(context.Background(), ManagerOptions{})
+	defer manager.Close()
+
+	if _, err := manager.Add(dockersync.Options{Destination: "daemontest://web:/app"}); err == nil {
+		t.Error("Add() without a source succeeded")
+	}
+	if list := manager.List(); len(list) != 0 {
+		t.Errorf("List() = %+v, want no sessions", list)
+	}
+}
@@ -0,0 +1,290 @@
+package daemon
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/axtgr/docker-sync/dockersync"
+	"github.com/axtgr/docker-sync/hostpath"
+)
+
+// AddRequest is the body of a request adding a session. Settings that aren't set
+// are taken from the daemon
+type AddRequest struct {
+	// Source must be an absolute path, since the daemon can run in another directory
+	Source        string   `json:"source"`
+	Destination   string   `json:"destination"`
+	Restart       *bool    `json:"restart,omitempty"`
+	RestartSignal string   `json:"restart_signal,omitempty"`
+	RestartMode   string   `json:"restart_mode,omitempty"`
+	Exclude       []string `json:"exclude,omitempty"`
+	Labels        []string `json:"labels,omitempty"`
+	ExecBefore    string   `json:"exec_before,omitempty"`
+	ExecAfter     string   `json:"exec_after,omitempty"`
+}
+
+// ResolveFunc turns a request into the options of a session
+type ResolveFunc func(request AddRequest) (dockersync.Options, error)
+
+// HandlerOptions restrict what the clients of the API can do
+type HandlerOptions struct {
+	// Token is the secret clients have to send as a bearer token in the Authorization header
+	// of every request. Without it, any client that can connect is trusted
+	Token string
+	// AllowExec lets requests set commands run in the target before and after copies,
+	// which are rejected otherwise
+	AllowExec bool
+	// SourceRoots are the directories the sources of added sessions have to be in,
+	// after resolving symlinks. Without them, any absolute path is accepted
+	SourceRoots []string
+}
+
+// errorResponse is the body of failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns the HTTP API of the manager:
+//
+//	GET    /sessions             lists the sessions
+//	POST   /sessions             adds a session from an AddRequest
+//	GET    /sessions/{id}        returns the status of a session
+//	DELETE /sessions/{id}        removes a session
+//	POST   /sessions/{id}/pause  pauses a session
+//	POST   /sessions/{id}/resume resumes a paused session
+//
+// Sessions are added from JSON bodies only, which browsers can't send to other sites
+// without asking them first, so that web pages can't add sessions through the API
+func NewHandler(manager *Manager, resolve ResolveFunc, options HandlerOptions) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /sessions", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, manager.List())
+	})
+
+	mux.HandleFunc("POST /sessions", func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			fail(w, errors.New("the request must be sent as application/json"), http.StatusUnsupportedMediaType)
+			return
+		}
+		var request AddRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			fail(w, fmt.Errorf("failed to parse the request: %w", err), http.StatusBadRequest)
+			return
+		}
+		if !filepath.IsAbs(request.Source) {
+			fail(w, fmt.Errorf("source %s must be an absolute path", request.Source), http.StatusBadRequest)
+			return
+		}
+		if err := checkSource(request.Source, options.SourceRoots); err != nil {
+			fail(w, err, http.StatusForbidden)
+			return
+		}
+		if !options.AllowExec && (request.ExecBefore != "" || request.ExecAfter != "") {
+			fail(w, errors.New("the daemon doesn't run commands in targets for requests, unless it's started with --allow-exec"), http.StatusForbidden)
+			return
+		}
+
+		options, err := resolve(request)
+		if err != nil {
+			fail(w, err, http.StatusBadRequest)
+			return
+		}
+
+		status, err := manager.Add(options)
+		if err != nil {
+			fail(w, err, http.StatusInternalServerError)
+			return
+		}
+		respond(w, http.StatusCreated, status)
+	})
+
+	mux.HandleFunc("GET /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		status, err := manager.Status(r.PathValue("id"))
+		if err != nil {
+			fail(w, err, http.StatusInternalServerError)
+			return
+		}
+		respond(w, http.StatusOK, status)
+	})
+
+	mux.HandleFunc("DELETE /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := manager.Remove(r.PathValue("id")); err != nil {
+			fail(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /sessions/{id}/pause", func(w http.ResponseWriter, r *http.Request) {
+		status, err := manager.Pause(r.PathValue("id"))
+		if err != nil {
+			fail(w, err, http.StatusInternalServerError)
+			return
+		}
+		respond(w, http.StatusOK, status)
+	})
+
+	mux.HandleFunc("POST /sessions/{id}/resume", func(w http.ResponseWriter, r *http.Request) {
+		status, err := manager.Resume(r.PathValue("id"))
+		if err != nil {
+			fail(w, err, http.StatusInternalServerError)
+			return
+		}
+		respond(w, http.StatusOK, status)
+	})
+
+	return authenticate(mux, options.Token)
+}
+
+// authenticate passes requests with the bearer token on to the handler,
+// or all of them when there's no token
+func authenticate(handler http.Handler, token string) http.Handler {
+	if token == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			fail(w, errors.New("the request must be authorized with the token of the daemon"), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// checkSource checks that the source is inside one of the roots, if there are any,
+// once symlinks are resolved so that they can't lead out of them
+func checkSource(source string, roots []string) error {
+	if len(roots) == 0 {
+		return nil
+	}
+	resolved, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return fmt.Errorf("failed to resolve source %s: %w", source, err)
+	}
+	for _, root := range roots {
+		if resolvedRoot, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolvedRoot
+		}
+		if _, ok := hostpath.Inside(root, resolved); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("source %s isn't in %s, which the daemon syncs from", source, strings.Join(roots, " or "))
+}
+
+func respond(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// fail responds with the error, using code unless the session isn't found
+func fail(w http.ResponseWriter, err error, code int) {
+	if errors.Is(err, ErrNotFound) {
+		code = http.StatusNotFound
+	}
+	respond(w, code, errorResponse{Error: err.Error()})
+}
+
+// DefaultAddress returns the Unix socket the daemon listens on by default,
+// in XDG_RUNTIME_DIR or the temporary directory
+func DefaultAddress() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return "unix://" + filepath.Join(dir, "docker-sync.sock")
+}
+
+// parseAddress splits an address, unix://<path> or tcp://<host>:<port>, into a network and an address
+func parseAddress(address string) (string, string, error) {
+	network, addr, ok := strings.Cut(address, "://")
+	if !ok || addr == "" || (network != "unix" && network != "tcp") {
+		return "", "", fmt.Errorf("address %s must be in the following format: unix://<path> or tcp://<host>:<port>", address)
+	}
+	return network, addr, nil
+}
+
+// Listen listens on the address. A Unix socket is made accessible only to the current user,
+// and one left behind by a daemon that is gone is replaced. Anyone who can reach a TCP address
+// could sync files from the host, so it has to be on the loopback interface unless clients
+// are authenticated with a token
+func Listen(address string, authenticated bool) (net.Listener, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "tcp" && !authenticated && !isLoopback(addr) {
+		return nil, fmt.Errorf("%s isn't a loopback address, listen on 127.0.0.1 or require a token with --token-file", address)
+	}
+
+	if network == "unix" {
+		if conn, err := net.Dial(network, addr); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", address)
+		}
+		if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove the stale socket %s: %w", addr, err)
+		}
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	if network == "unix" {
+		if err := os.Chmod(addr, 0o600); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to restrict access to %s: %w", addr, err)
+		}
+	}
+	return listener, nil
+}
+
+// isLoopback reports whether the host of the TCP address is localhost or a loopback IP address.
+// An empty host listens on every interface
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Serve serves the handler on the listener until ctx is canceled, then stops
+// accepting requests and waits for the ones in progress until shutdownCtx returns
+func Serve(ctx context.Context, listener net.Listener, handler http.Handler, shutdownCtx func() (context.Context, context.CancelFunc)) error {
+	server := &http.Server{Handler: handler}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	ctx, cancel := shutdownCtx()
+	defer cancel()
+	return server.Shutdown(ctx)
+}
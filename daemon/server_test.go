@@ -0,0 +1,206 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/axtgr/docker-sync/dockersync"
+)
+
+// errResolved is returned by the resolver of the tests, so that requests that get past
+// the checks of the handler end there without starting a session
+var errResolved = errors.New("resolved")
+
+// newTestHandler returns a handler of a manager without sessions, recording the sources of
+// the requests it lets through
+func newTestHandler(t *testing.T, options HandlerOptions) (http.Handler, *[]string) {
+	t.Helper()
+	manager := NewManager(context.Background(), ManagerOptions{})
+	t.Cleanup(manager.Close)
+	var resolved []string
+	handler := NewHandler(manager, func(request AddRequest) (dockersync.Options, error) {
+		resolved = append(resolved, request.Source)
+		return dockersync.Options{}, errResolved
+	}, options)
+	return handler, &resolved
+}
+
+// serve sends the request to the handler and returns the status of the response
+func serve(handler http.Handler, method, path, contentType, body string, header http.Header) int {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+// addBody returns the JSON body of the request to add a session
+func addBody(t *testing.T, request AddRequest) string {
+	t.Helper()
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestListenRequiresLoopbackWithoutToken(t *testing.T) {
+	tests := []struct {
+		address       string
+		authenticated bool
+		ok            bool
+	}{
+		{"tcp://127.0.0.1:0", false, true},
+		{"tcp://[::1]:0", false, true},
+		{"tcp://localhost:0", false, true},
+		{"tcp://0.0.0.0:0", false, false},
+		{"tcp://:0", false, false},
+		{"tcp://0.0.0.0:0", true, true},
+	}
+	for _, test := range tests {
+		listener, err := Listen(test.address, test.authenticated)
+		if err == nil {
+			listener.Close()
+		}
+		// IPv6 may be disabled, which only matters for addresses that are allowed
+		if err != nil && test.ok && strings.Contains(err.Error(), "failed to listen") {
+			continue
+		}
+		if (err == nil) != test.ok {
+			t.Errorf("Listen(%q, %v) error = %v, want ok = %v", test.address, test.authenticated, err, test.ok)
+		}
+	}
+}
+
+func TestHandlerRequiresToken(t *testing.T) {
+	handler, _ := newTestHandler(t, HandlerOptions{Token: "secret"})
+
+	tests := []struct {
+		method, path, authorization string
+		want                        int
+	}{
+		{"GET", "/sessions", "", http.StatusUnauthorized},
+		{"GET", "/sessions", "Bearer wrong", http.StatusUnauthorized},
+		{"GET", "/sessions", "secret", http.StatusUnauthorized},
+		{"GET", "/sessions", "Bearer secret", http.StatusOK},
+		{"GET", "/sessions/1", "", http.StatusUnauthorized},
+		{"DELETE", "/sessions/1", "", http.StatusUnauthorized},
+		{"POST", "/sessions/1/pause", "", http.StatusUnauthorized},
+		{"POST", "/sessions/1/resume", "", http.StatusUnauthorized},
+		{"POST", "/sessions/1/pause", "Bearer secret", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.authorization != "" {
+			header.Set("Authorization", test.authorization)
+		}
+		if code := serve(handler, test.method, test.path, "", "", header); code != test.want {
+			t.Errorf("%s %s with %q = %d, want %d", test.method, test.path, test.authorization, code, test.want)
+		}
+	}
+}
+
+func TestClientSendsToken(t *testing.T) {
+	handler, _ := newTestHandler(t, HandlerOptions{Token: "secret"})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	address := "tcp://" + server.Listener.Addr().String()
+
+	client, err := NewClient(address, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.List(context.Background()); err != nil {
+		t.Errorf("List() with the token failed: %v", err)
+	}
+
+	client, err = NewClient(address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.List(context.Background()); err == nil {
+		t.Error("List() without the token succeeded")
+	}
+}
+
+func TestAddRequiresJSON(t *testing.T) {
+	handler, resolved := newTestHandler(t, HandlerOptions{})
+	body := addBody(t, AddRequest{Source: t.TempDir(), Destination: "web:/app"})
+
+	// Forms can be posted to the daemon from any web page
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded", "multipart/form-data; boundary=x"} {
+		if code := serve(handler, "POST", "/sessions", contentType, body, nil); code != http.StatusUnsupportedMediaType {
+			t.Errorf("POST /sessions as %q = %d, want %d", contentType, code, http.StatusUnsupportedMediaType)
+		}
+	}
+	if len(*resolved) != 0 {
+		t.Fatalf("requests that aren't JSON were resolved: %q", *resolved)
+	}
+
+	if code := serve(handler, "POST", "/sessions", "application/json; charset=utf-8", body, nil); code != http.StatusBadRequest || len(*resolved) != 1 {
+		t.Errorf("POST /sessions as JSON = %d and resolved %d times, want %d and once", code, len(*resolved), http.StatusBadRequest)
+	}
+}
+
+func TestAddRejectsExecUnlessAllowed(t *testing.T) {
+	body := addBody(t, AddRequest{Source: t.TempDir(), Destination: "web:/app", ExecAfter: "touch /tmp/pwned"})
+
+	handler, resolved := newTestHandler(t, HandlerOptions{})
+	if code := serve(handler, "POST", "/sessions", "application/json", body, nil); code != http.StatusForbidden || len(*resolved) != 0 {
+		t.Errorf("POST /sessions with exec_after = %d and resolved %d times, want %d and never", code, len(*resolved), http.StatusForbidden)
+	}
+
+	handler, resolved = newTestHandler(t, HandlerOptions{AllowExec: true})
+	if code := serve(handler, "POST", "/sessions", "application/json", body, nil); code != http.StatusBadRequest || len(*resolved) != 1 {
+		t.Errorf("POST /sessions with exec_after allowed = %d and resolved %d times, want %d and once", code, len(*resolved), http.StatusBadRequest)
+	}
+}
+
+func TestAddChecksSource(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "projects")
+	for _, path := range []string{filepath.Join(root, "app"), filepath.Join(dir, "secrets")} {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler, _ := newTestHandler(t, HandlerOptions{SourceRoots: []string{root}})
+
+	type sourceTest struct {
+		source string
+		want   int
+	}
+	tests := []sourceTest{
+		{filepath.Join(root, "app"), http.StatusBadRequest},
+		{root, http.StatusBadRequest},
+		{filepath.Join(dir, "secrets"), http.StatusForbidden},
+		{filepath.Join(root, "app", "..", "..", "secrets"), http.StatusForbidden},
+		{filepath.Join(root, "missing"), http.StatusForbidden},
+		{"relative", http.StatusBadRequest},
+	}
+	// Creating symlinks takes a privilege on Windows
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(filepath.Join(dir, "secrets"), link); err == nil {
+		tests = append(tests, sourceTest{link, http.StatusForbidden})
+	} else {
+		t.Logf("failed to create a symlink, sources aren't checked through one: %v", err)
+	}
+	for _, test := range tests {
+		body := addBody(t, AddRequest{Source: test.source, Destination: "web:/app"})
+		if code := serve(handler, "POST", "/sessions", "application/json", body, nil); code != test.want {
+			t.Errorf("POST /sessions from %s = %d, want %d", test.source, code, test.want)
+		}
+	}
+}
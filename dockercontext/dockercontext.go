@@ -0,0 +1,172 @@
+// Package dockercontext reads Docker CLI contexts from their store in the Docker config
+// directory, so that they can be used without the docker binary
+package dockercontext
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/client"
+)
+
+// DefaultName is the context connecting to DOCKER_HOST or the default socket of the platform
+const DefaultName = "default"
+
+// Endpoint is where a context connects to Docker
+type Endpoint struct {
+	// Name is the name of the context
+	Name string
+	Host string
+	// Paths of the TLS material of the context, empty when it has none
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// SkipTLSVerify accepts any certificate of the host
+	SkipTLSVerify bool
+}
+
+// HasTLS reports whether the endpoint is accessed over TLS
+func (endpoint *Endpoint) HasTLS() bool {
+	return endpoint.CAFile != "" || endpoint.CertFile != "" || endpoint.SkipTLSVerify
+}
+
+// ConfigDir returns the Docker config directory, DOCKER_CONFIG or ~/.docker
+func ConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the Docker config directory: %w", err)
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// Current returns the name of the context used by the Docker CLI: DOCKER_CONTEXT,
+// the current context in config.json or the default one
+func Current() (string, error) {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+	// DOCKER_HOST takes precedence over the current context
+	if os.Getenv("DOCKER_HOST") != "" {
+		return DefaultName, nil
+	}
+
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultName, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the Docker config: %w", err)
+	}
+
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("failed to parse the Docker config: %w", err)
+	}
+	if config.CurrentContext == "" {
+		return DefaultName, nil
+	}
+	return config.CurrentContext, nil
+}
+
+// Load returns the Docker endpoint of the named context
+func Load(name string) (*Endpoint, error) {
+	if name == DefaultName {
+		return loadDefault()
+	}
+
+	dir, err := ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// Contexts are stored under the digest of their name
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+
+	data, err := os.ReadFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no Docker context named %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Docker context %s: %w", name, err)
+	}
+
+	var meta struct {
+		Endpoints struct {
+			Docker struct {
+				Host          string `json:"Host"`
+				SkipTLSVerify bool   `json:"SkipTLSVerify"`
+			} `json:"docker"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse Docker context %s: %w", name, err)
+	}
+	if meta.Endpoints.Docker.Host == "" {
+		return nil, fmt.Errorf("context %s has no Docker endpoint", name)
+	}
+
+	endpoint := &Endpoint{
+		Name:          name,
+		Host:          meta.Endpoints.Docker.Host,
+		SkipTLSVerify: meta.Endpoints.Docker.SkipTLSVerify,
+	}
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	endpoint.CAFile = existingFile(filepath.Join(tlsDir, "ca.pem"))
+	endpoint.CertFile = existingFile(filepath.Join(tlsDir, "cert.pem"))
+	endpoint.KeyFile = existingFile(filepath.Join(tlsDir, "key.pem"))
+
+	return endpoint, nil
+}
+
+// loadDefault returns the endpoint of the default context, configured by the DOCKER_HOST,
+// DOCKER_TLS_VERIFY and DOCKER_CERT_PATH environment variables
+func loadDefault() (*Endpoint, error) {
+	endpoint := &Endpoint{Name: DefaultName, Host: os.Getenv("DOCKER_HOST")}
+	if endpoint.Host == "" {
+		endpoint.Host = client.DefaultDockerHost
+	}
+
+	if os.Getenv("DOCKER_TLS_VERIFY") == "" && os.Getenv("DOCKER_CERT_PATH") == "" {
+		return endpoint, nil
+	}
+
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	if certPath == "" {
+		var err error
+		certPath, err = ConfigDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	endpoint.CAFile = existingFile(filepath.Join(certPath, "ca.pem"))
+	endpoint.CertFile = existingFile(filepath.Join(certPath, "cert.pem"))
+	endpoint.KeyFile = existingFile(filepath.Join(certPath, "key.pem"))
+	endpoint.SkipTLSVerify = os.Getenv("DOCKER_TLS_VERIFY") == ""
+
+	return endpoint, nil
+}
+
+// existingFile returns the path if the file exists, otherwise an empty string
+func existingFile(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,462 @@
+// Package dockersync syncs local directories to containers, services and pods, for Go programs
+// embedding docker-sync instead of running its CLI
+package dockersync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/axtgr/docker-sync/hostpath"
+	"github.com/axtgr/docker-sync/ignore"
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+// DefaultBatchInterval is used when Options.BatchInterval is not set
+const DefaultBatchInterval = 200 * time.Millisecond
+
+// DefaultCleanupTimeout limits how long cleaning up can take, unless Options.CleanupContext is set
+const DefaultCleanupTimeout = 30 * time.Second
+
+// Syncer watches a source and syncs its changes to a destination
+type Syncer interface {
+	// Start finds the destination, starts watching the source and returns a channel of events
+	// about the sync. Once ctx is canceled, the syncer cleans up after itself and closes
+	// the channel, which has to be read until then
+	Start(ctx context.Context) (<-chan Event, error)
+	// Pause holds back copying until Resume is called. Changes are still collected meanwhile
+	Pause()
+	// Resume copies the changes collected while paused and goes on syncing
+	Resume()
+	// CopyAll copies the whole source, including files that haven't changed, even when paused
+	CopyAll()
+	// Pending returns how many changed paths are waiting to be copied
+	Pending() int
+	// Trigger queues the path inside the sources to be copied as if it changed, or removed
+	// if it doesn't exist. Relative paths are relative to the working directory
+	Trigger(path string) error
+	// State returns the state of the syncers of every source and destination once started,
+	// to be passed as Resume to a Syncer continuing the sync
+	State() map[string]syncer.State
+}
+
+type Options struct {
+	// Source is the local directory to sync
+	Source string
+	// Sources are several local directories to sync instead of Source, each into the subdirectory
+	// of the destination path named after it. They share one watcher and one connection
+	Sources []string
+	// Destination is <container>:<path>, kube://<namespace>/<pod>[:<container>]:<path>,
+	// compose://<project>/<service>:<path>, volume://<volume>:<path> or, with Labels, just a path
+	Destination string
+	// Destinations are several destinations to sync to instead of Destination. The sources
+	// are watched once for all of them, and each destination has its own connection,
+	// retries and restarts, so that one failing doesn't hold up the others
+	Destinations []string
+	// Restart restarts the target after every sync, by sending it RestartSignal if set.
+	// RestartMode picks how, see syncer.Options, and RestartMethod how services are made to replace their tasks
+	Restart       bool
+	RestartSignal string
+	RestartMode   string
+	RestartMethod string
+	// Strategy is how files get into the target, see syncer.Options
+	Strategy string
+	// Paths matching Excludes (gitignore-style patterns) aren't synced, nor are the ones
+	// ignored by .gitignore files with RespectGitignore
+	Excludes         []string
+	RespectGitignore bool
+	// Includes (gitignore-style patterns) limit the changes that trigger syncing to matching paths,
+	// and Events to the kinds of changes given by EventCreate, EventWrite, EventRemove and EventChmod
+	// (all but removals by default). Copies of the whole source aren't affected
+	Includes []string
+	Events   []string
+	// Links, CaseCollisions, Normalize, Chown, Chmod, Umask, Compress, Workers, Parallel,
+	// Bandwidth and ChunkSize are passed to the syncer
+	Links          string
+	CaseCollisions string
+	Normalize      string
+	Chown          string
+	Chmod          string
+	Umask          string
+	Compress       string
+	Workers        *syncer.Workers
+	Parallel       int
+	Bandwidth      *syncer.Bandwidth
+	ChunkSize      int64
+	// Host is the Docker host, the default one if empty. Engine is docker or podman
+	Host   string
+	Engine syncer.Engine
+	// TLS is used for connecting to a tcp:// Host
+	TLS *syncer.TLSConfig
+	// SSHFlags are passed to ssh when connecting to an ssh:// Host
+	SSHFlags []string
+	// SSHBastion and Insecure are passed to the syncer
+	SSHBastion string
+	Insecure   bool
+	// Labels select the target containers by their labels instead of the destination
+	Labels []string
+	// TaskSlot and Node pick the replica of a service to copy into
+	TaskSlot int
+	Node     string
+	// Agent copies to a service through agents on every node of the swarm
+	Agent      bool
+	AgentImage string
+	AgentPort  int
+	// VolumeDriver and VolumeOptions create the temporary volumes (see syncer.Options)
+	VolumeDriver  string
+	VolumeOptions map[string]string
+	// HelperImage is the image of helper containers, pulled according to HelperPull
+	// for HelperPlatform (see syncer.Options)
+	HelperImage    string
+	HelperPull     string
+	HelperPlatform string
+	// Logger receives debug messages (discarded by default)
+	Logger *slog.Logger
+	// Trace logs every request to the Docker API, see syncer.Options
+	Trace bool
+	// BatchInterval is how long to wait for more changes before syncing them together
+	// (DefaultBatchInterval by default). Debounce, Settle, WatchMode, PollInterval
+	// and PollFallback are passed to the file watcher
+	BatchInterval time.Duration
+	Debounce      time.Duration
+	Settle        time.Duration
+	WatchMode     string
+	PollInterval  time.Duration
+	PollFallback  bool
+	// NoWatch doesn't watch the sources, so that only Trigger and CopyAll sync them
+	NoWatch bool
+	// Shell commands to run in the target before and after each sync
+	ExecBefore string
+	ExecAfter  string
+	// Logs follows the logs of the target after every restart, see syncer.Options
+	Logs bool
+	// Dependents are containers or services restarted in order after the target, see syncer.Dependent
+	Dependents []syncer.Dependent
+	// Rules decide whether changed paths restart the target, run a command in it or are only copied
+	Rules []syncer.Rule
+	// Transforms change the contents of matching files before they're copied
+	Transforms []syncer.Transform
+	// Operations failing because Docker is unreachable are retried up to Retries times,
+	// waiting RetryDelay before the first retry
+	Retries    int
+	RetryDelay time.Duration
+	// OnProgress receives reports on uploads to the target
+	OnProgress syncer.ProgressFunc
+	// AtomicFiles moves each file into place only once it's fully uploaded
+	AtomicFiles bool
+	// Xattrs copies the extended attributes of files, see syncer.Options
+	Xattrs bool
+	// Flatten copies files without their subdirectories, see syncer.Options
+	Flatten bool
+	// StoppedTarget is what to do with changes while the target container is stopped, see syncer.Options
+	StoppedTarget string
+	// Mkdir creates the destination path when it doesn't exist in the target, instead of failing
+	Mkdir bool
+	// Limits make syncing warn or fail when the source has too many or too large files
+	Limits syncer.Limits
+	// ResyncOnStart copies the whole source when a container of the target is started
+	// outside of docker-sync, e.g. after it crashed
+	ResyncOnStart bool
+	// OnRebuild is an image whose rebuilds replace the target containers with ones created
+	// from the new image and copy the whole source into them, or syncer.RebuildAuto
+	// for the image of the target container. Only works with containers
+	OnRebuild string
+	// CleanupContext returns the context for cleaning up after the one passed to Start
+	// is canceled (one expiring after DefaultCleanupTimeout by default)
+	CleanupContext func() (context.Context, context.CancelFunc)
+	// IndexCache is a directory where the index of copied files is kept between runs, for each
+	// source and destination. The sources are then copied on start, skipping the files that
+	// haven't changed since the last run
+	IndexCache string
+	// Resume holds the State of a Syncer of a previous run to continue from. Its temporary resources
+	// are reused, and the sources are copied on start, skipping the files that haven't changed since
+	Resume map[string]syncer.State
+	// ShutdownTimeout is how long the copy in progress and the pending changes can take
+	// to get to the target once the context passed to Start is canceled, before cleaning up.
+	// Without it, they're dropped
+	ShutdownTimeout time.Duration
+}
+
+// New creates a Syncer with the options, checking the destination without connecting to it
+func New(options Options) (Syncer, error) {
+	if options.Source == "" && len(options.Sources) == 0 {
+		return nil, fmt.Errorf("source is required")
+	}
+	if options.Source != "" && len(options.Sources) > 0 {
+		return nil, fmt.Errorf("either a source or several sources can be given, not both")
+	}
+	if options.Destination != "" && len(options.Destinations) > 0 {
+		return nil, fmt.Errorf("either a destination or several destinations can be given, not both")
+	}
+	if options.Destination == "" && len(options.Destinations) == 0 {
+		return nil, fmt.Errorf("destination is required")
+	}
+	for i, destination := range options.Destinations {
+		if slices.Contains(options.Destinations[:i], destination) {
+			return nil, fmt.Errorf("destination %s is given more than once", destination)
+		}
+	}
+
+	// Changes inside a source nested in another would be copied twice, into both of their directories
+	for i, source := range options.Sources {
+		for _, other := range options.Sources[:i] {
+			if sourcesOverlap(source, other) {
+				return nil, fmt.Errorf("sources %s and %s overlap, sync the outer one only or exclude the inner one from it", other, source)
+			}
+		}
+	}
+
+	// The same directory of the destination would get the files of both sources
+	names := make(map[string]string)
+	for _, source := range options.Sources {
+		name := sourceName(source)
+		if name == "" {
+			return nil, fmt.Errorf("source %s has no name to sync it into a subdirectory of the destination", source)
+		}
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("sources %s and %s would both be synced into %s", other, source, name)
+		}
+		names[name] = source
+	}
+
+	// Each source would recreate the target with its own volume, dropping the files of the others
+	recreates := options.RestartMode == syncer.RestartModeRecreate || (options.RestartMode == "" && options.RestartSignal == "")
+	if len(options.Sources) > 1 && options.Restart && recreates {
+		return nil, fmt.Errorf("targets of several sources can't be restarted by recreating them, use the %s or %s restart mode", syncer.RestartModeRestart, syncer.RestartModeSignal)
+	}
+	if len(options.Sources) > 1 && (options.Strategy == syncer.StrategyCopyRecreate || options.Strategy == syncer.StrategyVolumeServiceUpdate) {
+		return nil, fmt.Errorf("targets of several sources can't be synced with the %s strategy, which recreates them", options.Strategy)
+	}
+
+	_, err := parseEvents(options.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, destination := range options.DestinationList() {
+		err := ParseTarget(destination, &syncer.Options{Labels: options.Labels})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &pipeline{options: options}, nil
+}
+
+// PerDestination returns the options of syncing to each of the destinations on its own.
+// Without Destinations, it returns the options as they are
+func (options Options) PerDestination() []Options {
+	if len(options.Destinations) == 0 {
+		return []Options{options}
+	}
+
+	split := make([]Options, len(options.Destinations))
+	for i, destination := range options.Destinations {
+		split[i] = options
+		split[i].Destination = destination
+		split[i].Destinations = nil
+	}
+	return split
+}
+
+// Split returns the options of syncing each of the sources to each of the destinations on its own,
+// into the subdirectory of the destination path named after the source. Without Sources
+// and Destinations, it returns the options as they are
+func (options Options) Split() []Options {
+	var split []Options
+	for _, perDestination := range options.PerDestination() {
+		if len(perDestination.Sources) == 0 {
+			split = append(split, perDestination)
+			continue
+		}
+
+		for _, source := range perDestination.Sources {
+			part := perDestination
+			part.Source = source
+			part.Sources = nil
+			// The path is the last part of every kind of destination
+			part.Destination = strings.TrimSuffix(perDestination.Destination, "/") + "/" + sourceName(source)
+			split = append(split, part)
+		}
+	}
+	return split
+}
+
+// DestinationList returns Destinations, or Destination when there are none
+func (options Options) DestinationList() []string {
+	if len(options.Destinations) == 0 {
+		return []string{options.Destination}
+	}
+	return options.Destinations
+}
+
+// SourcePaths returns Sources, or Source when there are none
+func (options Options) SourcePaths() []string {
+	if len(options.Sources) == 0 {
+		return []string{options.Source}
+	}
+	return options.Sources
+}
+
+// sourcesOverlap reports whether the sources are the same or one of them is inside the other
+func sourcesOverlap(a, b string) bool {
+	absoluteA, errA := hostpath.Abs(a)
+	absoluteB, errB := hostpath.Abs(b)
+	return errA == nil && errB == nil && hostpath.Overlap(absoluteA, absoluteB)
+}
+
+// Overlaps reports whether the syncs watch the same files and copy them to the same destination,
+// because a source of one of them is the same as or inside a source of the other
+func (options Options) Overlaps(other Options) bool {
+	destinations := options.DestinationList()
+	if !slices.ContainsFunc(other.DestinationList(), func(destination string) bool {
+		return slices.Contains(destinations, destination)
+	}) {
+		return false
+	}
+
+	for _, source := range options.SourcePaths() {
+		for _, otherSource := range other.SourcePaths() {
+			if sourcesOverlap(source, otherSource) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sourceName returns the name of the directory of the source, or an empty string for a root
+func sourceName(source string) string {
+	absoluteSourcePath, err := hostpath.Abs(source)
+	if err != nil {
+		absoluteSourcePath = filepath.Clean(source)
+	}
+	name := filepath.Base(absoluteSourcePath)
+	if name == "." || name == string(filepath.Separator) {
+		return ""
+	}
+	return name
+}
+
+// Connect creates a syncer connected to the destination and returns it along with
+// the absolute path of the source, for copying without watching
+func Connect(ctx context.Context, options Options) (*syncer.Syncer, string, error) {
+	return connect(ctx, options, syncer.Options{})
+}
+
+// connect creates a syncer connected to the destination, with the hooks, the client and the state to resume set in hooks
+func connect(ctx context.Context, options Options, hooks syncer.Options) (*syncer.Syncer, string, error) {
+	absoluteSourcePath, err := hostpath.Abs(options.Source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A single file has no ignore files of its own, patterns are relative to its directory
+	sourceInfo, err := os.Stat(absoluteSourcePath)
+	sourceIsFile := err == nil && !sourceInfo.IsDir()
+	var ignoreMatcher *ignore.Matcher
+	if sourceIsFile {
+		ignoreMatcher, err = ignore.New(filepath.Dir(absoluteSourcePath), options.Excludes)
+	} else {
+		ignoreMatcher, err = ignore.Load(absoluteSourcePath, ignore.Options{
+			Exclude:          options.Excludes,
+			RespectGitignore: options.RespectGitignore,
+		})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	syncerOptions := syncer.Options{
+		RestartTarget:     options.Restart,
+		RestartSignal:     options.RestartSignal,
+		RestartMode:       options.RestartMode,
+		RestartMethod:     options.RestartMethod,
+		Strategy:          options.Strategy,
+		Host:              options.Host,
+		TLS:               options.TLS,
+		SSHFlags:          options.SSHFlags,
+		SSHBastion:        options.SSHBastion,
+		Insecure:          options.Insecure,
+		Engine:            options.Engine,
+		Labels:            options.Labels,
+		TaskSlot:          options.TaskSlot,
+		Node:              options.Node,
+		Agent:             options.Agent,
+		AgentImage:        options.AgentImage,
+		AgentPort:         options.AgentPort,
+		VolumeDriver:      options.VolumeDriver,
+		VolumeOptions:     options.VolumeOptions,
+		HelperImage:       options.HelperImage,
+		HelperPull:        options.HelperPull,
+		HelperPlatform:    options.HelperPlatform,
+		AtomicFiles:       options.AtomicFiles,
+		Xattrs:            options.Xattrs,
+		Flatten:           options.Flatten,
+		StoppedTarget:     options.StoppedTarget,
+		CreateDestination: options.Mkdir,
+		Limits:            options.Limits,
+		Logger:            options.Logger,
+		Trace:             options.Trace,
+		Identifier:        "docker-sync",
+		Ignore:            ignoreMatcher,
+		SourcePath:        absoluteSourcePath,
+		ExecBefore:        options.ExecBefore,
+		ExecAfter:         options.ExecAfter,
+		Logs:              options.Logs,
+		Dependents:        options.Dependents,
+		Rules:             options.Rules,
+		Transforms:        options.Transforms,
+		Retries:           options.Retries,
+		RetryDelay:        options.RetryDelay,
+		OnProgress:        options.OnProgress,
+		OnRestart:         hooks.OnRestart,
+		OnReconnect:       hooks.OnReconnect,
+		OnRetarget:        hooks.OnRetarget,
+		OnTargetEvent:     hooks.OnTargetEvent,
+		Client:            hooks.Client,
+		Resume:            hooks.Resume,
+		Links:             options.Links,
+		CaseCollisions:    options.CaseCollisions,
+		Normalize:         options.Normalize,
+		Chown:             options.Chown,
+		Chmod:             options.Chmod,
+		Umask:             options.Umask,
+		Compress:          options.Compress,
+		Workers:           options.Workers,
+		Parallel:          options.Parallel,
+		Bandwidth:         options.Bandwidth,
+		ChunkSize:         options.ChunkSize,
+	}
+	err = ParseTarget(options.Destination, &syncerOptions)
+	if err != nil {
+		return nil, "", err
+	}
+	// A file synced to a directory, given with a trailing slash, keeps its name
+	if sourceIsFile && strings.HasSuffix(syncerOptions.TargetPath, "/") {
+		syncerOptions.TargetPath = path.Join(syncerOptions.TargetPath, filepath.Base(absoluteSourcePath))
+	}
+
+	dockerSyncer, err := syncer.New(syncerOptions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = dockerSyncer.Connect(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = dockerSyncer.Init(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return dockerSyncer, absoluteSourcePath, nil
+}
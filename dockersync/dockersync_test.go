@@ -0,0 +1,147 @@
+package dockersync
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+func TestNewValidatesOptions(t *testing.T) {
+	source := t.TempDir()
+	tests := []struct {
+		options Options
+		want    string
+	}{
+		{Options{Destination: "web:/app"}, "source is required"},
+		{Options{Source: source}, "destination is required"},
+		{Options{Source: source, Destination: "web"}, "must be in the following format"},
+	}
+	for _, test := range tests {
+		if _, err := New(test.options); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("New(%+v) = %v, want an error about %q", test.options, err, test.want)
+		}
+	}
+
+	if _, err := New(Options{Source: source, Destination: "web:/app"}); err != nil {
+		t.Errorf("New() failed: %v", err)
+	}
+}
+
+func TestEventTypeString(t *testing.T) {
+	tests := map[EventType]string{
+		Connected:     "connected",
+		Copying:       "copying",
+		Copied:        "copied",
+		Restarted:     "restarted",
+		Error:         "error",
+		TargetStopped: "target-stopped",
+		TargetStarted: "target-started",
+		EventType(99): "unknown",
+	}
+	for eventType, want := range tests {
+		if got := eventType.String(); got != want {
+			t.Errorf("EventType(%d).String() = %q, want %q", int(eventType), got, want)
+		}
+	}
+}
+
+func TestNewValidatesSources(t *testing.T) {
+	dir := t.TempDir()
+	web, api := filepath.Join(dir, "web"), filepath.Join(dir, "api")
+	tests := []struct {
+		options Options
+		want    string
+	}{
+		{Options{Source: web, Sources: []string{api}, Destination: "web:/app"}, "either a source or several sources"},
+		{Options{Source: web, Destination: "web:/app", Destinations: []string{"api:/app"}}, "either a destination or several destinations"},
+		{Options{Sources: []string{web, filepath.Join(web, "static")}, Destination: "web:/app"}, "overlap"},
+		{Options{Sources: []string{web, web}, Destination: "web:/app"}, "overlap"},
+		{Options{Sources: []string{web, filepath.Join(dir, "other", "web")}, Destination: "web:/app"}, "would both be synced into web"},
+		{Options{Sources: []string{web, api}, Destination: "web:/app", Restart: true}, "can't be restarted by recreating them"},
+		{Options{Sources: []string{web, api}, Destination: "web:/app", Strategy: syncer.StrategyCopyRecreate}, "can't be synced with the"},
+	}
+	for _, test := range tests {
+		if _, err := New(test.options); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("New(%+v) = %v, want an error about %q", test.options, err, test.want)
+		}
+	}
+
+	options := Options{Sources: []string{web, api}, Destination: "web:/app", Restart: true, RestartMode: syncer.RestartModeSignal, RestartSignal: "SIGHUP"}
+	if _, err := New(options); err != nil {
+		t.Errorf("New() with several sources restarted by a signal failed: %v", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	web, api := filepath.Join(dir, "web"), filepath.Join(dir, "api")
+
+	split := Options{Sources: []string{web, api}, Destination: "app:/srv/"}.Split()
+	want := []Options{
+		{Source: web, Destination: "app:/srv/web"},
+		{Source: api, Destination: "app:/srv/api"},
+	}
+	if !reflect.DeepEqual(split, want) {
+		t.Errorf("Split() = %+v, want %+v", split, want)
+	}
+
+	options := Options{Source: web, Destination: "app:/srv"}
+	if split := options.Split(); !reflect.DeepEqual(split, []Options{options}) {
+		t.Errorf("Split() of a single source = %+v, want the options as they are", split)
+	}
+}
+
+func TestPerDestination(t *testing.T) {
+	source := t.TempDir()
+	if _, err := New(Options{Source: source, Destinations: []string{"web:/app", "worker:/app", "web:/app"}}); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("New() with a repeated destination = %v, want an error", err)
+	}
+
+	split := Options{Source: source, Destinations: []string{"web:/app", "worker:/app"}, Restart: true}.PerDestination()
+	want := []Options{
+		{Source: source, Destination: "web:/app", Restart: true},
+		{Source: source, Destination: "worker:/app", Restart: true},
+	}
+	if !reflect.DeepEqual(split, want) {
+		t.Errorf("PerDestination() = %+v, want %+v", split, want)
+	}
+
+	// Each source goes into its own directory of each of the destinations
+	web, api := filepath.Join(source, "web"), filepath.Join(source, "api")
+	var destinations []string
+	for _, part := range (Options{Sources: []string{web, api}, Destinations: []string{"a:/srv", "b:/srv"}}).Split() {
+		destinations = append(destinations, part.Destination)
+	}
+	if want := []string{"a:/srv/web", "a:/srv/api", "b:/srv/web", "b:/srv/api"}; !slices.Equal(destinations, want) {
+		t.Errorf("Split() destinations = %q, want %q", destinations, want)
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	dir := t.TempDir()
+	web, api := filepath.Join(dir, "web"), filepath.Join(dir, "api")
+	tests := []struct {
+		a, b Options
+		want bool
+	}{
+		{Options{Source: web, Destination: "web:/app"}, Options{Source: web, Destination: "web:/app"}, true},
+		{Options{Source: web, Destination: "web:/app"}, Options{Source: filepath.Join(web, "static"), Destination: "web:/app"}, true},
+		{Options{Source: web, Destination: "web:/app"}, Options{Source: api, Destination: "web:/app"}, false},
+		{Options{Source: web, Destination: "web:/app"}, Options{Source: web, Destination: "web:/srv"}, false},
+		{Options{Sources: []string{api, web}, Destination: "web:/app"}, Options{Source: web, Destinations: []string{"api:/app", "web:/app"}}, true},
+		// A prefix of the name isn't a parent directory
+		{Options{Source: web, Destination: "web:/app"}, Options{Source: web + "site", Destination: "web:/app"}, false},
+	}
+	for _, test := range tests {
+		if got := test.a.Overlaps(test.b); got != test.want {
+			t.Errorf("%+v.Overlaps(%+v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+		if got := test.b.Overlaps(test.a); got != test.want {
+			t.Errorf("%+v.Overlaps(%+v) = %v, want %v", test.b, test.a, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package dockersync
+
+import "github.com/axtgr/docker-sync/syncer"
+
+// EventType is the kind of an Event
+type EventType int
+
+const (
+	// Connected is emitted once the destination is found, and again when Docker
+	// is reachable after the connection was lost
+	Connected EventType = iota
+	// Copying is emitted before changed paths are copied to the destination
+	Copying
+	// Copied is emitted after changed paths are copied to the destination
+	Copied
+	// Restarted is emitted after the target is restarted following a copy
+	Restarted
+	// Error is emitted when copying fails or changes can't be watched. Syncing goes on
+	Error
+	// TargetStopped is emitted when a container of the destination stops or is removed
+	// outside of docker-sync. Changes to a single container are queued until it starts again
+	TargetStopped
+	// TargetStarted is emitted when a container of the destination is started outside of docker-sync
+	TargetStarted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Connected:
+		return "connected"
+	case Copying:
+		return "copying"
+	case Copied:
+		return "copied"
+	case Restarted:
+		return "restarted"
+	case Error:
+		return "error"
+	case TargetStopped:
+		return "target-stopped"
+	case TargetStarted:
+		return "target-started"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports what a Syncer is doing
+type Event struct {
+	Type EventType
+	// Paths are the changed files and directories in Copying and Copied events,
+	// as well as in Error events of failed copies
+	Paths []string
+	// Err is set in Error events
+	Err error
+	// Target is what happened to the container in TargetStopped and TargetStarted events
+	Target *syncer.TargetEvent
+}
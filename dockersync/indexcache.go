@@ -0,0 +1,78 @@
+package dockersync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+// indexCachePath returns the path of the cached index of syncing the source to the destination,
+// named after a hash of the engine, the host, the source and the destination
+func indexCachePath(options Options, source string) string {
+	key := sha256.Sum256([]byte(string(options.Engine) + "\n" + options.Host + "\n" + source + "\n" + options.Destination))
+	return filepath.Join(options.IndexCache, hex.EncodeToString(key[:])+".json")
+}
+
+// LoadIndex records the files of the index cached by SaveIndex as copied by the syncer, reporting
+// whether there was one that still holds. The cache is removed once read, so that a run that crashes
+// before saving it again doesn't leave an outdated one behind
+func LoadIndex(options Options, dockerSyncer *syncer.Syncer) (bool, error) {
+	if options.IndexCache == "" {
+		return false, nil
+	}
+
+	path := indexCachePath(options, dockerSyncer.SourcePath())
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read cached index %s: %w", path, err)
+	}
+	err = os.Remove(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove cached index %s: %w", path, err)
+	}
+
+	// An invalid cache is as good as none
+	var state syncer.State
+	if json.Unmarshal(data, &state) != nil {
+		return false, nil
+	}
+	return dockerSyncer.RestoreIndex(state), nil
+}
+
+// SaveIndex caches the index of the files copied by the syncer in Options.IndexCache,
+// so that the next run to the same destination skips them unless they change
+func SaveIndex(options Options, dockerSyncer *syncer.Syncer) error {
+	if options.IndexCache == "" || !dockerSyncer.KeepsFiles() {
+		return nil
+	}
+
+	current := dockerSyncer.State()
+	data, err := json.Marshal(syncer.State{Target: current.Target, Files: current.Files})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(options.IndexCache, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create the index cache: %w", err)
+	}
+	path := indexCachePath(options, dockerSyncer.SourcePath())
+	temporaryPath := path + ".tmp"
+	err = os.WriteFile(temporaryPath, data, 0o600)
+	if err == nil {
+		err = os.Rename(temporaryPath, path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cache the index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,557 @@
+package dockersync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axtgr/docker-sync/filewatcher"
+	"github.com/axtgr/docker-sync/hostpath"
+	"github.com/axtgr/docker-sync/ignore"
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+// pipeline watches the sources and syncs their changes to the destinations,
+// with a syncer for each source and destination
+type pipeline struct {
+	options Options
+	syncers []*syncer.Syncer
+	// keys identify the syncers in their State by their source and destination,
+	// and parts are the options of each of them
+	keys  []string
+	parts []Options
+	// catchUp copies the sources on start, since an index of what was copied before is restored
+	catchUp      bool
+	destinations []destination
+	// sources are the absolute paths of the sources, the same for every destination
+	sources []string
+	watcher *filewatcher.FileWatcher
+	batch   *syncer.Coalescer
+	// triggers are the operations of the Events, and changes to paths not matching
+	// includes don't trigger syncing
+	triggers filewatcher.Op
+	includes *ignore.Matcher
+
+	paused  atomic.Bool
+	copyAll chan struct{}
+	rebuilt chan struct{}
+
+	// mu guards closed, which is set once the pipeline is done. events is closed once
+	// the emits in progress, counted by sending, return. done is closed when the pipeline
+	// stops waiting for the consumer to receive them
+	mu       sync.Mutex
+	events   chan Event
+	closed   bool
+	sending  sync.WaitGroup
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// destination is where the sources are synced to, with a syncer for each of them
+type destination struct {
+	name    string
+	syncers []*syncer.Syncer
+}
+
+func (p *pipeline) Start(ctx context.Context) (<-chan Event, error) {
+	if p.events != nil {
+		return nil, fmt.Errorf("syncer of %s is already started", strings.Join(p.options.DestinationList(), ", "))
+	}
+	p.events = make(chan Event)
+	p.done = make(chan struct{})
+	p.copyAll = make(chan struct{}, 1)
+	p.rebuilt = make(chan struct{}, 1)
+
+	hooks := syncer.Options{
+		OnRestart: func() {
+			p.emit(Event{Type: Restarted})
+		},
+		OnReconnect: func() {
+			p.emit(Event{Type: Connected})
+		},
+		// A container replacing the target starts without the synced files
+		OnRetarget: p.CopyAll,
+		OnTargetEvent: func(event syncer.TargetEvent) {
+			if event.Stopped() {
+				p.emit(Event{Type: TargetStopped, Target: &event})
+				return
+			}
+			p.emit(Event{Type: TargetStarted, Target: &event})
+			if p.options.ResyncOnStart {
+				p.CopyAll()
+			}
+		},
+	}
+	for i, perDestination := range p.options.PerDestination() {
+		current := destination{name: perDestination.Destination}
+		for j, options := range perDestination.Split() {
+			// The syncers of the other sources use the connection of the first one,
+			// and only the first one reports events about the target
+			partHooks := hooks
+			if j > 0 {
+				partHooks = syncer.Options{
+					OnRestart:  hooks.OnRestart,
+					OnRetarget: hooks.OnRetarget,
+					Client:     current.syncers[0].Client(),
+				}
+				// Logs of the target are followed and its dependents restarted once, by the first syncer
+				options.Logs = false
+				options.Dependents = nil
+			}
+
+			absoluteSourcePath, err := hostpath.Abs(options.Source)
+			if err != nil {
+				p.cleanup()
+				return nil, err
+			}
+			key := absoluteSourcePath + " -> " + options.Destination
+			state, resumed := p.options.Resume[key]
+			if resumed {
+				partHooks.Resume = &state
+			}
+
+			dockerSyncer, _, err := connect(ctx, options, partHooks)
+			if err != nil {
+				p.cleanup()
+				return nil, err
+			}
+			p.syncers = append(p.syncers, dockerSyncer)
+			p.keys = append(p.keys, key)
+			p.parts = append(p.parts, options)
+
+			restored := resumed
+			if !resumed {
+				restored, err = LoadIndex(options, dockerSyncer)
+				if err != nil {
+					p.cleanup()
+					return nil, err
+				}
+			}
+			p.catchUp = p.catchUp || restored
+			current.syncers = append(current.syncers, dockerSyncer)
+
+			// The sources are the same for every destination
+			if i > 0 {
+				continue
+			}
+			p.sources = append(p.sources, absoluteSourcePath)
+
+			// Watching a directory like $HOME by mistake would take ages and flood the target
+			err = dockerSyncer.CheckLimits(ctx)
+			if err != nil {
+				p.cleanup()
+				return nil, err
+			}
+		}
+		p.destinations = append(p.destinations, current)
+	}
+
+	var err error
+	p.triggers, err = parseEvents(p.options.Events)
+	if err != nil {
+		p.cleanup()
+		return nil, err
+	}
+	p.includes, err = includeMatcher(p.sources, p.options.Includes)
+	if err != nil {
+		p.cleanup()
+		return nil, err
+	}
+
+	ignores := make([]*ignore.Matcher, len(p.syncers))
+	for i, dockerSyncer := range p.syncers {
+		ignores[i] = dockerSyncer.Ignore()
+	}
+
+	fw, err := filewatcher.NewFileWatcher(filewatcher.Options{
+		Ignore:       ignore.Join(ignores...),
+		Logger:       p.options.Logger,
+		Debounce:     p.options.Debounce,
+		Settle:       p.options.Settle,
+		Mode:         p.options.WatchMode,
+		PollInterval: p.options.PollInterval,
+		PollFallback: p.options.PollFallback,
+	})
+	if err != nil {
+		p.cleanup()
+		return nil, err
+	}
+
+	for _, source := range p.sources {
+		if p.options.NoWatch {
+			break
+		}
+		err = fw.AddWatch(source)
+		if err != nil {
+			fw.Close()
+			p.cleanup()
+			return nil, err
+		}
+	}
+	p.watcher = fw
+
+	if p.options.OnRebuild != "" {
+		for _, destination := range p.destinations {
+			image, err := destination.syncers[0].RebuildImage(ctx, p.options.OnRebuild)
+			if err != nil {
+				fw.Close()
+				p.cleanup()
+				return nil, err
+			}
+			go destination.syncers[0].WatchImage(ctx, image, p.rebuild)
+		}
+	}
+
+	batchInterval := p.options.BatchInterval
+	if batchInterval <= 0 {
+		batchInterval = DefaultBatchInterval
+	}
+	p.batch = syncer.NewCoalescer(batchInterval)
+
+	for _, dockerSyncer := range p.syncers {
+		go dockerSyncer.FollowTarget(ctx)
+	}
+	go p.run(ctx)
+	return p.events, nil
+}
+
+func (p *pipeline) Pause() {
+	p.paused.Store(true)
+}
+
+func (p *pipeline) Resume() {
+	if p.paused.Swap(false) && p.batch != nil {
+		p.batch.Flush()
+	}
+}
+
+func (p *pipeline) CopyAll() {
+	select {
+	case p.copyAll <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pipeline) State() map[string]syncer.State {
+	states := make(map[string]syncer.State, len(p.syncers))
+	for i, dockerSyncer := range p.syncers {
+		states[p.keys[i]] = dockerSyncer.State()
+	}
+	return states
+}
+
+func (p *pipeline) Trigger(path string) error {
+	if p.batch == nil {
+		return fmt.Errorf("syncer of %s is not started", strings.Join(p.options.DestinationList(), ", "))
+	}
+
+	absolutePath, err := hostpath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(p.sources, func(source string) bool {
+		_, inside := hostpath.Inside(source, absolutePath)
+		return inside
+	}) {
+		return fmt.Errorf("%s is not inside %s", absolutePath, strings.Join(p.sources, ", "))
+	}
+
+	if _, err := os.Lstat(absolutePath); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		p.queueRemoved(absolutePath)
+		return nil
+	}
+	p.batch.Add(absolutePath)
+	return nil
+}
+
+func (p *pipeline) Pending() int {
+	if p.batch == nil {
+		return 0
+	}
+	return p.batch.Len()
+}
+
+// rebuild makes the target recreated and the whole sources copied, at most once
+// for rebuilds in a row that happen while the previous one is handled
+func (p *pipeline) rebuild() {
+	select {
+	case p.rebuilt <- struct{}{}:
+	default:
+	}
+}
+
+// emit sends the event unless the pipeline is already done. The lock isn't held while sending,
+// so that a consumer that stops receiving only blocks the emit until the pipeline is done
+func (p *pipeline) emit(event Event) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.sending.Add(1)
+	p.mu.Unlock()
+	defer p.sending.Done()
+
+	select {
+	case p.events <- event:
+	case <-p.done:
+	}
+}
+
+// stopEmitting makes the emits in progress drop their events
+func (p *pipeline) stopEmitting() {
+	p.doneOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// run syncs the changes until ctx is canceled, then shuts down: it stops watching, syncs
+// the pending changes, cleans up and closes the events. Copies outlive ctx for up to
+// ShutdownTimeout, so that the one in progress can finish
+func (p *pipeline) run(ctx context.Context) {
+	p.emit(Event{Type: Connected})
+
+	copyCtx, cancelCopies := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelCopies()
+
+	// The context of cleaning up is created only on shutdown, since it can catch interrupts
+	var cleanupCtx context.Context
+	var cancelCleanup context.CancelFunc
+	shuttingDown := make(chan struct{})
+	context.AfterFunc(ctx, func() {
+		cleanupCtx, cancelCleanup = p.cleanupContext()
+		if p.options.ShutdownTimeout <= 0 {
+			cancelCopies()
+		} else {
+			timer := time.AfterFunc(p.options.ShutdownTimeout, cancelCopies)
+			// Aborting the cleanup, e.g. by interrupting the process again, aborts the copies too
+			context.AfterFunc(cleanupCtx, func() {
+				timer.Stop()
+				cancelCopies()
+			})
+		}
+		close(shuttingDown)
+	})
+
+	defer func() {
+		// The loop only ends once ctx is canceled
+		<-shuttingDown
+		defer cancelCleanup()
+		// Events the consumer doesn't receive by the time the cleanup is aborted are dropped
+		context.AfterFunc(cleanupCtx, p.stopEmitting)
+
+		p.flush(copyCtx)
+		if err := p.cleanupWith(cleanupCtx); err != nil {
+			p.emit(Event{Type: Error, Err: fmt.Errorf("failed to clean up: %w", err)})
+		}
+
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+		p.stopEmitting()
+		p.sending.Wait()
+		close(p.events)
+	}()
+
+	// Changes made while the previous run wasn't watching are caught up on,
+	// skipping the files it copied unless they changed since
+	if p.catchUp && !p.copy(copyCtx, p.sources, p.copyBatch) {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-p.watcher.Events:
+			p.queue(event.Name, event.Op)
+		case <-p.batch.Ready():
+			// Paused changes stay queued until resumed
+			if p.paused.Load() {
+				continue
+			}
+			paths := p.batch.Take()
+			if len(paths) == 0 {
+				continue
+			}
+
+			if !p.copy(copyCtx, paths, p.copyBatch) {
+				return
+			}
+		case <-p.copyAll:
+			// Changes queued so far are copied along with the rest
+			p.batch.Take()
+			if !p.copy(copyCtx, p.sources, p.copyAllSources) {
+				return
+			}
+		case <-p.rebuilt:
+			p.batch.Take()
+			if !p.copy(copyCtx, p.sources, p.recreateAndCopyAll) {
+				return
+			}
+		case err := <-p.watcher.Errors:
+			p.emit(Event{Type: Error, Err: err})
+		}
+	}
+}
+
+// flush stops watching and copies the changes that are still debounced or queued,
+// unless copying is paused or ctx is already canceled
+func (p *pipeline) flush(ctx context.Context) {
+	for _, event := range p.watcher.Drain() {
+		p.queue(event.Name, event.Op)
+	}
+
+	if p.paused.Load() || ctx.Err() != nil {
+		return
+	}
+	paths := p.batch.Take()
+	if len(paths) == 0 {
+		return
+	}
+	p.copy(ctx, paths, p.copyBatch)
+}
+
+// copy copies the paths with copyPaths, emitting events about it. It returns false
+// once ctx is canceled
+func (p *pipeline) copy(ctx context.Context, paths []string, copyPaths func(context.Context, []string) error) bool {
+	p.emit(Event{Type: Copying, Paths: paths})
+	err := copyPaths(ctx, paths)
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		p.emit(Event{Type: Error, Paths: paths, Err: err})
+		return true
+	}
+	p.emit(Event{Type: Copied, Paths: paths})
+	return true
+}
+
+// eachDestination calls fn for all the destinations at once, so that a destination
+// that is slow or retrying doesn't hold up the others
+func (p *pipeline) eachDestination(fn func(destination) error) error {
+	if len(p.destinations) == 1 {
+		return fn(p.destinations[0])
+	}
+
+	errs := make([]error, len(p.destinations))
+	var wg sync.WaitGroup
+	for i, destination := range p.destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(destination); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", destination.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// copyBatch copies the paths to every destination with the syncers of the sources they're in
+func (p *pipeline) copyBatch(ctx context.Context, paths []string) error {
+	if len(p.sources) == 1 {
+		return p.eachDestination(func(destination destination) error {
+			return destination.syncers[0].CopyBatch(ctx, paths)
+		})
+	}
+
+	batches := make([][]string, len(p.sources))
+	for _, path := range paths {
+		for i, source := range p.sources {
+			if _, ok := hostpath.Inside(source, path); ok {
+				batches[i] = append(batches[i], path)
+				break
+			}
+		}
+	}
+
+	return p.eachDestination(func(destination destination) error {
+		var errs []error
+		for i, batch := range batches {
+			if len(batch) == 0 {
+				continue
+			}
+			err := destination.syncers[i].CopyBatch(ctx, batch)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// copyAllSources copies the whole sources to every destination
+func (p *pipeline) copyAllSources(ctx context.Context, _ []string) error {
+	return p.eachDestination(func(destination destination) error {
+		return destination.copyAll(ctx)
+	})
+}
+
+// copyAll copies the whole sources to the destination
+func (destination destination) copyAll(ctx context.Context) error {
+	var errs []error
+	for _, dockerSyncer := range destination.syncers {
+		err := dockerSyncer.CopyAll(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recreateAndCopyAll recreates the targets from their rebuilt images and copies the whole sources into them
+func (p *pipeline) recreateAndCopyAll(ctx context.Context, _ []string) error {
+	return p.eachDestination(func(destination destination) error {
+		err := destination.syncers[0].RecreateTarget(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to recreate the target: %w", err)
+		}
+		p.emit(Event{Type: Restarted})
+		return destination.copyAll(ctx)
+	})
+}
+
+// cleanupContext returns the context of cleaning up, since the one passed to Start is already canceled
+func (p *pipeline) cleanupContext() (context.Context, context.CancelFunc) {
+	if p.options.CleanupContext != nil {
+		return p.options.CleanupContext()
+	}
+	return context.WithTimeout(context.Background(), DefaultCleanupTimeout)
+}
+
+// cleanup cleans up after the syncers with a context of its own
+func (p *pipeline) cleanup() error {
+	ctx, cancel := p.cleanupContext()
+	defer cancel()
+	return p.cleanupWith(ctx)
+}
+
+// cleanupWith cleans up after the syncers and caches their indexes for the next run
+func (p *pipeline) cleanupWith(ctx context.Context) error {
+	var errs []error
+	for i, dockerSyncer := range p.syncers {
+		err := dockerSyncer.Cleanup(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = SaveIndex(p.parts[i], dockerSyncer)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
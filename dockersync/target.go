@@ -0,0 +1,107 @@
+package dockersync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+// parseDestination splits a destination in the <container>:<path> format
+func parseDestination(destination string) (string, string, error) {
+	destinationSegments := strings.Split(destination, ":")
+	if len(destinationSegments) < 2 || destinationSegments[0] == "" || destinationSegments[1] == "" {
+		return "", "", fmt.Errorf("destination %s must be in the following format: <container>:<path>", destination)
+	}
+
+	return destinationSegments[0], destinationSegments[1], nil
+}
+
+// ParseTarget sets the target of the syncer options from a destination, which is in the
+// [container:|service:]<container or service>:<path> format, a Kubernetes destination starting with kube://,
+// a Docker Compose one starting with compose://, a volume starting with volume:// or
+// a Swarm secret or config starting with secret:// or config://, or one of a registered backend.
+// With labels, it's just a path
+func ParseTarget(destination string, options *syncer.Options) error {
+	// With a label selector, the destination is only the path
+	if len(options.Labels) > 0 {
+		targetPath := strings.TrimPrefix(destination, ":")
+		if targetPath == "" || strings.Contains(targetPath, ":") {
+			return fmt.Errorf("destination %s must be a path when containers are selected by labels", destination)
+		}
+		options.TargetPath = targetPath
+		return nil
+	}
+
+	if strings.HasPrefix(destination, syncer.KubeScheme) {
+		kubeTarget, targetPath, err := syncer.ParseKubeDestination(destination)
+		if err != nil {
+			return err
+		}
+		options.Target = kubeTarget.Pod
+		options.TargetPath = targetPath
+		options.Kube = kubeTarget
+		return nil
+	}
+
+	if strings.HasPrefix(destination, syncer.ComposeScheme) {
+		composeTarget, targetPath, err := syncer.ParseComposeDestination(destination)
+		if err != nil {
+			return err
+		}
+		options.Target = composeTarget.String()
+		options.TargetPath = targetPath
+		options.Compose = composeTarget
+		return nil
+	}
+
+	if strings.HasPrefix(destination, syncer.SecretScheme) || strings.HasPrefix(destination, syncer.ConfigScheme) {
+		swarmObjectTarget, err := syncer.ParseSwarmObjectDestination(destination)
+		if err != nil {
+			return err
+		}
+		options.Target = swarmObjectTarget.String()
+		options.SwarmObject = swarmObjectTarget
+		return nil
+	}
+
+	backend, target, targetPath, ok, err := syncer.ParseBackendDestination(destination)
+	if err != nil {
+		return err
+	}
+	if ok {
+		options.Target = target
+		options.TargetPath = targetPath
+		options.Backend = backend
+		return nil
+	}
+
+	if strings.HasPrefix(destination, syncer.VolumeScheme) {
+		volumeTarget, targetPath, err := syncer.ParseVolumeDestination(destination)
+		if err != nil {
+			return err
+		}
+		options.Target = volumeTarget.String()
+		options.TargetPath = targetPath
+		options.Volume = volumeTarget
+		return nil
+	}
+
+	// A container and a service can have the same name, the prefix picks one of them.
+	// Without a path after it, the prefix is the name of the target itself
+	for _, kind := range []string{syncer.KindContainer, syncer.KindService} {
+		if rest, ok := strings.CutPrefix(destination, kind+":"); ok && strings.Contains(rest, ":") {
+			options.TargetKind = kind
+			destination = rest
+			break
+		}
+	}
+
+	target, targetPath, err = parseDestination(destination)
+	if err != nil {
+		return err
+	}
+	options.Target = target
+	options.TargetPath = targetPath
+	return nil
+}
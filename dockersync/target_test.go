@@ -0,0 +1,70 @@
+package dockersync
+
+import (
+	"testing"
+
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		destination string
+		labels      []string
+		target      string
+		targetPath  string
+		ok          bool
+	}{
+		{"web:/app", nil, "web", "/app", true},
+		{"web:/app/static", nil, "web", "/app/static", true},
+		{"kube://default/deployment/web:/app", nil, "deployment/web", "/app", true},
+		{"compose://shop/api:/srv", nil, "compose://shop/api", "/srv", true},
+		{"volume://data:/cache", nil, "volume://data", "/cache", true},
+		// With labels, the destination is only the path
+		{"/app", []string{"app=web"}, "", "/app", true},
+		{":/app", []string{"app=web"}, "", "/app", true},
+		{"web:/app", []string{"app=web"}, "", "", false},
+		{"web", nil, "", "", false},
+		{":/app", nil, "", "", false},
+		{"web:", nil, "", "", false},
+		{"kube://web:/app", nil, "", "", false},
+		{"compose://shop:/srv", nil, "", "", false},
+		{"volume://data", nil, "", "", false},
+	}
+	for _, test := range tests {
+		options := syncer.Options{Labels: test.labels}
+		err := ParseTarget(test.destination, &options)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseTarget(%q) error = %v, want ok = %v", test.destination, err, test.ok)
+			continue
+		}
+		if err == nil && (options.Target != test.target || options.TargetPath != test.targetPath) {
+			t.Errorf("ParseTarget(%q) = %q, %q, want %q, %q", test.destination, options.Target, options.TargetPath, test.target, test.targetPath)
+		}
+	}
+}
+
+func TestParseTargetKind(t *testing.T) {
+	tests := []struct {
+		destination string
+		kind        string
+		target      string
+		targetPath  string
+	}{
+		{"container:web:/app", syncer.KindContainer, "web", "/app"},
+		{"service:web:/app", syncer.KindService, "web", "/app"},
+		{"web:/app", "", "web", "/app"},
+		// Without a path after it, the prefix is the name of the target
+		{"container:/app", "", "container", "/app"},
+		{"service:/app", "", "service", "/app"},
+	}
+	for _, test := range tests {
+		var options syncer.Options
+		if err := ParseTarget(test.destination, &options); err != nil {
+			t.Errorf("ParseTarget(%q) failed: %v", test.destination, err)
+			continue
+		}
+		if options.TargetKind != test.kind || options.Target != test.target || options.TargetPath != test.targetPath {
+			t.Errorf("ParseTarget(%q) = %q %q:%q, want %q %q:%q", test.destination, options.TargetKind, options.Target, options.TargetPath, test.kind, test.target, test.targetPath)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package dockersync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/axtgr/docker-sync/filewatcher"
+	"github.com/axtgr/docker-sync/ignore"
+	"github.com/axtgr/docker-sync/syncer"
+)
+
+// Kinds of changes that can trigger syncing, see Options.Events
+const (
+	EventCreate = "create"
+	EventWrite  = "write"
+	EventRemove = "remove"
+	EventChmod  = "chmod"
+)
+
+// parseEvents returns the file watcher operations of the kinds of changes,
+// creates, writes and chmods if none are given. Renames count as removals of the old path
+func parseEvents(events []string) (filewatcher.Op, error) {
+	if len(events) == 0 {
+		return filewatcher.Create | filewatcher.Write | filewatcher.Chmod, nil
+	}
+
+	var ops filewatcher.Op
+	for _, event := range events {
+		switch event {
+		case EventCreate:
+			ops |= filewatcher.Create
+		case EventWrite:
+			ops |= filewatcher.Write
+		case EventRemove:
+			ops |= filewatcher.Remove | filewatcher.Rename
+		case EventChmod:
+			ops |= filewatcher.Chmod
+		default:
+			return 0, fmt.Errorf("unknown event %s, expected %s, %s, %s or %s", event, EventCreate, EventWrite, EventRemove, EventChmod)
+		}
+	}
+	return ops, nil
+}
+
+// includeMatcher returns a matcher of the paths under the sources matching the patterns,
+// or nil when there are none, so that every path is included
+func includeMatcher(sources []string, patterns []string) (*ignore.Matcher, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	matchers := make([]*ignore.Matcher, len(sources))
+	for i, source := range sources {
+		// Patterns of a single file are relative to its directory, like the excludes
+		root := source
+		if info, err := os.Stat(source); err == nil && !info.IsDir() {
+			root = filepath.Dir(source)
+		}
+		matcher, err := ignore.New(root, patterns)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern: %w", err)
+		}
+		matchers[i] = matcher
+	}
+	return ignore.Join(matchers...), nil
+}
+
+// queue adds the changed path to the batch if the change is one of the Events and the path matches
+// the Includes. A removed path can't be copied, so its directory is synced instead, which runs
+// the commands and restarts of a sync without removing the path from the target, unless
+// the target is one of a backend deleting it
+func (p *pipeline) queue(path string, op filewatcher.Op) {
+	if op&p.triggers == 0 {
+		return
+	}
+
+	if p.includes != nil {
+		info, err := os.Lstat(path)
+		if !p.includes.Match(path, err == nil && info.IsDir()) {
+			return
+		}
+	}
+
+	if op&p.triggers&(filewatcher.Create|filewatcher.Write|filewatcher.Chmod) == 0 {
+		p.queueRemoved(path)
+		return
+	}
+	p.batch.Add(path)
+}
+
+// queueRemoved adds the directory of the removed path to the batch, along with the path itself
+// for backends deleting it
+func (p *pipeline) queueRemoved(path string) {
+	// A removed source has no directory of its own to sync
+	if slices.Contains(p.sources, path) {
+		return
+	}
+	if slices.ContainsFunc(p.syncers, (*syncer.Syncer).DeletesRemoved) {
+		p.batch.Add(path)
+	}
+	p.batch.Add(filepath.Dir(path))
+}
@@ -1,22 +1,116 @@
 package filewatcher
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/axtgr/docker-sync/hostpath"
+	"github.com/axtgr/docker-sync/ignore"
+	"github.com/axtgr/docker-sync/logger"
 	"github.com/fsnotify/fsnotify"
 )
 
 type FileWatcher struct {
+	// Watcher is nil in the polling mode
 	Watcher *fsnotify.Watcher
 	Events  chan fsnotify.Event
 	Errors  chan error
 	done    chan bool
+	ignore  *ignore.Matcher
+	logger  *slog.Logger
+	// debounce is how long a path has to be quiet before its event is processed,
+	// settle is how long the whole tree has to be quiet before events are reported
+	debounce time.Duration
+	settle   time.Duration
+	// settling holds the events waiting for the tree to settle, or the ones that
+	// couldn't be reported anymore once the watcher is closed
+	mu          sync.Mutex
+	settling    []fsnotify.Event
+	settleTimer *time.Timer
+	// debounced holds the events waiting for their paths to be quiet, inflight counts
+	// them until they're processed. Once draining, no more events are debounced
+	debounceMu sync.Mutex
+	debounced  map[string]*debouncedEvent
+	inflight   sync.WaitGroup
+	draining   bool
+	// watched holds the watched directories, roots holds the ones passed to AddWatch
+	watched map[string]bool
+	roots   map[string]bool
+	// files holds the single files passed to AddWatch, fileDirs the directories
+	// watched only to receive their events
+	files    map[string]bool
+	fileDirs map[string]bool
+	poller   *poller
+	// fallback polls the directories left unwatched once the watch limit is reached
+	fallback *poller
+	// recursive watches the roots as whole trees where the OS can, instead of every directory
+	recursive recursiveBackend
 }
 
+// debouncedEvent is an event processed once its timer fires
+type debouncedEvent struct {
+	event fsnotify.Event
+	timer *time.Timer
+}
+
+// recursiveBackend watches whole trees with a single watch through an API of the OS,
+// which saves walking them and a watch for every directory
+type recursiveBackend interface {
+	Add(root string) error
+	Events() <-chan fsnotify.Event
+	Close()
+}
+
+type Options struct {
+	// Paths matched by Ignore are neither watched nor reported
+	Ignore *ignore.Matcher
+	// Logger receives debug messages about watches and events (discarded by default)
+	Logger *slog.Logger
+	// Debounce is how long a path has to go without changes before its event is reported
+	// (DefaultDebounce by default)
+	Debounce time.Duration
+	// Settle holds back all events until nothing has changed for this long, so that
+	// a burst of changes, e.g. from a build, is reported once it's done (off by default)
+	Settle time.Duration
+	// Mode is how changes are detected, ModeNotify by default
+	Mode string
+	// PollInterval is how often the directories are scanned in ModePoll (DefaultPollInterval by default)
+	PollInterval time.Duration
+	// PollFallback polls the directories that can't be watched in ModeNotify once the limit
+	// of watches is reached, instead of failing with ErrWatchLimit
+	PollFallback bool
+}
+
+// ErrWatchLimit is returned when directories can't be watched because the system limit
+// of watches (fs.inotify.max_user_watches on Linux) is reached
+var ErrWatchLimit = errors.New("watch limit reached")
+
+const (
+	// ModeNotify relies on change notifications of the file system
+	ModeNotify = "notify"
+	// ModePoll scans the directories periodically, for file systems that don't deliver
+	// notifications, like some NFS, SMB and virtiofs mounts
+	ModePoll = "poll"
+)
+
+// DefaultPollInterval is used when Options.PollInterval is not set
+const DefaultPollInterval = 2 * time.Second
+
+// DefaultDebounce is used when Options.Debounce is not set
+const DefaultDebounce = 100 * time.Millisecond
+
+// rootPollInterval is how often a removed root is checked for reappearing
+const rootPollInterval = 500 * time.Millisecond
+
 type Op = fsnotify.Op
 
 const (
@@ -24,19 +118,71 @@ const (
 	Write  = fsnotify.Write
 	Remove = fsnotify.Remove
 	Rename = fsnotify.Rename
+	Chmod  = fsnotify.Chmod
 )
 
-func NewFileWatcher() (*FileWatcher, error) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create a new watcher: %w", err)
+func NewFileWatcher(options Options) (*FileWatcher, error) {
+	pollInterval := options.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	var watcher *fsnotify.Watcher
+	var filePoller, fallback *poller
+	var recursive recursiveBackend
+	switch options.Mode {
+	case "", ModeNotify:
+		var err error
+		watcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a new watcher: %w", err)
+		}
+		// Single files are still watched through fsnotify
+		recursive, err = newRecursiveBackend()
+		if err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to create a new watcher: %w", err)
+		}
+		if options.PollFallback {
+			fallback = newPoller(pollInterval, options.Ignore)
+		}
+	case ModePoll:
+		filePoller = newPoller(pollInterval, options.Ignore)
+	default:
+		return nil, fmt.Errorf("unknown watch mode %s, expected %s or %s", options.Mode, ModeNotify, ModePoll)
+	}
+
+	fwLogger := options.Logger
+	if fwLogger == nil {
+		fwLogger = logger.Discard()
+	}
+
+	debounce := options.Debounce
+	if debounce <= 0 {
+		debounce = DefaultDebounce
 	}
 
 	fw := &FileWatcher{
-		Watcher: watcher,
-		Events:  make(chan fsnotify.Event),
-		Errors:  make(chan error),
-		done:    make(chan bool),
+		Watcher:   watcher,
+		Events:    make(chan fsnotify.Event),
+		Errors:    make(chan error),
+		done:      make(chan bool),
+		debounced: make(map[string]*debouncedEvent),
+		ignore:    options.Ignore,
+		logger:    fwLogger,
+		debounce:  debounce,
+		settle:    options.Settle,
+		watched:   make(map[string]bool),
+		roots:     make(map[string]bool),
+		files:     make(map[string]bool),
+		fileDirs:  make(map[string]bool),
+		poller:    filePoller,
+		fallback:  fallback,
+		recursive: recursive,
+	}
+
+	if watcher != nil && recursive == nil && runtime.GOOS == "darwin" {
+		fw.logger.Debug("Watching every directory on its own with kqueue, FSEvents isn't supported without cgo")
 	}
 
 	go fw.Watch()
@@ -45,80 +191,463 @@ func NewFileWatcher() (*FileWatcher, error) {
 }
 
 func (fw *FileWatcher) Watch() {
-	debounceInterval := 100 * time.Millisecond
-	debounceTimers := make(map[string]*time.Timer)
-	var mu sync.Mutex
+	var events, fallbackEvents, recursiveEvents <-chan fsnotify.Event
+	var errors <-chan error
+	if fw.poller != nil {
+		events = fw.poller.events
+	} else {
+		events = fw.Watcher.Events
+		errors = fw.Watcher.Errors
+	}
+	if fw.fallback != nil {
+		fallbackEvents = fw.fallback.events
+	}
+	if fw.recursive != nil {
+		recursiveEvents = fw.recursive.Events()
+	}
 
 	for {
+		var event fsnotify.Event
+		var ok bool
 		select {
-		case event, ok := <-fw.Watcher.Events:
+		case event, ok = <-events:
+		case event, ok = <-fallbackEvents:
+		case event, ok = <-recursiveEvents:
+		case err, ok := <-errors:
 			if !ok {
 				return
 			}
-
-			mu.Lock()
-			if timer, exists := debounceTimers[event.Name]; exists {
-				timer.Stop()
-			}
-			debounceTimers[event.Name] = time.AfterFunc(debounceInterval, func() {
-				fw.processEvent(event)
-				mu.Lock()
-				delete(debounceTimers, event.Name)
-				mu.Unlock()
-			})
-			mu.Unlock()
-
-		case err, ok := <-fw.Watcher.Errors:
-			if !ok {
-				return
-			}
-			fw.Errors <- err
+			fw.reportError(err)
+			continue
 
 		case <-fw.done:
 			return
 		}
+
+		if !ok {
+			return
+		}
+		if !fw.wanted(event.Name) {
+			continue
+		}
+
+		fw.resetSettleTimer()
+
+		fw.debounceMu.Lock()
+		if fw.draining {
+			fw.debounceMu.Unlock()
+			return
+		}
+		if previous, exists := fw.debounced[event.Name]; exists && previous.timer.Stop() {
+			fw.inflight.Done()
+			// A file created and then made executable is still reported as created
+			event.Op |= previous.event.Op
+		}
+		pending := &debouncedEvent{event: event}
+		fw.inflight.Add(1)
+		pending.timer = time.AfterFunc(fw.debounce, func() {
+			defer fw.inflight.Done()
+			fw.processEvent(event)
+			fw.debounceMu.Lock()
+			if fw.debounced[event.Name] == pending {
+				delete(fw.debounced, event.Name)
+			}
+			fw.debounceMu.Unlock()
+		})
+		fw.debounced[event.Name] = pending
+		fw.debounceMu.Unlock()
+	}
+}
+
+// send reports the event, or keeps it among the settling ones once the watcher is closed,
+// so that Drain returns it
+func (fw *FileWatcher) send(event fsnotify.Event) {
+	select {
+	case fw.Events <- event:
+	case <-fw.done:
+		fw.mu.Lock()
+		fw.settling = append(fw.settling, event)
+		fw.mu.Unlock()
+	}
+}
+
+// reportError reports the error unless the watcher is closed
+func (fw *FileWatcher) reportError(err error) {
+	select {
+	case fw.Errors <- err:
+	case <-fw.done:
+	}
+}
+
+// resetSettleTimer postpones reporting the settling events, since the tree has just changed
+func (fw *FileWatcher) resetSettleTimer() {
+	if fw.settle <= 0 || fw.closed() {
+		return
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.settleTimer == nil {
+		fw.settleTimer = time.AfterFunc(fw.settle, fw.flushSettling)
+	} else {
+		fw.settleTimer.Reset(fw.settle)
+	}
+}
+
+// emit reports the event right away or, with a settle window, once the tree has settled
+func (fw *FileWatcher) emit(event fsnotify.Event) {
+	if fw.settle <= 0 {
+		fw.send(event)
+		return
+	}
+
+	fw.mu.Lock()
+	fw.settling = append(fw.settling, event)
+	fw.mu.Unlock()
+
+	// The event could be processed after the tree has already settled
+	fw.resetSettleTimer()
+}
+
+func (fw *FileWatcher) flushSettling() {
+	fw.mu.Lock()
+	events := fw.settling
+	fw.settling = nil
+	fw.mu.Unlock()
+
+	if len(events) > 0 {
+		fw.logger.Debug("Changes settled, reporting {count} events", "count", len(events))
+	}
+	for _, event := range events {
+		fw.send(event)
 	}
 }
 
 func (fw *FileWatcher) processEvent(event fsnotify.Event) {
+	fw.logger.Debug("Received {op} event for {path}", "op", event.Op.String(), "path", event.Name)
+
 	// Remove events are reported on both dirs and files
-	if event.Has(Remove) {
-		fw.Events <- event
-		return
+	if event.Has(Remove) || event.Has(Rename) {
+		if _, err := os.Lstat(event.Name); err == nil {
+			// The path was replaced in the meantime, e.g. by an atomic rename
+			event.Op = Create
+		} else {
+			fw.dropWatches(event.Name)
+			if event.Has(Remove) && !fw.ignore.Match(event.Name, false) {
+				fw.emit(event)
+			}
+			return
+		}
 	}
 
-	fileInfo, err := os.Stat(event.Name)
+	// Symlinks are reported as files, whatever they point to
+	fileInfo, err := os.Lstat(event.Name)
 	if err != nil {
-		fw.Errors <- err
+		fw.reportError(err)
 		return
 	}
 
-	// Events other than Remove are reported only on files
+	if fw.ignore.Match(event.Name, fileInfo.IsDir()) {
+		return
+	}
+
+	// Directories are reported only when they appear, e.g. when moved into the source,
+	// so that their contents are synced
 	if fileInfo.IsDir() {
 		if event.Has(Create) {
-			fw.AddWatch(event.Name)
+			// A drained watcher only reports the directory
+			if !fw.closed() {
+				fw.addWatches(event.Name)
+			}
+			fw.emit(event)
 		}
-	} else if event.Has(Create) || event.Has(Write) || event.Has(Rename) {
-		fw.Events <- event
+	} else if event.Has(Create) || event.Has(Write) || event.Has(Rename) || event.Has(Chmod) {
+		fw.emit(event)
 	}
 }
 
+// AddWatch watches the directory and all directories inside of it. If the directory
+// is removed or renamed, it's watched again once it reappears. A single file is watched
+// through its directory, so that it's still watched after being replaced
 func (fw *FileWatcher) AddWatch(path string) error {
-	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+	path = hostpath.Canonical(path)
+
+	if fw.poller != nil {
+		fw.mu.Lock()
+		fw.roots[path] = true
+		fw.mu.Unlock()
+
+		fw.logger.Debug("Polling {path} every {interval}", "path", path, "interval", fw.poller.interval.String())
+		fw.poller.add(path)
+		return nil
+	}
+
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return fw.addFileWatch(path)
+	}
+
+	fw.mu.Lock()
+	fw.roots[path] = true
+	fw.mu.Unlock()
+
+	return fw.addWatches(path)
+}
+
+// addFileWatch watches the directory of the file, reporting only the events of the file
+// unless the directory is watched for its own sake
+func (fw *FileWatcher) addFileWatch(path string) error {
+	dir := filepath.Dir(path)
+
+	fw.mu.Lock()
+	fw.files[path] = true
+	watching := fw.watched[dir] || fw.fileDirs[dir]
+	fw.fileDirs[dir] = true
+	fw.mu.Unlock()
+
+	fw.logger.Debug("Watching {path} through {dir}", "path", path, "dir", dir)
+	if watching {
+		return nil
+	}
+	err := fw.Watcher.Add(dir)
+	if err != nil {
+		return fmt.Errorf("failed to add watch for path %s: %w", dir, err)
+	}
+	return nil
+}
+
+// wanted reports whether the events of the path are reported, which they aren't for
+// the other files in the directories of watched files
+func (fw *FileWatcher) wanted(path string) bool {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	dir := filepath.Dir(path)
+	if !fw.fileDirs[dir] || fw.watched[dir] || fw.roots[path] {
+		return true
+	}
+	// Trees watched as a whole have no watched directories
+	if fw.recursive != nil {
+		for root := range fw.roots {
+			if dir == root || isInside(dir, root) {
+				return true
+			}
+		}
+	}
+	return fw.files[path]
+}
+
+func (fw *FileWatcher) addWatches(path string) error {
+	// The poller scans whole roots
+	if fw.poller != nil {
+		return nil
+	}
+
+	// Directories inside the roots are watched along with them
+	if fw.recursive != nil {
+		fw.mu.Lock()
+		isRoot := fw.roots[path]
+		fw.mu.Unlock()
+		if !isRoot {
+			return nil
+		}
+		fw.logger.Debug("Watching {path} recursively", "path", path)
+		return fw.recursive.Add(path)
+	}
+
+	// Once the limit is reached, the remaining directories are only counted
+	var unwatched []string
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("failed to walk path %s: %w", path, err)
 		}
 		if info.IsDir() {
+			if fw.ignore.Match(path, true) {
+				fw.logger.Debug("Not watching ignored directory {path}", "path", path)
+				return filepath.SkipDir
+			}
+			if len(unwatched) > 0 {
+				unwatched = append(unwatched, path)
+				return nil
+			}
+			fw.logger.Debug("Watching {path}", "path", path)
 			err = fw.Watcher.Add(path)
+			if errors.Is(err, syscall.ENOSPC) {
+				unwatched = append(unwatched, path)
+				return nil
+			}
 			if err != nil {
 				return fmt.Errorf("failed to add watch for path %s: %w", path, err)
 			}
+			fw.mu.Lock()
+			fw.watched[path] = true
+			fw.mu.Unlock()
 		}
 		return nil
 	})
+	if err != nil || len(unwatched) == 0 {
+		return err
+	}
+	return fw.handleWatchLimit(path, unwatched)
+}
+
+// handleWatchLimit reports the directories that couldn't be watched because the limit
+// of watches is reached and polls them if there's a fallback
+func (fw *FileWatcher) handleWatchLimit(root string, unwatched []string) error {
+	fw.mu.Lock()
+	watched := len(fw.watched)
+	fw.mu.Unlock()
+
+	message := fmt.Sprintf("watching %s needs %d more watches than available, %d directories are watched", root, len(unwatched), watched)
+	if limit, ok := WatchLimit(); ok {
+		// The watches of other programs count against the same limit
+		message += fmt.Sprintf(" and the limit is %d, raise it with sudo sysctl fs.inotify.max_user_watches=%d", limit, limit+2*len(unwatched))
+	}
+
+	if fw.fallback == nil {
+		return fmt.Errorf("%w: %s, exclude large directories or poll for changes", ErrWatchLimit, message)
+	}
+
+	// Polling a directory covers the ones inside of it
+	polled := 0
+	for i, dir := range unwatched {
+		if i > 0 && isInside(dir, unwatched[polled-1]) {
+			continue
+		}
+		unwatched[polled] = dir
+		polled++
+		fw.fallback.add(dir)
+	}
+	fw.logger.Warn("{message}, polling them every {interval} instead", "message", message, "interval", fw.fallback.interval.String())
+	return nil
+}
+
+// WatchLimit returns the maximum number of inotify watches of a user, if it's known
+func WatchLimit() (int, bool) {
+	data, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0, false
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, false
+	}
+	return limit, true
+}
+
+// isInside reports whether the path is inside the directory
+func isInside(path, dir string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// dropWatches removes the watches of a removed or renamed directory and the directories
+// inside of it, since they would report events under stale paths. Watched roots are
+// watched again once they reappear
+func (fw *FileWatcher) dropWatches(path string) {
+	if fw.poller != nil {
+		return
+	}
+
+	fw.mu.Lock()
+	var stale []string
+	for watched := range fw.watched {
+		if watched == path || strings.HasPrefix(watched, path+string(filepath.Separator)) {
+			stale = append(stale, watched)
+			delete(fw.watched, watched)
+		}
+	}
+	isRoot := fw.roots[path]
+	fw.mu.Unlock()
+
+	for _, watched := range stale {
+		fw.logger.Debug("No longer watching {path}", "path", watched)
+		// The watch of the path itself is already removed by fsnotify
+		fw.Watcher.Remove(watched)
+	}
+
+	if isRoot {
+		fw.logger.Debug("Waiting for {path} to reappear...", "path", path)
+		go fw.waitForRoot(path)
+	}
+}
+
+// waitForRoot watches the root again and reports it as created once it exists
+func (fw *FileWatcher) waitForRoot(path string) {
+	ticker := time.NewTicker(rootPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fw.done:
+			return
+		case <-ticker.C:
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			if err := fw.addWatches(path); err != nil {
+				fw.reportError(err)
+				return
+			}
+			fw.emit(fsnotify.Event{Name: path, Op: Create})
+			return
+		}
+	}
+}
+
+// Drain stops watching like Close and returns the changes that haven't been reported yet,
+// because they were still debounced or waiting for the tree to settle, so that they can
+// be synced before exiting
+func (fw *FileWatcher) Drain() []fsnotify.Event {
+	fw.Close()
+
+	fw.debounceMu.Lock()
+	fw.draining = true
+	pending := fw.debounced
+	fw.debounced = nil
+	fw.debounceMu.Unlock()
+
+	for _, debounced := range pending {
+		// A timer stopped before firing leaves its event to be processed here
+		if debounced.timer.Stop() {
+			fw.processEvent(debounced.event)
+			fw.inflight.Done()
+		}
+	}
+	// Events of the timers that already fired end up among the settling ones
+	fw.inflight.Wait()
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	events := fw.settling
+	fw.settling = nil
+	return events
+}
+
+// closed reports whether the watcher is closed
+func (fw *FileWatcher) closed() bool {
+	select {
+	case <-fw.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (fw *FileWatcher) Close() {
+	fw.mu.Lock()
+	if fw.settleTimer != nil {
+		fw.settleTimer.Stop()
+	}
+	fw.mu.Unlock()
+
 	close(fw.done)
-	fw.Watcher.Close()
+	if fw.poller != nil {
+		fw.poller.close()
+	} else {
+		fw.Watcher.Close()
+	}
+	if fw.fallback != nil {
+		fw.fallback.close()
+	}
+	if fw.recursive != nil {
+		fw.recursive.Close()
+	}
 }
@@ -0,0 +1,204 @@
+package filewatcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axtgr/docker-sync/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+// newTestWatcher returns a watcher of a new temporary directory with the options
+func newTestWatcher(t *testing.T, options Options) (*FileWatcher, string) {
+	t.Helper()
+	dir := t.TempDir()
+	fw, err := NewFileWatcher(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.AddWatch(dir); err != nil {
+		fw.Close()
+		t.Fatal(err)
+	}
+	return fw, dir
+}
+
+// receive returns the events reported until none come for quiet, failing on errors
+func receive(t *testing.T, fw *FileWatcher, quiet time.Duration) []fsnotify.Event {
+	t.Helper()
+	var events []fsnotify.Event
+	for {
+		select {
+		case event := <-fw.Events:
+			events = append(events, event)
+		case err := <-fw.Errors:
+			t.Fatalf("watcher failed: %v", err)
+		case <-time.After(quiet):
+			return events
+		}
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDebounceCoalescesEventsOfPath(t *testing.T) {
+	fw, dir := newTestWatcher(t, Options{Debounce: 100 * time.Millisecond})
+	defer fw.Close()
+
+	name := filepath.Join(dir, "main.go")
+	for i := range 5 {
+		writeFile(t, name, string(rune('a'+i)))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events := receive(t, fw, 500*time.Millisecond)
+	if len(events) != 1 || events[0].Name != name {
+		t.Fatalf("reported %v, want a single event for %s", events, name)
+	}
+	// A file created and then written to is still reported as created
+	if !events[0].Has(Create) {
+		t.Errorf("reported %s, want it to include %s", events[0].Op, Create)
+	}
+}
+
+func TestDebounceKeepsPathsApart(t *testing.T) {
+	fw, dir := newTestWatcher(t, Options{Debounce: 50 * time.Millisecond})
+	defer fw.Close()
+
+	writeFile(t, filepath.Join(dir, "a.go"), "a")
+	writeFile(t, filepath.Join(dir, "b.go"), "b")
+
+	reported := make(map[string]int)
+	for _, event := range receive(t, fw, 500*time.Millisecond) {
+		reported[filepath.Base(event.Name)]++
+	}
+	if reported["a.go"] != 1 || reported["b.go"] != 1 || len(reported) != 2 {
+		t.Errorf("reported %v, want one event for each of a.go and b.go", reported)
+	}
+}
+
+func TestSettleHoldsEventsUntilTreeIsQuiet(t *testing.T) {
+	const settle = 400 * time.Millisecond
+	fw, dir := newTestWatcher(t, Options{Debounce: 10 * time.Millisecond, Settle: settle})
+	defer fw.Close()
+
+	// A build writing files for a while is reported once it's done
+	for i := range 4 {
+		writeFile(t, filepath.Join(dir, string(rune('a'+i))+".o"), "obj")
+		time.Sleep(100 * time.Millisecond)
+	}
+	lastChange := time.Now()
+
+	select {
+	case event := <-fw.Events:
+		if waited := time.Since(lastChange); waited < settle/2 {
+			t.Fatalf("%s was reported %s after the last change, before the tree settled", event.Name, waited)
+		}
+		events := append([]fsnotify.Event{event}, receive(t, fw, 300*time.Millisecond)...)
+		if len(events) != 4 {
+			t.Errorf("reported %v once the tree settled, want 4 events", events)
+		}
+	case err := <-fw.Errors:
+		t.Fatalf("watcher failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was reported after the tree settled")
+	}
+}
+
+// waitFor waits until the condition holds for the watcher, checked with the lock held
+func waitFor(t *testing.T, mu *sync.Mutex, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		ok := condition()
+		mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the change never reached the watcher")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDrainReturnsDebouncedEvents(t *testing.T) {
+	fw, dir := newTestWatcher(t, Options{Debounce: time.Hour})
+
+	name := filepath.Join(dir, "main.go")
+	writeFile(t, name, "package main")
+	waitFor(t, &fw.debounceMu, func() bool { return fw.debounced[name] != nil })
+
+	events := fw.Drain()
+	if len(events) != 1 || events[0].Name != name || !events[0].Has(Create) {
+		t.Errorf("Drain() = %v, want the creation of %s", events, name)
+	}
+}
+
+func TestDrainReturnsSettlingEvents(t *testing.T) {
+	fw, dir := newTestWatcher(t, Options{Debounce: 10 * time.Millisecond, Settle: time.Hour})
+
+	name := filepath.Join(dir, "main.go")
+	writeFile(t, name, "package main")
+	waitFor(t, &fw.mu, func() bool { return len(fw.settling) > 0 })
+
+	events := fw.Drain()
+	if len(events) != 1 || events[0].Name != name {
+		t.Errorf("Drain() = %v, want the creation of %s", events, name)
+	}
+}
+
+func TestDrainReturnsNothingWithoutChanges(t *testing.T) {
+	fw, _ := newTestWatcher(t, Options{})
+	if events := fw.Drain(); len(events) != 0 {
+		t.Errorf("Drain() = %v, want nothing", events)
+	}
+}
+
+func TestWatchLimitFails(t *testing.T) {
+	root := t.TempDir()
+	fw := &FileWatcher{logger: logger.Discard(), watched: map[string]bool{root: true}}
+
+	err := fw.handleWatchLimit(root, []string{filepath.Join(root, "a"), filepath.Join(root, "b")})
+	if !errors.Is(err, ErrWatchLimit) {
+		t.Fatalf("handleWatchLimit() = %v, want %v", err, ErrWatchLimit)
+	}
+	if !strings.Contains(err.Error(), "needs 2 more watches than available, 1 directories are watched") {
+		t.Errorf("handleWatchLimit() = %v, want it to count the watches", err)
+	}
+}
+
+func TestWatchLimitPollsUnwatchedDirectories(t *testing.T) {
+	root := t.TempDir()
+	fallback := newPoller(time.Hour, nil)
+	defer fallback.close()
+	fw := &FileWatcher{logger: logger.Discard(), watched: map[string]bool{root: true}, fallback: fallback}
+
+	// Directories are walked parents first, and polling one covers the ones inside of it
+	unwatched := []string{
+		filepath.Join(root, "a"),
+		filepath.Join(root, "a", "b"),
+		filepath.Join(root, "a", "b", "c"),
+		filepath.Join(root, "ab"),
+		filepath.Join(root, "d"),
+	}
+	if err := fw.handleWatchLimit(root, unwatched); err != nil {
+		t.Fatalf("handleWatchLimit() failed: %v", err)
+	}
+	want := []string{filepath.Join(root, "a"), filepath.Join(root, "ab"), filepath.Join(root, "d")}
+	if !slices.Equal(fallback.roots, want) {
+		t.Errorf("polled %q, want %q", fallback.roots, want)
+	}
+}
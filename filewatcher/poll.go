@@ -0,0 +1,141 @@
+package filewatcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/axtgr/docker-sync/ignore"
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileState is what the poller compares between scans to detect changes
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+	isDir   bool
+}
+
+// poller detects changes by scanning the watched directories periodically, for file systems
+// that don't deliver change notifications, like some NFS, SMB and virtiofs mounts
+type poller struct {
+	interval time.Duration
+	ignore   *ignore.Matcher
+	events   chan fsnotify.Event
+	done     chan bool
+
+	mu    sync.Mutex
+	roots []string
+	state map[string]fileState
+}
+
+func newPoller(interval time.Duration, ignore *ignore.Matcher) *poller {
+	p := &poller{
+		interval: interval,
+		ignore:   ignore,
+		events:   make(chan fsnotify.Event),
+		done:     make(chan bool),
+		state:    make(map[string]fileState),
+	}
+	go p.run()
+	return p
+}
+
+// add starts polling the directory, taking its current contents as the initial state
+func (p *poller) add(root string) {
+	current := p.scanRoot(root)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.roots = append(p.roots, root)
+	for path, state := range current {
+		p.state[path] = state
+	}
+}
+
+func (p *poller) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			for _, event := range p.poll() {
+				select {
+				case p.events <- event:
+				case <-p.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// poll scans the roots and returns events for the paths created, written and removed since the last scan
+func (p *poller) poll() []fsnotify.Event {
+	p.mu.Lock()
+	roots := append([]string{}, p.roots...)
+	p.mu.Unlock()
+
+	current := make(map[string]fileState)
+	for _, root := range roots {
+		for path, state := range p.scanRoot(root) {
+			current[path] = state
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var events []fsnotify.Event
+	for path, state := range current {
+		previous, existed := p.state[path]
+		if !existed {
+			events = append(events, fsnotify.Event{Name: path, Op: Create})
+		} else if !state.isDir && (state.size != previous.size || !state.modTime.Equal(previous.modTime)) {
+			events = append(events, fsnotify.Event{Name: path, Op: Write})
+		} else if !state.isDir && state.mode != previous.mode {
+			events = append(events, fsnotify.Event{Name: path, Op: Chmod})
+		}
+	}
+	for path := range p.state {
+		if _, exists := current[path]; !exists {
+			events = append(events, fsnotify.Event{Name: path, Op: Remove})
+		}
+	}
+
+	p.state = current
+	return events
+}
+
+// scanRoot returns the state of every path in the root that is not ignored
+func (p *poller) scanRoot(root string) map[string]fileState {
+	state := make(map[string]fileState)
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		// Paths removed during the scan are reported in the next one
+		if err != nil {
+			return nil
+		}
+		if p.ignore.Match(path, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		state[path] = fileState{size: info.Size(), modTime: info.ModTime(), mode: info.Mode(), isDir: entry.IsDir()}
+		return nil
+	})
+	return state
+}
+
+func (p *poller) close() {
+	close(p.done)
+}
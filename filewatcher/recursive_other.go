@@ -0,0 +1,10 @@
+//go:build !windows
+
+package filewatcher
+
+// newRecursiveBackend returns nil, since inotify can't watch trees and FSEvents on macOS
+// is only reachable through cgo, which docker-sync is built without. Every directory
+// is watched on its own instead, with inotify on Linux and kqueue on macOS
+func newRecursiveBackend() (recursiveBackend, error) {
+	return nil, nil
+}
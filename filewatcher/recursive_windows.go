@@ -0,0 +1,168 @@
+package filewatcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/windows"
+)
+
+// notifyMask is the changes ReadDirectoryChangesW reports
+const notifyMask = windows.FILE_NOTIFY_CHANGE_FILE_NAME | windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+	windows.FILE_NOTIFY_CHANGE_SIZE | windows.FILE_NOTIFY_CHANGE_LAST_WRITE | windows.FILE_NOTIFY_CHANGE_CREATION
+
+// notifyBufferSize is the size of the buffer receiving changes, the largest one allowed over the network
+const notifyBufferSize = 64 * 1024
+
+// recursiveWatcher watches whole trees with ReadDirectoryChangesW, a handle per root.
+// Handles are read with overlapped I/O, so that canceling it unblocks the pending reads,
+// and they're closed by their readers once they stop
+type recursiveWatcher struct {
+	events chan fsnotify.Event
+	done   chan struct{}
+
+	mu      sync.Mutex
+	handles map[string]windows.Handle
+	readers sync.WaitGroup
+}
+
+func newRecursiveBackend() (recursiveBackend, error) {
+	return &recursiveWatcher{
+		events:  make(chan fsnotify.Event),
+		done:    make(chan struct{}),
+		handles: make(map[string]windows.Handle),
+	}, nil
+}
+
+func (w *recursiveWatcher) Add(root string) error {
+	pathp, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return fmt.Errorf("failed to add watch for path %s: %w", root, err)
+	}
+
+	// Sharing deletion lets the root be removed while it's watched
+	handle, err := windows.CreateFile(pathp, windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return fmt.Errorf("failed to add watch for path %s: %w", root, err)
+	}
+
+	w.mu.Lock()
+	if previous, ok := w.handles[root]; ok {
+		windows.CancelIoEx(previous, nil)
+	}
+	w.handles[root] = handle
+	w.readers.Add(1)
+	w.mu.Unlock()
+
+	go w.read(root, handle)
+	return nil
+}
+
+func (w *recursiveWatcher) Events() <-chan fsnotify.Event {
+	return w.events
+}
+
+// read reports the changes in the tree of the root until its reads are canceled
+// or the root can't be read anymore, e.g. because it was removed
+func (w *recursiveWatcher) read(root string, handle windows.Handle) {
+	defer w.readers.Done()
+	defer windows.CloseHandle(handle)
+
+	completed, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		w.release(root, handle)
+		return
+	}
+	defer windows.CloseHandle(completed)
+
+	buffer := make([]byte, notifyBufferSize)
+	for {
+		var size uint32
+		overlapped := windows.Overlapped{HEvent: completed}
+		err := windows.ReadDirectoryChanges(handle, &buffer[0], uint32(len(buffer)), true, notifyMask, nil, &overlapped, 0)
+		if err == nil || err == windows.ERROR_IO_PENDING {
+			err = windows.GetOverlappedResult(handle, &overlapped, &size, true)
+		}
+		// Overflows are reported either way
+		if err == windows.ERROR_NOTIFY_ENUM_DIR {
+			size, err = 0, nil
+		}
+		if err != nil {
+			if w.release(root, handle) {
+				w.send(fsnotify.Event{Name: root, Op: Remove})
+			}
+			return
+		}
+
+		// The buffer overflowed, so the whole tree is reported to sync everything that could have changed
+		if size == 0 {
+			w.send(fsnotify.Event{Name: root, Op: Create})
+			continue
+		}
+
+		offset := uint32(0)
+		for {
+			info := (*windows.FileNotifyInformation)(unsafe.Pointer(&buffer[offset]))
+			name := windows.UTF16ToString(unsafe.Slice(&info.FileName, info.FileNameLength/2))
+			event := fsnotify.Event{Name: filepath.Join(root, name)}
+			switch info.Action {
+			case windows.FILE_ACTION_ADDED, windows.FILE_ACTION_RENAMED_NEW_NAME:
+				event.Op = Create
+			case windows.FILE_ACTION_REMOVED:
+				event.Op = Remove
+			case windows.FILE_ACTION_RENAMED_OLD_NAME:
+				event.Op = Rename
+			default:
+				event.Op = Write
+			}
+			if !w.send(event) {
+				return
+			}
+
+			if info.NextEntryOffset == 0 {
+				break
+			}
+			offset += info.NextEntryOffset
+		}
+	}
+}
+
+// send reports the event and returns false once the watcher is closed
+func (w *recursiveWatcher) send(event fsnotify.Event) bool {
+	select {
+	case w.events <- event:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// release forgets the handle unless it was replaced or the watcher was closed,
+// and reports whether it did
+func (w *recursiveWatcher) release(root string, handle windows.Handle) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.handles[root] != handle {
+		return false
+	}
+	delete(w.handles, root)
+	return true
+}
+
+func (w *recursiveWatcher) Close() {
+	w.mu.Lock()
+	close(w.done)
+	// Canceling unblocks the pending reads, which close their handles
+	for root, handle := range w.handles {
+		windows.CancelIoEx(handle, nil)
+		delete(w.handles, root)
+	}
+	w.mu.Unlock()
+
+	w.readers.Wait()
+}
@@ -0,0 +1,180 @@
+package hostpath
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Windows exposes WSL distributions under both of these UNC hosts
+var wslHosts = []string{"wsl$", "wsl.localhost"}
+
+// Abs returns a cleaned absolute path with Windows-specific prefixes normalized,
+// so that paths reported by the watcher can be compared with the source root
+func Abs(p string) (string, error) {
+	p = stripExtendedPrefix(p)
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path of %s: %w", p, err)
+	}
+
+	return Canonical(abs), nil
+}
+
+// Canonical rewrites the different aliases of the same location to a single form:
+// \\wsl.localhost\ becomes \\wsl$\ and extended-length prefixes are removed
+func Canonical(p string) string {
+	p = stripExtendedPrefix(p)
+	if !IsUNC(p) {
+		return p
+	}
+
+	host, rest := splitUNC(p)
+	for _, wslHost := range wslHosts {
+		if strings.EqualFold(host, wslHost) {
+			host = wslHosts[0]
+		}
+	}
+
+	return `\\` + host + rest
+}
+
+// IsUNC reports whether p is a UNC path like \\server\share\dir, regardless of the host OS
+func IsUNC(p string) bool {
+	p = stripExtendedPrefix(p)
+	return len(p) > 2 && isSeparator(p[0]) && isSeparator(p[1]) && !isSeparator(p[2])
+}
+
+// IsWSL reports whether p points into a WSL distribution from the Windows side
+func IsWSL(p string) bool {
+	_, _, ok := WSLPath(p)
+	return ok
+}
+
+// WSLPath splits a \\wsl$\<distro>\<path> path into the distribution name
+// and the Linux path inside of it
+func WSLPath(p string) (distro string, linuxPath string, ok bool) {
+	if !IsUNC(p) {
+		return "", "", false
+	}
+
+	host, rest := splitUNC(stripExtendedPrefix(p))
+	isWSLHost := false
+	for _, wslHost := range wslHosts {
+		if strings.EqualFold(host, wslHost) {
+			isWSLHost = true
+		}
+	}
+	if !isWSLHost {
+		return "", "", false
+	}
+
+	segments := strings.FieldsFunc(rest, func(r rune) bool {
+		return r == '\\' || r == '/'
+	})
+	if len(segments) == 0 {
+		return "", "", false
+	}
+
+	return segments[0], "/" + strings.Join(segments[1:], "/"), true
+}
+
+// Rel returns the path of target relative to base using forward slashes,
+// which is the form expected inside tar archives and containers
+func Rel(base, target string) (string, error) {
+	rel, err := filepath.Rel(Canonical(base), Canonical(target))
+	if err != nil {
+		return "", fmt.Errorf("failed to get path of %s relative to %s: %w", target, base, err)
+	}
+
+	return path.Clean(strings.ReplaceAll(filepath.ToSlash(rel), `\`, "/")), nil
+}
+
+// Inside returns the path of target relative to root using forward slashes, reporting false
+// when target is neither root nor inside of it. On Windows and macOS, whose file systems
+// ignore case by default, root matches the beginning of target in any case, so that a source
+// given as ./App still contains the paths reported as .../app/... by the file system
+func Inside(root, target string) (string, bool) {
+	root = strings.TrimRight(filepath.ToSlash(Canonical(filepath.Clean(root))), "/")
+	target = filepath.ToSlash(Canonical(filepath.Clean(target)))
+
+	if len(target) < len(root) || !samePath(target[:len(root)], root) {
+		return "", false
+	}
+	rest := target[len(root):]
+	if rest == "" {
+		return ".", true
+	}
+	if rest[0] != '/' {
+		return "", false
+	}
+	return path.Clean(strings.TrimPrefix(rest, "/")), true
+}
+
+// Overlap reports whether the paths are the same or one of them is inside the other
+func Overlap(a, b string) bool {
+	_, aInsideB := Inside(b, a)
+	_, bInsideA := Inside(a, b)
+	return aInsideB || bInsideA
+}
+
+// samePath reports whether the paths are the same, ignoring case where file systems usually do
+func samePath(a, b string) bool {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// RunningInWSL reports whether the current process runs inside a WSL distribution
+func RunningInWSL() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+
+	version, err := os.ReadFile("/proc/version")
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(string(version)), "microsoft")
+}
+
+// IsWindowsMount reports whether p is a Windows drive mounted into WSL (e.g. /mnt/c/...).
+// Changes made by Windows programs on such mounts are not reported by inotify
+func IsWindowsMount(p string) bool {
+	if !RunningInWSL() {
+		return false
+	}
+
+	segments := strings.Split(strings.TrimPrefix(filepath.ToSlash(p), "/"), "/")
+	return len(segments) >= 2 && segments[0] == "mnt" && len(segments[1]) == 1
+}
+
+func stripExtendedPrefix(p string) string {
+	if strings.HasPrefix(p, `\\?\UNC\`) {
+		return `\\` + p[len(`\\?\UNC\`):]
+	}
+	return strings.TrimPrefix(p, `\\?\`)
+}
+
+func splitUNC(p string) (host string, rest string) {
+	p = p[2:]
+	i := strings.IndexAny(p, `\/`)
+	if i == -1 {
+		return p, ""
+	}
+	return p[:i], strings.ReplaceAll(p[i:], "/", `\`)
+}
+
+func isSeparator(c byte) bool {
+	return c == '\\' || c == '/'
+}
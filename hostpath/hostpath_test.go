@@ -0,0 +1,137 @@
+package hostpath
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestInside(t *testing.T) {
+	tests := []struct {
+		root, target string
+		want         string
+		wantOk       bool
+	}{
+		{"/src", "/src", ".", true},
+		{"/src/", "/src", ".", true},
+		{"/src", "/src/app/main.go", "app/main.go", true},
+		{"/src", "/src/app/../lib/./util.go", "lib/util.go", true},
+		{"/src", "/srcs/main.go", "", false},
+		{"/src", "/", "", false},
+		{"/src/app", "/src", "", false},
+		{"/", "/src/main.go", "src/main.go", true},
+	}
+	for _, test := range tests {
+		got, ok := Inside(test.root, test.target)
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("Inside(%q, %q) = %q, %v, want %q, %v", test.root, test.target, got, ok, test.want, test.wantOk)
+		}
+	}
+
+	// File systems of Windows and macOS ignore case by default
+	ignoresCase := runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+	if _, ok := Inside("/Src", "/src/main.go"); ok != ignoresCase {
+		t.Errorf("Inside(%q, %q) reports %v on %s", "/Src", "/src/main.go", ok, runtime.GOOS)
+	}
+}
+
+func TestRel(t *testing.T) {
+	tests := []struct {
+		base, target string
+		want         string
+	}{
+		{"/src", "/src", "."},
+		{"/src", "/src/app/main.go", "app/main.go"},
+		{"/src/app", "/src/lib/util.go", "../lib/util.go"},
+	}
+	for _, test := range tests {
+		got, err := Rel(test.base, test.target)
+		if err != nil {
+			t.Errorf("Rel(%q, %q) failed: %v", test.base, test.target, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Rel(%q, %q) = %q, want %q", test.base, test.target, got, test.want)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\src`, `C:\src`},
+		{`\\?\C:\src`, `C:\src`},
+		{`\\?\UNC\server\share\src`, `\\server\share\src`},
+		{`\\wsl.localhost\Ubuntu\home\me`, `\\wsl$\Ubuntu\home\me`},
+		{`\\WSL.LOCALHOST\Ubuntu\home\me`, `\\wsl$\Ubuntu\home\me`},
+		{`//wsl.localhost/Ubuntu/home/me`, `\\wsl$\Ubuntu\home\me`},
+		{`\\wsl$\Ubuntu\home\me`, `\\wsl$\Ubuntu\home\me`},
+		{`/home/me`, `/home/me`},
+	}
+	for _, test := range tests {
+		if got := Canonical(test.path); got != test.want {
+			t.Errorf("Canonical(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestIsUNC(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{`\\server\share`, true},
+		{`//server/share`, true},
+		{`\\?\UNC\server\share`, true},
+		{`\\?\C:\src`, false},
+		{`C:\src`, false},
+		{`/home/me`, false},
+		{`\\\server`, false},
+		{`\\`, false},
+	}
+	for _, test := range tests {
+		if got := IsUNC(test.path); got != test.want {
+			t.Errorf("IsUNC(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestWSLPath(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantDistro string
+		wantPath   string
+		wantOk     bool
+	}{
+		{`\\wsl$\Ubuntu\home\me\src`, "Ubuntu", "/home/me/src", true},
+		{`\\wsl.localhost\Debian\`, "Debian", "/", true},
+		{`\\?\UNC\wsl$\Ubuntu\srv`, "Ubuntu", "/srv", true},
+		{`\\wsl$`, "", "", false},
+		{`\\server\share\src`, "", "", false},
+		{`C:\src`, "", "", false},
+	}
+	for _, test := range tests {
+		distro, linuxPath, ok := WSLPath(test.path)
+		if distro != test.wantDistro || linuxPath != test.wantPath || ok != test.wantOk {
+			t.Errorf("WSLPath(%q) = %q, %q, %v, want %q, %q, %v", test.path, distro, linuxPath, ok, test.wantDistro, test.wantPath, test.wantOk)
+		}
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/src", "/src", true},
+		{"/src", "/src/app", true},
+		{"/src/app", "/src", true},
+		{"/src/app", "/src/lib", false},
+		{"/src", "/srcs", false},
+	}
+	for _, test := range tests {
+		if got := Overlap(test.a, test.b); got != test.want {
+			t.Errorf("Overlap(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
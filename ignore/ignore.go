@@ -0,0 +1,300 @@
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/axtgr/docker-sync/hostpath"
+)
+
+const (
+	FileName          = ".dockersyncignore"
+	GitignoreFileName = ".gitignore"
+)
+
+// Matcher decides whether paths under a root directory are excluded
+// using patterns in the gitignore syntax
+type Matcher struct {
+	root     string
+	patterns []pattern
+	// joined are matchers of other roots, see Join
+	joined []*Matcher
+}
+
+type pattern struct {
+	regexp  *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// base is the directory the pattern is relative to, for patterns from nested .gitignore files
+	base string
+}
+
+type Options struct {
+	// Exclude holds extra patterns taking precedence over the files
+	Exclude []string
+	// RespectGitignore adds the patterns of all .gitignore files in the tree
+	RespectGitignore bool
+}
+
+// New creates a matcher for paths under root from gitignore-style patterns
+func New(root string, patterns []string) (*Matcher, error) {
+	matcher := &Matcher{root: root}
+
+	for _, line := range patterns {
+		err := matcher.add(line, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return matcher, nil
+}
+
+// Load creates a matcher for root from, in the order of increasing precedence,
+// .gitignore files (if requested), the .dockersyncignore file and the extra patterns
+func Load(root string, options Options) (*Matcher, error) {
+	matcher := &Matcher{root: root}
+
+	if options.RespectGitignore {
+		err := matcher.addGitignores()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	patterns, err := ReadFile(filepath.Join(root, FileName))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range append(patterns, options.Exclude...) {
+		err := matcher.add(line, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return matcher, nil
+}
+
+// addGitignores adds the patterns of every .gitignore file in the tree, each relative
+// to its own directory. Directories ignored by their parents are not searched
+func (matcher *Matcher) addGitignores() error {
+	err := matcher.add(".git/", "")
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(matcher.root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk path %s: %w", filePath, err)
+		}
+
+		if info.IsDir() {
+			if matcher.Match(filePath, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Name() != GitignoreFileName {
+			return nil
+		}
+
+		base, err := hostpath.Rel(matcher.root, filepath.Dir(filePath))
+		if err != nil {
+			return err
+		}
+		if base == "." {
+			base = ""
+		}
+
+		patterns, err := ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range patterns {
+			err := matcher.add(line, base)
+			if err != nil {
+				return fmt.Errorf("%s: %w", filePath, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// ReadFile reads patterns from a gitignore-style file. A missing file yields no patterns
+func ReadFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ignore file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ignore file %s: %w", path, err)
+	}
+
+	return patterns, nil
+}
+
+// Join returns a matcher excluding the paths that any of the matchers excludes,
+// for watching several roots at once
+func Join(matchers ...*Matcher) *Matcher {
+	return &Matcher{joined: matchers}
+}
+
+// Match reports whether the absolute path is excluded. A path is also excluded
+// when any of its parent directories is, just like in git
+func (matcher *Matcher) Match(absPath string, isDir bool) bool {
+	if matcher == nil {
+		return false
+	}
+	for _, joined := range matcher.joined {
+		if joined.Match(absPath, isDir) {
+			return true
+		}
+	}
+	if len(matcher.patterns) == 0 {
+		return false
+	}
+
+	rel, ok := hostpath.Inside(matcher.root, absPath)
+	if !ok || rel == "." {
+		return false
+	}
+
+	segments := strings.Split(rel, "/")
+	for i := 1; i < len(segments); i++ {
+		if matcher.matchRel(strings.Join(segments[:i], "/"), true) {
+			return true
+		}
+	}
+
+	return matcher.matchRel(rel, isDir)
+}
+
+func (matcher *Matcher) matchRel(rel string, isDir bool) bool {
+	ignored := false
+	for _, p := range matcher.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		target := rel
+		if p.base != "" {
+			if !strings.HasPrefix(rel, p.base+"/") {
+				continue
+			}
+			target = rel[len(p.base)+1:]
+		}
+
+		if p.regexp.MatchString(target) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+func (matcher *Matcher) add(line string, base string) error {
+	line = strings.TrimRight(line, "\r")
+	if strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	p := pattern{base: base}
+
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// Patterns with a slash anywhere but at the end are relative to the root,
+	// others match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return nil
+	}
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return fmt.Errorf("invalid ignore pattern %q: %w", line, err)
+	}
+	p.regexp = re
+
+	matcher.patterns = append(matcher.patterns, p)
+	return nil
+}
+
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return expr.String()
+}
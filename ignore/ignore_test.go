@@ -0,0 +1,204 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes the files with the contents under root
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	root := t.TempDir()
+	matcher, err := New(root, []string{
+		"# comments and blank lines are skipped",
+		"",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/dist",
+		"docs/*.md",
+		"**/cache/**",
+		"tmp?",
+		"[abc].txt",
+		`\#notes`,
+		"trailing   ",
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"app.log", false, true},
+		{"logs/deep/app.log", false, true},
+		{"keep.log", false, false},
+		{"main.go", false, false},
+		// Directory patterns exclude directories and everything inside of them
+		{"build", true, true},
+		{"build", false, false},
+		{"src/build/out.js", false, true},
+		// Patterns starting with a slash are anchored at the root
+		{"dist", true, true},
+		{"src/dist", true, false},
+		{"docs/readme.md", false, true},
+		{"docs/api/readme.md", false, false},
+		{"src/cache/objects/1", false, true},
+		{"tmp1", false, true},
+		{"tmp12", false, false},
+		{"b.txt", false, true},
+		{"d.txt", false, false},
+		{"#notes", false, true},
+		{"trailing", false, true},
+		// The root itself is never excluded
+		{".", true, false},
+	}
+	for _, test := range tests {
+		path := filepath.Join(root, filepath.FromSlash(test.path))
+		if got := matcher.Match(path, test.isDir); got != test.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+
+	if matcher.Match(filepath.Join(filepath.Dir(root), "app.log"), false) {
+		t.Error("a path outside the root is excluded")
+	}
+}
+
+func TestMatchNil(t *testing.T) {
+	var matcher *Matcher
+	if matcher.Match("/src/app.log", false) {
+		t.Error("a nil matcher excludes paths")
+	}
+}
+
+func TestLoadGivesExcludesPrecedence(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{FileName: "*.log\nvendor/\n"})
+
+	matcher, err := Load(root, Options{Exclude: []string{"!debug.log", "*.tmp"}})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	tests := map[string]bool{
+		"app.log":        true,
+		"debug.log":      false,
+		"cache.tmp":      true,
+		"vendor/lib.go":  true,
+		"cmd/main.go":    false,
+		"vendor.go":      false,
+		"logs/debug.log": false,
+	}
+	for path, want := range tests {
+		if got := matcher.Match(filepath.Join(root, filepath.FromSlash(path)), false); got != want {
+			t.Errorf("Match(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestLoadWithoutIgnoreFile(t *testing.T) {
+	root := t.TempDir()
+	matcher, err := Load(root, Options{})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if matcher.Match(filepath.Join(root, "app.log"), false) {
+		t.Error("a path is excluded without any patterns")
+	}
+}
+
+func TestLoadRespectsGitignore(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		GitignoreFileName:                       "node_modules/\n*.o\n",
+		"web/" + GitignoreFileName:              "/dist\n!keep.o\n",
+		"node_modules/pkg/" + GitignoreFileName: "!*\n",
+		".git/HEAD":                             "ref: refs/heads/main\n",
+		FileName:                                "secrets/\n",
+	})
+
+	matcher, err := Load(root, Options{RespectGitignore: true})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{".git", true, true},
+		{".git/HEAD", false, true},
+		{"node_modules/pkg/index.js", false, true},
+		{"main.o", false, true},
+		{"main.go", false, false},
+		// Patterns of nested .gitignore files are relative to their directory
+		{"web/dist", true, true},
+		{"dist", true, false},
+		{"web/keep.o", false, false},
+		{"api/keep.o", false, true},
+		{"secrets/key", false, true},
+	}
+	for _, test := range tests {
+		if got := matcher.Match(filepath.Join(root, filepath.FromSlash(test.path)), test.isDir); got != test.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+}
+
+func TestLoadIgnoresGitignoreByDefault(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{GitignoreFileName: "*.o\n"})
+
+	matcher, err := Load(root, Options{})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if matcher.Match(filepath.Join(root, "main.o"), false) {
+		t.Error("a path ignored by .gitignore is excluded without RespectGitignore")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	web, api := t.TempDir(), t.TempDir()
+	webMatcher, err := New(web, []string{"*.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiMatcher, err := New(api, []string{"vendor/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each matcher only excludes paths under its own root
+	matcher := Join(webMatcher, apiMatcher, nil)
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{filepath.Join(web, "app.log"), false, true},
+		{filepath.Join(api, "app.log"), false, false},
+		{filepath.Join(api, "vendor"), true, true},
+		{filepath.Join(web, "vendor"), true, false},
+	}
+	for _, test := range tests {
+		if got := matcher.Match(test.path, test.isDir); got != test.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is the recorded state of a file at the time it was last synced
+type Entry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash"`
+	// Mode holds the permissions of the file, missing in entries recorded by older versions
+	Mode os.FileMode `json:"mode,omitempty"`
+}
+
+// Index keeps track of the contents of synced files to detect changes
+// that don't actually modify them, like touching a file
+type Index struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+	// hashed caches the last computed state of files that haven't been recorded yet,
+	// so that checking a file again before it's synced doesn't hash it twice
+	hashed map[string]Entry
+}
+
+func New() *Index {
+	return &Index{
+		entries: make(map[string]Entry),
+		hashed:  make(map[string]Entry),
+	}
+}
+
+// Check returns the current state of the file and whether it differs from the recorded one.
+// Files with the same size and modification time are assumed unchanged without hashing them
+func (index *Index) Check(path string, info os.FileInfo) (Entry, bool, error) {
+	index.mu.Lock()
+	recorded, ok := index.entries[path]
+	cached, isCached := index.hashed[path]
+	index.mu.Unlock()
+
+	if ok && recorded.Size == info.Size() && recorded.ModTime.Equal(info.ModTime()) {
+		return recorded, false, nil
+	}
+
+	entry := Entry{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode().Perm(),
+	}
+
+	if isCached && cached.Size == entry.Size && cached.ModTime.Equal(entry.ModTime) {
+		entry.Hash = cached.Hash
+	} else {
+		hash, err := Hash(path)
+		if err != nil {
+			return Entry{}, false, err
+		}
+		entry.Hash = hash
+
+		index.mu.Lock()
+		index.hashed[path] = entry
+		index.mu.Unlock()
+	}
+
+	if ok && recorded.Hash == entry.Hash {
+		index.Record(path, entry)
+		return entry, false, nil
+	}
+
+	return entry, true, nil
+}
+
+// ModeChanged reports whether the permissions of the file differ from the recorded ones,
+// which changing them doesn't show in the size or modification time
+func (index *Index) ModeChanged(path string, info os.FileInfo) bool {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	recorded, ok := index.entries[path]
+	return ok && recorded.Mode != 0 && recorded.Mode != info.Mode().Perm()
+}
+
+// Record stores the state of a file once it has been synced
+func (index *Index) Record(path string, entry Entry) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.entries[path] = entry
+	delete(index.hashed, path)
+}
+
+// Forget removes the recorded state of a file, so that it is considered changed next time
+func (index *Index) Forget(path string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	delete(index.entries, path)
+	delete(index.hashed, path)
+}
+
+// Reset forgets the recorded state of all files, so that they are all considered changed
+func (index *Index) Reset() {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.entries = make(map[string]Entry)
+	index.hashed = make(map[string]Entry)
+}
+
+// Entries returns a copy of the recorded states of all files, e.g. to persist them
+func (index *Index) Entries() map[string]Entry {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	entries := make(map[string]Entry, len(index.entries))
+	for path, entry := range index.entries {
+		entries[path] = entry
+	}
+	return entries
+}
+
+// Restore records the states of files returned by Entries, e.g. by a previous run
+func (index *Index) Restore(entries map[string]Entry) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	for path, entry := range entries {
+		index.entries[path] = entry
+	}
+}
+
+// Hash returns the hex-encoded SHA-256 of the contents of a file
+func Hash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,316 @@
+// Package livereload implements a server of the LiveReload protocol, which tells connected browsers
+// to reload the page, or only its stylesheets, when files change
+package livereload
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// protocol is the version of the LiveReload protocol the server speaks
+const protocol = "http://livereload.com/protocols/official-7"
+
+// websocketGUID is appended to the key of a WebSocket handshake to compute the accept key
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxFrameSize is the largest message accepted from browsers, which only send short commands
+const maxFrameSize = 64 * 1024
+
+// WebSocket opcodes
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+// script is served as /livereload.js for pages without the browser extension. It reloads
+// stylesheets in place when only they changed and the whole page otherwise
+const script = `(function () {
+  var src = document.currentScript && document.currentScript.src;
+  var url = src ? src.replace(/^http/, "ws").replace(/livereload\.js.*$/, "livereload") : "ws://" + location.hostname + ":%d/livereload";
+  function connect() {
+    var socket = new WebSocket(url);
+    socket.onopen = function () {
+      socket.send(JSON.stringify({command: "hello", protocols: ["%s"]}));
+    };
+    socket.onmessage = function (event) {
+      var message = JSON.parse(event.data);
+      if (message.command !== "reload") return;
+      if (message.liveCSS && /\.css$/i.test(message.path)) {
+        var links = document.querySelectorAll('link[rel="stylesheet"]');
+        for (var i = 0; i < links.length; i++) {
+          var href = links[i].href.replace(/[?&]livereload=\d+/, "");
+          links[i].href = href + (href.indexOf("?") < 0 ? "?" : "&") + "livereload=" + Date.now();
+        }
+        return;
+      }
+      location.reload();
+    };
+    socket.onclose = function () {
+      setTimeout(connect, 1000);
+    };
+  }
+  connect();
+})();
+`
+
+// Server tells the browsers connected to it to reload
+type Server struct {
+	port    int
+	logger  *slog.Logger
+	mu      sync.Mutex
+	clients map[*client]bool
+}
+
+// New creates a server listening on the port once started
+func New(port int, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Server{port: port, logger: logger, clients: make(map[*client]bool)}
+}
+
+// ListenAndServe serves browsers until ctx is canceled
+func (server *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", server.port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", server.port, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livereload", server.handleWebSocket)
+	mux.HandleFunc("/livereload.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		fmt.Fprintf(w, script, server.port, protocol)
+	})
+	httpServer := &http.Server{Handler: mux}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	server.mu.Lock()
+	for client := range server.clients {
+		client.conn.Close()
+	}
+	server.mu.Unlock()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
+
+// Reload tells the browsers that the files changed. Browsers reload only stylesheets when
+// nothing else changed, and the whole page otherwise or without paths
+func (server *Server) Reload(paths []string) {
+	reloaded := []string{""}
+	for i, path := range paths {
+		if !strings.HasSuffix(strings.ToLower(path), ".css") {
+			reloaded = []string{path}
+			break
+		}
+		if i == 0 {
+			reloaded = nil
+		}
+		reloaded = append(reloaded, path)
+	}
+
+	server.mu.Lock()
+	clients := make([]*client, 0, len(server.clients))
+	for client := range server.clients {
+		clients = append(clients, client)
+	}
+	server.mu.Unlock()
+
+	if len(clients) > 0 {
+		server.logger.Debug("Reloading {count} browsers...", "count", len(clients))
+	}
+	for _, client := range clients {
+		for _, path := range reloaded {
+			err := client.send(map[string]any{"command": "reload", "path": path, "liveCSS": true})
+			if err != nil {
+				server.logger.Debug("Failed to reload a browser: {error}", "error", err)
+				client.conn.Close()
+				break
+			}
+		}
+	}
+}
+
+// handleWebSocket upgrades the request to a WebSocket and serves the browser until it disconnects
+func (server *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket connection", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can't be upgraded", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(hash[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	c := &client{conn: conn, reader: rw.Reader}
+	server.mu.Lock()
+	server.clients[c] = true
+	server.mu.Unlock()
+	defer func() {
+		server.mu.Lock()
+		delete(server.clients, c)
+		server.mu.Unlock()
+	}()
+
+	server.logger.Debug("Browser connected from {address}", "address", conn.RemoteAddr().String())
+	err = c.serve()
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		server.logger.Debug("Browser at {address} disconnected: {error}", "address", conn.RemoteAddr().String(), "error", err)
+	}
+}
+
+// client is a browser connected over a WebSocket
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// mu serializes writes of frames
+	mu sync.Mutex
+}
+
+// serve answers the commands of the browser until it disconnects
+func (c *client) serve() error {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return io.EOF
+		case opPing:
+			err = c.writeFrame(opPong, payload)
+		case opText:
+			var command struct {
+				Command string `json:"command"`
+			}
+			if json.Unmarshal(payload, &command) == nil && command.Command == "hello" {
+				err = c.send(map[string]any{"command": "hello", "protocols": []string{protocol}, "serverName": "docker-sync"})
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// send writes the message as JSON in a text frame
+func (c *client) send(message any) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(opText, payload)
+}
+
+// readFrame reads a frame of the browser, which is always masked. Fragmented messages
+// aren't supported, since commands are short
+func (c *client) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", length)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// writeFrame writes a final unmasked frame, as servers do
+func (c *client) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	frame := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.conn.Write(frame)
+	return err
+}
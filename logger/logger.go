@@ -0,0 +1,132 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+const (
+	ColorReset  = "\033[0m"
+	ColorRed    = "\033[31m"
+	ColorYellow = "\033[33m"
+	ColorBlue   = "\033[34m"
+)
+
+type Options struct {
+	// Format is either FormatText (default) or FormatJSON
+	Format string
+	Level  slog.Level
+	// Text output goes to Stdout, except for warnings and errors that go to Stderr.
+	// JSON output always goes to Stdout. They default to os.Stdout and os.Stderr
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// New creates a leveled logger. Messages can reference attributes as {key}:
+// in text output they are replaced with the values, and JSON output carries
+// both the expanded message and the attributes
+func New(options Options) (*slog.Logger, error) {
+	stdout := options.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	stderr := options.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	switch options.Format {
+	case "", FormatText:
+		return slog.New(newTextHandler(stdout, stderr, options.Level)), nil
+	case FormatJSON:
+		handler := slog.NewJSONHandler(stdout, &slog.HandlerOptions{Level: options.Level})
+		return slog.New(&expandingHandler{handler: handler}), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %s or %s", options.Format, FormatText, FormatJSON)
+	}
+}
+
+// Discard returns a logger that drops everything
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(level string) (slog.Level, error) {
+	var parsed slog.Level
+	err := parsed.UnmarshalText([]byte(level))
+	if err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+	}
+	return parsed, nil
+}
+
+// expandingHandler replaces {key} placeholders in messages before passing
+// records to the wrapped handler
+type expandingHandler struct {
+	handler slog.Handler
+	attrs   []slog.Attr
+}
+
+func (h *expandingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *expandingHandler) Handle(ctx context.Context, record slog.Record) error {
+	message, _ := expand(record.Message, append(h.attrs, recordAttrs(record)...))
+	expanded := slog.NewRecord(record.Time, record.Level, message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		expanded.AddAttrs(attr)
+		return true
+	})
+	return h.handler.Handle(ctx, expanded)
+}
+
+func (h *expandingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &expandingHandler{
+		handler: h.handler.WithAttrs(attrs),
+		attrs:   append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+}
+
+func (h *expandingHandler) WithGroup(name string) slog.Handler {
+	return &expandingHandler{handler: h.handler.WithGroup(name), attrs: h.attrs}
+}
+
+func recordAttrs(record slog.Record) []slog.Attr {
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	return attrs
+}
+
+// expand replaces {key} placeholders with attribute values and returns
+// the message along with the attributes that weren't referenced
+func expand(message string, attrs []slog.Attr) (string, []slog.Attr) {
+	if !strings.Contains(message, "{") {
+		return message, attrs
+	}
+
+	var rest []slog.Attr
+	for _, attr := range attrs {
+		placeholder := "{" + attr.Key + "}"
+		if strings.Contains(message, placeholder) {
+			message = strings.ReplaceAll(message, placeholder, attr.Value.Resolve().String())
+		} else {
+			rest = append(rest, attr)
+		}
+	}
+
+	return message, rest
+}
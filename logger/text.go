@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// textHandler prints human-readable messages: the expanded message followed by
+// the remaining attributes, with warnings and errors prefixed and sent to stderr
+type textHandler struct {
+	stdout io.Writer
+	stderr io.Writer
+	level  slog.Level
+	attrs  []slog.Attr
+	group  string
+	mu     *sync.Mutex
+}
+
+func newTextHandler(stdout, stderr io.Writer, level slog.Level) *textHandler {
+	return &textHandler{
+		stdout: stdout,
+		stderr: stderr,
+		level:  level,
+		mu:     &sync.Mutex{},
+	}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	attrs := append([]slog.Attr{}, h.attrs...)
+	record.Attrs(func(attr slog.Attr) bool {
+		if h.group != "" {
+			attr.Key = h.group + "." + attr.Key
+		}
+		attrs = append(attrs, attr)
+		return true
+	})
+
+	message, rest := expand(record.Message, attrs)
+
+	var line strings.Builder
+	out := h.stdout
+
+	switch {
+	case record.Level >= slog.LevelError:
+		line.WriteString(ColorRed + "Error:" + ColorReset + " ")
+		out = h.stderr
+	case record.Level >= slog.LevelWarn:
+		line.WriteString(ColorYellow + "Warning:" + ColorReset + " ")
+		out = h.stderr
+	}
+
+	line.WriteString(message)
+	for _, attr := range rest {
+		line.WriteString(" " + attr.Key + "=" + formatValue(attr.Value))
+	}
+	line.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(out, line.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, attr := range attrs {
+		if h.group != "" {
+			attr.Key = h.group + "." + attr.Key
+		}
+		clone.attrs = append(clone.attrs, attr)
+	}
+	return &clone
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		clone.group += "." + name
+	} else {
+		clone.group = name
+	}
+	return &clone
+}
+
+func formatValue(value slog.Value) string {
+	s := value.Resolve().String()
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,297 @@
+package syncer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+const (
+	// DefaultAgentImage runs the agents, it needs sh, nc with -e and tar
+	DefaultAgentImage = "busybox:stable"
+	// DefaultAgentPort is published by the agents on every node
+	DefaultAgentPort = 47470
+	// agentDataPath is where the agents mount the shared volume
+	agentDataPath = "/data"
+	// agentStartTimeout limits how long to wait for the agents to start on all nodes
+	agentStartTimeout = 2 * time.Minute
+)
+
+// agentScript returns the script of the agents, which extract every archive received
+// on the port into the volume and acknowledge it
+func (syncer *Syncer) agentScript() string {
+	flags := "-x"
+	if syncer.compress == CompressGzip {
+		flags = "-xz"
+	}
+	return fmt.Sprintf("while true; do nc -l -p %d -e sh -c 'tar %s -C %s && echo ok'; done", DefaultAgentPort, flags, agentDataPath)
+}
+
+// startAgents creates a volume mounted by the target service and a global service of agents
+// writing into it on every node, so that files reach replicas on nodes other than
+// the one Docker is reached through
+func (syncer *Syncer) startAgents(ctx context.Context) error {
+	// Agents can't decompress zstd
+	if syncer.compress == CompressZstd {
+		syncer.logger.Warn("Agents can't decompress zstd archives, using gzip instead")
+		syncer.compress = CompressGzip
+	}
+
+	// The volume is created on each node when the agent there mounts it
+	syncer.temporaryVolume = syncer.generateTemporaryName()
+
+	agentName := syncer.identifier + "-agent-" + syncer.temporaryVolume[len(syncer.identifier)+1:]
+
+	// Every node pulls the image, with the credentials of the Docker CLI sent along with the service
+	auth, err := registryAuth(syncer.agentImage)
+	if err != nil {
+		syncer.logger.Debug("Pulling {image} anonymously, failed to get credentials: {error}", "image", syncer.agentImage, "error", err)
+	}
+
+	syncer.logger.Debug("Creating agent service {service}...", "service", agentName)
+	response, err := syncer.client.ServiceCreate(ctx, swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name:   agentName,
+			Labels: syncer.resourceLabels(),
+		},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: &swarm.ContainerSpec{
+				Image:   syncer.agentImage,
+				Command: []string{"sh", "-c", syncer.agentScript()},
+				Mounts: []mount.Mount{{
+					Type:          mount.TypeVolume,
+					Source:        syncer.temporaryVolume,
+					Target:        agentDataPath,
+					VolumeOptions: syncer.temporaryVolumeOptions(),
+				}},
+			},
+		},
+		Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}},
+		EndpointSpec: &swarm.EndpointSpec{
+			Ports: []swarm.PortConfig{{
+				Protocol:      swarm.PortConfigProtocolTCP,
+				TargetPort:    DefaultAgentPort,
+				PublishedPort: uint32(syncer.agentPort),
+				// Each node is reached directly instead of through the routing mesh
+				PublishMode: swarm.PortConfigPublishModeHost,
+			}},
+		},
+	}, types.ServiceCreateOptions{EncodedRegistryAuth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to create agent service: %w", err)
+	}
+	syncer.agentService = response.ID
+
+	err = syncer.waitForAgents(ctx)
+	if err != nil {
+		return err
+	}
+
+	// On every node, the service mounts the volume the agent there writes into
+	_, err = syncer.updateTargetService(ctx, true)
+	return err
+}
+
+// waitForAgents waits until an agent runs on every node running the target service
+func (syncer *Syncer) waitForAgents(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, agentStartTimeout)
+	defer cancel()
+
+	for {
+		targetNodes, err := syncer.serviceNodes(ctx, syncer.target)
+		if err != nil {
+			return err
+		}
+		agents, err := syncer.agentAddresses(ctx)
+		if err != nil {
+			return err
+		}
+
+		missing := 0
+		for node := range targetNodes {
+			if _, ok := agents[node]; !ok {
+				missing++
+			}
+		}
+		if missing == 0 {
+			return nil
+		}
+		syncer.logger.Debug("Waiting for agents on {count} nodes...", "count", missing)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("agents didn't start on %d nodes: %w", missing, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// serviceNodes returns the IDs of the nodes running tasks of the service
+func (syncer *Syncer) serviceNodes(ctx context.Context, service string) (map[string]bool, error) {
+	tasks, err := syncer.client.TaskList(ctx, types.TaskListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("service", service),
+			filters.Arg("desired-state", "running"),
+		),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks of service %s: %w", service, err)
+	}
+
+	nodes := make(map[string]bool)
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning && task.NodeID != "" {
+			nodes[task.NodeID] = true
+		}
+	}
+	return nodes, nil
+}
+
+// agentAddresses returns the addresses of the running agents by the IDs of their nodes
+func (syncer *Syncer) agentAddresses(ctx context.Context) (map[string]string, error) {
+	nodes, err := syncer.serviceNodes(ctx, syncer.agentService)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make(map[string]string, len(nodes))
+	for nodeId := range nodes {
+		node, _, err := syncer.client.NodeInspectWithRaw(ctx, nodeId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect node %s: %w", nodeId, err)
+		}
+		addresses[nodeId] = net.JoinHostPort(node.Status.Addr, strconv.Itoa(syncer.agentPort))
+	}
+	return addresses, nil
+}
+
+// copyToAgents streams the paths to the agent on every node and returns the number
+// of entries copied
+func (syncer *Syncer) copyToAgents(ctx context.Context, sourcePaths []string) (int, error) {
+	agents, err := syncer.agentAddresses(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(agents) == 0 {
+		return 0, fmt.Errorf("no agents are running")
+	}
+
+	// Paths in the archive are relative to the volume the agents extract it into
+	entries, pending, err := syncer.collectEntries(ctx, sourcePaths, ".")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create tar archive: %w", err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	addresses := make([]string, 0, len(agents))
+	for _, address := range agents {
+		addresses = append(addresses, address)
+	}
+
+	tracker := syncer.newTracker(entries, len(addresses))
+	err = syncer.workers.Each(ctx, addresses, func(address string) error {
+		syncer.logger.Debug("Copying to agent {agent}...", "agent", address)
+		err := syncer.streamArchive(ctx, entries, tracker, func(reader io.Reader) error {
+			return syncer.uploadToAgent(ctx, address, reader)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy to agent %s: %w", address, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if tracker != nil {
+		tracker.finish()
+	}
+
+	syncer.countCopied(entries)
+	for path, entry := range pending {
+		syncer.index.Record(path, entry)
+	}
+
+	return len(entries), nil
+}
+
+// uploadToAgent sends the archive to the agent and waits for it to be extracted.
+// With an SSH bastion, the agent is reached through it like the host
+func (syncer *Syncer) uploadToAgent(ctx context.Context, address string, reader io.Reader) error {
+	var conn net.Conn
+	var err error
+	dial := (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	if syncer.sshBastion != "" {
+		dial, err = bastionDialer(syncer.sshBastion, syncer.sshFlags)
+		if err != nil {
+			return err
+		}
+	}
+	// The agent listens again only once it's done with the previous archive
+	for attempt := 0; attempt < 10; attempt++ {
+		conn, err = dial(ctx, "tcp", address)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	if _, err := io.Copy(conn, reader); err != nil {
+		return err
+	}
+	// Closing the writing side ends the input of tar
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.CloseWrite(); err != nil {
+			return err
+		}
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if strings.TrimSpace(reply) != "ok" {
+		if err != nil {
+			return fmt.Errorf("agent failed to extract the archive: %w", err)
+		}
+		return fmt.Errorf("agent failed to extract the archive: %s", reply)
+	}
+	return nil
+}
+
+// removeAgents removes the agent service. The volumes it created on the nodes are left behind
+func (syncer *Syncer) removeAgents(ctx context.Context) error {
+	if syncer.agentService == "" {
+		return nil
+	}
+
+	syncer.logger.Debug("Removing agent service {service}...", "service", syncer.agentService)
+	err := syncer.client.ServiceRemove(ctx, syncer.agentService)
+	if err != nil {
+		return fmt.Errorf("failed to remove agent service %s: %w", syncer.agentService, err)
+	}
+	syncer.agentService = ""
+	return nil
+}
@@ -0,0 +1,108 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// stagingDirName is the directory inside the target path that files replaced atomically are uploaded into
+const stagingDirName = ".docker-sync-staging"
+
+// swapScript moves the staged files into place with a rename each, so that they're replaced
+// as a whole, and removes the staging directory. It's run with the staging directory
+// and the target path as arguments
+const swapScript = `[ -d "$1" ] || exit 0
+cd "$1" || exit 1
+find . -type f -exec sh -c 'for f; do mkdir -p "$(dirname "$0/$f")" && mv -f "$f" "$0/$f" || exit 1; done' "$2" {} +
+status=$?
+cd / && rm -rf "$1"
+exit $status`
+
+// initAtomicFiles turns atomic file replacement off for targets whose files aren't copied
+// into running containers
+func (syncer *Syncer) initAtomicFiles() {
+	if !syncer.atomicFiles {
+		return
+	}
+
+	reason := ""
+	switch {
+	case syncer.targetType == Volume:
+		reason = "volumes have no running containers"
+	case syncer.agent:
+		reason = "agents extract archives themselves"
+	case syncer.strategy == StrategyVolumeServiceUpdate:
+		reason = "the files are copied into a temporary volume"
+	}
+	if reason != "" {
+		syncer.logger.Warn("Files can't be replaced atomically in {target}, {reason}", "target", syncer.target, "reason", reason)
+		syncer.atomicFiles = false
+	}
+}
+
+// stagingRoot returns the directory whose files are staged, which is the target path
+// or, for a single file, the directory of the file
+func (syncer *Syncer) stagingRoot() string {
+	if syncer.sourceIsFile {
+		return path.Dir(syncer.targetPath)
+	}
+	return syncer.targetPath
+}
+
+// stagingPath returns the directory that files replaced atomically are uploaded into
+func (syncer *Syncer) stagingPath() string {
+	return path.Join(syncer.stagingRoot(), stagingDirName)
+}
+
+// stageEntries places the files among the entries into the staging directory when files are replaced atomically.
+// Directories and symlinks are created in place, since they can't be seen half-written.
+// Nothing reads the files of a stopped target, so they're copied in place as well
+func (syncer *Syncer) stageEntries(entries []archiveEntry) []archiveEntry {
+	if !syncer.atomicFiles || syncer.stoppedContainer != "" {
+		return entries
+	}
+
+	staged := make([]archiveEntry, len(entries))
+	for i, entry := range entries {
+		if entry.info.Mode().IsRegular() {
+			relPath := strings.TrimPrefix(entry.headerPath, syncer.stagingRoot())
+			entry.headerPath = path.Join(syncer.stagingPath(), relPath)
+		}
+		staged[i] = entry
+	}
+	return staged
+}
+
+// swapCommand returns the command moving the staged files into place
+func (syncer *Syncer) swapCommand() []string {
+	return []string{"sh", "-c", swapScript, "sh", syncer.stagingPath(), syncer.stagingRoot()}
+}
+
+// swapInContainer moves the files staged in the container into place
+func (syncer *Syncer) swapInContainer(ctx context.Context, containerId string) error {
+	syncer.logger.Debug("Moving staged files into place in container {container}...", "container", containerId)
+	var stderr bytes.Buffer
+	exitCode, err := syncer.ContainerExec(ctx, containerId, syncer.swapCommand(), io.Discard, &stderr)
+	if err != nil {
+		return fmt.Errorf("failed to move staged files into place: %w", err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("failed to move staged files into place (atomic file replacement needs sh and find in the container): %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// swapInPod moves the files staged in the pod into place
+func (syncer *Syncer) swapInPod(ctx context.Context) error {
+	syncer.logger.Debug("Moving staged files into place in {target}...", "target", syncer.kube.String())
+	var stderr bytes.Buffer
+	err := syncer.kubectlExec(ctx, nil, io.Discard, &stderr, syncer.swapCommand()...)
+	if err != nil {
+		return fmt.Errorf("failed to move staged files into place (atomic file replacement needs sh and find in the container): %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
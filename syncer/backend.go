@@ -0,0 +1,140 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Backend syncs files into targets docker-sync doesn't know, e.g. LXD containers, ECS tasks or remote
+// machines reached with rsync. Backends are registered with RegisterBackend under a scheme and picked
+// by destinations in the <scheme>://<target>:<path> format, so that programs embedding docker-sync can
+// add them without forking it
+type Backend interface {
+	// Resolve finds the target, failing if it doesn't exist. It's called before anything else
+	Resolve(ctx context.Context) error
+	// Copy extracts the uncompressed tar archive into the target. Names in the archive are
+	// absolute paths of the target
+	Copy(ctx context.Context, archive io.Reader) error
+	// Delete removes the paths of the target, which are absolute, along with everything inside them.
+	// It's called for paths removed from the source when removals are synced
+	Delete(ctx context.Context, paths []string) error
+	// Restart restarts the target after a sync with --restart or a restart rule
+	Restart(ctx context.Context) error
+	// Cleanup brings the target back to its original state on exit
+	Cleanup(ctx context.Context) error
+}
+
+// BackendFactory creates the backend syncing into the target, which is the part of the destination
+// between the scheme and the path
+type BackendFactory func(target string) (Backend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendFactory)
+)
+
+// builtinSchemes are the schemes of destinations handled by docker-sync itself
+var builtinSchemes = []string{KubeScheme, ComposeScheme, VolumeScheme, SecretScheme, ConfigScheme}
+
+// RegisterBackend makes the backend created by the factory handle destinations starting with
+// <scheme>://. It panics if the scheme is taken, like database/sql.Register does, so it's meant
+// to be called from init functions
+func RegisterBackend(scheme string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if scheme == "" || strings.ContainsAny(scheme, ":/") {
+		panic(fmt.Sprintf("syncer: invalid backend scheme %q", scheme))
+	}
+	if factory == nil {
+		panic("syncer: backend factory of " + scheme + " is nil")
+	}
+	for _, builtin := range builtinSchemes {
+		if builtin == scheme+"://" {
+			panic("syncer: backend scheme " + scheme + " is built in")
+		}
+	}
+	if _, taken := backends[scheme]; taken {
+		panic("syncer: backend scheme " + scheme + " is registered twice")
+	}
+	backends[scheme] = factory
+}
+
+// Backends returns the schemes of the registered backends in alphabetical order
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	schemes := make([]string, 0, len(backends))
+	for scheme := range backends {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// ParseBackendDestination creates the backend of a destination in the <scheme>://<target>:<path>
+// format and returns it along with the target and the path. ok is false if no backend is
+// registered for the scheme of the destination, if it has one
+func ParseBackendDestination(destination string) (backend Backend, target, targetPath string, ok bool, err error) {
+	scheme, rest, found := strings.Cut(destination, "://")
+	if !found {
+		return nil, "", "", false, nil
+	}
+
+	backendsMu.RLock()
+	factory := backends[scheme]
+	backendsMu.RUnlock()
+	if factory == nil {
+		return nil, "", "", false, nil
+	}
+
+	// Targets can have colons, e.g. host:port, while paths can't
+	separator := strings.LastIndex(rest, ":")
+	if separator <= 0 || separator == len(rest)-1 {
+		return nil, "", "", true, fmt.Errorf("destination %s must be in the following format: %s://<target>:<path>", destination, scheme)
+	}
+	target, targetPath = rest[:separator], rest[separator+1:]
+
+	backend, err = factory(target)
+	if err != nil {
+		return nil, "", "", true, fmt.Errorf("failed to create %s backend for %s: %w", scheme, target, err)
+	}
+	return backend, scheme + "://" + target, targetPath, true, nil
+}
+
+// DeletesRemoved reports whether paths removed from the source are deleted from the target,
+// which backends do when given the removed paths
+func (syncer *Syncer) DeletesRemoved() bool {
+	return syncer.targetType == External
+}
+
+// copyToBackend streams the paths to the backend of the target
+func (syncer *Syncer) copyToBackend(ctx context.Context, sourcePaths []string) (int, error) {
+	return syncer.uploadArchive(ctx, sourcePaths, syncer.targetPath, nil, func(reader io.Reader) error {
+		return syncer.workers.Do(ctx, syncer.target, func() error {
+			return syncer.backend.Copy(ctx, reader)
+		})
+	})
+}
+
+// deleteFromBackend removes the paths removed from the source from the target of the backend
+func (syncer *Syncer) deleteFromBackend(ctx context.Context, localPaths []string) error {
+	var paths []string
+	for _, localPath := range localPaths {
+		containerPath, err := syncer.containerPathFor(localPath, syncer.targetPath)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, containerPath)
+	}
+
+	syncer.logger.Debug("Deleting {count} paths from {target}...", "count", len(paths), "target", syncer.target)
+	return syncer.workers.Do(ctx, syncer.target, func() error {
+		return syncer.backend.Delete(ctx, paths)
+	})
+}
@@ -0,0 +1,107 @@
+package syncer
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthReadsPerSecond is about how often a stream limited by a Bandwidth gets to send,
+// so that low limits still send steadily instead of in bursts
+const bandwidthReadsPerSecond = 20
+
+// minBandwidthRead is the least a stream limited by a Bandwidth reads at once
+const minBandwidthRead = 512
+
+// Bandwidth limits how many bytes per second uploads send with a token bucket holding
+// up to a second worth of bytes. Syncers sharing a Bandwidth share the limit
+type Bandwidth struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidth creates a limit of bytesPerSecond, or nil, which doesn't limit anything,
+// if it's not positive
+func NewBandwidth(bytesPerSecond int64) *Bandwidth {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &Bandwidth{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// Limit returns the limit in bytes per second, 0 if there's none
+func (bandwidth *Bandwidth) Limit() float64 {
+	if bandwidth == nil {
+		return 0
+	}
+	return bandwidth.rate
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait before sending them.
+// The bucket can go into debt, which the streams sending next wait out
+func (bandwidth *Bandwidth) reserve(n int) time.Duration {
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+
+	now := time.Now()
+	bandwidth.tokens = min(bandwidth.tokens+now.Sub(bandwidth.last).Seconds()*bandwidth.rate, bandwidth.rate)
+	bandwidth.last = now
+
+	bandwidth.tokens -= float64(n)
+	if bandwidth.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bandwidth.tokens / bandwidth.rate * float64(time.Second))
+}
+
+// reader returns r limited by the bandwidth, reporting the bytes it sends to the tracker if set.
+// Reads waiting for the bandwidth fail once ctx is canceled
+func (bandwidth *Bandwidth) reader(ctx context.Context, r io.Reader, tracker *progressTracker) io.Reader {
+	if bandwidth == nil {
+		return r
+	}
+	return &limitedReader{
+		ctx:       ctx,
+		reader:    r,
+		bandwidth: bandwidth,
+		tracker:   tracker,
+		chunk:     max(int(bandwidth.rate/bandwidthReadsPerSecond), minBandwidthRead),
+	}
+}
+
+type limitedReader struct {
+	ctx       context.Context
+	reader    io.Reader
+	bandwidth *Bandwidth
+	tracker   *progressTracker
+	chunk     int
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.chunk {
+		p = p[:lr.chunk]
+	}
+	n, err := lr.reader.Read(p)
+	if n == 0 {
+		return n, err
+	}
+
+	wait := lr.bandwidth.reserve(n)
+	if wait > 0 {
+		if err := sleep(lr.ctx, wait); err != nil {
+			return n, err
+		}
+	}
+	if lr.tracker != nil {
+		lr.tracker.send(n, wait > 0)
+	}
+	return n, err
+}
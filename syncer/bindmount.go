@@ -0,0 +1,64 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// findServiceBindMount returns the bind mount of the target service at or above the target path,
+// the deepest one if there are several, or nil if the target path isn't bind-mounted
+func (syncer *Syncer) findServiceBindMount(ctx context.Context) (*mount.Mount, error) {
+	serviceInfo, _, err := syncer.client.ServiceInspectWithRaw(ctx, syncer.target, types.ServiceInspectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect service %s: %w", syncer.target, err)
+	}
+	if serviceInfo.Spec.TaskTemplate.ContainerSpec == nil {
+		return nil, nil
+	}
+
+	var found *mount.Mount
+	for _, m := range serviceInfo.Spec.TaskTemplate.ContainerSpec.Mounts {
+		if m.Type != mount.TypeBind {
+			continue
+		}
+		target := strings.TrimSuffix(m.Target, "/")
+		if syncer.targetPath != target && !strings.HasPrefix(syncer.targetPath, target+"/") {
+			continue
+		}
+		if found == nil || len(target) > len(strings.TrimSuffix(found.Target, "/")) {
+			found = &m
+		}
+	}
+	return found, nil
+}
+
+// initBindMount looks for a bind mount of the target service at or above the target path,
+// which a temporary volume would shadow or conflict with. Files copied into the running
+// container end up in the source of the bind mount on its node, so they survive updates
+// of the service and it can be restarted without a volume. A strategy picked by default
+// switches to copying into the bind mount, a temporary volume asked for is refused
+func (syncer *Syncer) initBindMount(ctx context.Context, given bool) error {
+	bindMount, err := syncer.findServiceBindMount(ctx)
+	if err != nil || bindMount == nil {
+		return err
+	}
+
+	if bindMount.ReadOnly {
+		return fmt.Errorf("service %s bind-mounts %s read-only at %s, sync into %s on its node instead", syncer.target, bindMount.Source, bindMount.Target, bindMount.Source)
+	}
+
+	if syncer.strategy == StrategyVolumeServiceUpdate {
+		if given {
+			return fmt.Errorf("service %s bind-mounts %s at %s, which the temporary volume of the %s strategy would shadow. Use the %s strategy to copy into the bind mount, or sync into %s on its node instead", syncer.target, bindMount.Source, bindMount.Target, syncer.strategy, StrategyDirectCopy, bindMount.Source)
+		}
+		syncer.logger.Warn("Service {service} bind-mounts {source} at {mount}, copying into the bind mount instead of a temporary volume. Tasks on other nodes don't get the files", "service", syncer.target, "source", bindMount.Source, "mount", bindMount.Target)
+		syncer.strategy = StrategyDirectCopy
+	}
+
+	syncer.bindMount = bindMount.Source
+	return nil
+}
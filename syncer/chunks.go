@@ -0,0 +1,288 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// chunksDirPrefix is the prefix of the directories in the target that chunks of archives are uploaded into
+const chunksDirPrefix = "/tmp/docker-sync-chunks-"
+
+// listChunksScript creates the directory of the chunks and prints the checksums of the chunks
+// already in it, skipping the ones being written. It's run with the directory as the argument
+const listChunksScript = `mkdir -p "$1" && cd "$1" || exit 1
+for f in *; do
+  case "$f" in *.part|"*") continue ;; esac
+  sha256sum "$f" || exit 1
+done`
+
+// writeChunkScript writes its input to the chunk given as the argument, renaming it into place
+// once complete, so that an interrupted upload leaves no chunk behind
+const writeChunkScript = `cat > "$1.part" && mv -f "$1.part" "$1"`
+
+// extractChunksScript extracts the chunks in the directory given as the first argument
+// as one archive with the command given as the rest of the arguments, then removes them
+const extractChunksScript = `dir=$1
+shift
+cat "$dir"/[0-9]* | "$@" || exit 1
+rm -rf "$dir"`
+
+// chunkTarget is a target archives can be uploaded into in chunks, see uploadChunks
+type chunkTarget struct {
+	// name identifies the target to the workers
+	name string
+	// run runs the command in the target with the input, writing its output to stdout
+	run func(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error
+	// finish is called once the archive is extracted, e.g. to move staged files into place
+	finish func(ctx context.Context) error
+}
+
+// chunkedArchive is an archive written to a local file and uploaded in chunks. It's kept
+// until it's uploaded, so that uploading the same entries again after a reconnect
+// only sends the chunks the target doesn't have yet
+type chunkedArchive struct {
+	// key identifies the entries of the archive, and the directory of its chunks in the target
+	key       string
+	file      string
+	size      int64
+	checksums []string
+}
+
+// chunksDir returns the directory in the target the chunks of the archive are uploaded into
+func (archive *chunkedArchive) chunksDir() string {
+	return chunksDirPrefix + archive.key[:16]
+}
+
+// chunkName returns the name of the i-th chunk, which sort in the order of the chunks
+func chunkName(i int) string {
+	return fmt.Sprintf("%06d", i)
+}
+
+// chunked reports whether the entries are large enough to be uploaded in chunks
+func (syncer *Syncer) chunked(entries []archiveEntry) bool {
+	if syncer.chunkSize <= 0 {
+		return false
+	}
+	var total int64
+	for _, entry := range entries {
+		if entry.info.Mode().IsRegular() {
+			total += entry.info.Size()
+		}
+	}
+	return total > syncer.chunkSize
+}
+
+// entriesKey returns a checksum of the entries and how they're archived, which changes
+// whenever the archive would
+func (syncer *Syncer) entriesKey(entries []archiveEntry) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%d\n", syncer.compress, syncer.chunkSize)
+	for _, entry := range entries {
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%d\x00%d\x00%s\n", entry.path, entry.headerPath, entry.info.Mode(), entry.info.Size(), entry.info.ModTime().UnixNano(), entry.linkTarget)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// prepareChunks writes the archive of the entries to a local file and computes the checksums
+// of its chunks, unless the archive of the same entries is still there from a previous attempt
+func (syncer *Syncer) prepareChunks(entries []archiveEntry, tracker *progressTracker) (*chunkedArchive, error) {
+	key := syncer.entriesKey(entries)
+	if syncer.pendingChunks != nil {
+		if syncer.pendingChunks.key == key {
+			syncer.logger.Debug("Resuming the upload of {size} bytes in {count} chunks", "size", syncer.pendingChunks.size, "count", len(syncer.pendingChunks.checksums))
+			return syncer.pendingChunks, nil
+		}
+		syncer.discardChunks()
+	}
+
+	file, err := os.CreateTemp("", "docker-sync-chunks-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tar archive: %w", err)
+	}
+	archive := &chunkedArchive{key: key, file: file.Name()}
+	// Chunks are extracted by the tar of the target, which may not understand sparse files
+	err = syncer.writeCompressedArchive(file, entries, tracker, false)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archive.file)
+		return nil, fmt.Errorf("failed to create tar archive: %w", err)
+	}
+
+	file, err = os.Open(archive.file)
+	if err != nil {
+		os.Remove(archive.file)
+		return nil, err
+	}
+	defer file.Close()
+	for {
+		hash := sha256.New()
+		n, err := io.CopyN(hash, file, syncer.chunkSize)
+		if n > 0 {
+			archive.size += n
+			archive.checksums = append(archive.checksums, hex.EncodeToString(hash.Sum(nil)))
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			os.Remove(archive.file)
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+	}
+
+	syncer.pendingChunks = archive
+	return archive, nil
+}
+
+// discardChunks removes the local file of the archive that wasn't completely uploaded
+func (syncer *Syncer) discardChunks() {
+	if syncer.pendingChunks == nil {
+		return
+	}
+	os.Remove(syncer.pendingChunks.file)
+	syncer.pendingChunks = nil
+}
+
+// uploadChunks uploads the archive of the entries into the target in chunks of ChunkSize,
+// skipping the chunks whose checksums show they're already there, and extracts it
+// once all of them are. Chunks stay in the target until then, so that an upload
+// interrupted by a lost connection resumes where it stopped
+func (syncer *Syncer) uploadChunks(ctx context.Context, entries []archiveEntry, tracker *progressTracker, target *chunkTarget) error {
+	archive, err := syncer.prepareChunks(entries, tracker)
+	if err != nil {
+		return err
+	}
+
+	return syncer.workers.Do(ctx, target.name, func() error {
+		present, err := syncer.listChunks(ctx, archive, target)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(archive.file)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		skipped := 0
+		for i, checksum := range archive.checksums {
+			if present[chunkName(i)] == checksum {
+				skipped++
+				continue
+			}
+			chunk := io.NewSectionReader(file, int64(i)*syncer.chunkSize, syncer.chunkSize)
+			err := target.run(ctx, []string{"sh", "-c", writeChunkScript, "sh", path.Join(archive.chunksDir(), chunkName(i))}, syncer.bandwidth.reader(ctx, chunk, tracker), io.Discard)
+			if err != nil {
+				return fmt.Errorf("failed to upload chunk %d of %d: %w", i+1, len(archive.checksums), err)
+			}
+		}
+		if skipped > 0 {
+			syncer.logger.Info("Resumed the upload to {target}, skipping {skipped} of {count} chunks already there", "target", target.name, "skipped", skipped, "count", len(archive.checksums))
+		}
+
+		// Chunks are verified before extracting them, since a corrupted one would corrupt every file after it
+		present, err = syncer.listChunks(ctx, archive, target)
+		if err != nil {
+			return err
+		}
+		for i, checksum := range archive.checksums {
+			if present[chunkName(i)] != checksum {
+				target.run(ctx, []string{"rm", "-f", path.Join(archive.chunksDir(), chunkName(i))}, nil, io.Discard)
+				return fmt.Errorf("chunk %d of %d doesn't match its checksum in the target", i+1, len(archive.checksums))
+			}
+		}
+
+		command := append([]string{"sh", "-c", extractChunksScript, "sh", archive.chunksDir()}, syncer.extractCommand()...)
+		err = target.run(ctx, command, nil, io.Discard)
+		if err != nil {
+			return fmt.Errorf("failed to extract the uploaded chunks: %w", err)
+		}
+		syncer.discardChunks()
+
+		if target.finish != nil {
+			return target.finish(ctx)
+		}
+		return nil
+	})
+}
+
+// listChunks returns the checksums of the chunks of the archive in the target by their names
+func (syncer *Syncer) listChunks(ctx context.Context, archive *chunkedArchive, target *chunkTarget) (map[string]string, error) {
+	var output bytes.Buffer
+	err := target.run(ctx, []string{"sh", "-c", listChunksScript, "sh", archive.chunksDir()}, nil, &output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the uploaded chunks (chunked uploads need sh, sha256sum and tar in the target): %w", err)
+	}
+
+	present := make(map[string]string)
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		checksum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if ok {
+			present[name] = checksum
+		}
+	}
+	return present, nil
+}
+
+// containerChunkTarget returns the container as a target of chunked uploads,
+// or nil when it's a helper container without a shell
+func (syncer *Syncer) containerChunkTarget(containerId string) *chunkTarget {
+	if containerId == syncer.temporaryContainer {
+		return nil
+	}
+	return &chunkTarget{
+		name: containerId,
+		run: func(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+			var stderr bytes.Buffer
+			// Like archives uploaded through the API, chunks are extracted as root whoever the container runs as
+			exitCode, err := syncer.containerExecAs(ctx, containerId, "root", command, stdin, stdout, &stderr)
+			if err != nil {
+				return err
+			}
+			if exitCode != 0 {
+				return fmt.Errorf("%s exited with code %d: %s", command[0], exitCode, strings.TrimSpace(stderr.String()))
+			}
+			return nil
+		},
+		finish: func(ctx context.Context) error {
+			if !syncer.atomicFiles {
+				return nil
+			}
+			return syncer.swapInContainer(ctx, containerId)
+		},
+	}
+}
+
+// podChunkTarget returns the target pod as a target of chunked uploads
+func (syncer *Syncer) podChunkTarget() *chunkTarget {
+	return &chunkTarget{
+		name: syncer.kube.String(),
+		run: func(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+			var stderr bytes.Buffer
+			err := syncer.kubectlExec(ctx, stdin, stdout, &stderr, command...)
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return nil
+		},
+		finish: func(ctx context.Context) error {
+			if !syncer.atomicFiles {
+				return nil
+			}
+			return syncer.swapInPod(ctx)
+		},
+	}
+}
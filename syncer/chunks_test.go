@@ -0,0 +1,286 @@
+package syncer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/klauspost/compress/zstd"
+)
+
+// chunkShell runs the scripts of chunked uploads in the containers of the fake
+type chunkShell struct {
+	client *fakeClient
+	// zstd is whether zstd is installed in the containers
+	zstd bool
+	// failWrite is the number of the write of a chunk that fails, counting from 1
+	failWrite int
+
+	writes   int
+	headers  []*tar.Header
+	extracts [][]string
+}
+
+func (shell *chunkShell) run(fake *fakeContainer, options container.ExecOptions, stdin io.Reader, stdout, stderr io.Writer) int {
+	shell.client.mu.Lock()
+	defer shell.client.mu.Unlock()
+
+	cmd := options.Cmd
+	if len(cmd) < 4 || cmd[0] != "sh" || cmd[1] != "-c" {
+		if len(cmd) == 3 && cmd[0] == "rm" {
+			delete(fake.files, cmd[2])
+			return 0
+		}
+		fmt.Fprintf(stderr, "unexpected command %q", cmd)
+		return 127
+	}
+
+	switch cmd[2] {
+	case "command -v zstd":
+		if !shell.zstd {
+			return 1
+		}
+		fmt.Fprintln(stdout, "/usr/bin/zstd")
+		return 0
+
+	case listChunksScript:
+		var names []string
+		for name := range fake.files {
+			if path, ok := strings.CutPrefix(name, cmd[4]+"/"); ok && !strings.HasSuffix(path, ".part") {
+				names = append(names, path)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			checksum := sha256.Sum256([]byte(fake.files[cmd[4]+"/"+name].content))
+			fmt.Fprintf(stdout, "%s  %s\n", hex.EncodeToString(checksum[:]), name)
+		}
+		return 0
+
+	case writeChunkScript:
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return 1
+		}
+		shell.writes++
+		if shell.writes == shell.failWrite {
+			fmt.Fprint(stderr, "No space left on device")
+			return 1
+		}
+		fake.files[cmd[4]] = fakeFile{mode: 0o644, content: string(data)}
+		return 0
+
+	case extractChunksScript:
+		dir, extract := cmd[4], cmd[5:]
+		shell.extracts = append(shell.extracts, extract)
+		var names []string
+		for name := range fake.files {
+			if strings.HasPrefix(name, dir+"/") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var archive bytes.Buffer
+		for _, name := range names {
+			archive.WriteString(fake.files[name].content)
+			delete(fake.files, name)
+		}
+
+		reader, err := shell.decompress(&archive, extract)
+		if err != nil {
+			fmt.Fprint(stderr, err)
+			return 1
+		}
+		var tee bytes.Buffer
+		if err := fake.extract(io.TeeReader(reader, &tee), "/"); err != nil {
+			fmt.Fprint(stderr, err)
+			return 1
+		}
+		tr := tar.NewReader(&tee)
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				break
+			}
+			shell.headers = append(shell.headers, header)
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "unexpected script %q", cmd[2])
+	return 127
+}
+
+// decompress returns the archive decompressed like the extraction command would
+func (shell *chunkShell) decompress(archive io.Reader, extract []string) (io.Reader, error) {
+	switch {
+	case slices.Equal(extract, []string{"tar", "-xf", "-", "-C", "/"}):
+		return archive, nil
+	case slices.Equal(extract, []string{"tar", "-xzf", "-", "-C", "/"}):
+		return gzip.NewReader(archive)
+	case slices.Equal(extract, []string{"sh", "-c", "zstd -dc | tar -xf - -C /"}):
+		if !shell.zstd {
+			return nil, errors.New("sh: zstd: not found")
+		}
+		decoder, err := zstd.NewReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unexpected extraction command %q", extract)
+}
+
+// newChunkSyncer returns a syncer uploading archives of more than 64 KiB in chunks into the
+// container web of the fake, compressed with the algorithm
+func newChunkSyncer(t *testing.T, shell *chunkShell, compress string) (*Syncer, string) {
+	t.Helper()
+	shell.client = newFakeClient()
+	shell.client.run = shell.run
+	shell.client.addContainer("web")
+	// The container runs as a user who can't write to /tmp/docker-sync-chunks-* or to /app
+	shell.client.byName("web").info.Config.User = "nobody"
+	return newTestSyncer(t, shell.client, "web", func(options *Options) {
+		options.ChunkSize = 64 << 10
+		options.Compress = compress
+	})
+}
+
+// writeRandomFile writes a file of the size with data that doesn't compress
+func writeRandomFile(t *testing.T, name string, size int) {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeHoleyFile writes a file of the size with the data at its start and a hole after it
+func writeHoleyFile(t *testing.T, name string, data string, size int64) {
+	t.Helper()
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	shell := &chunkShell{zstd: true}
+	syncer, source := newChunkSyncer(t, shell, CompressZstd)
+	if syncer.compress != CompressZstd {
+		t.Fatalf("compress = %s though the container has zstd, want %s", syncer.compress, CompressZstd)
+	}
+	largeTree(t, source)
+	writeRandomFile(t, filepath.Join(source, "random.bin"), 512<<10)
+	writeHoleyFile(t, filepath.Join(source, "disk.img"), "data", 1<<20)
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+
+	if calls := shell.client.recorded(); slices.Contains(calls, "copy web") {
+		t.Errorf("chunked archive was uploaded through the archive API: %v", calls)
+	}
+	if shell.writes < 2 {
+		t.Errorf("archive was uploaded in %d chunks, want more", shell.writes)
+	}
+	want := [][]string{{"sh", "-c", "zstd -dc | tar -xf - -C /"}}
+	if !slices.EqualFunc(shell.extracts, want, slices.Equal[[]string]) {
+		t.Errorf("chunks were extracted with %q, want %q", shell.extracts, want)
+	}
+
+	// The tar of the target extracts chunks as root, and may not understand sparse files
+	for _, options := range shell.client.executions() {
+		if options.Cmd[2] != "command -v zstd" && options.User != "root" {
+			t.Errorf("%q was run as %q, want root", options.Cmd, options.User)
+		}
+	}
+	for _, header := range shell.headers {
+		if strings.Contains(header.Name, "GNUSparseFile") || header.PAXRecords["GNU.sparse.major"] != "" {
+			t.Errorf("%s is archived as a sparse file", header.Name)
+		}
+	}
+	image, ok := shell.client.file("web", "/app/disk.img")
+	if !ok || image.content != "data"+strings.Repeat("\x00", 1<<20-4) {
+		t.Error("/app/disk.img wasn't copied with its hole")
+	}
+	if file, ok := shell.client.file("web", "/app/dir3/file19.bin"); !ok || len(file.content) != 64<<10 {
+		t.Error("/app/dir3/file19.bin wasn't copied")
+	}
+	for name := range shell.client.byName("web").files {
+		if strings.HasPrefix(name, chunksDirPrefix) {
+			t.Errorf("chunk %s was left in the container", name)
+		}
+	}
+}
+
+func TestChunkedUploadWithoutZstd(t *testing.T) {
+	shell := &chunkShell{zstd: false}
+	syncer, source := newChunkSyncer(t, shell, CompressZstd)
+	// The daemon could extract zstd archives, but chunks are extracted by the tar of the container
+	if syncer.compress != CompressGzip {
+		t.Fatalf("compress = %s though the container has no zstd, want %s", syncer.compress, CompressGzip)
+	}
+	largeTree(t, source)
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	want := [][]string{{"tar", "-xzf", "-", "-C", "/"}}
+	if !slices.EqualFunc(shell.extracts, want, slices.Equal[[]string]) {
+		t.Errorf("chunks were extracted with %q, want %q", shell.extracts, want)
+	}
+	if _, ok := shell.client.file("web", "/app/dir3/file19.bin"); !ok {
+		t.Error("/app/dir3/file19.bin wasn't copied")
+	}
+}
+
+func TestChunkedUploadResumes(t *testing.T) {
+	shell := &chunkShell{failWrite: 3}
+	syncer, source := newChunkSyncer(t, shell, CompressNone)
+	writeRandomFile(t, filepath.Join(source, "random.bin"), 512<<10)
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err == nil {
+		t.Fatal("CopyBatch() succeeded though a chunk couldn't be written")
+	}
+	chunks := len(syncer.pendingChunks.checksums)
+
+	// The next upload of the same files only sends the chunks the target doesn't have yet
+	err = syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	if want := 3 + chunks - 2; shell.writes != want {
+		t.Errorf("%d chunks were written, want %d", shell.writes, want)
+	}
+	if file, ok := shell.client.file("web", "/app/random.bin"); !ok || len(file.content) != 512<<10 {
+		t.Error("/app/random.bin wasn't copied")
+	}
+}
@@ -0,0 +1,77 @@
+package syncer
+
+import (
+	"sync"
+	"time"
+)
+
+// Coalescer collects changed paths and signals when no new paths have been
+// added for the interval, so that they can be synced together
+type Coalescer struct {
+	interval time.Duration
+	mu       sync.Mutex
+	paths    []string
+	seen     map[string]bool
+	timer    *time.Timer
+	ready    chan struct{}
+}
+
+func NewCoalescer(interval time.Duration) *Coalescer {
+	return &Coalescer{
+		interval: interval,
+		seen:     make(map[string]bool),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// Add queues a path and restarts the interval
+func (c *Coalescer) Add(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.seen[path] {
+		c.seen[path] = true
+		c.paths = append(c.paths, path)
+	}
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(c.interval, c.signal)
+}
+
+// Ready receives a value when queued paths are ready to be taken
+func (c *Coalescer) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Take returns the queued paths in the order they were first added and empties the queue
+func (c *Coalescer) Take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	paths := c.paths
+	c.paths = nil
+	c.seen = make(map[string]bool)
+
+	return paths
+}
+
+// Len returns how many paths are queued
+func (c *Coalescer) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.paths)
+}
+
+// Flush signals that the queued paths are ready without waiting for the interval
+func (c *Coalescer) Flush() {
+	c.signal()
+}
+
+func (c *Coalescer) signal() {
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,129 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// What to do with paths whose names differ from a sibling's only in case or Unicode normalization,
+// which are the same file on case-insensitive file systems like the default ones of macOS and Windows
+const (
+	// CollisionsWarn copies them and logs a warning
+	CollisionsWarn = "warn"
+	// CollisionsSkip copies only the first of them in alphabetical order and logs a warning
+	CollisionsSkip = "skip"
+	// CollisionsError fails the copy
+	CollisionsError = "error"
+)
+
+// Unicode normalization forms of names in the target
+const (
+	// NormalizeNone keeps names as they are on the host
+	NormalizeNone = "none"
+	// NormalizeNFC composes names, e.g. the decomposed ones of files created on macOS
+	// become what Linux programs usually write
+	NormalizeNFC = "nfc"
+	// NormalizeNFD decomposes names
+	NormalizeNFD = "nfd"
+)
+
+// normalizeName returns the name of a file in the target, normalized as requested
+func (syncer *Syncer) normalizeName(name string) string {
+	switch syncer.normalize {
+	case NormalizeNFC:
+		return norm.NFC.String(name)
+	case NormalizeNFD:
+		return norm.NFD.String(name)
+	default:
+		return name
+	}
+}
+
+// foldName returns the name as it's compared by case-insensitive file systems
+func foldName(name string) string {
+	return strings.ToLower(norm.NFC.String(name))
+}
+
+// collisionChecker finds paths of the source colliding with others, caching the directories
+// it reads for the duration of a copy
+type collisionChecker struct {
+	syncer *Syncer
+	// dirs map the folded names of the entries of directories to the first entry with each of them
+	dirs map[string]map[string]string
+}
+
+func (syncer *Syncer) newCollisionChecker() *collisionChecker {
+	return &collisionChecker{syncer: syncer, dirs: make(map[string]map[string]string)}
+}
+
+// check returns localPath or its parent inside the source that collides with another path,
+// along with that path, or empty strings if there's none
+func (checker *collisionChecker) check(localPath string) (string, string, error) {
+	if checker.syncer.sourcePath == "" {
+		return "", "", nil
+	}
+
+	for current := localPath; current != checker.syncer.sourcePath; {
+		dir := filepath.Dir(current)
+		if dir == current {
+			break
+		}
+		names, err := checker.read(dir)
+		if err != nil {
+			return "", "", err
+		}
+		name := filepath.Base(current)
+		if first, ok := names[foldName(name)]; ok && first != name {
+			return current, filepath.Join(dir, first), nil
+		}
+		current = dir
+	}
+	return "", "", nil
+}
+
+// read returns the folded names of the entries of the directory that aren't ignored
+func (checker *collisionChecker) read(dir string) (map[string]string, error) {
+	if names, ok := checker.dirs[dir]; ok {
+		return names, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+	names := make(map[string]string)
+	for _, entry := range entries {
+		if checker.syncer.ignore.Match(filepath.Join(dir, entry.Name()), entry.IsDir()) {
+			continue
+		}
+		folded := foldName(entry.Name())
+		if _, ok := names[folded]; !ok {
+			names[folded] = entry.Name()
+		}
+	}
+	checker.dirs[dir] = names
+	return names, nil
+}
+
+// handleCollision applies the collisions mode to the path colliding with another, reporting
+// whether it's copied anyway. Each colliding path is only logged once, not for everything inside of it
+func (syncer *Syncer) handleCollision(localPath, other string) (bool, error) {
+	if syncer.collisions == CollisionsError {
+		return false, fmt.Errorf("%s and %s differ only in case or Unicode normalization, so they're the same file on case-insensitive file systems", other, localPath)
+	}
+
+	copied := syncer.collisions != CollisionsSkip
+	if !syncer.warnedCollisions[localPath] {
+		syncer.warnedCollisions[localPath] = true
+		if copied {
+			syncer.logger.Warn("{path} differs from {other} only in case or Unicode normalization, one of them overwrites the other in case-insensitive targets", "path", localPath, "other", other)
+		} else {
+			syncer.logger.Warn("Skipping {path}, which differs from {other} only in case or Unicode normalization", "path", localPath, "other", other)
+		}
+	}
+	return copied, nil
+}
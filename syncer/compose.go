@@ -0,0 +1,72 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ComposeScheme is the prefix of destinations in a Docker Compose project
+const ComposeScheme = "compose://"
+
+// Labels set by Docker Compose on the containers it creates
+const (
+	composeProjectLabel         = "com.docker.compose.project"
+	composeServiceLabel         = "com.docker.compose.service"
+	composeContainerNumberLabel = "com.docker.compose.container-number"
+)
+
+// ComposeTarget is a service of a Docker Compose project. Its container is looked up
+// by the labels set by Compose, so it's found again after Compose recreates it
+type ComposeTarget struct {
+	Project string
+	Service string
+}
+
+// ParseComposeDestination parses a destination in the compose://<project>/<service>:<path> format
+func ParseComposeDestination(destination string) (*ComposeTarget, string, error) {
+	formatErr := fmt.Errorf("destination %s must be in the following format: %s<project>/<service>:<path>", destination, ComposeScheme)
+
+	project, rest, ok := strings.Cut(strings.TrimPrefix(destination, ComposeScheme), "/")
+	if !ok || project == "" {
+		return nil, "", formatErr
+	}
+
+	service, targetPath, ok := strings.Cut(rest, ":")
+	if !ok || service == "" || targetPath == "" {
+		return nil, "", formatErr
+	}
+
+	return &ComposeTarget{Project: project, Service: service}, targetPath, nil
+}
+
+func (target *ComposeTarget) String() string {
+	return ComposeScheme + target.Project + "/" + target.Service
+}
+
+// findComposeContainer returns the ID of the running container of the Compose service.
+// When the service is scaled, the first replica is used
+func (syncer *Syncer) findComposeContainer(ctx context.Context) (string, error) {
+	containers, err := syncer.client.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", composeProjectLabel+"="+syncer.compose.Project),
+			filters.Arg("label", composeServiceLabel+"="+syncer.compose.Service),
+		),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list containers: %w", err)
+	}
+	if len(containers) == 0 {
+		return "", nil
+	}
+
+	for _, c := range containers {
+		if c.Labels[composeContainerNumberLabel] == "1" {
+			return c.ID, nil
+		}
+	}
+	return containers[0].ID, nil
+}
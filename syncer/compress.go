@@ -0,0 +1,85 @@
+package syncer
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types/versions"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms of uploaded archives
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	// CompressZstd is faster and compresses better than gzip, but needs Docker 23 or newer,
+	// or zstd inside a Kubernetes pod. Otherwise gzip is used instead
+	CompressZstd = "zstd"
+)
+
+// zstdMinAPIVersion is the first Docker API version whose daemon extracts zstd archives
+const zstdMinAPIVersion = "1.42"
+
+// negotiateCompression falls back to gzip if the target can't decompress zstd archives
+func (syncer *Syncer) negotiateCompression(ctx context.Context) {
+	if syncer.compress != CompressZstd {
+		return
+	}
+
+	var supported bool
+	chunked := syncer.chunkSize > 0 && (syncer.targetType == Container || syncer.targetType == Service)
+	if syncer.targetType == Pod || syncer.strategy == StrategyExecExtract || chunked {
+		// Archives are extracted with the tar of the target, which usually can't decompress zstd by itself.
+		// So are chunked uploads into containers, even when the daemon extracts the other archives
+		_, err := syncer.output(ctx, "command -v zstd")
+		supported = err == nil
+	} else {
+		version, err := syncer.client.ServerVersion(ctx)
+		supported = err == nil && !versions.LessThan(version.APIVersion, zstdMinAPIVersion)
+	}
+
+	if !supported {
+		syncer.logger.Warn("{target} can't decompress zstd archives, using gzip instead", "target", syncer.target)
+		syncer.compress = CompressGzip
+	}
+}
+
+// compressWriter returns a writer compressing into w with the syncer's algorithm,
+// which has to be closed to flush it. Nothing is compressed with CompressNone
+func (syncer *Syncer) compressWriter(w io.Writer) (io.WriteCloser, error) {
+	switch syncer.compress {
+	case CompressGzip:
+		return gzip.NewWriterLevel(w, gzip.BestSpeed)
+	case CompressZstd:
+		encoder, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		return encoder, nil
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// extractCommand returns the command extracting archives compressed with the syncer's algorithm
+// in a pod or, with StrategyExecExtract, in a container
+func (syncer *Syncer) extractCommand() []string {
+	switch syncer.compress {
+	case CompressGzip:
+		return []string{"tar", "-xzf", "-", "-C", "/"}
+	case CompressZstd:
+		return []string{"sh", "-c", "zstd -dc | tar -xf - -C /"}
+	default:
+		return []string{"tar", "-xf", "-", "-C", "/"}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
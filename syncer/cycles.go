@@ -0,0 +1,77 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/axtgr/docker-sync/hostpath"
+)
+
+// dockerDesktopMountPrefix is prepended by Docker Desktop on macOS to the host paths of bind mounts
+const dockerDesktopMountPrefix = "/host_mnt"
+
+// hostIsLocal reports whether the engine runs on this machine, so that the host paths
+// of its bind mounts are paths of this machine too
+func (syncer *Syncer) hostIsLocal() bool {
+	return syncer.host == "" || strings.HasPrefix(syncer.host, "unix://") || strings.HasPrefix(syncer.host, "npipe://")
+}
+
+// realSourcePath returns the source with symlinks resolved, as Docker reports the host paths of mounts
+func (syncer *Syncer) realSourcePath() string {
+	realPath, err := filepath.EvalSymlinks(syncer.sourcePath)
+	if err != nil {
+		return syncer.sourcePath
+	}
+	return realPath
+}
+
+// checkCycleIn returns an error if files copied to the target path in the container end up in the source,
+// because a directory of the source or one containing it is bind-mounted there. Every copy would then
+// be seen as a change of the source and copied again, and files could be truncated while being read
+func (syncer *Syncer) checkCycleIn(ctx context.Context, containerId string) error {
+	if !syncer.hostIsLocal() {
+		return nil
+	}
+
+	info, err := syncer.client.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+	}
+
+	sourcePath := syncer.realSourcePath()
+	for _, mount := range info.Mounts {
+		if mount.Type != "bind" || !hostpath.Overlap(mount.Destination, syncer.targetPath) {
+			continue
+		}
+		hostPath := strings.TrimPrefix(mount.Source, dockerDesktopMountPrefix)
+		if hostpath.Overlap(hostPath, sourcePath) {
+			return fmt.Errorf("%s is mounted at %s in container %s, so files synced from %s to %s would come back to the source and be synced again. Sync into a path that isn't mounted from the source", mount.Source, mount.Destination, containerId, syncer.sourcePath, syncer.targetPath)
+		}
+	}
+	return nil
+}
+
+// checkVolumeCycle returns an error if the target volume binds a directory overlapping the source,
+// as volumes of the local driver created with the bind option do
+func (syncer *Syncer) checkVolumeCycle(ctx context.Context) error {
+	if !syncer.hostIsLocal() {
+		return nil
+	}
+
+	info, err := syncer.client.VolumeInspect(ctx, syncer.volume.Name)
+	if err != nil {
+		return fmt.Errorf("failed to inspect volume %s: %w", syncer.volume.Name, err)
+	}
+
+	device := info.Options["device"]
+	if device == "" || !strings.Contains(info.Options["o"], "bind") {
+		return nil
+	}
+	if hostpath.Overlap(path.Join(device, syncer.targetPath), syncer.realSourcePath()) {
+		return fmt.Errorf("volume %s is bound to %s, so files synced from %s would come back to the source and be synced again. Sync into a volume that isn't bound to the source", syncer.volume.Name, device, syncer.sourcePath)
+	}
+	return nil
+}
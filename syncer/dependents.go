@@ -0,0 +1,197 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/errdefs"
+)
+
+// What to wait for after restarting a dependent, besides a duration to wait
+const (
+	// DependentWaitRunning waits until the containers of the dependent are running
+	DependentWaitRunning = "running"
+	// DependentWaitHealthy waits until the health checks of the containers of the dependent pass
+	DependentWaitHealthy = "healthy"
+	// DependentWaitNone restarts the next dependent right away
+	DependentWaitNone = "none"
+)
+
+const (
+	// DefaultDependentTimeout is how long to wait for a dependent to meet its wait condition by default
+	DefaultDependentTimeout = 2 * time.Minute
+	// dependentPollInterval is how often to check whether a dependent meets its wait condition
+	dependentPollInterval = 500 * time.Millisecond
+)
+
+// Dependent is a container or a service restarted after the target, like a reverse proxy or workers
+// holding connections to it. Dependents are restarted one after another, each once the previous one
+// meets its wait condition
+type Dependent struct {
+	// Name is the name of the container or the service
+	Name string
+	// Wait is DependentWaitRunning (the default), DependentWaitHealthy, DependentWaitNone
+	// or a duration to wait for after restarting it, e.g. 5s
+	Wait string
+	// Timeout is how long to wait, DefaultDependentTimeout if not set
+	Timeout time.Duration
+}
+
+// checkDependents checks the wait conditions of the dependents
+func checkDependents(dependents []Dependent) error {
+	for _, dependent := range dependents {
+		if dependent.Name == "" {
+			return fmt.Errorf("dependents need a name")
+		}
+		switch dependent.Wait {
+		case "", DependentWaitRunning, DependentWaitHealthy, DependentWaitNone:
+		default:
+			if _, err := time.ParseDuration(dependent.Wait); err != nil {
+				return fmt.Errorf("dependent %s waits for %q, expected %s, %s, %s or a duration", dependent.Name, dependent.Wait, DependentWaitRunning, DependentWaitHealthy, DependentWaitNone)
+			}
+		}
+	}
+	return nil
+}
+
+// restartDependents restarts the dependents in order, waiting for each of them before the next one
+func (syncer *Syncer) restartDependents(ctx context.Context) error {
+	for _, dependent := range syncer.dependents {
+		syncer.logger.Info("Restarting {dependent}, which depends on {target}...", "dependent", dependent.Name, "target", syncer.target)
+		err := syncer.restartDependent(ctx, dependent)
+		if err != nil {
+			return fmt.Errorf("failed to restart %s: %w", dependent.Name, err)
+		}
+	}
+	return nil
+}
+
+func (syncer *Syncer) restartDependent(ctx context.Context, dependent Dependent) error {
+	timeout := dependent.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDependentTimeout
+	}
+
+	info, err := syncer.client.ContainerInspect(ctx, dependent.Name)
+	if err == nil {
+		stopTimeout := stopTimeoutInSeconds
+		err = syncer.client.ContainerRestart(ctx, info.ID, container.StopOptions{Timeout: &stopTimeout})
+		if err != nil {
+			return fmt.Errorf("failed to restart container: %w", err)
+		}
+		return syncer.waitForDependent(ctx, dependent, timeout, func() (bool, error) {
+			return syncer.containerReady(ctx, info.ID, dependent.Wait)
+		})
+	}
+	if !errdefs.IsNotFound(err) || !syncer.provider.supportsServices() {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	revision, err := syncer.forceUpdateService(ctx, dependent.Name)
+	if err != nil {
+		return err
+	}
+	return syncer.waitForDependent(ctx, dependent, timeout, func() (bool, error) {
+		return syncer.serviceReady(ctx, dependent.Name, revision)
+	})
+}
+
+// waitForDependent waits until ready reports the dependent meets its wait condition
+func (syncer *Syncer) waitForDependent(ctx context.Context, dependent Dependent, timeout time.Duration, ready func() (bool, error)) error {
+	switch dependent.Wait {
+	case DependentWaitNone:
+		return nil
+	case "", DependentWaitRunning, DependentWaitHealthy:
+	default:
+		delay, _ := time.ParseDuration(dependent.Wait)
+		return sleep(ctx, delay)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		ok, err := ready()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if sleep(ctx, dependentPollInterval) != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("not %s after %s", dependentCondition(dependent.Wait), timeout)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// dependentCondition returns the wait condition of a dependent waiting for its containers
+func dependentCondition(wait string) string {
+	if wait == "" {
+		return DependentWaitRunning
+	}
+	return wait
+}
+
+// containerReady reports whether the container is running, and healthy if that's what's waited for
+func (syncer *Syncer) containerReady(ctx context.Context, containerId, wait string) (bool, error) {
+	info, err := syncer.client.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if info.State == nil || !info.State.Running || info.State.Restarting {
+		return false, nil
+	}
+	if wait != DependentWaitHealthy {
+		return true, nil
+	}
+	if info.State.Health == nil {
+		return false, fmt.Errorf("container has no health check to wait for")
+	}
+	return info.State.Health.Status == types.Healthy, nil
+}
+
+// forceUpdateService makes the service replace its tasks like docker service update --force,
+// returning the ForceUpdate counter of the new tasks
+func (syncer *Syncer) forceUpdateService(ctx context.Context, name string) (uint64, error) {
+	for attempt := 1; ; attempt++ {
+		service, _, err := syncer.client.ServiceInspectWithRaw(ctx, name, types.ServiceInspectOptions{})
+		if err != nil {
+			return 0, fmt.Errorf("failed to find container or service: %w", err)
+		}
+		spec := service.Spec
+		spec.TaskTemplate.ForceUpdate++
+		_, err = syncer.client.ServiceUpdate(ctx, service.ID, service.Version, spec, types.ServiceUpdateOptions{})
+		if isOutOfSequence(err) && attempt < serviceUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to update service: %w", err)
+		}
+		return spec.TaskTemplate.ForceUpdate, nil
+	}
+}
+
+// serviceReady reports whether every task of the service runs the update. Tasks of services
+// with a health check only run once it passes, so they're healthy as well
+func (syncer *Syncer) serviceReady(ctx context.Context, name string, revision uint64) (bool, error) {
+	tasks, err := syncer.client.TaskList(ctx, types.TaskListOptions{Filters: filters.NewArgs(
+		filters.Arg("service", name),
+		filters.Arg("desired-state", string(swarm.TaskStateRunning)),
+	)})
+	if err != nil {
+		return false, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	for _, task := range tasks {
+		if task.Spec.ForceUpdate != revision || task.Status.State != swarm.TaskStateRunning {
+			return false, nil
+		}
+	}
+	return len(tasks) > 0, nil
+}
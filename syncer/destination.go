@@ -0,0 +1,220 @@
+package syncer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// destinationContainers returns the containers files are copied into directly,
+// whose target path can be checked before syncing
+func (syncer *Syncer) destinationContainers(ctx context.Context) ([]string, error) {
+	switch {
+	case syncer.strategy == StrategyVolumeServiceUpdate:
+		// The temporary volume is mounted at the target path
+		return nil, nil
+	case syncer.targetType == Container && len(syncer.labels) > 0:
+		return syncer.findLabeledContainers(ctx)
+	case syncer.targetType == Container:
+		return []string{syncer.target}, nil
+	case syncer.targetType == Service && !syncer.agent:
+		containerId, err := syncer.getContainerIdForTargetService(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []string{containerId}, nil
+	}
+	// Volumes and agents get the target path created for them
+	return nil, nil
+}
+
+// checkDestination makes sure that the target path is a directory (or a file, for a single file)
+// in the containers of the target, creating it with createDestination, so that copies don't fail later,
+// and that it isn't mounted from the source
+func (syncer *Syncer) checkDestination(ctx context.Context) error {
+	// Nothing is copied into targets without a source, e.g. when pulling from them
+	if syncer.sourcePath == "" {
+		return nil
+	}
+
+	if syncer.targetType == Volume {
+		return syncer.checkVolumeCycle(ctx)
+	}
+	if syncer.targetType == Pod {
+		return syncer.checkDestinationPath(ctx, syncer.kube.String(), syncer.statInPod, syncer.createDirectoryInPod)
+	}
+
+	containers, err := syncer.destinationContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, containerId := range containers {
+		err := syncer.checkCycleIn(ctx, containerId)
+		if err != nil {
+			return err
+		}
+		// The archive API may be disabled on daemons the exec-extract strategy is used with
+		stat := func(ctx context.Context, path string) (os.FileMode, error) {
+			stat, err := syncer.client.ContainerStatPath(ctx, containerId, path)
+			if client.IsErrNotFound(err) {
+				return 0, fs.ErrNotExist
+			}
+			return stat.Mode, err
+		}
+		create := func(ctx context.Context, dir string) error {
+			return syncer.createDirectoryIn(ctx, containerId, dir)
+		}
+		if syncer.strategy == StrategyExecExtract {
+			stat = func(ctx context.Context, path string) (os.FileMode, error) {
+				return syncer.statWithExec(ctx, containerId, path)
+			}
+			create = func(ctx context.Context, dir string) error {
+				return syncer.createDirectoryWithExec(ctx, containerId, dir)
+			}
+		}
+		err = syncer.checkDestinationPath(ctx, "container "+containerId, stat, create)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDestinationPath checks the target path in the container or pod named where, whose paths
+// are looked up with stat, returning fs.ErrNotExist for missing ones, and created with create.
+// A single file is copied over the target path, which doesn't have to exist yet, only its
+// directory does. A directory can't be copied over a file
+func (syncer *Syncer) checkDestinationPath(ctx context.Context, where string, stat func(context.Context, string) (os.FileMode, error), create func(context.Context, string) error) error {
+	dir := syncer.targetPath
+	if syncer.sourceIsFile {
+		mode, err := stat(ctx, syncer.targetPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to check destination path %s in %s: %w", syncer.targetPath, where, err)
+		}
+		if err == nil && mode.IsDir() {
+			return fmt.Errorf("destination path %s in %s is a directory, end it with / to copy the file into it", syncer.targetPath, where)
+		}
+		dir = path.Dir(dir)
+	}
+
+	syncer.logger.Debug("Checking {path} in {where}...", "path", dir, "where", where)
+	mode, err := stat(ctx, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		if !syncer.createDestination {
+			return fmt.Errorf("destination path %s doesn't exist in %s, create it or let docker-sync create it with --mkdir", dir, where)
+		}
+		return create(ctx, dir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check destination path %s in %s: %w", dir, where, err)
+	}
+
+	// Whatever a symlink points to is only known inside the container
+	if mode&os.ModeSymlink == 0 && !mode.IsDir() {
+		if !syncer.sourceIsFile {
+			return fmt.Errorf("destination path %s in %s is a file, only a single file can be synced over it, and a directory has to be synced into a directory", dir, where)
+		}
+		return fmt.Errorf("destination path %s in %s is not a directory", dir, where)
+	}
+	return nil
+}
+
+// statScript prints d for a directory, f for anything else that exists and nothing for
+// a missing path given as the argument, following symlinks
+const statScript = `if [ -d "$1" ]; then echo d; elif [ -e "$1" ]; then echo f; fi`
+
+// statInPod returns whether the path in the target pod is a directory, as its mode
+func (syncer *Syncer) statInPod(ctx context.Context, podPath string) (os.FileMode, error) {
+	kind, err := syncer.output(ctx, statScript, podPath)
+	if err != nil {
+		return 0, err
+	}
+	return statMode(kind)
+}
+
+// statWithExec returns whether the path in the container is a directory, as its mode,
+// looking it up with the shell of the container instead of the archive API
+func (syncer *Syncer) statWithExec(ctx context.Context, containerId, containerPath string) (os.FileMode, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := syncer.ContainerExec(ctx, containerId, []string{"sh", "-c", statScript, "sh", containerPath}, &stdout, &stderr)
+	if err != nil {
+		return 0, err
+	}
+	if exitCode != 0 {
+		return 0, fmt.Errorf("sh exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return statMode(strings.TrimSpace(stdout.String()))
+}
+
+// statMode returns the mode of a path printed by statScript, or fs.ErrNotExist when it's missing
+func statMode(kind string) (os.FileMode, error) {
+	switch kind {
+	case "d":
+		return os.ModeDir, nil
+	case "f":
+		return 0, nil
+	}
+	return 0, fs.ErrNotExist
+}
+
+// createDirectoryInPod creates the directory in the target pod along with its parents
+func (syncer *Syncer) createDirectoryInPod(ctx context.Context, dir string) error {
+	syncer.logger.Info("Creating {path} in {target}", "path", dir, "target", syncer.kube.String())
+	err := syncer.kubectlExec(ctx, nil, io.Discard, nil, "mkdir", "-p", dir)
+	if err != nil {
+		return fmt.Errorf("failed to create destination path %s in %s: %w", dir, syncer.kube, err)
+	}
+	return nil
+}
+
+// createDirectoryWithExec creates the directory in the container along with its parents with
+// mkdir run as the user of the container, who extracts the archives of the exec-extract strategy
+func (syncer *Syncer) createDirectoryWithExec(ctx context.Context, containerId, dir string) error {
+	syncer.logger.Info("Creating {path} in container {container}", "path", dir, "container", containerId)
+	var stderr bytes.Buffer
+	exitCode, err := syncer.ContainerExec(ctx, containerId, []string{"mkdir", "-p", dir}, io.Discard, &stderr)
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("mkdir exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create destination path %s in container %s: %w", dir, containerId, err)
+	}
+	return nil
+}
+
+// createDirectoryIn creates the directory in the container along with its parents.
+// It's done by copying an archive of the directory, so that no shell is needed
+func (syncer *Syncer) createDirectoryIn(ctx context.Context, containerId, dir string) error {
+	syncer.logger.Info("Creating {path} in container {container}", "path", dir, "container", containerId)
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     strings.TrimPrefix(dir, "/") + "/",
+		Mode:     0o755,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+
+	err = syncer.client.CopyToContainer(ctx, containerId, "/", &archive, container.CopyToContainerOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create destination path %s in container %s: %w", dir, containerId, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package syncer
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// noArchiveClient is a fake client of a daemon with the archive endpoints disabled,
+// which fails the test when they're called
+type noArchiveClient struct {
+	*fakeClient
+	t *testing.T
+}
+
+func (c noArchiveClient) ContainerStatPath(ctx context.Context, containerId, path string) (container.PathStat, error) {
+	c.t.Errorf("ContainerStatPath(%s) was called", path)
+	return container.PathStat{}, errors.New("the archive API is disabled")
+}
+
+func (c noArchiveClient) CopyToContainer(ctx context.Context, containerId, dstPath string, content io.Reader, options container.CopyToContainerOptions) error {
+	c.t.Errorf("CopyToContainer(%s) was called", dstPath)
+	return errors.New("the archive API is disabled")
+}
+
+func (c noArchiveClient) CopyFromContainer(ctx context.Context, containerId, srcPath string) (io.ReadCloser, container.PathStat, error) {
+	c.t.Errorf("CopyFromContainer(%s) was called", srcPath)
+	return nil, container.PathStat{}, errors.New("the archive API is disabled")
+}
+
+// runTar runs the commands of the exec-extract strategy in the containers of the fake: stat
+// and mkdir of the destination and the extraction of gzipped archives. zstd isn't installed
+func runTar(fake *fakeContainer, options container.ExecOptions, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := options.Cmd
+	switch {
+	case slices.Equal(cmd, []string{"sh", "-c", "command -v zstd", "sh"}):
+		return 1
+	case len(cmd) == 5 && cmd[2] == statScript:
+		if file, ok := fake.files[cmd[4]]; ok && file.mode.IsDir() {
+			fmt.Fprintln(stdout, "d")
+		} else if ok {
+			fmt.Fprintln(stdout, "f")
+		}
+		return 0
+	case len(cmd) == 3 && cmd[0] == "mkdir" && cmd[1] == "-p":
+		for dir := cmd[2]; dir != "/"; dir = path.Dir(dir) {
+			fake.files[dir] = fakeFile{mode: os.ModeDir | 0o755}
+		}
+		return 0
+	case slices.Equal(cmd, []string{"tar", "-xzf", "-", "-C", "/"}):
+		reader, err := gzip.NewReader(stdin)
+		if err == nil {
+			err = fake.extract(reader, "/")
+		}
+		if err != nil {
+			fmt.Fprint(stderr, err)
+			return 2
+		}
+		return 0
+	}
+	fmt.Fprintf(stderr, "sh: %s: not found", cmd[0])
+	return 127
+}
+
+func TestExecExtractAvoidsArchiveAPI(t *testing.T) {
+	fake := newFakeClient()
+	fake.run = func(container *fakeContainer, options container.ExecOptions, stdin io.Reader, stdout, stderr io.Writer) int {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return runTar(container, options, stdin, stdout, stderr)
+	}
+	fake.addContainer("web")
+	syncer, source := newTestSyncer(t, noArchiveClient{fake, t}, "web", func(options *Options) {
+		options.TargetPath = "/srv/site"
+		options.Strategy = StrategyExecExtract
+		options.CreateDestination = true
+		options.Compress = CompressZstd
+	})
+	writeTree(t, source, map[string]string{"index.html": "<h1>", "css/site.css": "body {}"})
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	if dir, ok := fake.file("web", "/srv/site"); !ok || !dir.mode.IsDir() {
+		t.Error("/srv/site wasn't created")
+	}
+	if file, ok := fake.file("web", "/srv/site/css/site.css"); !ok || file.content != "body {}" {
+		t.Errorf("/srv/site/css/site.css = %q, %v, want %q", file.content, ok, "body {}")
+	}
+}
@@ -0,0 +1,80 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// latencyPings is how many times Latency pings the daemon
+const latencyPings = 5
+
+// checkWritableScript succeeds if the path given as the argument, or its parent
+// directory if it doesn't exist yet, can be written by the user of the container
+const checkWritableScript = `[ -e "$1" ] || set -- "$(dirname "$1")"; [ -w "$1" ]`
+
+// ErrNotChecked is returned by CheckWritable for targets it can't check
+var ErrNotChecked = errors.New("can't be checked for this target")
+
+// Latency returns the average time it takes to ping the daemon
+func (syncer *Syncer) Latency(ctx context.Context) (time.Duration, error) {
+	var total time.Duration
+	for i := 0; i < latencyPings; i++ {
+		start := time.Now()
+		_, err := syncer.client.Ping(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to ping the daemon: %w", err)
+		}
+		total += time.Since(start)
+	}
+	return total / latencyPings, nil
+}
+
+// CheckWritable checks that files can be copied into the target path, or into the directory it's
+// created in if it doesn't exist yet. The daemon copies files as root, so it's only stopped
+// by read-only root filesystems, while tar run in the target writes as the user of the container
+func (syncer *Syncer) CheckWritable(ctx context.Context) error {
+	if syncer.targetType == Pod {
+		err := syncer.kubectlExec(ctx, nil, io.Discard, io.Discard, "sh", "-c", checkWritableScript, "sh", syncer.targetPath)
+		if err != nil {
+			return fmt.Errorf("%s isn't writable by the user of %s: %w", syncer.targetPath, syncer.kube, err)
+		}
+		return nil
+	}
+	if syncer.targetType != Container && syncer.targetType != Service {
+		return ErrNotChecked
+	}
+
+	containerId, err := syncer.getTargetContainer(ctx)
+	if err != nil {
+		return err
+	}
+	info, err := syncer.client.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+	}
+	if syncer.isReadOnly(info) {
+		return fmt.Errorf("container %s has a read-only root filesystem and no writable volume mounted at %s", containerId, syncer.targetPath)
+	}
+
+	if syncer.strategy == StrategyExecExtract {
+		exitCode, err := syncer.ContainerExec(ctx, containerId, []string{"sh", "-c", checkWritableScript, "sh", syncer.targetPath}, io.Discard, io.Discard)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("%s isn't writable by the user of container %s", syncer.targetPath, containerId)
+		}
+		return nil
+	}
+
+	_, err = syncer.client.ContainerStatPath(ctx, containerId, syncer.targetPath)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to stat %s in container %s: %w", syncer.targetPath, containerId, err)
+	}
+	return nil
+}
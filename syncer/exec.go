@@ -0,0 +1,185 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ContainerExec runs cmd in the container, streaming its output to stdout and stderr,
+// and returns the exit code of the command
+func (syncer *Syncer) ContainerExec(ctx context.Context, containerId string, cmd []string, stdout, stderr io.Writer) (int, error) {
+	return syncer.containerExecWithInput(ctx, containerId, cmd, nil, stdout, stderr)
+}
+
+// containerExecWithInput runs cmd in the container like ContainerExec, passing stdin to it when given
+func (syncer *Syncer) containerExecWithInput(ctx context.Context, containerId string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return syncer.containerExecAs(ctx, containerId, "", cmd, stdin, stdout, stderr)
+}
+
+// containerExecAs runs cmd in the container like containerExecWithInput, as the user
+// if it's given, instead of the user the container runs as
+func (syncer *Syncer) containerExecAs(ctx context.Context, containerId, user string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	execution, err := syncer.client.ContainerExecCreate(ctx, containerId, container.ExecOptions{
+		User:         user,
+		Cmd:          cmd,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec in container %s: %w", containerId, err)
+	}
+
+	attachment, err := syncer.client.ContainerExecAttach(ctx, execution.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach to exec %s: %w", execution.ID, err)
+	}
+	defer attachment.Close()
+
+	inputErr := make(chan error, 1)
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(attachment.Conn, stdin)
+			// Closing the input lets the command see the end of it
+			if closeErr := attachment.CloseWrite(); err == nil {
+				err = closeErr
+			}
+			inputErr <- err
+		}()
+	} else {
+		inputErr <- nil
+	}
+
+	_, err = stdcopy.StdCopy(stdout, stderr, attachment.Reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read output of exec %s: %w", execution.ID, err)
+	}
+	if err := <-inputErr; err != nil {
+		return 0, fmt.Errorf("failed to write input of exec %s: %w", execution.ID, err)
+	}
+
+	info, err := syncer.client.ContainerExecInspect(ctx, execution.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec %s: %w", execution.ID, err)
+	}
+
+	return info.ExitCode, nil
+}
+
+// Exec runs a shell command in the running container of the target. Targets selected
+// by labels run it in each of their containers
+func (syncer *Syncer) Exec(ctx context.Context, command string) error {
+	if syncer.targetType == Pod {
+		return syncer.execInPod(ctx, command)
+	}
+
+	if len(syncer.labels) > 0 {
+		return syncer.forEachLabeledContainer(ctx, func(containerId string) error {
+			return syncer.execInContainer(ctx, containerId, command)
+		})
+	}
+
+	containerId, err := syncer.getTargetContainer(ctx)
+	if err != nil {
+		return err
+	}
+
+	return syncer.execInContainer(ctx, containerId, command)
+}
+
+// extractInContainer extracts the archive in the container with its own tar
+func (syncer *Syncer) extractInContainer(ctx context.Context, containerId string, reader io.Reader) error {
+	syncer.logger.Debug("Extracting the archive in container {container}...", "container", containerId)
+	var stderr bytes.Buffer
+	exitCode, err := syncer.containerExecWithInput(ctx, containerId, syncer.extractCommand(), reader, io.Discard, &stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("failed to extract the archive (the %s strategy needs tar in the container): %s", StrategyExecExtract, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (syncer *Syncer) execInContainer(ctx context.Context, containerId string, command string) error {
+	syncer.logger.Debug("Running {command} in container {container}...", "command", command, "container", containerId)
+	exitCode, err := syncer.ContainerExec(ctx, containerId, []string{"sh", "-c", command}, syncer.stdout, syncer.stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("command %q exited with code %d", command, exitCode)
+	}
+
+	return nil
+}
+
+// getTargetContainer returns the ID of the running container of the target
+func (syncer *Syncer) getTargetContainer(ctx context.Context) (string, error) {
+	if syncer.targetType == Volume {
+		return "", fmt.Errorf("%s has no running containers, files can only be copied into it", syncer.volume)
+	}
+	if syncer.targetType == SwarmObject {
+		return "", fmt.Errorf("%s has no running containers, files can only be copied into it", syncer.swarmObject)
+	}
+	if syncer.targetType == External {
+		return "", fmt.Errorf("%s is synced by its backend, which can't run commands in it", syncer.target)
+	}
+
+	if syncer.targetType == Service {
+		containerId, err := syncer.getContainerIdForTargetService(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to get container ID for service %s: %w", syncer.target, err)
+		}
+		if containerId == "" {
+			return "", fmt.Errorf("service %s has no running containers%s", syncer.target, syncer.describeTaskSelection())
+		}
+		return containerId, nil
+	}
+
+	if len(syncer.labels) > 0 {
+		containers, err := syncer.findLabeledContainers(ctx)
+		if err != nil {
+			return "", err
+		}
+		return containers[0], nil
+	}
+
+	if syncer.compose != nil {
+		containerId, err := syncer.findComposeContainer(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to find the container of %s: %w", syncer.compose, err)
+		}
+		if containerId == "" {
+			return "", syncer.notRunning(ctx, fmt.Errorf("%s has no running containers", syncer.compose))
+		}
+		// Compose recreates containers with new IDs, so the target follows the current one
+		syncer.target = containerId
+		return containerId, nil
+	}
+
+	containerId, err := syncer.findTargetContainer(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to find container %s: %w", syncer.target, err)
+	}
+	if containerId == "" && syncer.targetName != "" {
+		// The container may have been replaced by one with the same name
+		containerId, err = syncer.findContainerByExactName(ctx, syncer.targetName)
+		if err != nil {
+			return "", fmt.Errorf("failed to find container %s: %w", syncer.targetName, err)
+		}
+		if containerId != "" {
+			syncer.retarget(containerId)
+		}
+	}
+	if containerId == "" {
+		return "", syncer.notRunning(ctx, fmt.Errorf("container %s is not running", syncer.target))
+	}
+	return containerId, nil
+}
@@ -0,0 +1,459 @@
+package syncer
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeClient is a DockerClient keeping containers in memory. Files copied into them are extracted
+// into their files, and every call changing a container is recorded. Methods it doesn't implement
+// panic through the nil embedded interface, so tests fail loudly when the syncer starts using them
+type fakeClient struct {
+	DockerClient
+
+	// run runs the commands executed in containers, like a shell would. It's given the input of
+	// the command and returns its exit code. Without it, commands exit with 127 as if not found
+	run func(fake *fakeContainer, options container.ExecOptions, stdin io.Reader, stdout, stderr io.Writer) int
+
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	calls      []string
+	execs      map[string]*fakeExec
+	executed   []container.ExecOptions
+	nextId     int
+}
+
+type fakeContainer struct {
+	info  types.ContainerJSON
+	files map[string]fakeFile
+}
+
+type fakeFile struct {
+	mode    os.FileMode
+	content string
+}
+
+// fakeExec is a command created in a container, with its exit code once it's done
+type fakeExec struct {
+	container *fakeContainer
+	options   container.ExecOptions
+	exitCode  int
+	done      bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{containers: make(map[string]*fakeContainer), execs: make(map[string]*fakeExec)}
+}
+
+// extract adds the files in the archive to the container under dstPath, creating missing
+// parents like the extraction does. The lock of the client has to be held
+func (fake *fakeContainer) extract(content io.Reader, dstPath string) error {
+	tr := tar.NewReader(content)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		name := "/" + strings.TrimPrefix(strings.TrimSuffix(strings.TrimPrefix(dstPath, "/")+header.Name, "/"), "/")
+		fake.files[name] = fakeFile{mode: header.FileInfo().Mode(), content: string(data)}
+		for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+			if _, ok := fake.files[dir]; !ok {
+				fake.files[dir] = fakeFile{mode: os.ModeDir | 0o755}
+			}
+		}
+	}
+}
+
+// addContainer adds a running container with the name and a directory at /app
+func (c *fakeClient) addContainer(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.newId()
+	c.containers[id] = &fakeContainer{
+		info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         id,
+				Name:       "/" + name,
+				State:      &types.ContainerState{Running: true, Status: "running"},
+				HostConfig: &container.HostConfig{NetworkMode: "default"},
+			},
+			Config:          &container.Config{Image: "alpine", Hostname: id[:shortIdLength]},
+			NetworkSettings: &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{}},
+		},
+		files: map[string]fakeFile{"/app": {mode: os.ModeDir | 0o755}},
+	}
+	return id
+}
+
+// newId returns a 64 character ID like the ones of Docker
+func (c *fakeClient) newId() string {
+	c.nextId++
+	return fmt.Sprintf("%064x", c.nextId)
+}
+
+// record adds the call to the list of calls, the lock has to be held
+func (c *fakeClient) record(format string, args ...any) {
+	c.calls = append(c.calls, fmt.Sprintf(format, args...))
+}
+
+// recorded returns the calls made so far
+func (c *fakeClient) recorded() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// file returns the file copied into the container with the name
+func (c *fakeClient) file(name, path string) (fakeFile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fake := range c.containers {
+		if fake.info.Name == "/"+name {
+			file, ok := fake.files[path]
+			return file, ok
+		}
+	}
+	return fakeFile{}, false
+}
+
+// byName returns the container with the name
+func (c *fakeClient) byName(name string) *fakeContainer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fake := range c.containers {
+		if fake.info.Name == "/"+name {
+			return fake
+		}
+	}
+	return nil
+}
+
+// get returns the container with the ID, its unique prefix or its name, the lock has to be held
+func (c *fakeClient) get(needle string) (*fakeContainer, error) {
+	for id, fake := range c.containers {
+		if id == needle || fake.info.Name == "/"+needle {
+			return fake, nil
+		}
+	}
+	for id, fake := range c.containers {
+		if strings.HasPrefix(id, needle) {
+			return fake, nil
+		}
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("no such container: %s", needle))
+}
+
+func (c *fakeClient) Close() error {
+	return nil
+}
+
+// ServiceList returns no services, like a daemon outside of a swarm
+func (c *fakeClient) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var containers []types.Container
+	for id, fake := range c.containers {
+		if !options.All && !fake.info.State.Running {
+			continue
+		}
+		matches := true
+		for _, needle := range options.Filters.Get("id") {
+			matches = matches && strings.HasPrefix(id, needle)
+		}
+		for _, needle := range options.Filters.Get("name") {
+			pattern, err := regexp.Compile(needle)
+			if err != nil {
+				return nil, errdefs.InvalidParameter(err)
+			}
+			matches = matches && pattern.MatchString(fake.info.Name)
+		}
+		for _, label := range options.Filters.Get("label") {
+			key, value, hasValue := strings.Cut(label, "=")
+			actual, ok := fake.info.Config.Labels[key]
+			matches = matches && ok && (!hasValue || actual == value)
+		}
+		if !matches {
+			continue
+		}
+		containers = append(containers, types.Container{
+			ID:     id,
+			Names:  []string{fake.info.Name},
+			Labels: fake.info.Config.Labels,
+			State:  fake.info.State.Status,
+		})
+	}
+	return containers, nil
+}
+
+func (c *fakeClient) ContainerInspect(ctx context.Context, containerId string) (types.ContainerJSON, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	return fake.info, nil
+}
+
+func (c *fakeClient) ContainerStatPath(ctx context.Context, containerId, path string) (container.PathStat, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return container.PathStat{}, err
+	}
+	file, ok := fake.files[path]
+	if !ok {
+		return container.PathStat{}, errdefs.NotFound(fmt.Errorf("no such file: %s", path))
+	}
+	return container.PathStat{Name: path[strings.LastIndex(path, "/")+1:], Mode: file.mode}, nil
+}
+
+// CopyToContainer extracts the archive into the files of the container. Like Docker, it reads
+// the whole archive before the container is looked up, so that the sender is never left blocked
+func (c *fakeClient) CopyToContainer(ctx context.Context, containerId, dstPath string, content io.Reader, options container.CopyToContainerOptions) error {
+	archive, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return err
+	}
+	c.record("copy %s", strings.TrimPrefix(fake.info.Name, "/"))
+	return fake.extract(strings.NewReader(string(archive)), dstPath)
+}
+
+func (c *fakeClient) ContainerExecCreate(ctx context.Context, containerId string, options container.ExecOptions) (types.IDResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return types.IDResponse{}, err
+	}
+	if !fake.info.State.Running {
+		return types.IDResponse{}, errdefs.Conflict(fmt.Errorf("container %s is not running", containerId))
+	}
+	id := c.newId()
+	c.execs[id] = &fakeExec{container: fake, options: options}
+	c.executed = append(c.executed, options)
+	return types.IDResponse{ID: id}, nil
+}
+
+// ContainerExecAttach starts the command with run, streaming its input and its multiplexed
+// output over a connection like the hijacked one of Docker
+func (c *fakeClient) ContainerExecAttach(ctx context.Context, execId string, options container.ExecAttachOptions) (types.HijackedResponse, error) {
+	c.mu.Lock()
+	exec, ok := c.execs[execId]
+	c.mu.Unlock()
+	if !ok {
+		return types.HijackedResponse{}, errdefs.NotFound(fmt.Errorf("no such exec: %s", execId))
+	}
+
+	stdinReader, stdinWriter := io.Pipe()
+	outputReader, outputWriter := io.Pipe()
+	go func() {
+		var stdin io.Reader = strings.NewReader("")
+		if exec.options.AttachStdin {
+			stdin = stdinReader
+		}
+		exitCode := 127
+		if c.run != nil {
+			exitCode = c.run(exec.container, exec.options, stdin, stdcopy.NewStdWriter(outputWriter, stdcopy.Stdout), stdcopy.NewStdWriter(outputWriter, stdcopy.Stderr))
+		}
+		stdinReader.Close()
+
+		c.mu.Lock()
+		exec.exitCode = exitCode
+		exec.done = true
+		c.mu.Unlock()
+		outputWriter.Close()
+	}()
+
+	conn := &fakeExecConn{stdin: stdinWriter, output: outputReader}
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(outputReader)}, nil
+}
+
+func (c *fakeClient) ContainerExecInspect(ctx context.Context, execId string) (container.ExecInspect, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	exec, ok := c.execs[execId]
+	if !ok {
+		return container.ExecInspect{}, errdefs.NotFound(fmt.Errorf("no such exec: %s", execId))
+	}
+	return container.ExecInspect{ExecID: execId, Running: !exec.done, ExitCode: exec.exitCode}, nil
+}
+
+// executions returns the options of the commands executed so far
+func (c *fakeClient) executions() []container.ExecOptions {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]container.ExecOptions(nil), c.executed...)
+}
+
+// fakeExecConn is the connection of an exec, writing to the input of the command.
+// Closing it for writing lets the command see the end of its input
+type fakeExecConn struct {
+	net.Conn
+	stdin  *io.PipeWriter
+	output *io.PipeReader
+}
+
+func (conn *fakeExecConn) Write(p []byte) (int, error) {
+	return conn.stdin.Write(p)
+}
+
+func (conn *fakeExecConn) Read(p []byte) (int, error) {
+	return conn.output.Read(p)
+}
+
+func (conn *fakeExecConn) CloseWrite() error {
+	return conn.stdin.Close()
+}
+
+func (conn *fakeExecConn) Close() error {
+	conn.stdin.Close()
+	return conn.output.Close()
+}
+
+func (c *fakeClient) ContainerRestart(ctx context.Context, containerId string, options container.StopOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return err
+	}
+	c.record("restart %s", strings.TrimPrefix(fake.info.Name, "/"))
+	fake.info.State.Running = true
+	fake.info.State.Status = "running"
+	return nil
+}
+
+func (c *fakeClient) ContainerStop(ctx context.Context, containerId string, options container.StopOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return err
+	}
+	c.record("stop %s", strings.TrimPrefix(fake.info.Name, "/"))
+	fake.info.State.Running = false
+	fake.info.State.Status = "exited"
+	return nil
+}
+
+func (c *fakeClient) ContainerStart(ctx context.Context, containerId string, options container.StartOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return err
+	}
+	c.record("start %s", strings.TrimPrefix(fake.info.Name, "/"))
+	fake.info.State.Running = true
+	fake.info.State.Status = "running"
+	return nil
+}
+
+func (c *fakeClient) ContainerRename(ctx context.Context, containerId, newContainerName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return err
+	}
+	c.record("rename %s %s", strings.TrimPrefix(fake.info.Name, "/"), newContainerName)
+	fake.info.Name = "/" + newContainerName
+	return nil
+}
+
+func (c *fakeClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.newId()
+	if containerName == "" {
+		// Docker generates a name
+		containerName = "generated-" + id[:shortIdLength]
+	} else if _, err := c.get(containerName); err == nil {
+		return container.CreateResponse{}, errdefs.Conflict(fmt.Errorf("the container name /%s is already in use", containerName))
+	}
+	c.record("create %s", containerName)
+
+	networks := make(map[string]*network.EndpointSettings)
+	if networkingConfig != nil {
+		for name, settings := range networkingConfig.EndpointsConfig {
+			networks[name] = settings
+		}
+	}
+	c.containers[id] = &fakeContainer{
+		info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         id,
+				Name:       "/" + containerName,
+				State:      &types.ContainerState{Status: "created"},
+				HostConfig: hostConfig,
+			},
+			Config:          config,
+			NetworkSettings: &types.NetworkSettings{Networks: networks},
+		},
+		files: make(map[string]fakeFile),
+	}
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (c *fakeClient) ContainerRemove(ctx context.Context, containerId string, options container.RemoveOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return err
+	}
+	c.record("remove %s", strings.TrimPrefix(fake.info.Name, "/"))
+	delete(c.containers, fake.info.ID)
+	return nil
+}
+
+func (c *fakeClient) NetworkConnect(ctx context.Context, networkName, containerId string, config *network.EndpointSettings) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fake, err := c.get(containerId)
+	if err != nil {
+		return err
+	}
+	c.record("connect %s %s", strings.TrimPrefix(fake.info.Name, "/"), networkName)
+	fake.info.NetworkSettings.Networks[networkName] = config
+	return nil
+}
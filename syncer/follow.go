@@ -0,0 +1,256 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const (
+	// followRetryDelay is how long to wait before subscribing to Docker events again after the stream breaks
+	followRetryDelay = 5 * time.Second
+	// ownEventsWindow is how long events of containers restarted by the syncer itself are ignored
+	ownEventsWindow = 30 * time.Second
+	// swarmServiceLabel is set by Swarm on the containers of a service
+	swarmServiceLabel = "com.docker.swarm.service.id"
+)
+
+// ErrTargetStopped is returned when copying to a target container that was stopped outside of
+// the syncer. Unless the StoppedTarget option says otherwise, the paths of the failed copy
+// are queued and copied once it starts again
+var ErrTargetStopped = errors.New("target container is stopped")
+
+// TargetEvent is a change of a container of the target made outside of the syncer
+type TargetEvent struct {
+	// Action is start, stop, die or destroy
+	Action string
+	// Container is the ID of the container
+	Container string
+	// ExitCode is the exit code of the container in die events
+	ExitCode int
+}
+
+// Stopped reports whether the container stopped running
+func (event TargetEvent) Stopped() bool {
+	return event.Action != string(events.ActionStart)
+}
+
+// follows reports whether the target is a single container that's looked up again by its name
+// when it's replaced. Compose targets and containers selected by labels are looked up before every copy anyway
+func (syncer *Syncer) follows() bool {
+	return syncer.targetType == Container && syncer.compose == nil && len(syncer.labels) == 0
+}
+
+// singleContainer reports whether the target is a single container, which can't be copied to while it's stopped
+func (syncer *Syncer) singleContainer() bool {
+	return syncer.follows() || syncer.compose != nil
+}
+
+// containerName returns the name of the container without the leading slash
+func (syncer *Syncer) containerName(ctx context.Context, containerId string) (string, error) {
+	info, err := syncer.client.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+	}
+	return strings.TrimPrefix(info.Name, "/"), nil
+}
+
+// findContainerByExactName returns the ID of the running container with exactly this name
+func (syncer *Syncer) findContainerByExactName(ctx context.Context, name string) (string, error) {
+	return syncer.findContainerByName(ctx, "^/"+name+"$")
+}
+
+// retarget switches to the container that replaced the target
+func (syncer *Syncer) retarget(containerId string) {
+	if containerId == syncer.target {
+		return
+	}
+
+	syncer.logger.Info("Container {name} was recreated, syncing to the new container {container}", "name", syncer.targetName, "container", containerId)
+	syncer.target = containerId
+	if syncer.strategy == StrategyVolumeServiceUpdate {
+		syncer.logger.Warn("The new container {container} doesn't mount the temporary volume of the synced files, restart docker-sync to sync to it", "container", containerId)
+	}
+	if syncer.onRetarget != nil {
+		// The hook may copy, which locks mu
+		go syncer.onRetarget()
+	}
+}
+
+// markOwnEvents makes events of the container or service caused by the syncer itself,
+// e.g. by restarting it, not reported as changes made outside of it
+func (syncer *Syncer) markOwnEvents(id string) {
+	syncer.ownMu.Lock()
+	defer syncer.ownMu.Unlock()
+	if syncer.ownEvents == nil {
+		syncer.ownEvents = make(map[string]time.Time)
+	}
+	syncer.ownEvents[id] = time.Now()
+}
+
+// isOwnEvent reports whether the event is caused by the syncer itself
+func (syncer *Syncer) isOwnEvent(message events.Message) bool {
+	syncer.ownMu.Lock()
+	defer syncer.ownMu.Unlock()
+	for id, marked := range syncer.ownEvents {
+		if time.Since(marked) > ownEventsWindow {
+			delete(syncer.ownEvents, id)
+			continue
+		}
+		if id == message.Actor.ID || id == message.Actor.Attributes[swarmServiceLabel] {
+			return true
+		}
+	}
+	return false
+}
+
+// targetFilters returns the filters of Docker events about the containers of the target
+func (syncer *Syncer) targetFilters() filters.Args {
+	args := filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("event", string(events.ActionStart)),
+		filters.Arg("event", string(events.ActionStop)),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionDestroy)),
+	)
+
+	switch {
+	case syncer.targetType == Service:
+		args.Add("label", swarmServiceLabel+"="+syncer.target)
+	case syncer.compose != nil:
+		args.Add("label", composeProjectLabel+"="+syncer.compose.Project)
+		args.Add("label", composeServiceLabel+"="+syncer.compose.Service)
+	case len(syncer.labels) > 0:
+		for _, label := range syncer.labels {
+			args.Add("label", label)
+		}
+	}
+	return args
+}
+
+// FollowTarget watches Docker events about the containers of the target until ctx is canceled.
+// Changes made outside of the syncer are reported to OnTargetEvent. While a single target container
+// is stopped, copies are queued and they're made once it starts again. When the target container is
+// replaced by one with the same name, e.g. by Compose or Watchtower, the syncer switches to the new one.
+// Replaced containers are also found again before copying, so following only makes it sooner
+func (syncer *Syncer) FollowTarget(ctx context.Context) {
+	if syncer.targetType != Container && syncer.targetType != Service {
+		return
+	}
+
+	for resubscribed := false; ; resubscribed = true {
+		syncer.mu.Lock()
+		dockerClient := syncer.client
+		syncer.mu.Unlock()
+
+		messages, errs := dockerClient.Events(ctx, events.ListOptions{Filters: syncer.targetFilters()})
+		if resubscribed {
+			syncer.recheckStopped(ctx)
+		}
+		err := syncer.followEvents(ctx, messages, errs)
+		if ctx.Err() != nil {
+			return
+		}
+
+		syncer.logger.Debug("Stopped receiving Docker events, subscribing again in {delay}: {error}", "delay", followRetryDelay.String(), "error", err)
+		if sleep(ctx, followRetryDelay) != nil {
+			return
+		}
+	}
+}
+
+// followEvents handles the events until the stream breaks
+func (syncer *Syncer) followEvents(ctx context.Context, messages <-chan events.Message, errs <-chan error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case message := <-messages:
+			syncer.handleEvent(ctx, message)
+		}
+	}
+}
+
+// handleEvent reports an event of a container of the target and keeps track of whether it's running
+func (syncer *Syncer) handleEvent(ctx context.Context, message events.Message) {
+	if syncer.isOwnEvent(message) {
+		return
+	}
+
+	syncer.mu.Lock()
+	if syncer.follows() {
+		if message.Actor.ID != syncer.target && message.Actor.Attributes["name"] != syncer.targetName {
+			syncer.mu.Unlock()
+			return
+		}
+		if message.Action == events.ActionStart {
+			syncer.retarget(message.Actor.ID)
+		}
+	}
+
+	event := TargetEvent{Action: string(message.Action), Container: message.Actor.ID}
+	if exitCode, err := strconv.Atoi(message.Actor.Attributes["exitCode"]); err == nil {
+		event.ExitCode = exitCode
+	}
+
+	resume := false
+	if syncer.singleContainer() {
+		if !event.Stopped() {
+			resume = syncer.targetStopped && len(syncer.pending) > 0
+			syncer.targetStopped = false
+			syncer.target = event.Container
+		} else if event.Container == syncer.target {
+			// Replaced containers are removed after their successors start
+			syncer.targetStopped = true
+		}
+	}
+	syncer.mu.Unlock()
+
+	syncer.logger.Debug("Container {container} of the target: {action}", "container", event.Container, "action", event.Action)
+	if syncer.onTargetEvent != nil {
+		syncer.onTargetEvent(event)
+	}
+
+	if resume {
+		syncer.copyQueued(ctx)
+	}
+}
+
+// recheckStopped finds out whether a target container known to be stopped runs again, since
+// the event of its start is missed when it starts while the syncer isn't subscribed to events
+func (syncer *Syncer) recheckStopped(ctx context.Context) {
+	syncer.mu.Lock()
+	if !syncer.targetStopped {
+		syncer.mu.Unlock()
+		return
+	}
+	containerId, err := syncer.findStoppedTarget(ctx)
+	if err != nil || containerId != "" {
+		syncer.mu.Unlock()
+		return
+	}
+	syncer.targetStopped = false
+	resume := len(syncer.pending) > 0
+	syncer.mu.Unlock()
+
+	if resume {
+		syncer.copyQueued(ctx)
+	}
+}
+
+// copyQueued copies the paths queued while the target was stopped
+func (syncer *Syncer) copyQueued(ctx context.Context) {
+	syncer.logger.Info("Target started again, copying queued paths...")
+	err := syncer.CopyBatch(ctx, nil)
+	if err != nil {
+		syncer.logger.Error("Failed to copy queued paths: {error}", "error", err)
+	}
+}
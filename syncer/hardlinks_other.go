@@ -0,0 +1,18 @@
+//go:build !windows
+
+package syncer
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardLinkKey returns the device and inode of a file with several hard links,
+// which identify it among its links
+func hardLinkKey(info os.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{device: uint64(stat.Dev), inode: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,9 @@
+package syncer
+
+import "os"
+
+// hardLinkKey reports false, since file infos on Windows don't carry the file index
+// and opening every file to get it would slow down archiving
+func hardLinkKey(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,205 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/distribution/reference"
+	cliconfig "github.com/docker/cli/cli/config"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// When to pull the image of helper containers
+const (
+	// PullMissing pulls the image unless it's already present on the daemon
+	PullMissing = "missing"
+	// PullAlways pulls the image every time, e.g. to get a newer version of a tag
+	PullAlways = "always"
+	// PullNever uses the image present on the daemon, for hosts without access to a registry
+	PullNever = "never"
+)
+
+// dockerHubAuthKey is the key of Docker Hub credentials in the Docker CLI config file
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// parsePlatform parses a platform in the os/arch[/variant] format, returning nil for an empty one
+func parsePlatform(platform string) (*ocispec.Platform, error) {
+	if platform == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("platform %s must be in the following format: <os>/<arch>[/<variant>]", platform)
+	}
+
+	parsed := &ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		parsed.Variant = parts[2]
+	}
+	return parsed, nil
+}
+
+// platformMinAPIVersion is the first version of the API creating containers for a given platform
+const platformMinAPIVersion = "1.41"
+
+// detectHelperPlatform makes helper containers use the platform of the daemon unless one was given,
+// so that an image present for another platform isn't used, and images for several platforms
+// are pulled for the one of the daemon, e.g. on ARM or Windows
+func (syncer *Syncer) detectHelperPlatform(ctx context.Context) error {
+	if syncer.helperPlatform != nil || syncer.daemonOS != "" {
+		return nil
+	}
+
+	version, err := syncer.client.ServerVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the version of the daemon: %w", err)
+	}
+	syncer.daemonOS = version.Os
+	// Older daemons pick the platform themselves
+	if version.Os == "" || version.Arch == "" || versions.LessThan(version.APIVersion, platformMinAPIVersion) {
+		return nil
+	}
+
+	syncer.helperPlatform = &ocispec.Platform{OS: version.Os, Architecture: version.Arch}
+	syncer.logger.Debug("Using helper containers for {platform}, the platform of the daemon", "platform", formatPlatform(syncer.helperPlatform))
+	return nil
+}
+
+// helperMountPath returns the path helper containers mount volumes at for a path in archives,
+// which is on drive C: in Windows containers
+func (syncer *Syncer) helperMountPath(archivePath string) string {
+	helperOS := syncer.daemonOS
+	if syncer.helperPlatform != nil {
+		helperOS = syncer.helperPlatform.OS
+	}
+	if helperOS != "windows" {
+		return archivePath
+	}
+	return "C:" + strings.ReplaceAll(archivePath, "/", `\`)
+}
+
+// ensureHelperImage makes sure the image of helper containers is on the daemon,
+// pulling it according to the pull policy
+func (syncer *Syncer) ensureHelperImage(ctx context.Context) error {
+	if err := syncer.detectHelperPlatform(ctx); err != nil {
+		return err
+	}
+
+	if syncer.helperPull != PullAlways {
+		present, err := syncer.hasHelperImage(ctx)
+		if err != nil {
+			return err
+		}
+		if present {
+			syncer.logger.Debug("Using image {image} present on the daemon", "image", syncer.helperImage)
+			return nil
+		}
+		if syncer.helperPull == PullNever {
+			return fmt.Errorf("image %s is not present on the daemon and pulling it is disabled, load it with docker load or pick another with --helper-image", syncer.helperImage)
+		}
+	}
+
+	return syncer.pullHelperImage(ctx)
+}
+
+// hasHelperImage reports whether the image of helper containers is on the daemon for the platform
+func (syncer *Syncer) hasHelperImage(ctx context.Context) (bool, error) {
+	info, _, err := syncer.client.ImageInspectWithRaw(ctx, syncer.helperImage)
+	if client.IsErrNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect image %s: %w", syncer.helperImage, err)
+	}
+
+	if syncer.helperPlatform != nil && (info.Os != syncer.helperPlatform.OS || info.Architecture != syncer.helperPlatform.Architecture) {
+		syncer.logger.Debug("Image {image} is present for {os}/{arch} only", "image", syncer.helperImage, "os", info.Os, "arch", info.Architecture)
+		return false, nil
+	}
+	return true, nil
+}
+
+// pullHelperImage pulls the image of helper containers with the credentials of the Docker CLI
+func (syncer *Syncer) pullHelperImage(ctx context.Context) error {
+	options := image.PullOptions{}
+	if syncer.helperPlatform != nil {
+		options.Platform = formatPlatform(syncer.helperPlatform)
+	}
+
+	auth, err := registryAuth(syncer.helperImage)
+	if err != nil {
+		syncer.logger.Debug("Pulling {image} anonymously, failed to get credentials: {error}", "image", syncer.helperImage, "error", err)
+	}
+	options.RegistryAuth = auth
+
+	syncer.logger.Info("Pulling image {image}...", "image", syncer.helperImage)
+	reader, err := syncer.client.ImagePull(ctx, syncer.helperImage, options)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", syncer.helperImage, err)
+	}
+	defer reader.Close()
+
+	// The pull is only done once its progress stream ends, errors are reported in it
+	decoder := json.NewDecoder(reader)
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		err := decoder.Decode(&message)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to pull image %s: %w", syncer.helperImage, err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("failed to pull image %s: %s", syncer.helperImage, message.Error)
+		}
+	}
+}
+
+// formatPlatform formats the platform as os/arch[/variant]
+func formatPlatform(platform *ocispec.Platform) string {
+	formatted := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		formatted += "/" + platform.Variant
+	}
+	return formatted
+}
+
+// registryAuth returns the encoded credentials for the registry of the image
+// stored by the Docker CLI, including the ones kept by credential helpers
+func registryAuth(imageName string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", fmt.Errorf("invalid image %s: %w", imageName, err)
+	}
+
+	hostname := reference.Domain(named)
+	if hostname == "docker.io" {
+		hostname = dockerHubAuthKey
+	}
+
+	authConfig, err := cliconfig.LoadDefaultConfigFile(io.Discard).GetAuthConfig(hostname)
+	if err != nil {
+		return "", err
+	}
+
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      authConfig.Username,
+		Password:      authConfig.Password,
+		Auth:          authConfig.Auth,
+		ServerAddress: authConfig.ServerAddress,
+		IdentityToken: authConfig.IdentityToken,
+		RegistryToken: authConfig.RegistryToken,
+	})
+}
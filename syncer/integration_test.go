@@ -0,0 +1,187 @@
+//go:build integration
+
+package syncer
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/google/uuid"
+)
+
+// integrationImage is the image of the containers the integration tests sync to
+const integrationImage = "alpine:3.20"
+
+// dockerClient returns a client of the daemon in the environment, skipping the test if it can't be reached
+func dockerClient(t *testing.T) *client.Client {
+	t.Helper()
+	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dockerClient.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := dockerClient.Ping(ctx); err != nil {
+		t.Skipf("Docker isn't reachable: %v", err)
+	}
+	return dockerClient
+}
+
+// runContainer starts a container of integrationImage with /app, removing it once the test ends
+func runContainer(t *testing.T, dockerClient *client.Client) string {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, _, err := dockerClient.ImageInspectWithRaw(ctx, integrationImage); errdefs.IsNotFound(err) {
+		progress, err := dockerClient.ImagePull(ctx, integrationImage, image.PullOptions{})
+		if err != nil {
+			t.Fatalf("failed to pull %s: %v", integrationImage, err)
+		}
+		io.Copy(io.Discard, progress)
+		progress.Close()
+	}
+
+	name := "docker-sync-test-" + uuid.New().String()[:8]
+	response, err := dockerClient.ContainerCreate(ctx, &container.Config{
+		Image:      integrationImage,
+		Cmd:        []string{"sh", "-c", "mkdir -p /app && exec sleep 3600"},
+		StopSignal: "SIGKILL",
+	}, nil, nil, nil, name)
+	if err != nil {
+		t.Fatalf("failed to create container: %v", err)
+	}
+	t.Cleanup(func() {
+		// The container may have been replaced by one with the same name
+		dockerClient.ContainerRemove(context.Background(), name, container.RemoveOptions{Force: true})
+		dockerClient.ContainerRemove(context.Background(), response.ID, container.RemoveOptions{Force: true})
+	})
+
+	err = dockerClient.ContainerStart(ctx, response.ID, container.StartOptions{})
+	if err != nil {
+		t.Fatalf("failed to start container: %v", err)
+	}
+	// /app is created by the command
+	for {
+		_, err := dockerClient.ContainerStatPath(ctx, response.ID, "/app")
+		if err == nil {
+			break
+		}
+		if !errdefs.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return name
+}
+
+// readFile returns the contents of the file in the container
+func readFile(t *testing.T, dockerClient *client.Client, containerName, path string) string {
+	t.Helper()
+	reader, _, err := dockerClient.CopyFromContainer(context.Background(), containerName, path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestIntegrationCopyBatch(t *testing.T) {
+	dockerClient := dockerClient(t)
+	name := runContainer(t, dockerClient)
+	syncer, source := newTestSyncer(t, dockerClient, name, nil)
+	writeTree(t, source, map[string]string{"index.html": "<h1>", "css/site.css": "body {}"})
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	if content := readFile(t, dockerClient, name, "/app/css/site.css"); content != "body {}" {
+		t.Errorf("/app/css/site.css = %q, want %q", content, "body {}")
+	}
+}
+
+func TestIntegrationRestartKeepsFiles(t *testing.T) {
+	dockerClient := dockerClient(t)
+	name := runContainer(t, dockerClient)
+	syncer, source := newTestSyncer(t, dockerClient, name, func(options *Options) {
+		options.RestartTarget = true
+		options.RestartMode = RestartModeRestart
+	})
+	writeTree(t, source, map[string]string{"main.go": "package main"})
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	info, err := dockerClient.ContainerInspect(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.State.Running || info.ID != syncer.target {
+		t.Errorf("container %s isn't running after the restart", name)
+	}
+	if content := readFile(t, dockerClient, name, "/app/main.go"); content != "package main" {
+		t.Errorf("/app/main.go = %q after the restart, want %q", content, "package main")
+	}
+}
+
+func TestIntegrationRecreateAndCleanup(t *testing.T) {
+	dockerClient := dockerClient(t)
+	name := runContainer(t, dockerClient)
+	original, err := dockerClient.ContainerInspect(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer, source := newTestSyncer(t, dockerClient, name, func(options *Options) {
+		options.RestartTarget = true
+	})
+	writeTree(t, source, map[string]string{"main.go": "package main"})
+
+	err = syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	recreated, err := dockerClient.ContainerInspect(context.Background(), name)
+	if err != nil {
+		t.Fatalf("container %s is gone after recreating it: %v", name, err)
+	}
+	if recreated.ID == original.ID || recreated.ID != syncer.target {
+		t.Errorf("container %s = %s, want a new container followed by the syncer (%s)", name, recreated.ID, syncer.target)
+	}
+	if !recreated.State.Running {
+		t.Errorf("the new container %s isn't running", name)
+	}
+
+	err = syncer.Cleanup(context.Background())
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+	cleaned, err := dockerClient.ContainerInspect(context.Background(), name)
+	if err != nil {
+		t.Fatalf("container %s is gone after cleaning up: %v", name, err)
+	}
+	if !cleaned.State.Running {
+		t.Errorf("container %s isn't running after cleaning up", name)
+	}
+	if _, err := dockerClient.ContainerInspect(context.Background(), name+replacedSuffix); !errdefs.IsNotFound(err) {
+		t.Errorf("the replaced container %s is left behind", name+replacedSuffix)
+	}
+}
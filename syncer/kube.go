@@ -0,0 +1,200 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// KubeScheme is the prefix of destinations in a Kubernetes cluster
+const KubeScheme = "kube://"
+
+// KubeTarget is a pod in a Kubernetes cluster. Kubernetes is accessed with kubectl,
+// using its current context
+type KubeTarget struct {
+	Namespace string
+	// Pod is the name of a pod or a workload in the TYPE/NAME format, e.g. deployment/web,
+	// in which case kubectl picks one of its pods
+	Pod string
+	// Container is the container in the pod, the default one if empty
+	Container string
+}
+
+// ParseKubeDestination parses a destination in the kube://<namespace>/<pod>[:<container>]:<path> format
+func ParseKubeDestination(destination string) (*KubeTarget, string, error) {
+	formatErr := fmt.Errorf("destination %s must be in the following format: %s<namespace>/<pod>[:<container>]:<path>", destination, KubeScheme)
+
+	namespace, rest, ok := strings.Cut(strings.TrimPrefix(destination, KubeScheme), "/")
+	if !ok || namespace == "" {
+		return nil, "", formatErr
+	}
+
+	segments := strings.Split(rest, ":")
+	target := &KubeTarget{Namespace: namespace, Pod: segments[0]}
+	var targetPath string
+	switch len(segments) {
+	case 2:
+		targetPath = segments[1]
+	case 3:
+		target.Container = segments[1]
+		targetPath = segments[2]
+	default:
+		return nil, "", formatErr
+	}
+	if target.Pod == "" || targetPath == "" {
+		return nil, "", formatErr
+	}
+
+	return target, targetPath, nil
+}
+
+func (target *KubeTarget) String() string {
+	s := KubeScheme + target.Namespace + "/" + target.Pod
+	if target.Container != "" {
+		s += ":" + target.Container
+	}
+	return s
+}
+
+// resource returns the pod in the TYPE/NAME format understood by kubectl
+func (target *KubeTarget) resource() string {
+	if strings.Contains(target.Pod, "/") {
+		return target.Pod
+	}
+	return "pod/" + target.Pod
+}
+
+// isWorkload reports whether the target is a workload managing pods rather than a single pod
+func (target *KubeTarget) isWorkload() bool {
+	return !strings.HasPrefix(target.resource(), "pod/")
+}
+
+// kubectl runs kubectl in the namespace of the target. The output is written to stdout
+// and stderr when given, otherwise stderr is included in the returned error
+func (syncer *Syncer) kubectl(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+	args = append([]string{"--namespace", syncer.kube.Namespace}, args...)
+	syncer.logger.Debug("Running kubectl {args}...", "args", strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+
+	var errOutput bytes.Buffer
+	cmd.Stderr = &errOutput
+	if stderr != nil {
+		cmd.Stderr = stderr
+	}
+
+	err := cmd.Run()
+	if err != nil && errOutput.Len() > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(errOutput.String()))
+	}
+	return err
+}
+
+// kubectlExec runs a command in the container of the target pod
+func (syncer *Syncer) kubectlExec(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, command ...string) error {
+	args := []string{"exec", syncer.kube.resource()}
+	if stdin != nil {
+		args = append(args, "--stdin")
+	}
+	if syncer.kube.Container != "" {
+		args = append(args, "--container", syncer.kube.Container)
+	}
+	args = append(args, "--")
+	return syncer.kubectl(ctx, stdin, stdout, stderr, append(args, command...)...)
+}
+
+// initKube checks that kubectl is available and the target exists
+func (syncer *Syncer) initKube(ctx context.Context) error {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return fmt.Errorf("kubectl is required to sync with %s: %w", syncer.kube, err)
+	}
+
+	err := syncer.kubectl(ctx, nil, io.Discard, nil, "get", syncer.kube.resource(), "--output", "name")
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %w", syncer.kube, err)
+	}
+
+	if syncer.recreatesTarget() && !syncer.kube.isWorkload() {
+		return fmt.Errorf("pod %s can't be restarted, target the workload managing it instead, e.g. %s%s/deployment/<name>:<path>", syncer.kube.Pod, KubeScheme, syncer.kube.Namespace)
+	}
+
+	return nil
+}
+
+// copyToPod streams the paths to the target pod, extracting them with tar inside of it
+func (syncer *Syncer) copyToPod(ctx context.Context, sourcePaths []string) (int, error) {
+	return syncer.uploadArchive(ctx, sourcePaths, syncer.targetPath, syncer.podChunkTarget(), func(reader io.Reader) error {
+		return syncer.workers.Do(ctx, syncer.kube.String(), func() error {
+			err := syncer.kubectlExec(ctx, reader, io.Discard, nil, syncer.extractCommand()...)
+			if err != nil || !syncer.atomicFiles {
+				return err
+			}
+			return syncer.swapInPod(ctx)
+		})
+	})
+}
+
+// execInPod runs a shell command in the target pod
+func (syncer *Syncer) execInPod(ctx context.Context, command string) error {
+	syncer.logger.Debug("Running {command} in {target}...", "command", command, "target", syncer.kube.String())
+	err := syncer.kubectlExec(ctx, nil, syncer.stdout, syncer.stderr, "sh", "-c", command)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("command %q exited with code %d", command, exitErr.ExitCode())
+	}
+	return err
+}
+
+// pullFromPod downloads the target path from the target pod into localDir
+func (syncer *Syncer) pullFromPod(ctx context.Context, localDir string) error {
+	syncer.logger.Debug("Downloading {path} from {target}...", "path", syncer.targetPath, "target", syncer.kube.String())
+
+	// Archive the contents of directories and files by their name, like Pull does with containers
+	script := `if [ -d "$1" ]; then cd "$1" && tar -cf - .; else cd "$(dirname "$1")" && tar -cf - "$(basename "$1")"; fi`
+
+	reader, writer := io.Pipe()
+	execErr := make(chan error, 1)
+	go func() {
+		err := syncer.kubectlExec(ctx, nil, writer, nil, "sh", "-c", script, "sh", syncer.targetPath)
+		writer.CloseWithError(err)
+		execErr <- err
+	}()
+
+	err := extractArchive(reader, localDir, "")
+	reader.CloseWithError(io.ErrClosedPipe)
+	if pullErr := <-execErr; pullErr != nil && !errors.Is(pullErr, io.ErrClosedPipe) {
+		return fmt.Errorf("failed to copy %s from %s: %w", syncer.targetPath, syncer.kube, pullErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract %s into %s: %w", syncer.targetPath, localDir, err)
+	}
+
+	return nil
+}
+
+// restartWorkload restarts the pods of the target workload and waits for the rollout to finish
+func (syncer *Syncer) restartWorkload(ctx context.Context) error {
+	if !syncer.kube.isWorkload() {
+		return fmt.Errorf("pod %s can't be restarted, target the workload managing it instead", syncer.kube.Pod)
+	}
+
+	syncer.logger.Debug("Restarting {target}...", "target", syncer.kube.String())
+	err := syncer.kubectl(ctx, nil, io.Discard, nil, "rollout", "restart", syncer.kube.resource())
+	if err != nil {
+		return fmt.Errorf("failed to restart %s: %w", syncer.kube, err)
+	}
+
+	err = syncer.kubectl(ctx, nil, io.Discard, nil, "rollout", "status", syncer.kube.resource())
+	if err != nil {
+		return fmt.Errorf("failed to wait for %s to restart: %w", syncer.kube, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// describeLabels returns a description of the containers selected by the labels
+func describeLabels(labels []string) string {
+	return "containers labeled " + strings.Join(labels, ",")
+}
+
+// findLabeledContainers returns the IDs of the running containers having all the labels,
+// sorted by name. They are looked up on every call, since orchestrators often replace
+// containers under new names
+func (syncer *Syncer) findLabeledContainers(ctx context.Context) ([]string, error) {
+	args := filters.NewArgs()
+	for _, label := range syncer.labels {
+		args.Add("label", label)
+	}
+
+	containers, err := syncer.client.ContainerList(ctx, container.ListOptions{Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no running %s", describeLabels(syncer.labels))
+	}
+
+	slices.SortFunc(containers, func(a, b types.Container) int {
+		return strings.Compare(strings.Join(a.Names, ","), strings.Join(b.Names, ","))
+	})
+
+	ids := make([]string, len(containers))
+	for i, c := range containers {
+		ids[i] = c.ID
+	}
+	return ids, nil
+}
+
+// forEachLabeledContainer calls fn for every labeled container, running the calls through the workers
+func (syncer *Syncer) forEachLabeledContainer(ctx context.Context, fn func(containerId string) error) error {
+	containers, err := syncer.findLabeledContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	return syncer.workers.Each(ctx, containers, fn)
+}
+
+// copyToLabeledContainers uploads the paths to all labeled containers, archiving them
+// separately for each container, so that a slow container doesn't hold up the others
+func (syncer *Syncer) copyToLabeledContainers(ctx context.Context, sourcePaths []string) (int, error) {
+	containers, err := syncer.findLabeledContainers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	entries, pending, err := syncer.collectEntries(ctx, sourcePaths, syncer.targetPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create tar archive: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	entries = syncer.stageEntries(entries)
+
+	tracker := syncer.newTracker(entries, len(containers))
+	err = syncer.workers.Each(ctx, containers, func(containerId string) error {
+		syncer.logger.Debug("Copying to container {container}...", "container", containerId)
+		err := syncer.streamArchive(ctx, entries, tracker, func(reader io.Reader) error {
+			err := syncer.uploadToContainer(ctx, containerId, reader)
+			if err != nil || !syncer.atomicFiles {
+				return err
+			}
+			return syncer.swapInContainer(ctx, containerId)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy to container %s: %w", containerId, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if tracker != nil {
+		tracker.finish()
+	}
+
+	syncer.countCopied(entries)
+	for path, entry := range pending {
+		syncer.index.Record(path, entry)
+	}
+
+	return len(entries), nil
+}
@@ -0,0 +1,121 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Actions taken when a limit is exceeded
+const (
+	// LimitAbort fails the copy
+	LimitAbort = "abort"
+	// LimitWarn logs a warning and copies anyway
+	LimitWarn = "warn"
+)
+
+// ErrLimitExceeded is returned when the files to copy exceed the limits with LimitAbort
+var ErrLimitExceeded = errors.New("sync limit exceeded")
+
+// Limits protect against syncing far more than intended, e.g. a home directory
+// instead of a project. Zero values don't limit anything
+type Limits struct {
+	// MaxFileSize is the size of the largest file that can be copied, in bytes
+	MaxFileSize int64
+	// MaxTotalSize is how many bytes the files of a copy can take in total
+	MaxTotalSize int64
+	// MaxFiles is how many files a copy can contain
+	MaxFiles int
+	// Action is LimitAbort (the default) or LimitWarn
+	Action string
+}
+
+// limitCounter adds up the files of a copy to check them against the limits
+type limitCounter struct {
+	syncer        *Syncer
+	files         int
+	total         int64
+	filesExceeded bool
+	totalExceeded bool
+}
+
+// add counts the entry, returning an error if it exceeds a limit with LimitAbort
+func (counter *limitCounter) add(entry archiveEntry) error {
+	if !entry.info.Mode().IsRegular() {
+		return nil
+	}
+
+	limits := counter.syncer.limits
+	size := entry.info.Size()
+	counter.files++
+	counter.total += size
+
+	if limits.MaxFileSize > 0 && size > limits.MaxFileSize {
+		err := counter.syncer.exceeded(fmt.Sprintf("%s takes %s, more than the limit of %s per file", entry.path, formatSize(size), formatSize(limits.MaxFileSize)))
+		if err != nil {
+			return err
+		}
+	}
+
+	if limits.MaxFiles > 0 && counter.files > limits.MaxFiles && !counter.filesExceeded {
+		counter.filesExceeded = true
+		err := counter.syncer.exceeded(fmt.Sprintf("%s has more than %d files to copy", counter.syncer.sourcePath, limits.MaxFiles))
+		if err != nil {
+			return err
+		}
+	}
+
+	if limits.MaxTotalSize > 0 && counter.total > limits.MaxTotalSize && !counter.totalExceeded {
+		counter.totalExceeded = true
+		err := counter.syncer.exceeded(fmt.Sprintf("%s has more than %s of files to copy", counter.syncer.sourcePath, formatSize(limits.MaxTotalSize)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exceeded fails with the reason when limits abort, otherwise it logs a warning
+func (syncer *Syncer) exceeded(reason string) error {
+	if syncer.limits.Action == LimitWarn {
+		syncer.logger.Warn("{reason}, copying anyway", "reason", reason)
+		return nil
+	}
+	return fmt.Errorf("%w: %s. Check the source and the exclusions, or raise the limit if this is intended", ErrLimitExceeded, reason)
+}
+
+// CheckLimits walks the whole source and checks it against the limits before anything is copied,
+// so that pointing a sync at the wrong directory fails right away
+func (syncer *Syncer) CheckLimits(ctx context.Context) error {
+	limits := syncer.limits
+	if syncer.sourcePath == "" || limits.MaxFileSize == 0 && limits.MaxTotalSize == 0 && limits.MaxFiles == 0 {
+		return nil
+	}
+
+	sourceInfo, err := os.Stat(syncer.sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	counter := &limitCounter{syncer: syncer}
+	return syncer.walkEntries(ctx, syncer.sourcePath, sourceInfo, syncer.targetPath, make(map[string]bool), counter.add)
+}
+
+// formatSize formats a number of bytes with a binary unit
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes)
+	exponent := 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exponent-1])
+}
@@ -0,0 +1,239 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axtgr/docker-sync/logger"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// logColors are the colors of the names prefixing the logs of the containers, taken in turn
+var logColors = []string{"\033[36m", "\033[32m", "\033[35m", "\033[33m", "\033[34m", "\033[31m"}
+
+// logSource is the log stream of a container of the target
+type logSource struct {
+	name string
+	tty  bool
+	open func(ctx context.Context, options container.LogsOptions) (io.ReadCloser, error)
+}
+
+// restarted reports that the target was restarted at since, following its logs if requested,
+// and restarts its dependents
+func (syncer *Syncer) restarted(ctx context.Context, since time.Time) error {
+	syncer.followLogs(ctx, since)
+	if syncer.onRestart != nil {
+		syncer.onRestart()
+	}
+	return syncer.restartDependents(ctx)
+}
+
+// followLogs streams the logs of the containers of the target written since the restart to Stdout,
+// every line prefixed with the name of its container, until the next restart or until ctx is canceled
+func (syncer *Syncer) followLogs(ctx context.Context, since time.Time) {
+	if !syncer.logs {
+		return
+	}
+
+	syncer.logsMu.Lock()
+	if syncer.stopLogs != nil {
+		syncer.stopLogs()
+	}
+	ctx, syncer.stopLogs = context.WithCancel(ctx)
+	syncer.logsMu.Unlock()
+
+	if syncer.targetType == Pod {
+		output := &logOutput{out: syncer.stdout, width: len(syncer.kube.String())}
+		go syncer.followPodLogs(ctx, since, output.writer(syncer.kube.String(), logColors[0]))
+		return
+	}
+
+	sources, err := syncer.logSources(ctx)
+	if err != nil {
+		syncer.logger.Warn("Not following the logs of {target}: {error}", "target", syncer.target, "error", err)
+		return
+	}
+
+	output := &logOutput{out: syncer.stdout}
+	for _, source := range sources {
+		output.width = max(output.width, len(source.name))
+	}
+	for i, source := range sources {
+		go syncer.followContainerLogs(ctx, source, since, output.writer(source.name, logColors[i%len(logColors)]))
+	}
+}
+
+// logSources returns the log streams of the running containers of the target, every replica
+// of services and every container selected by labels
+func (syncer *Syncer) logSources(ctx context.Context) ([]logSource, error) {
+	switch syncer.targetType {
+	case Service:
+		return syncer.serviceLogSources(ctx)
+	case Container:
+	default:
+		return nil, nil
+	}
+
+	var containers []string
+	if len(syncer.labels) > 0 {
+		var err error
+		containers, err = syncer.findLabeledContainers(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		containerId, err := syncer.getTargetContainer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		containers = []string{containerId}
+	}
+
+	sources := make([]logSource, 0, len(containers))
+	for _, containerId := range containers {
+		info, err := syncer.client.ContainerInspect(ctx, containerId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+		}
+		sources = append(sources, logSource{
+			name: strings.TrimPrefix(info.Name, "/"),
+			tty:  info.Config != nil && info.Config.Tty,
+			open: func(ctx context.Context, options container.LogsOptions) (io.ReadCloser, error) {
+				return syncer.client.ContainerLogs(ctx, containerId, options)
+			},
+		})
+	}
+	return sources, nil
+}
+
+// serviceLogSources returns the log streams of the running tasks of the target service. Logs of tasks
+// are read through the swarm, so that the replicas on other nodes are followed as well
+func (syncer *Syncer) serviceLogSources(ctx context.Context) ([]logSource, error) {
+	args := filters.NewArgs(
+		filters.Arg("service", syncer.target),
+		filters.Arg("desired-state", "running"),
+	)
+	tasks, err := syncer.client.TaskList(ctx, types.TaskListOptions{Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	var sources []logSource
+	for _, task := range tasks {
+		tty := task.Spec.ContainerSpec != nil && task.Spec.ContainerSpec.TTY
+		sources = append(sources, logSource{
+			name: fmt.Sprintf("%s.%d", syncer.target, task.Slot),
+			tty:  tty,
+			open: func(ctx context.Context, options container.LogsOptions) (io.ReadCloser, error) {
+				return syncer.client.TaskLogs(ctx, task.ID, options)
+			},
+		})
+	}
+	return sources, nil
+}
+
+// followContainerLogs streams the logs of the container until it stops or ctx is canceled
+func (syncer *Syncer) followContainerLogs(ctx context.Context, source logSource, since time.Time, writer *logWriter) {
+	defer writer.flush()
+
+	options := container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true}
+	if !since.IsZero() {
+		options.Since = since.Format(time.RFC3339Nano)
+	}
+	reader, err := source.open(ctx, options)
+	if err != nil {
+		if ctx.Err() == nil {
+			syncer.logger.Warn("Failed to follow the logs of {container}: {error}", "container", source.name, "error", err)
+		}
+		return
+	}
+	defer reader.Close()
+
+	// Containers with a TTY have a single stream, the others multiplex stdout and stderr
+	if source.tty {
+		_, err = io.Copy(writer, reader)
+	} else {
+		_, err = stdcopy.StdCopy(writer, writer, reader)
+	}
+	if err != nil && ctx.Err() == nil && !errors.Is(err, io.EOF) {
+		syncer.logger.Debug("Stopped following the logs of {container}: {error}", "container", source.name, "error", err)
+	}
+}
+
+// followPodLogs streams the logs of the target pod with kubectl until ctx is canceled
+func (syncer *Syncer) followPodLogs(ctx context.Context, since time.Time, writer *logWriter) {
+	defer writer.flush()
+
+	args := []string{"logs", "--follow", syncer.kube.resource()}
+	if !since.IsZero() {
+		args = append(args, "--since-time", since.Format(time.RFC3339))
+	}
+	if syncer.kube.Container != "" {
+		args = append(args, "--container", syncer.kube.Container)
+	}
+	err := syncer.kubectl(ctx, nil, writer, nil, args...)
+	if err != nil && ctx.Err() == nil {
+		syncer.logger.Warn("Failed to follow the logs of {target}: {error}", "target", syncer.kube.String(), "error", err)
+	}
+}
+
+// logOutput writes the lines of the logs of several containers to out, without mixing up
+// the lines written at the same time
+type logOutput struct {
+	mu  sync.Mutex
+	out io.Writer
+	// width is the width the names of the containers are padded to
+	width int
+}
+
+// writer returns a writer prefixing every line written to it with the name in the color
+func (output *logOutput) writer(name, color string) *logWriter {
+	return &logWriter{
+		output: output,
+		prefix: fmt.Sprintf("%s%-*s |%s ", color, output.width, name, logger.ColorReset),
+	}
+}
+
+func (output *logOutput) writeLine(prefix string, line []byte) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	io.WriteString(output.out, prefix)
+	output.out.Write(line)
+}
+
+// logWriter writes whole lines to a logOutput, holding back the incomplete last line
+type logWriter struct {
+	output  *logOutput
+	prefix  string
+	partial []byte
+}
+
+func (writer *logWriter) Write(p []byte) (int, error) {
+	writer.partial = append(writer.partial, p...)
+	for {
+		i := bytes.IndexByte(writer.partial, '\n')
+		if i < 0 {
+			break
+		}
+		writer.output.writeLine(writer.prefix, writer.partial[:i+1])
+		writer.partial = writer.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush writes the incomplete last line, once the stream ends
+func (writer *logWriter) flush() {
+	if len(writer.partial) > 0 {
+		writer.output.writeLine(writer.prefix, append(writer.partial, '\n'))
+		writer.partial = nil
+	}
+}
@@ -0,0 +1,65 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/axtgr/docker-sync/index"
+)
+
+// modeScript sets the mode given as its first argument on the paths given as the rest
+const modeScript = `chmod "$@"`
+
+// modeChanged reports whether the permissions of the file changed since it was copied, whether or not
+// its contents did. Permissions set with Options.Chmod don't follow the local ones, so they never change
+func (syncer *Syncer) modeChanged(localPath string, info os.FileInfo) bool {
+	// The permissions of secrets and configs are set by the services using them
+	if syncer.targetType == SwarmObject {
+		return false
+	}
+	return syncer.fileMode == 0 && syncer.index.ModeChanged(localPath, info)
+}
+
+// changesModesInPlace reports whether the copied files can be found in a running target container
+// to run chmod on them. Otherwise, files whose permissions changed are copied again
+func (syncer *Syncer) changesModesInPlace() bool {
+	switch {
+	case syncer.targetType == Volume, syncer.targetType == External, syncer.agent, len(syncer.labels) > 0:
+		return false
+	case syncer.strategy == StrategyVolumeServiceUpdate, syncer.strategy == StrategyCopyRecreate:
+		return false
+	case syncer.stoppedContainer != "":
+		// chmod can't run in stopped containers
+		return false
+	}
+	return true
+}
+
+// changeModes sets the permissions of files already in the target by running chmod in it,
+// once for every mode, and records them as copied with these permissions
+func (syncer *Syncer) changeModes(ctx context.Context, entries map[string]index.Entry) error {
+	byMode := make(map[os.FileMode][]string)
+	for localPath, entry := range entries {
+		containerPath, err := syncer.containerPathFor(localPath, syncer.targetPath)
+		if err != nil {
+			return err
+		}
+		byMode[entry.Mode] = append(byMode[entry.Mode], containerPath)
+	}
+
+	for mode, containerPaths := range byMode {
+		sort.Strings(containerPaths)
+		syncer.logger.Debug("Changing the mode of {count} files to {mode}...", "count", len(containerPaths), "mode", fmt.Sprintf("%o", mode))
+		_, err := syncer.output(ctx, modeScript, append([]string{fmt.Sprintf("%o", mode)}, containerPaths...)...)
+		if err != nil {
+			return fmt.Errorf("failed to change the mode of files in %s: %w", syncer.target, err)
+		}
+	}
+
+	for localPath, entry := range entries {
+		syncer.index.Record(localPath, entry)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// Kinds of resources created by syncers
+const (
+	ResourceContainer = "container"
+	ResourceVolume    = "volume"
+	ResourceService   = "service"
+)
+
+// Resource is a container, volume or service created by a syncer and labeled with its identifier
+type Resource struct {
+	Kind    string
+	ID      string
+	Name    string
+	Created time.Time
+	// Owner is the <hostname>:<pid> of the process that created the resource, if known
+	Owner string
+	// Stale is set if the resource is left behind by a process that's gone
+	// or it's older than the age given to FindResources
+	Stale bool
+}
+
+// ownerLabel returns the label holding the process that created a resource
+func (syncer *Syncer) ownerLabel() string {
+	return syncer.identifier + ".owner"
+}
+
+// resourceLabels returns the labels of the resources created by the syncer
+func (syncer *Syncer) resourceLabels() map[string]string {
+	return map[string]string{
+		syncer.identifier:   "true",
+		syncer.ownerLabel(): ProcessOwner(),
+	}
+}
+
+// ProcessOwner identifies the current process as <hostname>:<pid>
+func ProcessOwner() string {
+	hostname, _ := os.Hostname()
+	return hostname + ":" + strconv.Itoa(os.Getpid())
+}
+
+// OwnerGone reports whether the owner is a process of this host that's no longer running.
+// Owners on other hosts can't be checked
+func OwnerGone(owner string) bool {
+	index := strings.LastIndex(owner, ":")
+	if index < 0 {
+		return false
+	}
+	pid, err := strconv.Atoi(owner[index+1:])
+	if err != nil {
+		return false
+	}
+	hostname, err := os.Hostname()
+	if err != nil || owner[:index] != hostname {
+		return false
+	}
+	if pid == os.Getpid() {
+		return false
+	}
+	return !processRunning(pid)
+}
+
+// processRunning reports whether a process with the PID exists
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Finding a process only succeeds for existing ones on Windows, which can't signal them
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// FindResources lists the containers, services and volumes labeled with the identifier of the syncer,
+// in the order they can be removed in, since volumes can't be removed while they're used.
+// They're marked as stale if the process that created them on this host is gone or,
+// unless olderThan is 0, if they were created longer than olderThan ago
+func (syncer *Syncer) FindResources(ctx context.Context, olderThan time.Duration) ([]Resource, error) {
+	if syncer.identifier == "" {
+		return nil, fmt.Errorf("resources can't be found without an identifier")
+	}
+
+	labelFilter := filters.NewArgs(filters.Arg("label", syncer.identifier))
+	var resources []Resource
+
+	containers, err := syncer.client.ContainerList(ctx, container.ListOptions{All: true, Filters: labelFilter})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	for _, info := range containers {
+		name := info.ID
+		if len(info.Names) > 0 {
+			name = strings.TrimPrefix(info.Names[0], "/")
+		}
+		resources = append(resources, Resource{
+			Kind:    ResourceContainer,
+			ID:      info.ID,
+			Name:    name,
+			Created: time.Unix(info.Created, 0),
+			Owner:   info.Labels[syncer.ownerLabel()],
+		})
+	}
+
+	if syncer.provider.supportsServices() {
+		services, err := syncer.client.ServiceList(ctx, types.ServiceListOptions{Filters: labelFilter})
+		// Daemons outside of a swarm have no services
+		if err != nil && !errdefs.IsUnavailable(err) {
+			return nil, fmt.Errorf("failed to list services: %w", err)
+		}
+		for _, info := range services {
+			resources = append(resources, Resource{
+				Kind:    ResourceService,
+				ID:      info.ID,
+				Name:    info.Spec.Name,
+				Created: info.CreatedAt,
+				Owner:   info.Spec.Labels[syncer.ownerLabel()],
+			})
+		}
+	}
+
+	volumes, err := syncer.client.VolumeList(ctx, volume.ListOptions{Filters: labelFilter})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for _, info := range volumes.Volumes {
+		created, _ := time.Parse(time.RFC3339, info.CreatedAt)
+		resources = append(resources, Resource{
+			Kind:    ResourceVolume,
+			ID:      info.Name,
+			Name:    info.Name,
+			Created: created,
+			Owner:   info.Labels[syncer.ownerLabel()],
+		})
+	}
+
+	for i, resource := range resources {
+		old := olderThan > 0 && !resource.Created.IsZero() && time.Since(resource.Created) > olderThan
+		resources[i].Stale = OwnerGone(resource.Owner) || old && resource.Owner != ProcessOwner()
+	}
+	return resources, nil
+}
+
+// RemoveResource removes a resource found by FindResources. Removing one that's already gone
+// isn't an error, removing a volume still used by a container is
+func (syncer *Syncer) RemoveResource(ctx context.Context, resource Resource) error {
+	var err error
+	switch resource.Kind {
+	case ResourceContainer:
+		err = syncer.client.ContainerRemove(ctx, resource.ID, container.RemoveOptions{Force: true})
+	case ResourceVolume:
+		err = syncer.client.VolumeRemove(ctx, resource.ID, false)
+	case ResourceService:
+		err = syncer.client.ServiceRemove(ctx, resource.ID)
+	default:
+		return fmt.Errorf("unknown kind of resource %s", resource.Kind)
+	}
+	if err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to remove %s %s: %w", resource.Kind, resource.Name, err)
+	}
+	return nil
+}
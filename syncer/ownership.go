@@ -0,0 +1,237 @@
+package syncer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ChownAuto makes copied files owned by the user the target container runs as
+const ChownAuto = "auto"
+
+// owner is the numeric owner given to copied files
+type owner struct {
+	uid int
+	gid int
+}
+
+// parseChmod parses permissions in the D<mode>,F<mode> format, where D applies to directories
+// and F to files. A mode without a prefix applies to both
+func parseChmod(spec string) (fileMode, dirMode os.FileMode, err error) {
+	for _, part := range strings.Split(spec, ",") {
+		applyToFiles, applyToDirs := true, true
+		if strings.HasPrefix(part, "F") {
+			applyToDirs = false
+			part = part[1:]
+		} else if strings.HasPrefix(part, "D") {
+			applyToFiles = false
+			part = part[1:]
+		}
+
+		mode, err := strconv.ParseUint(part, 8, 32)
+		if err != nil || mode > 0777 {
+			return 0, 0, fmt.Errorf("invalid permissions %s, expected octal modes like D755,F644", spec)
+		}
+		if applyToFiles {
+			fileMode = os.FileMode(mode)
+		}
+		if applyToDirs {
+			dirMode = os.FileMode(mode)
+		}
+	}
+	return fileMode, dirMode, nil
+}
+
+// parseUmask parses an octal umask, like 022, into the default permissions of the files
+// and directories created with it. Executable files get the ones of directories
+func parseUmask(umask string) (fileMode, dirMode os.FileMode, err error) {
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0777 || mask&0500 != 0 {
+		return 0, 0, fmt.Errorf("invalid umask %s, expected an octal mask letting the owner read files, like 022", umask)
+	}
+	return 0666 &^ os.FileMode(mask), 0777 &^ os.FileMode(mask), nil
+}
+
+// grantsDefaultAccess reports whether the permissions let everyone read (and enter) what the
+// default permissions do, e.g. 0664 does for 0644, but 0700 doesn't for 0755
+func grantsDefaultAccess(mode, defaultMode os.FileMode) bool {
+	return mode&defaultMode&0555 == defaultMode&0555
+}
+
+// rewriteHeader applies the owner and permissions set for copied files to the header
+func (syncer *Syncer) rewriteHeader(header *tar.Header) {
+	if syncer.owner != nil {
+		header.Uid = syncer.owner.uid
+		header.Gid = syncer.owner.gid
+		// Names take precedence over IDs when extracting, and they mean nothing in the container
+		header.Uname = ""
+		header.Gname = ""
+	}
+
+	var mode, defaultMode os.FileMode
+	switch header.Typeflag {
+	case tar.TypeReg:
+		mode = syncer.fileMode
+		defaultMode = syncer.defaultFileMode
+		if header.Mode&0111 != 0 {
+			defaultMode = syncer.defaultDirMode
+		}
+	case tar.TypeDir:
+		mode = syncer.dirMode
+		defaultMode = syncer.defaultDirMode
+	}
+	if mode == 0 && runtime.GOOS == "windows" {
+		mode = windowsMode(header)
+	}
+	// Permissions too narrow for the users of the target, like 0700, are replaced with the ones of its umask
+	if mode == 0 && defaultMode != 0 && !grantsDefaultAccess(os.FileMode(header.Mode), defaultMode) {
+		mode = defaultMode
+	}
+	if mode != 0 {
+		header.Mode = header.Mode&^0777 | int64(mode)
+	}
+}
+
+// windowsMode returns Unix permissions for a file from a Windows host, where files are
+// reported as writable by everyone (or by no one when read-only), and directories as 0777
+func windowsMode(header *tar.Header) os.FileMode {
+	switch {
+	case header.Typeflag == tar.TypeDir:
+		return 0755
+	case header.Typeflag != tar.TypeReg:
+		return 0
+	case header.Mode&0200 == 0:
+		return 0444
+	default:
+		return 0644
+	}
+}
+
+// resolveOwner turns the user and group given with Options.Chown into numeric IDs,
+// looking up names inside the target container
+func (syncer *Syncer) resolveOwner(ctx context.Context) error {
+	if syncer.chown == "" {
+		return nil
+	}
+
+	spec := syncer.chown
+	if spec == ChownAuto {
+		var err error
+		spec, err = syncer.containerUser(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to detect the user of %s: %w", syncer.target, err)
+		}
+	}
+
+	user, group, hasGroup := strings.Cut(spec, ":")
+	if user == "" {
+		return fmt.Errorf("invalid owner %s, expected user[:group]", syncer.chown)
+	}
+
+	uid, err := syncer.resolveId(ctx, user, `id -u "$1"`)
+	if err != nil {
+		return fmt.Errorf("failed to find user %s: %w", user, err)
+	}
+
+	var gid int
+	switch {
+	case hasGroup && group != "":
+		gid, err = syncer.resolveId(ctx, group, `grep "^$1:" /etc/group | cut -d: -f3`)
+	case isNumeric(user):
+		gid = uid
+	default:
+		gid, err = syncer.resolveId(ctx, user, `id -g "$1"`)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find group of %s: %w", spec, err)
+	}
+
+	syncer.logger.Debug("Copying files as {uid}:{gid}", "uid", uid, "gid", gid)
+	syncer.owner = &owner{uid: uid, gid: gid}
+	return nil
+}
+
+// containerUser returns the user the main process of the target runs as, root by default
+func (syncer *Syncer) containerUser(ctx context.Context) (string, error) {
+	// Pods don't expose the user, but commands run as the same user
+	if syncer.targetType == Pod {
+		return syncer.output(ctx, `echo "$(id -u):$(id -g)"`)
+	}
+
+	containerId, err := syncer.getTargetContainer(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := syncer.client.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+	}
+	if info.Config == nil || info.Config.User == "" {
+		return "0:0", nil
+	}
+	return info.Config.User, nil
+}
+
+// resolveId returns the name as a number if it's numeric, otherwise it runs the script
+// in the target container with the name as $1 and parses its output
+func (syncer *Syncer) resolveId(ctx context.Context, name, script string) (int, error) {
+	if isNumeric(name) {
+		return strconv.Atoi(name)
+	}
+
+	output, err := syncer.output(ctx, script, name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.Atoi(output)
+	if err != nil {
+		return 0, fmt.Errorf("%s not found in the container", name)
+	}
+	return id, nil
+}
+
+// output runs a shell script in the running container of the target and returns its trimmed output
+func (syncer *Syncer) output(ctx context.Context, script string, args ...string) (string, error) {
+	command := append([]string{"sh", "-c", script, "sh"}, args...)
+	var stdout, stderr bytes.Buffer
+
+	if syncer.targetType == Pod {
+		err := syncer.kubectlExec(ctx, nil, &stdout, nil, command...)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+
+	containerId, err := syncer.getTargetContainer(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	exitCode, err := syncer.ContainerExec(ctx, containerId, command, &stdout, &stderr)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("%s exited with code %d: %s", script, exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// copiesParents reports whether the parent directories of copied paths are copied along with them,
+// so that they get the permissions set for directories instead of being created by the extraction
+func (syncer *Syncer) copiesParents() bool {
+	return syncer.sourcePath != "" && !syncer.sourceIsFile && !syncer.flatten && (syncer.dirMode != 0 || syncer.defaultDirMode != 0)
+}
@@ -0,0 +1,29 @@
+package syncer
+
+import (
+	"archive/tar"
+	"os"
+	"testing"
+)
+
+func TestWindowsMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		typeflag byte
+		mode     int64
+		want     os.FileMode
+	}{
+		{"writable file", tar.TypeReg, 0o666, 0o644},
+		{"read-only file", tar.TypeReg, 0o444, 0o444},
+		{"executable file", tar.TypeReg, 0o777, 0o644},
+		{"directory", tar.TypeDir, 0o777, 0o755},
+		{"read-only directory", tar.TypeDir, 0o555, 0o755},
+		{"symlink", tar.TypeSymlink, 0o777, 0},
+	}
+	for _, test := range tests {
+		got := windowsMode(&tar.Header{Typeflag: test.typeflag, Mode: test.mode})
+		if got != test.want {
+			t.Errorf("windowsMode() of a %s with mode %o = %o, want %o", test.name, test.mode, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package syncer
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const progressInterval = 100 * time.Millisecond
+
+// Progress describes the state of an upload to a container
+type Progress struct {
+	// File is the file being uploaded and FileBytes is how much of it has been sent
+	File      string
+	FileBytes int64
+	FileSize  int64
+	// Bytes is how much of the contents of all files has been sent out of Total
+	Bytes int64
+	Total int64
+	// Rate is the average transfer rate in bytes per second
+	Rate float64
+	// Sent is how much of the archive has been sent, after compression, at SendRate bytes per second.
+	// Limit is the bandwidth limit this is held to, 0 without one, and Throttled is set
+	// while the limit holds the upload back
+	Sent      int64
+	SendRate  float64
+	Limit     float64
+	Throttled bool
+	// Done is set in the last report of an upload
+	Done bool
+}
+
+// ProgressFunc receives progress reports, at most every 100ms
+type ProgressFunc func(Progress)
+
+// progressTracker counts the contents of files as they are streamed into an archive.
+// Since the archive is uploaded while being written, this reflects the upload progress
+type progressTracker struct {
+	mu         sync.Mutex
+	onProgress ProgressFunc
+	progress   Progress
+	started    time.Time
+	lastReport time.Time
+}
+
+func newProgressTracker(total int64, onProgress ProgressFunc) *progressTracker {
+	return &progressTracker{
+		onProgress: onProgress,
+		progress:   Progress{Total: total},
+		started:    time.Now(),
+	}
+}
+
+// startFile returns a reader of the file contents that counts them
+func (tracker *progressTracker) startFile(name string, size int64, contents io.Reader) io.Reader {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.progress.File = name
+	tracker.progress.FileSize = size
+	tracker.progress.FileBytes = 0
+
+	return &progressReader{reader: contents, tracker: tracker}
+}
+
+func (tracker *progressTracker) add(n int) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.progress.Bytes += int64(n)
+	tracker.progress.FileBytes += int64(n)
+
+	if time.Since(tracker.lastReport) >= progressInterval {
+		tracker.report()
+	}
+}
+
+// send counts n bytes of the archive sent, throttled if the bandwidth limit held them back
+func (tracker *progressTracker) send(n int, throttled bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.progress.Sent += int64(n)
+	tracker.progress.Throttled = throttled
+
+	if time.Since(tracker.lastReport) >= progressInterval {
+		tracker.report()
+	}
+}
+
+// finish sends the final report
+func (tracker *progressTracker) finish() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.progress.Done = true
+	tracker.report()
+}
+
+func (tracker *progressTracker) report() {
+	tracker.lastReport = time.Now()
+
+	if elapsed := time.Since(tracker.started).Seconds(); elapsed > 0 {
+		tracker.progress.Rate = float64(tracker.progress.Bytes) / elapsed
+		tracker.progress.SendRate = float64(tracker.progress.Sent) / elapsed
+	}
+
+	tracker.onProgress(tracker.progress)
+}
+
+type progressReader struct {
+	reader  io.Reader
+	tracker *progressTracker
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	pr.tracker.add(n)
+	return n, err
+}
@@ -0,0 +1,205 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/cli/cli/connhelper"
+	"github.com/docker/cli/cli/connhelper/commandconn"
+	"github.com/docker/cli/cli/connhelper/ssh"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// Engine is the container engine running the target
+type Engine string
+
+const (
+	Docker Engine = "docker"
+	// Podman is accessed through its Docker-compatible API
+	Podman Engine = "podman"
+)
+
+// TLSConfig is the TLS material for connecting to a tcp:// host
+type TLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// SkipVerify accepts any certificate of the host
+	SkipVerify bool
+}
+
+// endpoint is where and how the container engine is connected to
+type endpoint struct {
+	host string
+	// tlsConfig is used for tcp:// hosts
+	tlsConfig *TLSConfig
+	// sshFlags are passed to ssh for ssh:// hosts and the bastion
+	sshFlags []string
+	// bastion is the [user@]host[:port] of an SSH server tcp:// hosts are reached through
+	bastion string
+}
+
+// provider covers the differences between the container engines
+type provider interface {
+	// clientOptions returns the options of a client connecting to the endpoint
+	clientOptions(endpoint endpoint) ([]client.Opt, error)
+	// supportsServices reports whether the engine can run Swarm services
+	supportsServices() bool
+}
+
+func newProvider(engine Engine) (provider, error) {
+	switch engine {
+	case "", Docker:
+		return dockerProvider{}, nil
+	case Podman:
+		return podmanProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown container engine %s, expected docker or podman", engine)
+	}
+}
+
+type dockerProvider struct{}
+
+func (dockerProvider) clientOptions(endpoint endpoint) ([]client.Opt, error) {
+	helper, err := connhelper.GetConnectionHelperWithSSHOpts(endpoint.host, endpoint.sshFlags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Docker host %s: %w", endpoint.host, err)
+	}
+	if helper == nil {
+		// Not an SSH URL, use default connection
+		return directClientOptions(endpoint)
+	}
+	return helperClientOptions(helper), nil
+}
+
+func (dockerProvider) supportsServices() bool {
+	return true
+}
+
+type podmanProvider struct{}
+
+// clientOptions connects to ssh:// hosts the way podman-remote does, by running
+// podman on the remote machine, optionally with the socket given as the URL path
+func (podmanProvider) clientOptions(endpoint endpoint) ([]client.Opt, error) {
+	if !strings.HasPrefix(endpoint.host, "ssh://") {
+		return directClientOptions(endpoint)
+	}
+
+	spec, err := ssh.ParseURL(endpoint.host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Podman host %s: %w", endpoint.host, err)
+	}
+
+	args := []string{"podman"}
+	if spec.Path != "" {
+		args = append(args, "--url", "unix://"+spec.Path)
+	}
+	args = append(args, "system", "dial-stdio")
+
+	sshArgs := append([]string{"-o", "ConnectTimeout=30"}, endpoint.sshFlags...)
+	sshArgs = append(sshArgs, spec.Args(args...)...)
+	helper := &connhelper.ConnectionHelper{
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return commandconn.New(ctx, "ssh", sshArgs...)
+		},
+		Host: "http://podman",
+	}
+	return helperClientOptions(helper), nil
+}
+
+func (podmanProvider) supportsServices() bool {
+	return false
+}
+
+// directClientOptions returns the options of a client connecting to the host directly,
+// or through the SSH bastion of the endpoint
+func directClientOptions(endpoint endpoint) ([]client.Opt, error) {
+	var opts []client.Opt
+	if endpoint.tlsConfig != nil {
+		config, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             endpoint.tlsConfig.CAFile,
+			CertFile:           endpoint.tlsConfig.CertFile,
+			KeyFile:            endpoint.tlsConfig.KeyFile,
+			InsecureSkipVerify: endpoint.tlsConfig.SkipVerify,
+			ExclusiveRootPools: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS config for %s: %w", endpoint.host, err)
+		}
+		opts = append(opts, client.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: config},
+		}))
+	}
+
+	// The host configures the transport for its protocol, so it has to be set after the HTTP client,
+	// and the dialer of the bastion after the host
+	opts = append(opts, client.WithHost(endpoint.host), client.WithAPIVersionNegotiation())
+	if endpoint.bastion != "" {
+		dialer, err := bastionDialer(endpoint.bastion, endpoint.sshFlags)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, client.WithDialContext(dialer))
+	}
+	return opts, nil
+}
+
+// bastionDialer returns a dialer forwarding connections through the SSH server given as
+// [ssh://][user@]host[:port], which has to allow TCP forwarding. TLS to the host, if any,
+// goes through the tunnel, so the bastion doesn't see the traffic
+func bastionDialer(bastion string, sshFlags []string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	if !strings.HasPrefix(bastion, "ssh://") {
+		bastion = "ssh://" + bastion
+	}
+	spec, err := ssh.ParseURL(bastion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH bastion %s: %w", bastion, err)
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		sshArgs := append([]string{"-o", "ConnectTimeout=30"}, sshFlags...)
+		sshArgs = append(sshArgs, "-W", addr)
+		sshArgs = append(sshArgs, spec.Args()...)
+		return commandconn.New(ctx, "ssh", sshArgs...)
+	}, nil
+}
+
+// checkEncrypted returns an error if files would be sent to the host unencrypted, which is the case
+// for tcp:// hosts without TLS, unless they're reached through an SSH bastion. Loopback addresses
+// are fine, since the traffic doesn't leave the machine
+func checkEncrypted(host string, tlsConfig *TLSConfig, bastion string) error {
+	if !strings.HasPrefix(host, "tcp://") || tlsConfig != nil || bastion != "" {
+		return nil
+	}
+
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return fmt.Errorf("failed to parse Docker host %s: %w", host, err)
+	}
+	hostname := hostURL.Hostname()
+	if ip := net.ParseIP(hostname); hostname == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	return fmt.Errorf("refusing to send files to %s unencrypted, use TLS with --tlsverify, tunnel the connection with --ssh-bastion or allow it with --insecure", host)
+}
+
+// helperClientOptions returns the options of a client tunneling through a connection helper
+func helperClientOptions(helper *connhelper.ConnectionHelper) []client.Opt {
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: helper.Dialer,
+		},
+	}
+
+	return []client.Opt{
+		client.WithHTTPClient(httpClient),
+		client.WithHost(helper.Host),
+		client.WithDialContext(helper.Dialer),
+		client.WithAPIVersionNegotiation(),
+	}
+}
@@ -0,0 +1,141 @@
+package syncer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Pull downloads the target path from the running container of the target into localDir,
+// preserving the directory structure and permissions. The contents of a directory
+// are placed directly into localDir, while a file is placed into it by its name
+func (syncer *Syncer) Pull(ctx context.Context, localDir string) error {
+	if syncer.targetType == Pod {
+		return syncer.pullFromPod(ctx, localDir)
+	}
+
+	// Volumes are read through their helper container, which doesn't have to run
+	containerId, targetPath := syncer.temporaryContainer, syncer.volumeTargetPath()
+	if syncer.targetType != Volume {
+		var err error
+		containerId, err = syncer.getTargetContainer(ctx)
+		if err != nil {
+			return err
+		}
+		targetPath = syncer.targetPath
+	}
+
+	return syncer.retry(ctx, "pulling", func() error {
+		syncer.logger.Debug("Downloading {path} from container {container}...", "path", targetPath, "container", containerId)
+		reader, stat, err := syncer.client.CopyFromContainer(ctx, containerId, targetPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s from container %s: %w", targetPath, containerId, err)
+		}
+		defer reader.Close()
+
+		// The archive contains the requested path under its base name
+		stripPrefix := ""
+		if stat.Mode.IsDir() {
+			stripPrefix = stat.Name
+		}
+
+		err = extractArchive(reader, localDir, stripPrefix)
+		if err != nil {
+			return fmt.Errorf("failed to extract %s into %s: %w", syncer.targetPath, localDir, err)
+		}
+
+		return nil
+	})
+}
+
+// extractArchive writes the entries of a tar stream into dir, removing
+// stripPrefix from their names
+func extractArchive(reader io.Reader, dir string, stripPrefix string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name := path.Clean(header.Name)
+		if stripPrefix != "" {
+			if name == stripPrefix {
+				continue
+			}
+			name = strings.TrimPrefix(name, stripPrefix+"/")
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %s points outside of %s", header.Name, dir)
+		}
+
+		mode := header.FileInfo().Mode()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode.Perm()|0700)
+			if err == nil {
+				err = os.Chmod(target, mode.Perm())
+			}
+		case tar.TypeReg:
+			err = writeFile(target, tr, mode.Perm())
+		case tar.TypeSymlink:
+			os.Remove(target)
+			err = os.Symlink(header.Linkname, target)
+		case tar.TypeLink:
+			linkTarget := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(path.Clean(header.Linkname), stripPrefix+"/")))
+			os.Remove(target)
+			err = os.Link(linkTarget, target)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+
+		if header.Typeflag != tar.TypeSymlink {
+			os.Chtimes(target, header.AccessTime, header.ModTime)
+		}
+	}
+}
+
+func writeFile(target string, reader io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return err
+	}
+
+	// The mode passed to OpenFile is affected by umask and ignored for existing files
+	return file.Chmod(mode)
+}
@@ -0,0 +1,67 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// findReadOnlyContainer returns the first container of the target with a read-only root filesystem,
+// which files can't be copied into, or an empty string if there's none
+func (syncer *Syncer) findReadOnlyContainer(ctx context.Context) (string, error) {
+	var containers []string
+	if len(syncer.labels) > 0 {
+		var err error
+		containers, err = syncer.findLabeledContainers(ctx)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		containerId, err := syncer.getTargetContainer(ctx)
+		if err != nil {
+			return "", err
+		}
+		containers = []string{containerId}
+	}
+
+	for _, containerId := range containers {
+		info, err := syncer.client.ContainerInspect(ctx, containerId)
+		if err != nil {
+			return "", fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+		}
+		if syncer.isReadOnly(info) {
+			return containerId, nil
+		}
+	}
+	return "", nil
+}
+
+// checkOverlay checks that a temporary volume can be mounted over the target path of the target container.
+// Containers selected by labels would each need to be recreated with it
+func (syncer *Syncer) checkOverlay() error {
+	if !syncer.singleContainer() {
+		return fmt.Errorf("a temporary volume can only be mounted into single containers, not into all containers of %s", syncer.target)
+	}
+	if syncer.sourceIsFile {
+		return fmt.Errorf("a temporary volume can't be mounted over a single file in container %s, sync the directory of the file instead", syncer.target)
+	}
+	return nil
+}
+
+// isReadOnly reports whether files can't be copied to the target path of the inspected container,
+// because its root filesystem is read-only and no writable mount contains the path
+func (syncer *Syncer) isReadOnly(info types.ContainerJSON) bool {
+	if info.HostConfig == nil || !info.HostConfig.ReadonlyRootfs {
+		return false
+	}
+
+	for _, mount := range info.Mounts {
+		destination := strings.TrimSuffix(mount.Destination, "/")
+		if mount.RW && (syncer.targetPath == destination || strings.HasPrefix(syncer.targetPath, destination+"/")) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// RebuildAuto watches the image the target container was created from
+const RebuildAuto = "auto"
+
+// RebuildImage returns the name of the image whose rebuilds replace the target containers,
+// looking up the image of the target container for RebuildAuto. Only containers run the image
+// they were created from until they're recreated, services and pods are updated by their orchestrators
+func (syncer *Syncer) RebuildImage(ctx context.Context, image string) (string, error) {
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+
+	if syncer.targetType != Container {
+		return "", fmt.Errorf("only containers are recreated when their image is rebuilt, %s is not a container", syncer.target)
+	}
+
+	if image == RebuildAuto {
+		containerId, err := syncer.getTargetContainer(ctx)
+		if err != nil {
+			return "", err
+		}
+		info, err := syncer.client.ContainerInspect(ctx, containerId)
+		if err != nil {
+			return "", fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+		}
+		image = info.Config.Image
+	}
+
+	named, err := normalizeImage(image)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return "", fmt.Errorf("image %s is pinned by its digest, so rebuilding it doesn't change what the target runs", image)
+	}
+	return reference.FamiliarString(named), nil
+}
+
+// normalizeImage parses the image name, adding the latest tag when it has none,
+// so that names referring to the same image compare equal
+func normalizeImage(image string) (reference.Named, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %w", image, err)
+	}
+	return reference.TagNameOnly(named), nil
+}
+
+// WatchImage calls onRebuild whenever the image is tagged on the daemon until ctx is canceled,
+// which is what docker build, buildx with --load and docker tag do once the image is built
+func (syncer *Syncer) WatchImage(ctx context.Context, image string, onRebuild func()) {
+	watched, err := normalizeImage(image)
+	if err != nil {
+		syncer.logger.Error("Not watching rebuilds: {error}", "error", err)
+		return
+	}
+
+	args := filters.NewArgs(
+		filters.Arg("type", string(events.ImageEventType)),
+		filters.Arg("event", string(events.ActionTag)),
+	)
+
+	for {
+		syncer.mu.Lock()
+		dockerClient := syncer.client
+		syncer.mu.Unlock()
+
+		messages, errs := dockerClient.Events(ctx, events.ListOptions{Filters: args})
+		err := syncer.watchImageEvents(ctx, watched, messages, errs, onRebuild)
+		if ctx.Err() != nil {
+			return
+		}
+
+		syncer.logger.Debug("Stopped receiving Docker events, subscribing again in {delay}: {error}", "delay", followRetryDelay.String(), "error", err)
+		if sleep(ctx, followRetryDelay) != nil {
+			return
+		}
+	}
+}
+
+// watchImageEvents calls onRebuild on tag events of the image until the stream breaks
+func (syncer *Syncer) watchImageEvents(ctx context.Context, image reference.Named, messages <-chan events.Message, errs <-chan error, onRebuild func()) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case message := <-messages:
+			tagged, err := normalizeImage(message.Actor.Attributes["name"])
+			if err != nil || tagged.String() != image.String() {
+				continue
+			}
+			syncer.logger.Info("Image {image} was rebuilt", "image", reference.FamiliarString(image))
+			onRebuild()
+		}
+	}
+}
+
+// RecreateTarget replaces the target containers with new ones created from the current image
+// of the same name, e.g. after it was rebuilt. Targets recreated after every copy are left as they are,
+// since the copy that follows a rebuild recreates them anyway
+func (syncer *Syncer) RecreateTarget(ctx context.Context) error {
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+
+	if syncer.targetType != Container || syncer.strategy == StrategyCopyRecreate {
+		return nil
+	}
+
+	recreatedAt := time.Now()
+	err := syncer.retry(ctx, "recreating", func() error {
+		if len(syncer.labels) > 0 {
+			return syncer.forEachLabeledContainer(ctx, func(containerId string) error {
+				_, err := syncer.recreateContainer(ctx, containerId, false)
+				return err
+			})
+		}
+		return syncer.recreateTargetContainer(ctx, syncer.strategy == StrategyVolumeServiceUpdate)
+	})
+	if err != nil {
+		return err
+	}
+	syncer.followLogs(ctx, recreatedAt)
+	return nil
+}
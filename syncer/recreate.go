@@ -0,0 +1,213 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// replacedSuffix is appended to the name of a container while its replacement is created under its name
+const replacedSuffix = "-docker-sync-replaced"
+
+// shortIdLength is the length of the short form of container IDs
+const shortIdLength = 12
+
+func (syncer *Syncer) recreateTargetContainer(ctx context.Context, mountTemporaryVolume bool) error {
+	// The target may have been replaced in the meantime, a stopped one is recreated as is
+	containerId := syncer.target
+	if found, err := syncer.getTargetContainer(ctx); err == nil {
+		containerId = found
+	}
+
+	newContainerId, err := syncer.recreateContainer(ctx, containerId, mountTemporaryVolume)
+	if newContainerId != "" {
+		syncer.target = newContainerId
+	}
+	return err
+}
+
+// recreateContainer replaces the container with a new one with the same name, config, networks,
+// port bindings and restart policy, with or without the temporary volume mounted, and returns
+// the ID of the new container once it's created. The old container is renamed while the new one
+// is created, and brought back if that fails
+func (syncer *Syncer) recreateContainer(ctx context.Context, containerId string, mountTemporaryVolume bool) (string, error) {
+	containerInfo, err := syncer.client.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+	}
+	name := strings.TrimPrefix(containerInfo.Name, "/")
+
+	syncer.markOwnEvents(containerId)
+	syncer.logger.Debug("Stopping container {container}...", "container", containerId)
+	timeout := stopTimeoutInSeconds
+	err = syncer.client.ContainerStop(ctx, containerId, container.StopOptions{Timeout: &timeout})
+	if err != nil {
+		return "", fmt.Errorf("failed to stop container %s: %w", containerId, err)
+	}
+
+	// Containers started with --rm are removed once stopped, which frees their name
+	oldRemoved := false
+	err = syncer.client.ContainerRename(ctx, containerId, name+replacedSuffix)
+	if client.IsErrNotFound(err) {
+		oldRemoved = true
+	} else if err != nil {
+		return "", fmt.Errorf("failed to rename container %s: %w", containerId, err)
+	}
+
+	newConfig, newHostConfig := syncer.recreatedConfig(containerInfo, mountTemporaryVolume)
+	primaryNetwork, endpoints := recreatedEndpoints(containerInfo)
+
+	var networkingConfig *network.NetworkingConfig
+	if settings, ok := endpoints[primaryNetwork]; ok {
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{primaryNetwork: settings},
+		}
+	}
+
+	newTarget, err := syncer.client.ContainerCreate(ctx, newConfig, newHostConfig, networkingConfig, nil, name)
+	if err != nil {
+		err = fmt.Errorf("failed to create new container: %w", err)
+		if !oldRemoved {
+			return "", syncer.restoreContainer(ctx, containerId, name, err)
+		}
+		return "", err
+	}
+	syncer.markOwnEvents(newTarget.ID)
+
+	// Containers can only be created in a single network with older API versions
+	for networkName, settings := range endpoints {
+		if networkName == primaryNetwork {
+			continue
+		}
+		syncer.logger.Debug("Connecting the new container {container} to network {network}...", "container", newTarget.ID, "network", networkName)
+		err = syncer.client.NetworkConnect(ctx, networkName, newTarget.ID, settings)
+		if err != nil {
+			return newTarget.ID, fmt.Errorf("failed to connect new container to network %s: %w", networkName, err)
+		}
+	}
+
+	if !oldRemoved {
+		syncer.logger.Debug("Removing the old container {container}...", "container", containerId)
+		err = syncer.client.ContainerRemove(ctx, containerId, container.RemoveOptions{})
+		if err != nil && !client.IsErrNotFound(err) {
+			return newTarget.ID, fmt.Errorf("failed to remove old container %s: %w", containerId, err)
+		}
+	}
+
+	syncer.logger.Debug("Starting the new container {container}...", "container", newTarget.ID)
+	err = syncer.client.ContainerStart(ctx, newTarget.ID, container.StartOptions{})
+	if err != nil {
+		return newTarget.ID, fmt.Errorf("failed to start new container: %w", err)
+	}
+
+	return newTarget.ID, nil
+}
+
+// restoreContainer gives the stopped container its name back and starts it after it couldn't be replaced,
+// returning the error of the replacement along with any of restoring it
+func (syncer *Syncer) restoreContainer(ctx context.Context, containerId, name string, cause error) error {
+	syncer.logger.Debug("Restoring container {container}...", "container", containerId)
+	err := syncer.client.ContainerRename(ctx, containerId, name)
+	if err != nil {
+		return fmt.Errorf("%w, then failed to rename container %s back to %s: %w", cause, containerId, name, err)
+	}
+
+	syncer.markOwnEvents(containerId)
+	err = syncer.client.ContainerStart(ctx, containerId, container.StartOptions{})
+	if err != nil {
+		return fmt.Errorf("%w, then failed to start container %s again: %w", cause, containerId, err)
+	}
+	return cause
+}
+
+// recreatedConfig returns the config of a container replacing the inspected one, with or without
+// the temporary volume mounted at the target path. The host config carries port bindings,
+// the restart policy and the network mode over
+func (syncer *Syncer) recreatedConfig(info types.ContainerJSON, mountTemporaryVolume bool) (*container.Config, *container.HostConfig) {
+	config := *info.Config
+	hostConfig := *info.HostConfig
+
+	// Docker sets the hostname to the short ID unless one is given, the new container gets its own
+	if isShortId(info.ID, config.Hostname) {
+		config.Hostname = ""
+	}
+
+	// Links are inspected as /<container>:/<name>/<alias> but created as <container>:<alias>
+	hostConfig.Links = make([]string, 0, len(info.HostConfig.Links))
+	for _, link := range info.HostConfig.Links {
+		linked, alias, ok := strings.Cut(link, ":")
+		if !ok {
+			hostConfig.Links = append(hostConfig.Links, link)
+			continue
+		}
+		hostConfig.Links = append(hostConfig.Links, strings.TrimPrefix(linked, "/")+":"+alias[strings.LastIndex(alias, "/")+1:])
+	}
+
+	mounts := []mount.Mount{}
+	for _, mount := range info.HostConfig.Mounts {
+		if mount.Source != syncer.temporaryVolume {
+			mounts = append(mounts, mount)
+		}
+	}
+
+	if mountTemporaryVolume {
+		syncer.logger.Debug("Creating a container with a temporary volume...")
+		newMount := mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: syncer.temporaryVolume,
+			Target: syncer.targetPath,
+		}
+		hostConfig.Mounts = append(mounts, newMount)
+	} else {
+		syncer.logger.Debug("Creating a container without temporary volumes...")
+		hostConfig.Mounts = mounts
+	}
+
+	return &config, &hostConfig
+}
+
+// recreatedEndpoints returns the network the container is created in and the settings of all
+// the networks the inspected container is connected to, by their names. Only the settings given
+// when connecting are carried over, the addresses Docker assigned aren't
+func recreatedEndpoints(info types.ContainerJSON) (string, map[string]*network.EndpointSettings) {
+	primary := string(info.HostConfig.NetworkMode)
+	if primary == "default" {
+		primary = network.NetworkBridge
+	}
+
+	endpoints := make(map[string]*network.EndpointSettings)
+	if info.NetworkSettings == nil {
+		return primary, endpoints
+	}
+
+	for name, settings := range info.NetworkSettings.Networks {
+		if settings == nil {
+			continue
+		}
+		endpoint := &network.EndpointSettings{
+			IPAMConfig: settings.IPAMConfig,
+			Links:      slices.Clone(settings.Links),
+			DriverOpts: settings.DriverOpts,
+		}
+		// Docker adds the short ID of the container as an alias itself
+		for _, alias := range settings.Aliases {
+			if !isShortId(info.ID, alias) {
+				endpoint.Aliases = append(endpoint.Aliases, alias)
+			}
+		}
+		endpoints[name] = endpoint
+	}
+	return primary, endpoints
+}
+
+// isShortId reports whether the name is the short form of the container ID
+func isShortId(containerId, name string) bool {
+	return len(name) == shortIdLength && strings.HasPrefix(containerId, name)
+}
@@ -0,0 +1,242 @@
+package syncer
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/axtgr/docker-sync/logger"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+const fixtureId = "3f2a9c1b7d4e8f6a5b0c2d1e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a"
+
+// inspectFixture returns a container as inspected after `docker run --name web --hostname <short ID>
+// --restart unless-stopped --health-cmd true -p 8080:80 --link db:database -v data:/data
+// --network app --ip 172.20.0.10 --network-alias api`, connected to the bridge network too
+func inspectFixture() types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   fixtureId,
+			Name: "/web",
+			HostConfig: &container.HostConfig{
+				NetworkMode:   "app",
+				RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+				PortBindings:  nat.PortMap{"80/tcp": {{HostIP: "", HostPort: "8080"}}},
+				Links:         []string{"/db:/web/database"},
+				Mounts: []mount.Mount{
+					{Type: mount.TypeVolume, Source: "data", Target: "/data"},
+				},
+			},
+		},
+		Config: &container.Config{
+			Hostname: fixtureId[:shortIdLength],
+			Image:    "nginx",
+			Healthcheck: &container.HealthConfig{
+				Test:     []string{"CMD-SHELL", "true"},
+				Interval: 5 * time.Second,
+			},
+		},
+		NetworkSettings: &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"app": {
+					IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "172.20.0.10"},
+					Aliases:    []string{"api", fixtureId[:shortIdLength]},
+					IPAddress:  "172.20.0.10",
+					NetworkID:  "a1b2c3",
+					EndpointID: "d4e5f6",
+				},
+				"bridge": {
+					IPAddress:  "172.17.0.2",
+					NetworkID:  "f6e5d4",
+					EndpointID: "c3b2a1",
+				},
+			},
+		},
+	}
+}
+
+func TestRecreatedConfig(t *testing.T) {
+	temporaryMount := mount.Mount{Type: mount.TypeVolume, Source: "docker-sync-tmp", Target: "/app"}
+	dataMount := mount.Mount{Type: mount.TypeVolume, Source: "data", Target: "/data"}
+
+	tests := []struct {
+		name                 string
+		change               func(*types.ContainerJSON)
+		mountTemporaryVolume bool
+		wantHostname         string
+		wantLinks            []string
+		wantMounts           []mount.Mount
+	}{
+		{
+			name:         "default hostname",
+			wantHostname: "",
+			wantLinks:    []string{"db:database"},
+			wantMounts:   []mount.Mount{dataMount},
+		},
+		{
+			name:         "given hostname",
+			change:       func(info *types.ContainerJSON) { info.Config.Hostname = "web.local" },
+			wantHostname: "web.local",
+			wantLinks:    []string{"db:database"},
+			wantMounts:   []mount.Mount{dataMount},
+		},
+		{
+			name:         "link without alias",
+			change:       func(info *types.ContainerJSON) { info.HostConfig.Links = []string{"/db:/web/db", "cache"} },
+			wantHostname: "",
+			wantLinks:    []string{"db:db", "cache"},
+			wantMounts:   []mount.Mount{dataMount},
+		},
+		{
+			name:                 "mounting the temporary volume",
+			mountTemporaryVolume: true,
+			wantHostname:         "",
+			wantLinks:            []string{"db:database"},
+			wantMounts:           []mount.Mount{dataMount, temporaryMount},
+		},
+		{
+			name: "temporary volume mounted already",
+			change: func(info *types.ContainerJSON) {
+				info.HostConfig.Mounts = append(info.HostConfig.Mounts, temporaryMount)
+			},
+			mountTemporaryVolume: true,
+			wantHostname:         "",
+			wantLinks:            []string{"db:database"},
+			wantMounts:           []mount.Mount{dataMount, temporaryMount},
+		},
+		{
+			name: "unmounting the temporary volume",
+			change: func(info *types.ContainerJSON) {
+				info.HostConfig.Mounts = append(info.HostConfig.Mounts, temporaryMount)
+			},
+			wantHostname: "",
+			wantLinks:    []string{"db:database"},
+			wantMounts:   []mount.Mount{dataMount},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := inspectFixture()
+			if test.change != nil {
+				test.change(&info)
+			}
+			hostname, links := info.Config.Hostname, slices.Clone(info.HostConfig.Links)
+			syncer := &Syncer{targetPath: "/app", temporaryVolume: "docker-sync-tmp", logger: logger.Discard()}
+
+			config, hostConfig := syncer.recreatedConfig(info, test.mountTemporaryVolume)
+			if config.Hostname != test.wantHostname {
+				t.Errorf("hostname = %q, want %q", config.Hostname, test.wantHostname)
+			}
+			if !slices.Equal(hostConfig.Links, test.wantLinks) {
+				t.Errorf("links = %v, want %v", hostConfig.Links, test.wantLinks)
+			}
+			if !reflect.DeepEqual(hostConfig.Mounts, test.wantMounts) {
+				t.Errorf("mounts = %v, want %v", hostConfig.Mounts, test.wantMounts)
+			}
+
+			// The rest of the config is carried over as is
+			fixture := inspectFixture()
+			if !reflect.DeepEqual(config.Healthcheck, fixture.Config.Healthcheck) {
+				t.Errorf("healthcheck = %v, want %v", config.Healthcheck, fixture.Config.Healthcheck)
+			}
+			if hostConfig.RestartPolicy != fixture.HostConfig.RestartPolicy {
+				t.Errorf("restart policy = %v, want %v", hostConfig.RestartPolicy, fixture.HostConfig.RestartPolicy)
+			}
+			if !reflect.DeepEqual(hostConfig.PortBindings, fixture.HostConfig.PortBindings) {
+				t.Errorf("port bindings = %v, want %v", hostConfig.PortBindings, fixture.HostConfig.PortBindings)
+			}
+			if hostConfig.NetworkMode != fixture.HostConfig.NetworkMode {
+				t.Errorf("network mode = %s, want %s", hostConfig.NetworkMode, fixture.HostConfig.NetworkMode)
+			}
+
+			// The inspected container isn't changed
+			if info.Config.Hostname != hostname || !slices.Equal(info.HostConfig.Links, links) {
+				t.Errorf("the inspected container was changed to hostname %q and links %v", info.Config.Hostname, info.HostConfig.Links)
+			}
+		})
+	}
+}
+
+func TestRecreatedEndpoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		change      func(*types.ContainerJSON)
+		wantPrimary string
+		want        map[string]*network.EndpointSettings
+	}{
+		{
+			name:        "multiple networks with a static IP and aliases",
+			wantPrimary: "app",
+			want: map[string]*network.EndpointSettings{
+				"app": {
+					IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "172.20.0.10"},
+					Aliases:    []string{"api"},
+				},
+				"bridge": {},
+			},
+		},
+		{
+			name: "default network",
+			change: func(info *types.ContainerJSON) {
+				info.HostConfig.NetworkMode = "default"
+				delete(info.NetworkSettings.Networks, "app")
+				info.NetworkSettings.Networks["bridge"].Links = []string{"db:database"}
+			},
+			wantPrimary: network.NetworkBridge,
+			want: map[string]*network.EndpointSettings{
+				"bridge": {Links: []string{"db:database"}},
+			},
+		},
+		{
+			name: "network of another container",
+			change: func(info *types.ContainerJSON) {
+				info.HostConfig.NetworkMode = "container:db"
+				info.NetworkSettings.Networks = map[string]*network.EndpointSettings{}
+			},
+			wantPrimary: "container:db",
+			want:        map[string]*network.EndpointSettings{},
+		},
+		{
+			name: "driver options",
+			change: func(info *types.ContainerJSON) {
+				info.NetworkSettings.Networks["bridge"].DriverOpts = map[string]string{"com.docker.network.endpoint.ifname": "eth1"}
+			},
+			wantPrimary: "app",
+			want: map[string]*network.EndpointSettings{
+				"app": {
+					IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "172.20.0.10"},
+					Aliases:    []string{"api"},
+				},
+				"bridge": {DriverOpts: map[string]string{"com.docker.network.endpoint.ifname": "eth1"}},
+			},
+		},
+		{
+			name:        "no network settings",
+			change:      func(info *types.ContainerJSON) { info.NetworkSettings = nil },
+			wantPrimary: "app",
+			want:        map[string]*network.EndpointSettings{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := inspectFixture()
+			if test.change != nil {
+				test.change(&info)
+			}
+
+			primary, endpoints := recreatedEndpoints(info)
+			if primary != test.wantPrimary {
+				t.Errorf("primary network = %s, want %s", primary, test.wantPrimary)
+			}
+			if !reflect.DeepEqual(endpoints, test.want) {
+				t.Errorf("endpoints = %v, want %v", endpoints, test.want)
+			}
+		})
+	}
+}
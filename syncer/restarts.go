@@ -0,0 +1,273 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+const (
+	// serviceUpdateTimeout is how long to wait for the tasks of a service to be replaced after updating it
+	serviceUpdateTimeout = 5 * time.Minute
+	// serviceUpdatePollInterval is how often to check whether a service update has converged
+	serviceUpdatePollInterval = time.Second
+	// serviceUpdateAttempts is how many times to update a service whose version changed in the meantime
+	serviceUpdateAttempts = 3
+)
+
+// How services are made to replace their tasks
+const (
+	// RestartMethodForceUpdate increments the ForceUpdate counter of the service, like docker service update --force
+	RestartMethodForceUpdate = "force-update"
+	// RestartMethodEnvBump sets the RestartEnv environment variable of the service to the time of the restart,
+	// which shows up in the history of the service
+	RestartMethodEnvBump = "env-bump"
+	// RestartMethodImageLabel sets a label of the containers of the service to the time of the restart
+	RestartMethodImageLabel = "image-label"
+)
+
+// RestartEnv is the environment variable set by RestartMethodEnvBump
+const RestartEnv = "DOCKER_SYNC_TS"
+
+// restartLabel is the container label set by RestartMethodImageLabel
+func (syncer *Syncer) restartLabel() string {
+	return syncer.identifier + ".restarted"
+}
+
+// bumpService changes the task template so that the tasks of the service are replaced,
+// returning the revision the new tasks run
+func (syncer *Syncer) bumpService(spec *swarm.TaskSpec) string {
+	switch syncer.restartMethod {
+	case RestartMethodEnvBump:
+		revision := time.Now().UTC().Format(time.RFC3339Nano)
+		env := slices.DeleteFunc(slices.Clone(spec.ContainerSpec.Env), func(variable string) bool {
+			return strings.HasPrefix(variable, RestartEnv+"=")
+		})
+		spec.ContainerSpec.Env = append(env, RestartEnv+"="+revision)
+		return revision
+	case RestartMethodImageLabel:
+		revision := time.Now().UTC().Format(time.RFC3339Nano)
+		labels := maps.Clone(spec.ContainerSpec.Labels)
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[syncer.restartLabel()] = revision
+		spec.ContainerSpec.Labels = labels
+		return revision
+	default:
+		spec.ForceUpdate++
+		return strconv.FormatUint(spec.ForceUpdate, 10)
+	}
+}
+
+// serviceRevision returns the revision set by bumpService the task template runs
+func (syncer *Syncer) serviceRevision(spec swarm.TaskSpec) string {
+	switch syncer.restartMethod {
+	case RestartMethodEnvBump:
+		if spec.ContainerSpec == nil {
+			return ""
+		}
+		for _, variable := range spec.ContainerSpec.Env {
+			if value, ok := strings.CutPrefix(variable, RestartEnv+"="); ok {
+				return value
+			}
+		}
+		return ""
+	case RestartMethodImageLabel:
+		if spec.ContainerSpec == nil {
+			return ""
+		}
+		return spec.ContainerSpec.Labels[syncer.restartLabel()]
+	default:
+		return strconv.FormatUint(spec.ForceUpdate, 10)
+	}
+}
+
+// restartQueue runs restarts one at a time in the background. Restarts requested while one
+// is running are collapsed into a single one made once it's done
+type restartQueue struct {
+	mu      sync.Mutex
+	running bool
+	pending func() error
+	// idle is closed once nothing is running
+	idle chan struct{}
+}
+
+// schedule runs the restart unless one is running, otherwise it replaces the pending one
+func (queue *restartQueue) schedule(restart func() error, onDone func(error)) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.running {
+		queue.pending = restart
+		return
+	}
+
+	queue.running = true
+	queue.idle = make(chan struct{})
+	go func() {
+		for restart != nil {
+			onDone(restart())
+
+			queue.mu.Lock()
+			restart = queue.pending
+			queue.pending = nil
+			if restart == nil {
+				queue.running = false
+				close(queue.idle)
+			}
+			queue.mu.Unlock()
+		}
+	}()
+}
+
+// wait blocks until the running and pending restarts are done or ctx is canceled
+func (queue *restartQueue) wait(ctx context.Context) error {
+	queue.mu.Lock()
+	running, idle := queue.running, queue.idle
+	queue.mu.Unlock()
+
+	if !running {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scheduleServiceRestart queues an update of the target service making it mount the temporary volume, if any.
+// Copies go on meanwhile, and the changes copied while an update converges are picked up by the next one
+func (syncer *Syncer) scheduleServiceRestart(ctx context.Context) {
+	syncer.restarts.schedule(func() error {
+		return syncer.retry(ctx, "restarting", func() error {
+			return syncer.restartService(ctx)
+		})
+	}, func(err error) {
+		if err != nil {
+			if ctx.Err() == nil {
+				syncer.logger.Error("Failed to restart service {service}: {error}", "service", syncer.target, "error", err)
+			}
+			return
+		}
+		// The tasks replacing the old ones are new, so all of their logs are followed
+		err = syncer.restarted(ctx, time.Time{})
+		if err != nil && ctx.Err() == nil {
+			syncer.logger.Error("Failed to restart the dependents of service {service}: {error}", "service", syncer.target, "error", err)
+		}
+	})
+}
+
+// restartService updates the target service and waits until its tasks are replaced
+func (syncer *Syncer) restartService(ctx context.Context) error {
+	revision, err := syncer.updateTargetService(ctx, syncer.agent || syncer.strategy == StrategyVolumeServiceUpdate)
+	if err != nil {
+		return err
+	}
+	return syncer.waitForServiceUpdate(ctx, revision)
+}
+
+// isOutOfSequence reports whether a service update failed because the service changed
+// since it was inspected, e.g. because the update status of the previous update was set
+func isOutOfSequence(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "update out of sequence")
+}
+
+// waitForServiceUpdate waits until every running task of the target service runs the spec
+// with the given revision, logging how many of them do. Swarm rolls out the update according to
+// the update config of the service, whose failure action decides what happens when tasks fail:
+// a paused or rolled back update is an error, while one that continues is done once Swarm says so
+func (syncer *Syncer) waitForServiceUpdate(ctx context.Context, revision string) error {
+	service, _, err := syncer.client.ServiceInspectWithRaw(ctx, syncer.target, types.ServiceInspectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to inspect service %s: %w", syncer.target, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, serviceRolloutTimeout(service))
+	defer cancel()
+
+	syncer.logger.Debug("Waiting for service {service} to update...", "service", syncer.target)
+	reported := -1
+	for {
+		service, _, err := syncer.client.ServiceInspectWithRaw(ctx, syncer.target, types.ServiceInspectOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to inspect service %s: %w", syncer.target, err)
+		}
+		status := service.UpdateStatus
+		if syncer.serviceRevision(service.Spec.TaskTemplate) != revision {
+			if status != nil && strings.HasPrefix(string(status.State), "rollback_") {
+				return fmt.Errorf("update of service %s was rolled back: %s", syncer.target, status.Message)
+			}
+			return fmt.Errorf("service %s was rolled back or updated outside of docker-sync", syncer.target)
+		}
+		if status != nil && status.State == swarm.UpdateStatePaused {
+			return fmt.Errorf("update of service %s is paused: %s", syncer.target, status.Message)
+		}
+
+		tasks, err := syncer.client.TaskList(ctx, types.TaskListOptions{Filters: filters.NewArgs(
+			filters.Arg("service", syncer.target),
+			filters.Arg("desired-state", string(swarm.TaskStateRunning)),
+		)})
+		if err != nil {
+			return fmt.Errorf("failed to list tasks: %w", err)
+		}
+
+		updated := 0
+		for _, task := range tasks {
+			if syncer.serviceRevision(task.Spec) == revision && task.Status.State == swarm.TaskStateRunning {
+				updated++
+			}
+		}
+		total := len(tasks)
+		if replicated := service.Spec.Mode.Replicated; replicated != nil && replicated.Replicas != nil {
+			total = int(*replicated.Replicas)
+		}
+		if updated != reported && total > 1 {
+			syncer.logger.Info("Service {service}: {updated}/{total} tasks updated", "service", syncer.target, "updated", updated, "total", total)
+			reported = updated
+		}
+
+		if len(tasks) > 0 && updated == len(tasks) {
+			return nil
+		}
+		// With the continue failure action, the update completes even though some tasks failed
+		if status != nil && status.State == swarm.UpdateStateCompleted {
+			syncer.logger.Warn("Update of service {service} completed with {failed} of {total} tasks not running the update", "service", syncer.target, "failed", total-updated, "total", total)
+			return nil
+		}
+
+		err = sleep(ctx, serviceUpdatePollInterval)
+		if err != nil {
+			return fmt.Errorf("service %s didn't finish updating: %w", syncer.target, err)
+		}
+	}
+}
+
+// serviceRolloutTimeout returns how long to wait for an update of the service, which Swarm rolls out
+// in batches of the parallelism of its update config, waiting its delay after and monitoring each batch
+func serviceRolloutTimeout(service swarm.Service) time.Duration {
+	updateConfig := service.Spec.UpdateConfig
+	if updateConfig == nil {
+		return serviceUpdateTimeout
+	}
+
+	tasks := uint64(1)
+	if replicated := service.Spec.Mode.Replicated; replicated != nil && replicated.Replicas != nil && *replicated.Replicas > 0 {
+		tasks = *replicated.Replicas
+	}
+	batches := uint64(1)
+	if updateConfig.Parallelism > 0 {
+		batches = (tasks + updateConfig.Parallelism - 1) / updateConfig.Parallelism
+	}
+	return serviceUpdateTimeout + time.Duration(batches)*(updateConfig.Delay+updateConfig.Monitor)
+}
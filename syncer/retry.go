@@ -0,0 +1,154 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+const (
+	maxRetryDelay      = 30 * time.Second
+	pingTimeoutSeconds = 10
+)
+
+// ErrDisconnected is returned when Docker can't be reached even after retrying.
+// The paths of the failed copy are queued and copied once the connection is restored
+var ErrDisconnected = errors.New("lost connection to Docker")
+
+// isConnectionError reports whether err is caused by the daemon or the tunnel
+// to it being unreachable, as opposed to a failed operation
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// Canceled operations shouldn't be retried, even though the HTTP client reports them as network errors
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if client.IsErrConnectionFailed(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	// Errors of the SSH connection helper are reported as plain strings
+	message := err.Error()
+	return strings.Contains(message, "connection reset") ||
+		strings.Contains(message, "broken pipe") ||
+		strings.Contains(message, "has exited with")
+}
+
+// retry runs the operation and, if it fails because Docker is unreachable,
+// reconnects and runs it again with exponential backoff
+func (syncer *Syncer) retry(ctx context.Context, operation string, fn func() error) error {
+	delay := syncer.retryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if !isConnectionError(err) {
+			return err
+		}
+		if attempt >= syncer.retries {
+			return fmt.Errorf("%w: %w", ErrDisconnected, err)
+		}
+
+		syncer.logger.Warn("Lost connection to Docker while {operation}, retrying in {delay}...", "operation", operation, "delay", delay.String(), "error", err)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		delay = min(delay*2, maxRetryDelay)
+
+		err = syncer.Connect(ctx)
+		if err != nil {
+			syncer.logger.Debug("Failed to reconnect: {error}", "error", err)
+		}
+	}
+}
+
+// sleep waits for the delay to pass, returning early with an error if ctx is canceled
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ping checks whether the daemon is reachable
+func (syncer *Syncer) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeoutSeconds*time.Second)
+	defer cancel()
+
+	_, err := syncer.client.Ping(ctx)
+	return err
+}
+
+// reconnectInBackground keeps trying to reach Docker and copies the queued
+// paths once it succeeds. It does nothing if it's already running and gives up
+// when ctx is canceled
+func (syncer *Syncer) reconnectInBackground(ctx context.Context) {
+	if syncer.reconnecting {
+		return
+	}
+	syncer.reconnecting = true
+
+	go func() {
+		delay := max(syncer.retryDelay, time.Second)
+
+		for {
+			if err := sleep(ctx, delay); err != nil {
+				syncer.mu.Lock()
+				syncer.reconnecting = false
+				syncer.mu.Unlock()
+				return
+			}
+			delay = min(delay*2, maxRetryDelay)
+
+			syncer.mu.Lock()
+			err := syncer.Connect(ctx)
+			if err == nil {
+				err = syncer.ping(ctx)
+			}
+			if err != nil {
+				syncer.mu.Unlock()
+				syncer.logger.Debug("Docker is still unreachable: {error}", "error", err)
+				continue
+			}
+			syncer.reconnecting = false
+			queued := len(syncer.pending)
+			syncer.mu.Unlock()
+
+			if syncer.onReconnect != nil {
+				syncer.onReconnect()
+			}
+
+			syncer.logger.Info("Reconnected to Docker, copying {count} queued paths...", "count", queued)
+			err = syncer.CopyBatch(ctx, nil)
+			if err != nil {
+				syncer.logger.Error("Failed to copy queued paths: {error}", "error", err)
+			}
+			return
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package syncer
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/axtgr/docker-sync/ignore"
+)
+
+// Actions of rules
+const (
+	// ActionCopy only copies the matching paths, without restarting the target
+	ActionCopy = "copy"
+	// ActionRestart restarts the target after copying the matching paths
+	ActionRestart = "restart"
+	// ActionExec runs the command of the rule in the target after copying the matching paths
+	ActionExec = "exec"
+	// ActionBuild runs the command of the rule in the target after copying the matching paths,
+	// before restarting the target like paths matching no rule do
+	ActionBuild = "build"
+)
+
+// Rule decides what happens after paths matching its patterns are copied
+type Rule struct {
+	// Match are gitignore-style patterns relative to the source, e.g. *.go or config/*.yml
+	Match  []string
+	Action string
+	// Exec is the shell command run in the target by ActionExec and ActionBuild
+	Exec string
+}
+
+// compiledRule is a rule with its patterns compiled
+type compiledRule struct {
+	Rule
+	matcher *ignore.Matcher
+}
+
+// compileRules checks the rules and compiles their patterns relative to the source
+func compileRules(rules []Rule, sourcePath string) ([]compiledRule, error) {
+	if len(rules) > 0 && sourcePath == "" {
+		return nil, fmt.Errorf("rules require a source path")
+	}
+
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		if len(rule.Match) == 0 {
+			return nil, fmt.Errorf("rule #%d has no patterns to match", i+1)
+		}
+		switch rule.Action {
+		case ActionCopy, ActionRestart:
+		case ActionExec, ActionBuild:
+			if rule.Exec == "" {
+				return nil, fmt.Errorf("rule #%d runs a command but has none", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("rule #%d has unknown action %s, expected %s, %s, %s or %s", i+1, rule.Action, ActionCopy, ActionRestart, ActionExec, ActionBuild)
+		}
+
+		matcher, err := ignore.New(sourcePath, rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the patterns of rule #%d: %w", i+1, err)
+		}
+		compiled = append(compiled, compiledRule{Rule: rule, matcher: matcher})
+	}
+	return compiled, nil
+}
+
+// hasRestartRule reports whether any of the rules restarts the target
+func hasRestartRule(rules []Rule) bool {
+	return slices.ContainsFunc(rules, func(rule Rule) bool { return rule.Action == ActionRestart })
+}
+
+// batchPlan is what to do after copying a batch of paths. Builds run before restarting
+// the target, commands after it
+type batchPlan struct {
+	restart  bool
+	builds   []string
+	commands []string
+}
+
+// plan adds the action for a copied path to the plan. The first matching rule applies,
+// paths matching none restart the target if it's restarted on every change
+func (syncer *Syncer) plan(plan *batchPlan, path string, isDir bool) {
+	for _, rule := range syncer.rules {
+		if !rule.matcher.Match(path, isDir) {
+			continue
+		}
+		switch rule.Action {
+		case ActionRestart:
+			plan.restart = true
+		case ActionExec:
+			if !slices.Contains(plan.commands, rule.Exec) {
+				plan.commands = append(plan.commands, rule.Exec)
+			}
+		case ActionBuild:
+			if !slices.Contains(plan.builds, rule.Exec) {
+				plan.builds = append(plan.builds, rule.Exec)
+			}
+			if syncer.restartByDefault {
+				plan.restart = true
+			}
+		}
+		return
+	}
+
+	if syncer.restartByDefault {
+		plan.restart = true
+	}
+}
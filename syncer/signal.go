@@ -0,0 +1,43 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// signalTarget sends the restart signal to the running containers of the target, letting
+// the app reload its files without stopping the container
+func (syncer *Syncer) signalTarget(ctx context.Context) error {
+	if syncer.targetType == Pod {
+		syncer.logger.Debug("Sending {signal} to {target}...", "signal", syncer.restartSignal, "target", syncer.kube.String())
+		signal := strings.TrimPrefix(strings.ToUpper(syncer.restartSignal), "SIG")
+		err := syncer.kubectlExec(ctx, nil, io.Discard, nil, "kill", "-s", signal, "1")
+		if err != nil {
+			return fmt.Errorf("failed to send %s to %s: %w", syncer.restartSignal, syncer.kube, err)
+		}
+		return nil
+	}
+
+	signal := func(containerId string) error {
+		syncer.logger.Debug("Sending {signal} to container {container}...", "signal", syncer.restartSignal, "container", containerId)
+		err := syncer.client.ContainerKill(ctx, containerId, syncer.restartSignal)
+		if err != nil {
+			return fmt.Errorf("failed to send %s to container %s: %w", syncer.restartSignal, containerId, err)
+		}
+		return nil
+	}
+
+	return syncer.retry(ctx, "signaling", func() error {
+		if len(syncer.labels) > 0 {
+			return syncer.forEachLabeledContainer(ctx, signal)
+		}
+
+		containerId, err := syncer.getTargetContainer(ctx)
+		if err != nil {
+			return err
+		}
+		return signal(containerId)
+	})
+}
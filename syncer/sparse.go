@@ -0,0 +1,178 @@
+package syncer
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// tarBlockSize is the size of tar headers, and file contents are padded to a multiple of it
+const tarBlockSize = 512
+
+// fileKey identifies a file by its device and inode, shared by all its hard links
+type fileKey struct {
+	device uint64
+	inode  uint64
+}
+
+// fragment is a part of a sparse file holding data, the rest of it are holes
+type fragment struct {
+	offset int64
+	length int64
+}
+
+// writesSparse reports whether sparse files are archived with only their data. The PAX format
+// for them is understood by the Docker daemon, but not by every tar in containers and pods
+func (syncer *Syncer) writesSparse() bool {
+	return syncer.targetType != Pod && syncer.targetType != External && !syncer.agent && syncer.strategy != StrategyExecExtract
+}
+
+// writeSparseFile writes the header and the fragments of a sparse file in the GNU sparse format 1.0
+// of PAX. archive/tar can read the format but not write it, so the entry is written to w directly,
+// which has to be where the tar writer writes once it's flushed. Extracting it without support for
+// the format gives a file named after the original one in a GNUSparseFile.0 directory
+func writeSparseFile(w io.Writer, header *tar.Header, file io.ReaderAt, fragments []fragment, tracker *progressTracker, trackedPath string) error {
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(fragments))
+	var dataSize int64
+	for _, fragment := range fragments {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", fragment.offset, fragment.length)
+		dataSize += fragment.length
+	}
+	sparseMap.Write(make([]byte, tarPadding(int64(sparseMap.Len()))))
+	size := int64(sparseMap.Len()) + dataSize
+
+	dir, base := path.Split(header.Name)
+	name := path.Join(dir, "GNUSparseFile.0", base)
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     header.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(header.Size, 10),
+	}
+	// Records of the header, like extended attributes, go along with the ones of the format
+	for key, value := range header.PAXRecords {
+		records[key] = value
+	}
+	if len(name) > 100 {
+		records["path"] = name
+	}
+	if size > 0o77777777777 {
+		records["size"] = strconv.FormatInt(size, 10)
+	}
+	if header.Uid > 0o7777777 {
+		records["uid"] = strconv.Itoa(header.Uid)
+	}
+	if header.Gid > 0o7777777 {
+		records["gid"] = strconv.Itoa(header.Gid)
+	}
+	if len(header.Uname) > 32 {
+		records["uname"] = header.Uname
+	}
+	if len(header.Gname) > 32 {
+		records["gname"] = header.Gname
+	}
+
+	var extended bytes.Buffer
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		extended.WriteString(paxRecord(key, records[key]))
+	}
+
+	extendedHeader := *header
+	extendedHeader.Uid, extendedHeader.Gid, extendedHeader.Uname, extendedHeader.Gname = 0, 0, "", ""
+	blocks := [][]byte{
+		ustarBlock(path.Join(dir, "PaxHeaders.0", base), tar.TypeXHeader, int64(extended.Len()), &extendedHeader),
+		extended.Bytes(),
+		make([]byte, tarPadding(int64(extended.Len()))),
+		ustarBlock(name, tar.TypeReg, size, header),
+		sparseMap.Bytes(),
+	}
+	for _, block := range blocks {
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+
+	readers := make([]io.Reader, len(fragments))
+	for i, fragment := range fragments {
+		readers[i] = io.NewSectionReader(file, fragment.offset, fragment.length)
+	}
+	data := io.MultiReader(readers...)
+	if tracker != nil {
+		data = tracker.startFile(trackedPath, dataSize, data)
+	}
+	// The file might have shrunk since its holes were found
+	if _, err := io.CopyN(w, data, dataSize); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, tarPadding(size)))
+	return err
+}
+
+// tarPadding returns how many bytes pad contents of the size to a whole block
+func tarPadding(size int64) int64 {
+	return -size & (tarBlockSize - 1)
+}
+
+// paxRecord formats a record of a PAX extended header, which starts with its own length
+func paxRecord(key, value string) string {
+	record := " " + key + "=" + value + "\n"
+	length := len(record) + len(strconv.Itoa(len(record)))
+	if len(strconv.Itoa(length)) > len(strconv.Itoa(len(record))) {
+		length++
+	}
+	return strconv.Itoa(length) + record
+}
+
+// ustarBlock returns a ustar header block of the entry with the mode, owner and modification time
+// of the header. Values that don't fit are left out, to be given in a PAX extended header
+func ustarBlock(name string, typeflag byte, size int64, header *tar.Header) []byte {
+	block := make([]byte, tarBlockSize)
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	copy(block[0:100], name)
+	putOctal(block[100:108], header.Mode&0o7777)
+	if header.Uid <= 0o7777777 {
+		putOctal(block[108:116], int64(header.Uid))
+	}
+	if header.Gid <= 0o7777777 {
+		putOctal(block[116:124], int64(header.Gid))
+	}
+	if size <= 0o77777777777 {
+		putOctal(block[124:136], size)
+	}
+	putOctal(block[136:148], max(header.ModTime.Unix(), 0))
+	block[156] = typeflag
+	copy(block[257:263], "ustar\x00")
+	copy(block[263:265], "00")
+	if len(header.Uname) <= 32 {
+		copy(block[265:297], header.Uname)
+	}
+	if len(header.Gname) <= 32 {
+		copy(block[297:329], header.Gname)
+	}
+
+	// The checksum is computed with its own field filled with spaces
+	copy(block[148:156], "        ")
+	var checksum int64
+	for _, b := range block {
+		checksum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+	return block
+}
+
+// putOctal writes the number as zero-padded octal digits followed by a NUL
+func putOctal(field []byte, n int64) {
+	copy(field, fmt.Sprintf("%0*o\x00", len(field)-1, n))
+}
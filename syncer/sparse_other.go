@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package syncer
+
+import "os"
+
+// dataFragments returns nil, since finding holes needs SEEK_DATA, so files are archived whole
+func dataFragments(file *os.File, info os.FileInfo) ([]fragment, error) {
+	return nil, nil
+}
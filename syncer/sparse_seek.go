@@ -0,0 +1,56 @@
+//go:build linux || darwin
+
+package syncer
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataFragments returns the parts of the file holding data, or nil if it has no holes.
+// Files taking as many blocks as their size needs aren't searched for holes
+func dataFragments(file *os.File, info os.FileInfo) ([]fragment, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int64(stat.Blocks)*512 >= info.Size() {
+		return nil, nil
+	}
+
+	var fragments []fragment
+	for offset := int64(0); offset < info.Size(); {
+		data, err := file.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			// The rest of the file is a hole
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hole, err := file.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		hole = min(hole, info.Size())
+		if hole > data {
+			fragments = append(fragments, fragment{offset: data, length: hole - data})
+		}
+		offset = hole
+	}
+
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	// Files that take few blocks for other reasons, e.g. compression, have no holes
+	if len(fragments) == 1 && fragments[0] == (fragment{length: info.Size()}) {
+		return nil, nil
+	}
+	// An empty fragment at the end gives the size to extractors ignoring the recorded one, like GNU tar
+	if len(fragments) == 0 || fragments[len(fragments)-1].offset+fragments[len(fragments)-1].length < info.Size() {
+		fragments = append(fragments, fragment{offset: info.Size()})
+	}
+	return fragments, nil
+}
@@ -0,0 +1,114 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/axtgr/docker-sync/index"
+	"github.com/docker/docker/client"
+)
+
+// State is what a syncer needs to pick up where a previous run left off: the target it copied to,
+// the temporary container and volume it created and the recorded state of the files it copied
+type State struct {
+	Target             string                 `json:"target"`
+	TemporaryContainer string                 `json:"temporary_container,omitempty"`
+	TemporaryVolume    string                 `json:"temporary_volume,omitempty"`
+	Files              map[string]index.Entry `json:"files"`
+}
+
+// State returns the current state of the syncer, to be passed as Resume to a syncer
+// continuing its work, e.g. after docker-sync crashed
+func (syncer *Syncer) State() State {
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+	return State{
+		Target:             syncer.target,
+		TemporaryContainer: syncer.temporaryContainer,
+		TemporaryVolume:    syncer.temporaryVolume,
+		Files:              syncer.index.Entries(),
+	}
+}
+
+// resumeTemporaryContainer takes over the temporary container and volume of the resumed state
+// if they still exist, reporting whether it did
+func (syncer *Syncer) resumeTemporaryContainer(ctx context.Context) (bool, error) {
+	if syncer.resume == nil || syncer.resume.TemporaryContainer == "" {
+		return false, nil
+	}
+
+	_, err := syncer.client.ContainerInspect(ctx, syncer.resume.TemporaryContainer)
+	if client.IsErrNotFound(err) {
+		syncer.logger.Debug("Temporary container {container} of the resumed session is gone, creating a new one", "container", syncer.resume.TemporaryContainer)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container %s: %w", syncer.resume.TemporaryContainer, err)
+	}
+
+	syncer.logger.Debug("Reusing temporary container {container} with volume {volume}", "container", syncer.resume.TemporaryContainer, "volume", syncer.resume.TemporaryVolume)
+	syncer.temporaryContainer = syncer.resume.TemporaryContainer
+	syncer.temporaryVolume = syncer.resume.TemporaryVolume
+	return true, nil
+}
+
+// mountsTemporaryVolume reports whether the target container already mounts the temporary volume
+// at the target path, e.g. since it was recreated with it by a previous run
+func (syncer *Syncer) mountsTemporaryVolume(ctx context.Context) (bool, error) {
+	containerId, err := syncer.getTargetContainer(ctx)
+	if err != nil {
+		return false, err
+	}
+	info, err := syncer.client.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+	}
+
+	for _, mount := range info.Mounts {
+		if mount.Name == syncer.temporaryVolume && mount.Destination == syncer.targetPath {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkResumedTarget forgets the files copied by the previous run when they can't be in the target
+// anymore, since it's another container now and they weren't kept in a temporary volume
+func (syncer *Syncer) checkResumedTarget() {
+	if syncer.resume == nil || syncer.targetType != Container || !syncer.follows() {
+		return
+	}
+	if syncer.temporaryVolume != "" && syncer.temporaryVolume == syncer.resume.TemporaryVolume {
+		return
+	}
+	if syncer.resume.Target != syncer.target {
+		syncer.logger.Info("Container {name} was replaced since the session was saved, copying everything again", "name", syncer.targetName)
+		syncer.index.Reset()
+	}
+}
+
+// KeepsFiles reports whether the copied files stay in the target after cleaning up, so that
+// the record of what was copied still holds for the next run. Files copied into temporary volumes
+// and through agents are removed with them, and containers selected by labels can change
+func (syncer *Syncer) KeepsFiles() bool {
+	switch {
+	case syncer.agent, len(syncer.labels) > 0:
+		return false
+	case syncer.strategy == StrategyVolumeServiceUpdate, syncer.strategy == StrategyCopyRecreate:
+		return false
+	}
+	return true
+}
+
+// RestoreIndex records the files copied by a previous run to the same target, given by its State,
+// so that they're skipped unless they changed since. It reports false and records nothing when they
+// can't be in the target anymore, because it was replaced or doesn't keep the files
+func (syncer *Syncer) RestoreIndex(state State) bool {
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+	if !syncer.KeepsFiles() || state.Target != syncer.target {
+		return false
+	}
+	syncer.index.Restore(state.Files)
+	return true
+}
@@ -0,0 +1,25 @@
+package syncer
+
+// Stats counts what a syncer has copied since it was created
+type Stats struct {
+	// Files is how many files were copied and Bytes is how large they are in the source
+	Files int
+	Bytes int64
+}
+
+// Stats returns what the syncer has copied so far
+func (syncer *Syncer) Stats() Stats {
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+	return syncer.stats
+}
+
+// countCopied adds the files among the copied entries to the stats. It's called with mu held
+func (syncer *Syncer) countCopied(entries []archiveEntry) {
+	for _, entry := range entries {
+		if entry.info.Mode().IsRegular() {
+			syncer.stats.Files++
+			syncer.stats.Bytes += entry.info.Size()
+		}
+	}
+}
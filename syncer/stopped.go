@@ -0,0 +1,200 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+)
+
+// What to do with changes while the target container is stopped, e.g. in a crash loop
+const (
+	// StoppedTargetQueue queues the changes and copies them once the container starts again
+	StoppedTargetQueue = "queue"
+	// StoppedTargetStart starts the container and copies the changes into it
+	StoppedTargetStart = "start"
+	// StoppedTargetCopy copies the changes into the stopped container, which gets them when it starts
+	// again. It isn't restarted and no commands are run in it
+	StoppedTargetCopy = "copy"
+)
+
+// copyToStoppedTarget handles a copy made while the target container is stopped the way the StoppedTarget
+// option says, queuing the paths if the container can't be started or copied into. The container may
+// have started again without an event reporting it, in which case the paths are copied as usual
+func (syncer *Syncer) copyToStoppedTarget(ctx context.Context, paths []string) error {
+	containerId, err := syncer.findStoppedTarget(ctx)
+	if err == nil && containerId == "" {
+		syncer.targetStopped = false
+		return syncer.copyStarted(ctx, paths)
+	}
+
+	switch {
+	case err != nil:
+		syncer.logger.Warn("Failed to find the stopped target: {error}", "error", err)
+	case syncer.stoppedTargetMode == StoppedTargetStart:
+		err := syncer.startStoppedTarget(ctx, containerId)
+		if err != nil {
+			syncer.logger.Warn("Failed to start the stopped target: {error}", "error", err)
+			break
+		}
+		syncer.targetStopped = false
+		return syncer.copyStarted(ctx, paths)
+	case syncer.stoppedTargetMode == StoppedTargetCopy:
+		syncer.stoppedContainer = containerId
+		defer func() { syncer.stoppedContainer = "" }()
+		return syncer.copyOrQueue(ctx, paths)
+	}
+
+	syncer.pending = paths
+	return fmt.Errorf("%w, changes are copied once it starts again", ErrTargetStopped)
+}
+
+// copyStarted copies the paths to the target container found running, queuing them
+// if it has stopped again in the meantime
+func (syncer *Syncer) copyStarted(ctx context.Context, paths []string) error {
+	err := syncer.copyOrQueue(ctx, paths)
+	if errors.Is(err, ErrTargetStopped) {
+		syncer.targetStopped = true
+		syncer.pending = paths
+	}
+	return err
+}
+
+// findStoppedTarget returns the ID of the target container if it exists and isn't running,
+// or an empty string otherwise
+func (syncer *Syncer) findStoppedTarget(ctx context.Context) (string, error) {
+	if syncer.compose != nil {
+		containers, err := syncer.client.ContainerList(ctx, container.ListOptions{
+			All: true,
+			Filters: filters.NewArgs(
+				filters.Arg("label", composeProjectLabel+"="+syncer.compose.Project),
+				filters.Arg("label", composeServiceLabel+"="+syncer.compose.Service),
+			),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to list containers: %w", err)
+		}
+		if len(containers) == 0 {
+			return "", nil
+		}
+		target := containers[0]
+		for _, c := range containers {
+			if c.Labels[composeContainerNumberLabel] == "1" {
+				target = c
+			}
+		}
+		if target.State == "running" {
+			return "", nil
+		}
+		return target.ID, nil
+	}
+
+	if !syncer.follows() {
+		return "", nil
+	}
+	info, err := syncer.client.ContainerInspect(ctx, syncer.target)
+	if errdefs.IsNotFound(err) && syncer.targetName != "" {
+		info, err = syncer.client.ContainerInspect(ctx, syncer.targetName)
+	}
+	if errdefs.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container %s: %w", syncer.target, err)
+	}
+	if info.State != nil && info.State.Running {
+		return "", nil
+	}
+	return info.ID, nil
+}
+
+// notRunning returns ErrTargetStopped if the target container exists but isn't running, and err otherwise
+func (syncer *Syncer) notRunning(ctx context.Context, err error) error {
+	containerId, findErr := syncer.findStoppedTarget(ctx)
+	if findErr != nil || containerId == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrTargetStopped, containerId)
+}
+
+// startStoppedTarget starts the stopped target container
+func (syncer *Syncer) startStoppedTarget(ctx context.Context, containerId string) error {
+	syncer.logger.Info("Starting the stopped container {container} to copy the changes into it...", "container", containerId)
+	syncer.markOwnEvents(containerId)
+	err := syncer.client.ContainerStart(ctx, containerId, container.StartOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to start container %s: %w", containerId, err)
+	}
+	syncer.target = containerId
+	return nil
+}
+
+// copyToStoppedContainer copies the paths into the stopped target container. Paths in its volumes
+// are copied through a temporary helper container sharing them, so that the volumes get them
+// whether or not the daemon mounts the volumes of stopped containers. Other paths are copied
+// into its root filesystem through the archive API, which works on stopped containers
+func (syncer *Syncer) copyToStoppedContainer(ctx context.Context, paths []string) (int, error) {
+	containerId := syncer.stoppedContainer
+	info, err := syncer.client.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect container %s: %w", containerId, err)
+	}
+
+	target := containerId
+	if syncer.inVolume(info) {
+		err = syncer.ensureHelperImage(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		helperName := syncer.generateTemporaryName()
+		syncer.logger.Debug("Creating temporary container {container} sharing the volumes of {stopped}...", "container", helperName, "stopped", containerId)
+		response, err := syncer.client.ContainerCreate(ctx,
+			&container.Config{
+				Image:  syncer.helperImage,
+				Labels: syncer.resourceLabels(),
+			},
+			&container.HostConfig{
+				VolumesFrom: []string{containerId},
+			},
+			nil, syncer.helperPlatform, helperName)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create container: %w", err)
+		}
+		defer func() {
+			err := syncer.client.ContainerRemove(context.WithoutCancel(ctx), response.ID, container.RemoveOptions{Force: true})
+			if err != nil {
+				syncer.logger.Warn("Failed to remove temporary container {container}: {error}", "container", response.ID, "error", err)
+			}
+		}()
+		target = response.ID
+	} else if syncer.strategy == StrategyExecExtract {
+		return 0, fmt.Errorf("%s isn't in a volume of the stopped container %s, and the %s strategy can't copy into its filesystem", syncer.targetPath, containerId, syncer.strategy)
+	}
+
+	syncer.logger.Info("Copying into the stopped container {container}, it gets the changes when it starts again", "container", containerId)
+	return syncer.uploadArchive(ctx, paths, syncer.targetPath, nil, func(reader io.Reader) error {
+		return syncer.workers.Do(ctx, target, func() error {
+			return syncer.client.CopyToContainer(ctx, target, "/", reader, types.CopyToContainerOptions{
+				AllowOverwriteDirWithFile: true,
+			})
+		})
+	})
+}
+
+// inVolume reports whether the target path is in a volume or a bind mount of the container
+func (syncer *Syncer) inVolume(info types.ContainerJSON) bool {
+	for _, mount := range info.Mounts {
+		destination := strings.TrimSuffix(mount.Destination, "/")
+		if syncer.targetPath == destination || strings.HasPrefix(syncer.targetPath, destination+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Strategies getting files into the target
+const (
+	// StrategyDirectCopy copies files into the running containers of the target through the archive API
+	StrategyDirectCopy = "direct-copy"
+	// StrategyCopyRecreate copies files into the target container and then replaces it with a new one
+	// created from the same config, after every copy. Only containers can be recreated
+	StrategyCopyRecreate = "copy+recreate"
+	// StrategyVolumeServiceUpdate copies files into a temporary volume through a temporary container.
+	// Services are updated to mount the volume after every copy, while containers are recreated
+	// with it mounted once and see the files as soon as they're copied
+	StrategyVolumeServiceUpdate = "volume+service-update"
+	// StrategyExecExtract streams archives into tar run in the containers of the target,
+	// for when the archive API can't be used. The containers need tar (and zstd to decompress zstd)
+	StrategyExecExtract = "exec-extract"
+)
+
+// strategies are all the strategies in the order they're listed in errors
+var strategies = []string{StrategyDirectCopy, StrategyCopyRecreate, StrategyVolumeServiceUpdate, StrategyExecExtract}
+
+// checkStrategy checks a strategy given in the options before the target is known
+func checkStrategy(strategy, restartMode string, sourceIsFile bool) error {
+	if strategy == "" {
+		return nil
+	}
+	if !slices.Contains(strategies, strategy) {
+		return fmt.Errorf("unknown strategy %s, expected %s, %s, %s or %s", strategy, StrategyDirectCopy, StrategyCopyRecreate, StrategyVolumeServiceUpdate, StrategyExecExtract)
+	}
+	if strategy == StrategyCopyRecreate && restartMode != RestartModeRecreate {
+		return fmt.Errorf("the %s strategy restarts the target by recreating it, it can't be used with the %s restart mode", strategy, restartMode)
+	}
+	// Volumes are mounted at the target path, which can't be a file
+	if strategy == StrategyVolumeServiceUpdate && sourceIsFile {
+		return fmt.Errorf("the %s strategy can't sync a single file, sync its directory instead", strategy)
+	}
+	return nil
+}
+
+// defaultStrategy returns the strategy of the target unless another one is given:
+// targets restarted by recreating them get the files through the new containers
+// or a volume, pods through tar, and the others get them copied in directly.
+// Containers with a read-only root filesystem get a volume mounted over the target path
+func (syncer *Syncer) defaultStrategy(ctx context.Context) (string, error) {
+	if syncer.targetType == Pod {
+		return StrategyExecExtract, nil
+	}
+
+	if syncer.targetType == Container {
+		readOnly, err := syncer.findReadOnlyContainer(ctx)
+		if err != nil {
+			return "", err
+		}
+		if readOnly != "" {
+			syncer.logger.Info("Container {container} has a read-only root filesystem, mounting a temporary volume at {path}", "container", readOnly, "path", syncer.targetPath)
+			return StrategyVolumeServiceUpdate, nil
+		}
+	}
+
+	switch {
+	case syncer.recreatesTarget() && syncer.targetType == Container:
+		return StrategyCopyRecreate, nil
+	case syncer.recreatesTarget() && syncer.targetType == Service:
+		return StrategyVolumeServiceUpdate, nil
+	}
+	return StrategyDirectCopy, nil
+}
+
+// validateStrategy checks that the given strategy can get files into the target
+func (syncer *Syncer) validateStrategy() error {
+	if syncer.targetType == Pod {
+		if syncer.strategy != StrategyExecExtract {
+			return fmt.Errorf("files are only copied to pods with the %s strategy", StrategyExecExtract)
+		}
+		return nil
+	}
+
+	switch syncer.strategy {
+	case StrategyCopyRecreate:
+		if syncer.targetType != Container {
+			return fmt.Errorf("the %s strategy only works with containers, %s is a service, use the %s strategy instead", syncer.strategy, syncer.target, StrategyVolumeServiceUpdate)
+		}
+	case StrategyVolumeServiceUpdate:
+		if syncer.targetType == Service && syncer.restartSignal != "" {
+			return fmt.Errorf("the %s strategy gets the files into service %s by updating it, which can't be combined with a restart signal", syncer.strategy, syncer.target)
+		}
+	case StrategyDirectCopy, StrategyExecExtract:
+		// Files copied into a bind mount are kept when the service is updated
+		if syncer.recreatesTarget() && !(syncer.targetType == Service && syncer.bindMount != "") {
+			return fmt.Errorf("the %s strategy doesn't recreate the target, restart it with the %s or %s restart mode instead", syncer.strategy, RestartModeRestart, RestartModeSignal)
+		}
+	}
+	return nil
+}
+
+// initStrategy picks the strategy of the target unless one was given and prepares
+// the temporary resources it needs
+func (syncer *Syncer) initStrategy(ctx context.Context) error {
+	// Volumes get the files through their helper container and agents extract them themselves,
+	// nothing is copied into targets without a source, e.g. when pulling from them
+	if syncer.targetType == Volume || syncer.agent || syncer.sourcePath == "" {
+		if syncer.strategy != "" && syncer.sourcePath != "" {
+			return fmt.Errorf("strategies don't apply to volumes and agents")
+		}
+		syncer.strategy = ""
+		return nil
+	}
+
+	given := syncer.strategy != ""
+	if !given {
+		strategy, err := syncer.defaultStrategy(ctx)
+		if err != nil {
+			return err
+		}
+		syncer.strategy = strategy
+	}
+
+	if syncer.targetType == Service {
+		err := syncer.initBindMount(ctx, given)
+		if err != nil {
+			return err
+		}
+	}
+
+	if given {
+		err := syncer.validateStrategy()
+		if err != nil {
+			return err
+		}
+	}
+	syncer.logger.Debug("Copying to {target} with the {strategy} strategy", "target", syncer.target, "strategy", syncer.strategy)
+
+	if syncer.strategy != StrategyVolumeServiceUpdate {
+		return nil
+	}
+
+	if syncer.targetType == Container {
+		err := syncer.checkOverlay()
+		if err != nil {
+			return err
+		}
+	}
+
+	resumed, err := syncer.resumeTemporaryContainer(ctx)
+	if err != nil {
+		return err
+	}
+	if !resumed {
+		err := syncer.createTemporaryContainerWithVolume(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create a temporary container with a volume: %w", err)
+		}
+	}
+
+	// Services mount the volume when they're updated after a copy
+	if syncer.targetType == Service {
+		return nil
+	}
+
+	syncer.recreated = true
+	if resumed {
+		mounted, err := syncer.mountsTemporaryVolume(ctx)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			return nil
+		}
+	}
+	err = syncer.recreateTargetContainer(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to recreate container %s with a temporary volume: %w", syncer.target, err)
+	}
+	return nil
+}
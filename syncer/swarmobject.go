@@ -0,0 +1,329 @@
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// Prefixes of destinations in Swarm secrets and configs
+const (
+	SecretScheme = "secret://"
+	ConfigScheme = "config://"
+)
+
+// swarmObjectMaxSize is the largest secret or config Swarm accepts
+const swarmObjectMaxSize = 500 * 1024
+
+// SwarmObjectTarget is a Swarm secret or config holding the contents of a single file. Since they can't
+// be changed, every change creates a new version, the services using the object are updated to use it
+// instead and the previous versions are removed
+type SwarmObjectTarget struct {
+	// Secret is set for secrets, configs are the rest
+	Secret bool
+	Name   string
+}
+
+// ParseSwarmObjectDestination parses a destination in the secret://<name> or config://<name> format
+func ParseSwarmObjectDestination(destination string) (*SwarmObjectTarget, error) {
+	target := &SwarmObjectTarget{Secret: strings.HasPrefix(destination, SecretScheme)}
+	scheme := ConfigScheme
+	if target.Secret {
+		scheme = SecretScheme
+	}
+
+	target.Name = strings.TrimPrefix(destination, scheme)
+	if target.Name == "" || strings.ContainsAny(target.Name, "/:") {
+		return nil, fmt.Errorf("destination %s must be in the following format: %s<name>", destination, scheme)
+	}
+	return target, nil
+}
+
+func (target *SwarmObjectTarget) String() string {
+	if target.Secret {
+		return SecretScheme + target.Name
+	}
+	return ConfigScheme + target.Name
+}
+
+// kind returns what the object is called in messages
+func (target *SwarmObjectTarget) kind() string {
+	if target.Secret {
+		return "secret"
+	}
+	return "config"
+}
+
+// swarmObjectVersion is a version of the target secret or config
+type swarmObjectVersion struct {
+	id   string
+	name string
+}
+
+// rotationLabel marks the versions created by the syncer with the name of the object they replace
+func (syncer *Syncer) rotationLabel() string {
+	return syncer.identifier + ".rotates"
+}
+
+// initSwarmObject checks that the target secret or config can be synced
+func (syncer *Syncer) initSwarmObject(ctx context.Context) error {
+	if !syncer.sourceIsFile {
+		return fmt.Errorf("only a single file can be synced into %s", syncer.swarmObject)
+	}
+
+	services, err := syncer.swarmObjectServices(ctx)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		syncer.logger.Warn("No service uses {target}, new versions of it are only created", "target", syncer.swarmObject)
+	}
+	return nil
+}
+
+// swarmObjectVersions returns the object of the target name, if any, and the versions of it
+// created by the syncer, which services might be using
+func (syncer *Syncer) swarmObjectVersions(ctx context.Context) ([]swarmObjectVersion, error) {
+	var versions []swarmObjectVersion
+	seen := make(map[string]bool)
+	add := func(id string, annotations swarm.Annotations) {
+		// The name filter matches names starting with it too
+		if seen[id] || (annotations.Name != syncer.swarmObject.Name && annotations.Labels[syncer.rotationLabel()] != syncer.swarmObject.Name) {
+			return
+		}
+		seen[id] = true
+		versions = append(versions, swarmObjectVersion{id: id, name: annotations.Name})
+	}
+
+	for _, args := range []filters.Args{
+		filters.NewArgs(filters.Arg("name", syncer.swarmObject.Name)),
+		filters.NewArgs(filters.Arg("label", syncer.rotationLabel()+"="+syncer.swarmObject.Name)),
+	} {
+		if syncer.swarmObject.Secret {
+			secrets, err := syncer.client.SecretList(ctx, types.SecretListOptions{Filters: args})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list secrets: %w", err)
+			}
+			for _, secret := range secrets {
+				add(secret.ID, secret.Spec.Annotations)
+			}
+		} else {
+			configs, err := syncer.client.ConfigList(ctx, types.ConfigListOptions{Filters: args})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list configs: %w", err)
+			}
+			for _, config := range configs {
+				add(config.ID, config.Spec.Annotations)
+			}
+		}
+	}
+	return versions, nil
+}
+
+// swarmObjectServices returns the services using the target object or any version of it
+func (syncer *Syncer) swarmObjectServices(ctx context.Context) ([]swarm.Service, error) {
+	versions, err := syncer.swarmObjectVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, version := range versions {
+		ids[version.id] = true
+	}
+
+	services, err := syncer.client.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	var using []swarm.Service
+	for _, service := range services {
+		containerSpec := service.Spec.TaskTemplate.ContainerSpec
+		if containerSpec == nil {
+			continue
+		}
+		uses := false
+		if syncer.swarmObject.Secret {
+			for _, reference := range containerSpec.Secrets {
+				uses = uses || ids[reference.SecretID]
+			}
+		} else {
+			for _, reference := range containerSpec.Configs {
+				uses = uses || ids[reference.ConfigID]
+			}
+		}
+		if uses {
+			using = append(using, service)
+		}
+	}
+	return using, nil
+}
+
+// rotateSwarmObject creates a version of the target object with the contents of the source file,
+// updates the services using the object to use the new version and removes the previous versions
+// created by the syncer. The version is named after the contents, so unchanged contents reuse it
+func (syncer *Syncer) rotateSwarmObject(ctx context.Context) (int, error) {
+	info, err := os.Stat(syncer.sourcePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat source: %w", err)
+	}
+	entry, changed, err := syncer.index.Check(syncer.sourcePath, info)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check %s for changes: %w", syncer.sourcePath, err)
+	}
+	if !changed {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(syncer.sourcePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", syncer.sourcePath, err)
+	}
+	if len(data) > swarmObjectMaxSize {
+		return 0, fmt.Errorf("%s is %d bytes, while Swarm limits a %s to %d", syncer.sourcePath, len(data), syncer.swarmObject.kind(), swarmObjectMaxSize)
+	}
+
+	hash := sha256.Sum256(data)
+	current, err := syncer.createSwarmObjectVersion(ctx, syncer.swarmObject.Name+"-"+hex.EncodeToString(hash[:])[:12], data)
+	if err != nil {
+		return 0, err
+	}
+
+	services, err := syncer.swarmObjectServices(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, service := range services {
+		err := syncer.useSwarmObjectVersion(ctx, service, current)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	syncer.pruneSwarmObjectVersions(ctx, current)
+
+	syncer.index.Record(syncer.sourcePath, entry)
+	syncer.countCopied([]archiveEntry{{path: syncer.sourcePath, info: info}})
+	return 1, nil
+}
+
+// createSwarmObjectVersion creates a version of the target object with the name and the data,
+// unless it already exists
+func (syncer *Syncer) createSwarmObjectVersion(ctx context.Context, name string, data []byte) (swarmObjectVersion, error) {
+	versions, err := syncer.swarmObjectVersions(ctx)
+	if err != nil {
+		return swarmObjectVersion{}, err
+	}
+	for _, version := range versions {
+		if version.name == name {
+			return version, nil
+		}
+	}
+
+	syncer.logger.Debug("Creating {kind} {name}...", "kind", syncer.swarmObject.kind(), "name", name)
+	annotations := swarm.Annotations{
+		Name:   name,
+		Labels: map[string]string{syncer.rotationLabel(): syncer.swarmObject.Name},
+	}
+	var id string
+	if syncer.swarmObject.Secret {
+		response, err := syncer.client.SecretCreate(ctx, swarm.SecretSpec{Annotations: annotations, Data: data})
+		if err != nil {
+			return swarmObjectVersion{}, fmt.Errorf("failed to create secret %s: %w", name, err)
+		}
+		id = response.ID
+	} else {
+		response, err := syncer.client.ConfigCreate(ctx, swarm.ConfigSpec{Annotations: annotations, Data: data})
+		if err != nil {
+			return swarmObjectVersion{}, fmt.Errorf("failed to create config %s: %w", name, err)
+		}
+		id = response.ID
+	}
+	return swarmObjectVersion{id: id, name: name}, nil
+}
+
+// useSwarmObjectVersion updates the service to use the version instead of the one it uses, at the same
+// path and with the same permissions, which rolls out new tasks of the service
+func (syncer *Syncer) useSwarmObjectVersion(ctx context.Context, service swarm.Service, version swarmObjectVersion) error {
+	versions, err := syncer.swarmObjectVersions(ctx)
+	if err != nil {
+		return err
+	}
+	previous := make(map[string]bool)
+	for _, v := range versions {
+		previous[v.id] = v.id != version.id
+	}
+
+	spec := service.Spec
+	containerSpec := *spec.TaskTemplate.ContainerSpec
+	updated := false
+	if syncer.swarmObject.Secret {
+		containerSpec.Secrets = append([]*swarm.SecretReference(nil), containerSpec.Secrets...)
+		for i, reference := range containerSpec.Secrets {
+			if previous[reference.SecretID] {
+				replaced := *reference
+				replaced.SecretID, replaced.SecretName = version.id, version.name
+				containerSpec.Secrets[i] = &replaced
+				updated = true
+			}
+		}
+	} else {
+		containerSpec.Configs = append([]*swarm.ConfigReference(nil), containerSpec.Configs...)
+		for i, reference := range containerSpec.Configs {
+			if previous[reference.ConfigID] {
+				replaced := *reference
+				replaced.ConfigID, replaced.ConfigName = version.id, version.name
+				containerSpec.Configs[i] = &replaced
+				updated = true
+			}
+		}
+	}
+	if !updated {
+		return nil
+	}
+	spec.TaskTemplate.ContainerSpec = &containerSpec
+
+	syncer.logger.Debug("Updating service {service} to use {kind} {name}...", "service", service.Spec.Name, "kind", syncer.swarmObject.kind(), "name", version.name)
+	syncer.markOwnEvents(service.ID)
+	_, err = syncer.client.ServiceUpdate(ctx, service.ID, service.Version, spec, types.ServiceUpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update service %s: %w", service.Spec.Name, err)
+	}
+	if syncer.onRestart != nil {
+		syncer.onRestart()
+	}
+	return nil
+}
+
+// pruneSwarmObjectVersions removes the versions created by the syncer other than the current one.
+// The object the versions replace isn't removed, and neither are versions still in use, e.g. by
+// services that failed to update. Failing to remove them doesn't fail the copy
+func (syncer *Syncer) pruneSwarmObjectVersions(ctx context.Context, current swarmObjectVersion) {
+	versions, err := syncer.swarmObjectVersions(ctx)
+	if err != nil {
+		syncer.logger.Debug("Failed to prune versions of {target}: {error}", "target", syncer.swarmObject, "error", err)
+		return
+	}
+
+	for _, version := range versions {
+		if version.id == current.id || version.name == syncer.swarmObject.Name {
+			continue
+		}
+		syncer.logger.Debug("Removing {kind} {name}...", "kind", syncer.swarmObject.kind(), "name", version.name)
+		if syncer.swarmObject.Secret {
+			err = syncer.client.SecretRemove(ctx, version.id)
+		} else {
+			err = syncer.client.ConfigRemove(ctx, version.id)
+		}
+		if err != nil {
+			syncer.logger.Debug("Failed to remove {kind} {name}: {error}", "kind", syncer.swarmObject.kind(), "name", version.name, "error", err)
+		}
+	}
+}
@@ -4,15 +4,24 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/axtgr/docker-sync/filewatcher"
-	"github.com/docker/cli/cli/connhelper"
+	"github.com/axtgr/docker-sync/hostpath"
+	"github.com/axtgr/docker-sync/ignore"
+	"github.com/axtgr/docker-sync/index"
+	"github.com/axtgr/docker-sync/logger"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
@@ -20,222 +29,1333 @@ import (
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/google/uuid"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 const (
+	// TemporaryContainerImage is the default image of helper containers, which are never started
 	TemporaryContainerImage = "hello-world"
 	stopTimeoutInSeconds    = 10
 )
 
+// Ways of copying symlinks
+const (
+	// LinksPreserve copies symlinks as they are
+	LinksPreserve = "preserve"
+	// LinksFollow copies the files and directories symlinks point to, skipping dangling links and loops
+	LinksFollow = "follow"
+	// LinksSkip leaves symlinks out
+	LinksSkip = "skip"
+)
+
+// Ways of restarting the target
+const (
+	// RestartModeRecreate replaces the containers of the target, services get the files through a volume
+	RestartModeRecreate = "recreate"
+	// RestartModeRestart restarts the target container in place, keeping its ID and the files copied into it
+	RestartModeRestart = "restart"
+	// RestartModeSignal sends a signal to the containers of the target, DefaultRestartSignal unless another is given
+	RestartModeSignal = "signal"
+)
+
+// DefaultRestartSignal is sent to the target with RestartModeSignal unless another signal is given
+const DefaultRestartSignal = "SIGHUP"
+
 type TargetType int
 
 const (
 	Container = iota
 	Service
+	// Pod is a pod in a Kubernetes cluster, accessed with kubectl
+	Pod
+	// Volume is a named volume, copied into through a helper container
+	Volume
+	// SwarmObject is a Swarm secret or config, replaced by a new version on every change
+	SwarmObject
+	// External is a target of a registered Backend
+	External
+)
+
+// Kinds of targets given by their names, which both containers and services have
+const (
+	KindContainer = "container"
+	KindService   = "service"
 )
 
 type Syncer struct {
-	client             *client.Client
-	host               string
-	target             string
-	targetType         TargetType
-	targetPath         string
-	restartTarget      bool
+	client      DockerClient
+	givenClient bool
+	host        string
+	tlsConfig   *TLSConfig
+	sshFlags    []string
+	sshBastion  string
+	target      string
+	// targetName is the name of a container target, by which it's found again when it's replaced
+	targetName    string
+	targetType    TargetType
+	targetPath    string
+	restartTarget bool
+	restartSignal string
+	restartMode   string
+	restartMethod string
+	// dependents are restarted in order after the target
+	dependents []Dependent
+	// recreated is set once the target containers are replaced to mount the temporary volume
+	recreated bool
+	// strategy is how files get into the target, one of the Strategy constants
+	strategy string
+	// bindMount is the source of the bind mount of a service target at or above the target path,
+	// which keeps the copied files when the service is updated
+	bindMount string
+	// restartByDefault restarts the target after changes to paths matching no rule
+	restartByDefault   bool
+	rules              []compiledRule
+	transforms         []compiledTransform
+	links              string
+	collisions         string
+	normalize          string
+	chown              string
+	owner              *owner
+	fileMode           os.FileMode
+	dirMode            os.FileMode
+	defaultFileMode    os.FileMode
+	defaultDirMode     os.FileMode
+	compress           string
+	workers            *Workers
+	bandwidth          *Bandwidth
+	chunkSize          int64
+	pendingChunks      *chunkedArchive
 	temporaryContainer string
 	temporaryVolume    string
-	logger             *log.Logger
+	logger             *slog.Logger
+	trace              bool
 	identifier         string
+	ignore             *ignore.Matcher
+	sourcePath         string
+	sourceIsFile       bool
+	execBefore         string
+	execAfter          string
+	stdout             io.Writer
+	stderr             io.Writer
+	logs               bool
+	index              *index.Index
+	retries            int
+	retryDelay         time.Duration
+	onProgress         ProgressFunc
+	onRestart          func()
+	onReconnect        func()
+	onRetarget         func()
+	onTargetEvent      func(TargetEvent)
+	kube               *KubeTarget
+	compose            *ComposeTarget
+	volume             *VolumeTarget
+	swarmObject        *SwarmObjectTarget
+	backend            Backend
+	// createdVolumeHelper is set if the helper container of the volume was created by this syncer
+	createdVolumeHelper bool
+	labels              []string
+	targetKind          string
+	resume              *State
+	taskSlot            int
+	node                string
+	agent               bool
+	agentImage          string
+	agentPort           int
+	agentService        string
+	volumeDriver        string
+	volumeOptions       map[string]string
+	atomicFiles         bool
+	xattrs              bool
+	flatten             bool
+	// stoppedTargetMode is what to do with changes while the target container is stopped,
+	// one of the StoppedTarget constants
+	stoppedTargetMode string
+	createDestination bool
+	limits            Limits
+	helperImage       string
+	helperPull        string
+	helperPlatform    *ocispec.Platform
+	engine            Engine
+	provider          provider
+	// daemonOS is the operating system of the containers of the daemon once helper containers are needed
+	daemonOS string
+	// mu serializes copies, pending holds the paths of copies that failed
+	// because Docker was unreachable and stats counts what the copies copied
+	mu      sync.Mutex
+	pending []string
+	stats   Stats
+	// warnedCollisions are the paths already logged as colliding with others
+	warnedCollisions map[string]bool
+	reconnecting     bool
+	// restarts runs the updates of service targets one at a time
+	restarts restartQueue
+	// stopLogs stops following the logs of the target since the last restart
+	logsMu   sync.Mutex
+	stopLogs context.CancelFunc
+	// targetStopped is set while a single target container is stopped outside of the syncer,
+	// stoppedContainer while copying into it anyway
+	targetStopped    bool
+	stoppedContainer string
+	// ownEvents are the containers and services restarted by the syncer, by when they were
+	ownMu     sync.Mutex
+	ownEvents map[string]time.Time
 }
 
 type Options struct {
 	Target        string
 	TargetPath    string
 	RestartTarget bool
-	Host          string
-	Logger        *log.Logger
-	Identifier    string
+	// RestartSignal restarts the target by sending this signal to its containers
+	// instead of recreating them, e.g. SIGHUP
+	RestartSignal string
+	// RestartMode is how the target is restarted: RestartModeRecreate, RestartModeRestart or RestartModeSignal.
+	// It's RestartModeSignal if RestartSignal is given and RestartModeRecreate otherwise by default
+	RestartMode string
+	// RestartMethod is how services are made to replace their tasks: RestartMethodForceUpdate (default),
+	// RestartMethodEnvBump or RestartMethodImageLabel
+	RestartMethod string
+	// Dependents are restarted in order after every restart of the target
+	Dependents []Dependent
+	Host       string
+	// TLS is used for connecting to a tcp:// Host
+	TLS *TLSConfig
+	// SSHFlags are passed to ssh when connecting to an ssh:// Host, e.g. -i <identity file>
+	SSHFlags []string
+	// SSHBastion is the [user@]host[:port] of an SSH server a tcp:// Host is reached through
+	SSHBastion string
+	// Insecure allows connecting to a tcp:// Host without TLS or an SSHBastion,
+	// which is refused unless the host is a loopback address
+	Insecure bool
+	// Logger receives debug messages about every interaction with Docker (discarded by default)
+	Logger     *slog.Logger
+	Identifier string
+	// Trace logs every request to the Docker API to Logger at the info level, with its status and duration
+	Trace bool
+	// Paths matched by Ignore are skipped when copying
+	Ignore *ignore.Matcher
+	// Paths inside SourcePath are copied to the same relative location under TargetPath
+	SourcePath string
+	// Shell commands to run in the target container before and after each sync
+	ExecBefore string
+	ExecAfter  string
+	// Rules decide whether changed paths restart the target, run a command in it or are only copied.
+	// Paths matching no rule restart the target with RestartTarget or RestartSignal
+	Rules []Rule
+	// Transforms change the contents of matching files before they're copied
+	Transforms []Transform
+	// Output of the commands is streamed to Stdout and Stderr (os.Stdout and os.Stderr by default)
+	Stdout io.Writer
+	Stderr io.Writer
+	// Logs follows the logs of the containers of the target after every restart, writing them
+	// to Stdout prefixed with the names of the containers
+	Logs bool
+	// Index records the contents of copied files to skip unchanged ones (a new one by default)
+	Index *index.Index
+	// Resume is the State of a syncer of a previous run to continue from. Its temporary container
+	// and volume are reused if they still exist, and the files it copied aren't copied again
+	Resume *State
+	// Operations failing because Docker is unreachable are retried up to Retries times,
+	// waiting RetryDelay before the first retry and twice as long before each next one
+	Retries    int
+	RetryDelay time.Duration
+	// Links is how symlinks are copied: LinksPreserve (default), LinksFollow or LinksSkip
+	Links string
+	// CaseCollisions is what to do with paths of the source that differ from others only in case
+	// or Unicode normalization: CollisionsWarn (default), CollisionsSkip or CollisionsError
+	CaseCollisions string
+	// Normalize is the Unicode normalization of names in the target: NormalizeNone (default),
+	// NormalizeNFC or NormalizeNFD
+	Normalize string
+	// Chown makes copied files owned by this user[:group] in the target, given by names or IDs.
+	// ChownAuto uses the user the target container runs as
+	Chown string
+	// Chmod sets the permissions of copied files in the D<mode>,F<mode> format, e.g. D755,F644
+	Chmod string
+	// Umask is the octal umask of the users of the target, e.g. 022. Copied files and directories that
+	// don't let everyone read what files created with it do, like 0700 directories, get its permissions
+	// instead, and the parent directories of copied paths are copied along with them
+	Umask string
+	// Compress is the algorithm compressing uploads: CompressNone (default), CompressGzip or CompressZstd.
+	// It's worth it over slow connections, e.g. to a remote host over SSH
+	Compress string
+	// Operations on containers go through Workers, which limit how many of them run at once
+	// and run them one at a time per container. Syncers can share them so that the limit
+	// applies to all of them. By default, a syncer has its own, running up to Parallel operations
+	Workers  *Workers
+	Parallel int
+	// Bandwidth limits how fast archives are uploaded, over all the syncers sharing it
+	Bandwidth *Bandwidth
+	// ChunkSize splits archives of more than this many bytes into chunks uploaded one by one
+	// into a temporary directory of the target, so that an upload interrupted by a lost
+	// connection resumes with the chunks the target doesn't have. 0 disables it
+	ChunkSize int64
+	// OnProgress receives reports on uploads to the target
+	OnProgress ProgressFunc
+	// OnRestart is called after the target is restarted following a copy
+	OnRestart func()
+	// OnReconnect is called when Docker is reachable again after the connection was lost
+	OnReconnect func()
+	// OnRetarget is called when the target container was replaced by one with the same name,
+	// which doesn't have the synced files yet
+	OnRetarget func()
+	// OnTargetEvent is called when a container of the target is started, stopped or removed
+	// outside of the syncer, once FollowTarget is running
+	OnTargetEvent func(TargetEvent)
+	// Client is used instead of connecting to Host, e.g. to run the syncer against
+	// a fake Docker API. It's kept when reconnecting
+	Client DockerClient
+	// Engine is the container engine running the target, Docker by default
+	Engine Engine
+	// Compose makes the target the container of a Docker Compose service, looked up by its labels
+	Compose *ComposeTarget
+	// Volume makes the target a named volume, created unless it exists, instead of a container
+	Volume *VolumeTarget
+	// SwarmObject makes the target a Swarm secret or config, which gets the contents of a single file
+	SwarmObject *SwarmObjectTarget
+	// Backend makes the target one of a registered backend, which does the copying and restarting
+	Backend Backend
+	// Labels make the target all running containers having these labels (key or key=value)
+	Labels []string
+	// TargetKind makes the Target only a KindContainer or only a KindService. By default,
+	// it's either, and a container with the same name as a service is an error
+	TargetKind string
+	// TaskSlot and Node pick the task of a service target to copy into: the replica in the slot
+	// and one running on the node (by hostname or ID). A Target in the <service>.<slot> format
+	// sets TaskSlot too. By default, the first running task is picked
+	TaskSlot int
+	Node     string
+	// Agent copies to a service through a global service of agents writing into a volume
+	// on every node, so that replicas on nodes other than the one Docker is reached through
+	// are synced as well. AgentImage and AgentPort default to DefaultAgentImage and DefaultAgentPort
+	Agent      bool
+	AgentImage string
+	AgentPort  int
+	// VolumeDriver and VolumeOptions are the driver and its options of the temporary volumes,
+	// e.g. to keep them in memory with tmpfs or on NFS. The local driver is used by default
+	VolumeDriver  string
+	VolumeOptions map[string]string
+	// Strategy is how files get into the target: StrategyDirectCopy, StrategyCopyRecreate,
+	// StrategyVolumeServiceUpdate or StrategyExecExtract. By default, it's picked by the kind
+	// of the target and whether it's restarted by recreating it
+	Strategy string
+	// AtomicFiles uploads files into a staging directory inside the target path and then moves
+	// each of them into place, so that the target never sees a file half-written. The files
+	// are replaced one by one, so the tree as a whole isn't replaced atomically
+	AtomicFiles bool
+	// Xattrs copies the extended attributes of files, e.g. file capabilities, which costs
+	// a few more system calls for every file
+	Xattrs bool
+	// Flatten copies the files directly into the target path under their names, leaving out
+	// the directories they're in, e.g. for plugins built into several directories
+	Flatten bool
+	// StoppedTarget is what to do with changes while the target container is stopped, e.g. crash looping:
+	// StoppedTargetQueue (default), StoppedTargetStart or StoppedTargetCopy
+	StoppedTarget string
+	// CreateDestination creates the target path in the target when it doesn't exist,
+	// instead of failing on Init
+	CreateDestination bool
+	// Limits make copies warn or fail when they contain too many or too large files
+	Limits Limits
+	// HelperImage is the image of helper containers, TemporaryContainerImage by default. HelperPull
+	// is when to pull it: PullMissing (the default), PullAlways or PullNever. HelperPlatform is
+	// the os/arch[/variant] to pull it for and create helper containers with, the platform
+	// of the daemon by default, including windows for daemons running Windows containers
+	HelperImage    string
+	HelperPull     string
+	HelperPlatform string
+	// Kube makes the target a Kubernetes pod instead of a Docker container or service
+	Kube *KubeTarget
 }
 
 func New(options Options) (*Syncer, error) {
+	stdout := options.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	stderr := options.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	syncLogger := options.Logger
+	if syncLogger == nil {
+		syncLogger = logger.Discard()
+	}
+
+	fileIndex := options.Index
+	if fileIndex == nil {
+		fileIndex = index.New()
+	}
+	if options.Resume != nil {
+		fileIndex.Restore(options.Resume.Files)
+	}
+
+	links := options.Links
+	switch links {
+	case "":
+		links = LinksPreserve
+	case LinksPreserve, LinksFollow, LinksSkip:
+	default:
+		return nil, fmt.Errorf("unknown links mode %s, expected %s, %s or %s", links, LinksPreserve, LinksFollow, LinksSkip)
+	}
+
+	collisions := options.CaseCollisions
+	switch collisions {
+	case "":
+		collisions = CollisionsWarn
+	case CollisionsWarn, CollisionsSkip, CollisionsError:
+	default:
+		return nil, fmt.Errorf("unknown case collisions mode %s, expected %s, %s or %s", collisions, CollisionsWarn, CollisionsSkip, CollisionsError)
+	}
+
+	normalize := options.Normalize
+	switch normalize {
+	case "":
+		normalize = NormalizeNone
+	case NormalizeNone, NormalizeNFC, NormalizeNFD:
+	default:
+		return nil, fmt.Errorf("unknown normalization %s, expected %s, %s or %s", normalize, NormalizeNone, NormalizeNFC, NormalizeNFD)
+	}
+
+	stoppedTarget := options.StoppedTarget
+	switch stoppedTarget {
+	case "":
+		stoppedTarget = StoppedTargetQueue
+	case StoppedTargetQueue, StoppedTargetStart, StoppedTargetCopy:
+	default:
+		return nil, fmt.Errorf("unknown stopped target mode %s, expected %s, %s or %s", stoppedTarget, StoppedTargetQueue, StoppedTargetStart, StoppedTargetCopy)
+	}
+
+	limits := options.Limits
+	switch limits.Action {
+	case "":
+		limits.Action = LimitAbort
+	case LimitAbort, LimitWarn:
+	default:
+		return nil, fmt.Errorf("unknown limit action %s, expected %s or %s", limits.Action, LimitAbort, LimitWarn)
+	}
+
+	targetPath, err := normalizeContainerPath(options.TargetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileMode, dirMode os.FileMode
+	if options.Chmod != "" {
+		var err error
+		fileMode, dirMode, err = parseChmod(options.Chmod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var defaultFileMode, defaultDirMode os.FileMode
+	if options.Umask != "" {
+		var err error
+		defaultFileMode, defaultDirMode, err = parseUmask(options.Umask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	compress := options.Compress
+	switch compress {
+	case "":
+		compress = CompressNone
+	case CompressNone, CompressGzip, CompressZstd:
+	default:
+		return nil, fmt.Errorf("unknown compression %s, expected %s, %s or %s", compress, CompressNone, CompressGzip, CompressZstd)
+	}
+
+	// A single file is copied to the target path itself, and patterns are relative to its directory
+	sourceIsFile := false
+	patternRoot := options.SourcePath
+	if options.SourcePath != "" {
+		if info, err := os.Stat(options.SourcePath); err == nil && !info.IsDir() {
+			sourceIsFile = true
+			patternRoot = filepath.Dir(options.SourcePath)
+		}
+	}
+
+	rules, err := compileRules(options.Rules, patternRoot)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDependents(options.Dependents)
+	if err != nil {
+		return nil, err
+	}
+
+	transforms, err := compileTransforms(options.Transforms, patternRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Volume != nil && (options.RestartTarget || options.RestartSignal != "" || hasRestartRule(options.Rules)) {
+		return nil, fmt.Errorf("%s has no containers to restart", options.Volume)
+	}
+	if options.SwarmObject != nil && (options.RestartTarget || options.RestartSignal != "" || hasRestartRule(options.Rules)) {
+		return nil, fmt.Errorf("the services using %s are updated with every change, they can't be restarted", options.SwarmObject)
+	}
+	// Backends get plain archives and only copy, delete and restart
+	if options.Backend != nil && (options.RestartSignal != "" || options.RestartMode != "" || options.Strategy != "" || options.Agent || options.AtomicFiles || (options.Compress != "" && options.Compress != CompressNone)) {
+		return nil, fmt.Errorf("%s is synced by its backend, which can't be combined with restart signals and modes, strategies, agents, atomic file replacement or compression", options.Target)
+	}
+
+	restartSignal := options.RestartSignal
+	restartMode := options.RestartMode
+	switch restartMode {
+	case "":
+		restartMode = RestartModeRecreate
+		if restartSignal != "" {
+			restartMode = RestartModeSignal
+		}
+	case RestartModeSignal:
+		if restartSignal == "" && (options.RestartTarget || hasRestartRule(options.Rules)) {
+			restartSignal = DefaultRestartSignal
+		}
+	case RestartModeRecreate, RestartModeRestart:
+		if restartSignal != "" {
+			return nil, fmt.Errorf("a restart signal can't be used with the %s restart mode", restartMode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown restart mode %s, expected %s, %s or %s", restartMode, RestartModeRecreate, RestartModeRestart, RestartModeSignal)
+	}
+
+	restartMethod := options.RestartMethod
+	switch restartMethod {
+	case "":
+		restartMethod = RestartMethodForceUpdate
+	case RestartMethodForceUpdate, RestartMethodEnvBump, RestartMethodImageLabel:
+	default:
+		return nil, fmt.Errorf("unknown restart method %s, expected %s, %s or %s", restartMethod, RestartMethodForceUpdate, RestartMethodEnvBump, RestartMethodImageLabel)
+	}
+
+	err = checkStrategy(options.Strategy, restartMode, sourceIsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Volumes keeping the synced files are mounted at the target path, which can't be a file
+	if sourceIsFile && options.Agent {
+		return nil, fmt.Errorf("agents can't sync a single file, sync its directory instead")
+	}
+	if sourceIsFile && restartMode == RestartModeRecreate && (options.RestartTarget || hasRestartRule(options.Rules)) {
+		return nil, fmt.Errorf("targets of a single file can't be restarted by recreating them, use the %s or %s restart mode", RestartModeRestart, RestartModeSignal)
+	}
+
+	workers := options.Workers
+	if workers == nil {
+		workers = NewWorkers(options.Parallel)
+	}
+
+	agentImage := options.AgentImage
+	if agentImage == "" {
+		agentImage = DefaultAgentImage
+	}
+	agentPort := options.AgentPort
+	if agentPort == 0 {
+		agentPort = DefaultAgentPort
+	}
+
+	helperImage := options.HelperImage
+	if helperImage == "" {
+		helperImage = TemporaryContainerImage
+	}
+	helperPull := options.HelperPull
+	switch helperPull {
+	case "":
+		helperPull = PullMissing
+	case PullMissing, PullAlways, PullNever:
+	default:
+		return nil, fmt.Errorf("unknown pull policy %s, expected %s, %s or %s", helperPull, PullMissing, PullAlways, PullNever)
+	}
+	helperPlatform, err := parsePlatform(options.HelperPlatform)
+	if err != nil {
+		return nil, err
+	}
+
+	switch options.TargetKind {
+	case "", KindContainer, KindService:
+	default:
+		return nil, fmt.Errorf("unknown target kind %s, expected %s or %s", options.TargetKind, KindContainer, KindService)
+	}
+	if options.TargetKind != "" && (options.Kube != nil || options.Compose != nil || options.Volume != nil || options.SwarmObject != nil || options.Backend != nil || len(options.Labels) > 0) {
+		return nil, fmt.Errorf("only targets given by their names can be prefixed with %s: or %s:", KindContainer, KindService)
+	}
+
+	engine := options.Engine
+	if engine == "" {
+		engine = Docker
+	}
+	engineProvider, err := newProvider(engine)
+	if err != nil {
+		return nil, err
+	}
+	if options.SSHBastion != "" && !strings.HasPrefix(options.Host, "tcp://") {
+		return nil, fmt.Errorf("an SSH bastion can only be used with tcp:// hosts, got %s", options.Host)
+	}
+	if !options.Insecure && options.Client == nil {
+		err = checkEncrypted(options.Host, options.TLS, options.SSHBastion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Syncer{
-		host:          options.Host,
-		target:        options.Target,
-		targetPath:    options.TargetPath,
-		restartTarget: options.RestartTarget,
-		logger:        options.Logger,
-		identifier:    options.Identifier,
+		client:            options.Client,
+		givenClient:       options.Client != nil,
+		host:              options.Host,
+		tlsConfig:         options.TLS,
+		sshFlags:          options.SSHFlags,
+		sshBastion:        options.SSHBastion,
+		target:            options.Target,
+		targetPath:        targetPath,
+		restartTarget:     options.RestartTarget || restartSignal != "" || hasRestartRule(options.Rules) || options.Strategy == StrategyCopyRecreate,
+		restartSignal:     restartSignal,
+		restartMode:       restartMode,
+		restartMethod:     restartMethod,
+		restartByDefault:  options.RestartTarget || restartSignal != "" || options.Strategy == StrategyCopyRecreate,
+		strategy:          options.Strategy,
+		rules:             rules,
+		transforms:        transforms,
+		links:             links,
+		collisions:        collisions,
+		normalize:         normalize,
+		warnedCollisions:  make(map[string]bool),
+		chown:             options.Chown,
+		fileMode:          fileMode,
+		dirMode:           dirMode,
+		defaultFileMode:   defaultFileMode,
+		defaultDirMode:    defaultDirMode,
+		compress:          compress,
+		workers:           workers,
+		bandwidth:         options.Bandwidth,
+		chunkSize:         options.ChunkSize,
+		logger:            syncLogger,
+		trace:             options.Trace,
+		identifier:        options.Identifier,
+		ignore:            options.Ignore,
+		sourcePath:        options.SourcePath,
+		sourceIsFile:      sourceIsFile,
+		execBefore:        options.ExecBefore,
+		execAfter:         options.ExecAfter,
+		stdout:            stdout,
+		logs:              options.Logs,
+		dependents:        options.Dependents,
+		stderr:            stderr,
+		index:             fileIndex,
+		retries:           options.Retries,
+		retryDelay:        options.RetryDelay,
+		onProgress:        options.OnProgress,
+		onRestart:         options.OnRestart,
+		onReconnect:       options.OnReconnect,
+		onRetarget:        options.OnRetarget,
+		onTargetEvent:     options.OnTargetEvent,
+		kube:              options.Kube,
+		compose:           options.Compose,
+		volume:            options.Volume,
+		swarmObject:       options.SwarmObject,
+		backend:           options.Backend,
+		labels:            options.Labels,
+		targetKind:        options.TargetKind,
+		resume:            options.Resume,
+		taskSlot:          options.TaskSlot,
+		node:              options.Node,
+		agent:             options.Agent,
+		agentImage:        agentImage,
+		volumeDriver:      options.VolumeDriver,
+		volumeOptions:     options.VolumeOptions,
+		agentPort:         agentPort,
+		atomicFiles:       options.AtomicFiles,
+		xattrs:            options.Xattrs,
+		flatten:           options.Flatten,
+		stoppedTargetMode: stoppedTarget,
+		createDestination: options.CreateDestination,
+		limits:            limits,
+		helperImage:       helperImage,
+		helperPull:        helperPull,
+		helperPlatform:    helperPlatform,
+		engine:            engine,
+		provider:          engineProvider,
 	}, nil
 }
 
+// Ignore returns the matcher of paths excluded from syncing
+func (syncer *Syncer) Ignore() *ignore.Matcher {
+	return syncer.ignore
+}
+
+// Client returns the Docker client of the syncer, for passing it to other syncers of the same host
+func (syncer *Syncer) Client() DockerClient {
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+	return syncer.client
+}
+
+// SourcePath returns the absolute local path that is synced to the target
+func (syncer *Syncer) SourcePath() string {
+	return syncer.sourcePath
+}
+
+// TargetPath returns the path inside the target that files are synced to
+func (syncer *Syncer) TargetPath() string {
+	return syncer.targetPath
+}
+
+// recreatesTarget reports whether restarting the target replaces its containers,
+// which requires keeping the synced files outside of them
+func (syncer *Syncer) recreatesTarget() bool {
+	return syncer.restartTarget && syncer.restartMode == RestartModeRecreate
+}
+
 func (syncer *Syncer) generateTemporaryName() string {
 	return syncer.identifier + "-" + uuid.New().String()
 }
 
+// temporaryVolumeOptions returns the options creating the temporary volume like it was created
+// when a service mounts it on a node that doesn't have it yet
+func (syncer *Syncer) temporaryVolumeOptions() *mount.VolumeOptions {
+	options := &mount.VolumeOptions{Labels: syncer.resourceLabels()}
+	if syncer.volumeDriver != "" || len(syncer.volumeOptions) > 0 {
+		options.DriverConfig = &mount.Driver{Name: syncer.volumeDriver, Options: syncer.volumeOptions}
+	}
+	return options
+}
+
 func (syncer *Syncer) getTemporaryVolumePath() string {
 	return "/" + syncer.identifier + "-data"
 }
 
-func (syncer *Syncer) Connect() error {
-	var clientOpts []client.Opt
+// Connect creates a client for the host of the container engine, replacing the previous one
+func (syncer *Syncer) Connect(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	helper, err := connhelper.GetConnectionHelper(syncer.host)
-	if err != nil {
-		// Not an SSH URL, use default connection
-		clientOpts = append(clientOpts, client.WithHost(syncer.host))
-	} else {
-		// SSH URL
-		httpClient := &http.Client{
-			Transport: &http.Transport{
-				DialContext: helper.Dialer,
-			},
-		}
+	// Kubernetes is accessed with kubectl, and backends on their own
+	if syncer.kube != nil || syncer.backend != nil || syncer.givenClient {
+		return nil
+	}
+
+	if syncer.client != nil {
+		syncer.client.Close()
+	}
 
-		clientOpts = append(clientOpts,
-			client.WithHTTPClient(httpClient),
-			client.WithHost(helper.Host),
-			client.WithDialContext(helper.Dialer),
-			client.WithAPIVersionNegotiation(),
-		)
+	clientOpts, err := syncer.provider.clientOptions(endpoint{
+		host:      syncer.host,
+		tlsConfig: syncer.tlsConfig,
+		sshFlags:  syncer.sshFlags,
+		bastion:   syncer.sshBastion,
+	})
+	if err != nil {
+		return err
+	}
+	if syncer.trace {
+		clientOpts = append(clientOpts, withTrace(syncer.logger))
 	}
 
 	client, err := client.NewClientWithOpts(clientOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to create Docker client: %w", err)
+		return fmt.Errorf("failed to create %s client: %w", syncer.engine, err)
 	}
 
 	syncer.client = client
 	return nil
 }
 
-func (syncer *Syncer) Init() error {
-	err := syncer.Connect()
+// Init finds the target, prepares the temporary resources needed to restart it or to copy into
+// a read-only container, looks up the owner of copied files and checks that the target can decompress them
+func (syncer *Syncer) Init(ctx context.Context) error {
+	err := syncer.initTarget(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to connect to docker: %w", err)
+		return err
+	}
+	// Secrets and configs get the contents of the file as they are
+	if syncer.targetType == SwarmObject {
+		return nil
+	}
+	if syncer.targetType == External {
+		return syncer.resolveOwner(ctx)
 	}
 
-	service, err := syncer.findTargetService()
+	if syncer.restartTarget && syncer.restartMode == RestartModeRestart && syncer.targetType != Container {
+		return fmt.Errorf("only containers can be restarted in place, restarting %s replaces its containers", syncer.target)
+	}
+
+	err = syncer.initStrategy(ctx)
+	if err != nil {
+		return err
+	}
+	syncer.checkResumedTarget()
+
+	err = syncer.checkDestination(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to find service %s: %w", syncer.target, err)
+		return err
+	}
+
+	err = syncer.resolveOwner(ctx)
+	if err != nil {
+		return err
+	}
+
+	syncer.negotiateCompression(ctx)
+	syncer.initAtomicFiles()
+	return nil
+}
+
+func (syncer *Syncer) initTarget(ctx context.Context) error {
+	if syncer.kube != nil {
+		syncer.targetType = Pod
+		return syncer.initKube(ctx)
+	}
+
+	if syncer.backend != nil {
+		syncer.targetType = External
+		err := syncer.backend.Resolve(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to find %s: %w", syncer.target, err)
+		}
+		return nil
+	}
+
+	err := syncer.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to docker: %w", err)
+	}
+
+	if syncer.swarmObject != nil {
+		if syncer.agent {
+			return fmt.Errorf("agents can only copy to services")
+		}
+		syncer.targetType = SwarmObject
+		return syncer.initSwarmObject(ctx)
+	}
+
+	if syncer.volume != nil {
+		if syncer.agent {
+			return fmt.Errorf("agents can only copy to services")
+		}
+		syncer.targetType = Volume
+		return syncer.initVolume(ctx)
+	}
+
+	if len(syncer.labels) > 0 {
+		if syncer.agent {
+			return fmt.Errorf("agents can only copy to services")
+		}
+		syncer.targetType = Container
+		syncer.target = describeLabels(syncer.labels)
+		_, err := syncer.findLabeledContainers(ctx)
+		return err
+	}
+
+	service := ""
+	if syncer.targetKind != KindContainer && syncer.provider.supportsServices() && syncer.compose == nil {
+		service, err = syncer.findTargetService(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to find service %s: %w", syncer.target, err)
+		}
+	}
+
+	if syncer.targetKind == KindService && service == "" {
+		if !syncer.provider.supportsServices() {
+			return fmt.Errorf("%s can't run services, so %s can't be a service", syncer.engine, syncer.target)
+		}
+		return fmt.Errorf("failed to find service %s", syncer.target)
+	}
+
+	if service != "" && syncer.targetKind == "" {
+		err := syncer.checkAmbiguousTarget(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
 	if service == "" {
-		container, err := syncer.findTargetContainer()
+		container, err := syncer.findTargetContainer(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to find container %s: %w", syncer.target, err)
 		}
+		if container == "" && syncer.targetKind == KindContainer {
+			return fmt.Errorf("failed to find container %s", syncer.target)
+		}
 		if container == "" {
 			return fmt.Errorf("failed to find container or service %s", syncer.target)
 		}
 
 		syncer.targetType = Container
 		syncer.target = container
+		if syncer.follows() {
+			syncer.targetName, err = syncer.containerName(ctx, container)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		syncer.targetType = Service
 		syncer.target = service
 	}
 
-	if syncer.restartTarget && syncer.targetType == Service {
-		err := syncer.createTemporaryContainerWithVolume()
+	if syncer.agent {
+		if syncer.targetType != Service {
+			return fmt.Errorf("agents can only copy to services, %s is a container", syncer.target)
+		}
+		err := syncer.startAgents(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to create a temporary container with a volume: %w", err)
+			return fmt.Errorf("failed to start agents: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func (syncer *Syncer) Copy(localPath string, op filewatcher.Op) error {
-	if syncer.targetType == Container && !syncer.restartTarget {
-		container, err := syncer.findTargetContainer()
+func (syncer *Syncer) Copy(ctx context.Context, localPath string, op filewatcher.Op) error {
+	return syncer.CopyBatch(ctx, []string{localPath})
+}
+
+// CopyBatch copies the paths in a single archive and restarts the target at most once.
+// Files whose contents haven't changed since they were last copied are skipped.
+// If Docker can't be reached, the paths are queued and copied along with the next
+// batch or as soon as the connection is restored, unless ctx is canceled by then
+func (syncer *Syncer) CopyBatch(ctx context.Context, localPaths []string) error {
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+
+	paths := syncer.pending
+	syncer.pending = nil
+	for _, localPath := range localPaths {
+		if !slices.Contains(paths, localPath) {
+			paths = append(paths, localPath)
+		}
+	}
+
+	if !syncer.targetStopped {
+		err := syncer.copyOrQueue(ctx, paths)
+		if !errors.Is(err, ErrTargetStopped) || !syncer.singleContainer() {
+			return err
+		}
+		syncer.targetStopped = true
+	}
+	return syncer.copyToStoppedTarget(ctx, paths)
+}
+
+// copyOrQueue copies the paths, queuing them if Docker can't be reached
+func (syncer *Syncer) copyOrQueue(ctx context.Context, paths []string) error {
+	err := syncer.copyBatch(ctx, paths)
+	if errors.Is(err, ErrDisconnected) {
+		syncer.pending = paths
+		syncer.reconnectInBackground(ctx)
+	}
+	return err
+}
+
+func (syncer *Syncer) copyBatch(ctx context.Context, localPaths []string) error {
+	var paths []string
+	var plan batchPlan
+	// modes are the files whose contents are in the target, but not their current permissions
+	modes := make(map[string]index.Entry)
+	// removed are the paths removed from the source, which backends delete from the target
+	var removed []string
+	for _, localPath := range localPaths {
+		info, err := os.Lstat(localPath)
+		if os.IsNotExist(err) && syncer.targetType == External && !syncer.ignore.Match(localPath, false) {
+			removed = append(removed, localPath)
+			continue
+		}
 		if err != nil {
-			return fmt.Errorf("failed to find container %s: %w", syncer.target, err)
+			syncer.logger.Debug("Skipping {path}: {error}", "path", localPath, "error", err)
+			continue
+		}
+		if syncer.ignore.Match(localPath, info.IsDir()) {
+			syncer.logger.Debug("Skipping ignored path {path}", "path", localPath)
+			continue
+		}
+		if info.Mode().IsRegular() {
+			modeChanged := syncer.modeChanged(localPath, info)
+			entry, changed, err := syncer.index.Check(localPath, info)
+			if err != nil {
+				return fmt.Errorf("failed to check %s for changes: %w", localPath, err)
+			}
+			if !changed && modeChanged && syncer.changesModesInPlace() {
+				entry.Mode = info.Mode().Perm()
+				modes[localPath] = entry
+				syncer.plan(&plan, localPath, false)
+				continue
+			}
+			if !changed && modeChanged {
+				// Copying the file again is the only way to change its mode in the target
+				syncer.index.Forget(localPath)
+				changed = true
+			}
+			if !changed {
+				syncer.logger.Debug("Skipping unchanged file {path}", "path", localPath)
+				continue
+			}
 		}
+		paths = append(paths, localPath)
+		syncer.plan(&plan, localPath, info.IsDir())
+	}
 
-		err = syncer.copyToContainer(localPath, container, syncer.targetPath)
+	if len(removed) > 0 {
+		err := syncer.retry(ctx, "deleting", func() error {
+			return syncer.deleteFromBackend(ctx, removed)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to copy to container %s: %w", container, err)
+			return fmt.Errorf("failed to delete from %s: %w", syncer.target, err)
 		}
-	} else if syncer.targetType == Container && syncer.restartTarget {
-		container, err := syncer.findTargetContainer()
+		for _, localPath := range removed {
+			syncer.index.Forget(localPath)
+		}
+	}
+
+	if len(paths) == 0 && len(modes) == 0 {
+		return nil
+	}
+
+	if syncer.execBefore != "" && syncer.stoppedContainer == "" {
+		err := syncer.retry(ctx, "running the command before sync", func() error {
+			return syncer.Exec(ctx, syncer.execBefore)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to find container %s: %w", syncer.target, err)
+			return fmt.Errorf("failed to run the command before sync: %w", err)
 		}
+	}
+
+	var shipped int
+	if len(paths) > 0 {
+		err := syncer.retry(ctx, "copying", func() error {
+			var err error
+			if syncer.targetType == Pod {
+				shipped, err = syncer.copyToPod(ctx, paths)
+				if err != nil {
+					return fmt.Errorf("failed to copy to %s: %w", syncer.kube, err)
+				}
+				return nil
+			}
+
+			if len(syncer.labels) > 0 {
+				shipped, err = syncer.copyToLabeledContainers(ctx, paths)
+				return err
+			}
+
+			if syncer.targetType == External {
+				shipped, err = syncer.copyToBackend(ctx, paths)
+				if err != nil {
+					return fmt.Errorf("failed to copy to %s: %w", syncer.target, err)
+				}
+				return nil
+			}
+
+			if syncer.targetType == SwarmObject {
+				shipped, err = syncer.rotateSwarmObject(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to update %s: %w", syncer.swarmObject, err)
+				}
+				return nil
+			}
 
-		err = syncer.copyToContainer(localPath, container, syncer.targetPath)
+			if syncer.targetType == Volume {
+				shipped, err = syncer.copyToContainer(ctx, paths, syncer.temporaryContainer, syncer.volumeTargetPath())
+				if err != nil {
+					return fmt.Errorf("failed to copy to %s: %w", syncer.volume, err)
+				}
+				return nil
+			}
+
+			if syncer.agent {
+				shipped, err = syncer.copyToAgents(ctx, paths)
+				return err
+			}
+
+			if syncer.strategy == StrategyVolumeServiceUpdate {
+				shipped, err = syncer.copyToContainer(ctx, paths, syncer.temporaryContainer, syncer.getTemporaryVolumePath())
+				if err != nil {
+					return fmt.Errorf("failed to copy to temporary container %s: %w", syncer.temporaryContainer, err)
+				}
+				return nil
+			}
+
+			if syncer.stoppedContainer != "" {
+				shipped, err = syncer.copyToStoppedContainer(ctx, paths)
+				if err != nil {
+					return fmt.Errorf("failed to copy to the stopped container %s: %w", syncer.stoppedContainer, err)
+				}
+				return nil
+			}
+
+			container, err := syncer.getTargetContainer(ctx)
+			if err != nil {
+				return err
+			}
+
+			shipped, err = syncer.copyToContainer(ctx, paths, container, syncer.targetPath)
+			if err != nil {
+				return fmt.Errorf("failed to copy to container %s: %w", container, err)
+			}
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("failed to copy to container %s: %w", container, err)
+			return err
 		}
+	}
 
-		err = syncer.recreateTargetContainer(true)
+	// Files whose permissions changed are already in the target, so they're only changed there
+	if len(modes) > 0 {
+		err := syncer.retry(ctx, "changing modes", func() error {
+			return syncer.changeModes(ctx, modes)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to restart container %s: %w", container, err)
+			return err
 		}
-	} else if syncer.targetType == Service && !syncer.restartTarget {
-		container, err := syncer.getContainerIdForTargetService()
+		shipped += len(modes)
+	}
+
+	if shipped == 0 {
+		syncer.logger.Debug("Nothing changed, skipping the restart")
+		return nil
+	}
+
+	// A stopped target gets the files when it starts again, and commands can't run in it
+	if syncer.stoppedContainer != "" {
+		return nil
+	}
+
+	// Builds run in the target before it's restarted, so that it restarts with their output
+	for _, command := range plan.builds {
+		err := syncer.retry(ctx, "running the build of a rule", func() error {
+			return syncer.Exec(ctx, command)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to container ID for service %s: %w", syncer.target, err)
+			return fmt.Errorf("failed to build with %q, not restarting the target: %w", command, err)
 		}
+	}
 
-		err = syncer.copyToContainer(localPath, container, syncer.targetPath)
+	// Files copied to a service restarted by recreating it only reach it with the restart,
+	// agents write into the volume the service already mounts
+	restart := plan.restart || (syncer.targetType == Service && syncer.strategy == StrategyVolumeServiceUpdate)
+	restartedAt := time.Now()
+	if !restart {
+		if syncer.restartTarget {
+			syncer.logger.Debug("No rule restarts the target for these changes, skipping the restart")
+		}
+	} else if syncer.restartSignal != "" {
+		err := syncer.signalTarget(ctx)
+		if err != nil {
+			return err
+		}
+	} else if syncer.restartMode == RestartModeRestart {
+		err := syncer.Restart(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to copy to container %s: %w", container, err)
+			return err
+		}
+	} else if syncer.targetType == Container && syncer.restartTarget {
+		syncer.recreated = true
+		err := syncer.retry(ctx, "restarting", func() error {
+			if len(syncer.labels) > 0 {
+				return syncer.forEachLabeledContainer(ctx, func(containerId string) error {
+					_, err := syncer.recreateContainer(ctx, containerId, true)
+					return err
+				})
+			}
+			return syncer.recreateTargetContainer(ctx, true)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restart container %s: %w", syncer.target, err)
 		}
 	} else if syncer.targetType == Service && syncer.restartTarget {
-		err := syncer.copyToContainer(localPath, syncer.temporaryContainer, syncer.getTemporaryVolumePath())
+		// The queued update reports the restart once it converges
+		syncer.scheduleServiceRestart(ctx)
+		restart = false
+	} else if syncer.targetType == Pod && syncer.restartTarget {
+		err := syncer.restartWorkload(ctx)
+		if err != nil {
+			return err
+		}
+	} else if syncer.targetType == External && syncer.restartTarget {
+		err := syncer.Restart(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if restart {
+		err := syncer.restarted(ctx, restartedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, command := range plan.commands {
+		err := syncer.retry(ctx, "running the command of a rule", func() error {
+			return syncer.Exec(ctx, command)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to copy to temporary container %s: %w", syncer.temporaryContainer, err)
+			return fmt.Errorf("failed to run %q: %w", command, err)
 		}
+	}
 
-		err = syncer.updateTargetService(true)
+	if syncer.execAfter != "" {
+		err := syncer.retry(ctx, "running the command after sync", func() error {
+			return syncer.Exec(ctx, syncer.execAfter)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to restart service %s: %w", syncer.target, err)
+			return fmt.Errorf("failed to run the command after sync: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func (syncer *Syncer) Cleanup() error {
-	syncer.logger.Println("Cleaning up...")
+// CopyAll copies the whole source, including the files that haven't changed since they were last copied
+func (syncer *Syncer) CopyAll(ctx context.Context) error {
+	syncer.index.Reset()
+	return syncer.CopyBatch(ctx, []string{syncer.sourcePath})
+}
+
+// Restart restarts the target container in place, keeping the files copied into it.
+// Services can't be restarted this way, since restarting them replaces their containers,
+// unless they are restarted with a signal
+func (syncer *Syncer) Restart(ctx context.Context) error {
+	if syncer.targetType == Volume {
+		return fmt.Errorf("%s has no containers to restart", syncer.volume)
+	}
+	if syncer.targetType == SwarmObject {
+		return fmt.Errorf("%s has no containers to restart", syncer.swarmObject)
+	}
+	if syncer.targetType == External {
+		return syncer.retry(ctx, "restarting", func() error {
+			err := syncer.backend.Restart(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to restart %s: %w", syncer.target, err)
+			}
+			return nil
+		})
+	}
+
+	if syncer.restartSignal != "" {
+		return syncer.signalTarget(ctx)
+	}
+
+	if syncer.targetType == Pod {
+		return syncer.restartWorkload(ctx)
+	}
 
-	ctx := context.Background()
+	if syncer.targetType == Service {
+		return fmt.Errorf("service %s can't be restarted without losing the copied files, watch it with --restart instead", syncer.target)
+	}
+
+	if syncer.compose != nil {
+		if _, err := syncer.getTargetContainer(ctx); err != nil {
+			return err
+		}
+	}
+
+	restart := func(containerId string) error {
+		syncer.logger.Debug("Restarting container {container}...", "container", containerId)
+		syncer.markOwnEvents(containerId)
+		timeout := stopTimeoutInSeconds
+		err := syncer.client.ContainerRestart(ctx, containerId, container.StopOptions{Timeout: &timeout})
+		if err != nil {
+			return fmt.Errorf("failed to restart container %s: %w", containerId, err)
+		}
+		return nil
+	}
+
+	return syncer.retry(ctx, "restarting", func() error {
+		if len(syncer.labels) > 0 {
+			return syncer.forEachLabeledContainer(ctx, restart)
+		}
+		return restart(syncer.target)
+	})
+}
+
+// Cleanup brings the target back to its original state and removes the temporary resources.
+// It should be given a fresh context when called after the main one was canceled
+func (syncer *Syncer) Cleanup(ctx context.Context) error {
+	syncer.discardChunks()
+
+	if syncer.targetType == Pod {
+		return nil
+	}
+
+	if syncer.targetType == Volume {
+		return syncer.cleanupVolume(ctx)
+	}
+
+	// The current version of a secret or config is in use by the services
+	if syncer.targetType == SwarmObject {
+		return nil
+	}
+
+	if syncer.targetType == External {
+		err := syncer.backend.Cleanup(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to clean up %s: %w", syncer.target, err)
+		}
+		return nil
+	}
+
+	syncer.logger.Debug("Cleaning up...")
 
 	if syncer.targetType == Container {
-		syncer.logger.Printf("Recreating container %s...", syncer.target)
-		err := syncer.recreateTargetContainer(false)
+		// Containers that were never replaced are already in their original state
+		if !syncer.recreated {
+			return nil
+		}
+
+		syncer.logger.Debug("Recreating container {container}...", "container", syncer.target)
+		var err error
+		if len(syncer.labels) > 0 {
+			err = syncer.forEachLabeledContainer(ctx, func(containerId string) error {
+				_, err := syncer.recreateContainer(ctx, containerId, false)
+				return err
+			})
+		} else {
+			err = syncer.recreateTargetContainer(ctx, false)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to restart target container %s: %w", syncer.target, err)
 		}
-	} else {
-		syncer.logger.Printf("Updating service %s...", syncer.target)
-		err := syncer.updateTargetService(false)
-		if err != nil {
-			return fmt.Errorf("failed to restart target service: %w", err)
+		syncer.recreated = false
+
+		if syncer.temporaryContainer != "" {
+			return syncer.removeTemporaryContainerWithVolume(ctx)
 		}
+		return nil
 	}
 
-	syncer.logger.Printf("Removing temporary container %s...", syncer.temporaryContainer)
-	err := syncer.client.ContainerRemove(ctx, syncer.temporaryContainer, container.RemoveOptions{
-		Force: true,
-	})
+	err := syncer.restarts.wait(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to remove temporary container %s: %w", syncer.temporaryContainer, err)
+		return fmt.Errorf("failed to wait for the restart of service %s: %w", syncer.target, err)
 	}
 
-	syncer.logger.Printf("Removing temporary volume %s...", syncer.temporaryVolume)
-	err = syncer.client.VolumeRemove(ctx, syncer.temporaryVolume, true)
+	// Services that never mounted a temporary volume are in their original state
+	if !syncer.agent && syncer.strategy != StrategyVolumeServiceUpdate {
+		return nil
+	}
+
+	syncer.logger.Debug("Updating service {service}...", "service", syncer.target)
+	_, err = syncer.updateTargetService(ctx, false)
 	if err != nil {
-		return fmt.Errorf("failed to remove temporary volume %s: %w", syncer.temporaryVolume, err)
+		return fmt.Errorf("failed to restart target service: %w", err)
 	}
 
-	syncer.temporaryContainer = ""
-	syncer.temporaryVolume = ""
+	if syncer.agent {
+		err := syncer.removeAgents(ctx)
+		if err != nil {
+			return err
+		}
+		// The volumes on the nodes are still used by the agents until their tasks are shut down
+		syncer.temporaryVolume = ""
+		return nil
+	}
 
-	return nil
+	return syncer.removeTemporaryContainerWithVolume(ctx)
 }
 
-func (syncer *Syncer) findContainerById(needle string) (string, error) {
-	containers, err := syncer.client.ContainerList(context.Background(), container.ListOptions{
+func (syncer *Syncer) findContainerById(ctx context.Context, needle string) (string, error) {
+	containers, err := syncer.client.ContainerList(ctx, container.ListOptions{
 		Filters: filters.NewArgs(filters.Arg("id", needle)),
 	})
 	if err != nil {
@@ -247,8 +1367,8 @@ func (syncer *Syncer) findContainerById(needle string) (string, error) {
 	return containers[0].ID, nil
 }
 
-func (syncer *Syncer) findContainerByName(needle string) (string, error) {
-	containers, err := syncer.client.ContainerList(context.Background(), container.ListOptions{
+func (syncer *Syncer) findContainerByName(ctx context.Context, needle string) (string, error) {
+	containers, err := syncer.client.ContainerList(ctx, container.ListOptions{
 		Filters: filters.NewArgs(filters.Arg("name", needle)),
 	})
 	if err != nil {
@@ -260,23 +1380,40 @@ func (syncer *Syncer) findContainerByName(needle string) (string, error) {
 	return containers[0].ID, nil
 }
 
-func (syncer *Syncer) findTargetContainer() (string, error) {
-	id, err := syncer.findContainerById(syncer.target)
+func (syncer *Syncer) findTargetContainer(ctx context.Context) (string, error) {
+	if syncer.compose != nil {
+		return syncer.findComposeContainer(ctx)
+	}
+
+	id, err := syncer.findContainerById(ctx, syncer.target)
 	if err != nil {
 		return "", fmt.Errorf("failed to find container by ID or name %s: %w", syncer.target, err)
 	}
 	if id != "" {
 		return id, nil
 	}
-	containerId, err := syncer.findContainerByName(syncer.target)
+	containerId, err := syncer.findContainerByName(ctx, syncer.target)
 	if err != nil {
 		return "", fmt.Errorf("failed to find container by ID or name %s: %w", syncer.target, err)
 	}
 	return containerId, nil
 }
 
-func (syncer *Syncer) findServiceById(needle string) (string, error) {
-	services, err := syncer.client.ServiceList(context.Background(), types.ServiceListOptions{
+// checkAmbiguousTarget fails when the target names a container as well as the service it was found as,
+// so that files aren't synced to the service when the container was meant
+func (syncer *Syncer) checkAmbiguousTarget(ctx context.Context) error {
+	container, err := syncer.findContainerByExactName(ctx, syncer.target)
+	if err != nil {
+		return fmt.Errorf("failed to find container %s: %w", syncer.target, err)
+	}
+	if container != "" {
+		return fmt.Errorf("both a service and a container are named %s, prefix the target with %s: or %s: to pick one", syncer.target, KindService, KindContainer)
+	}
+	return nil
+}
+
+func (syncer *Syncer) findServiceById(ctx context.Context, needle string) (string, error) {
+	services, err := syncer.client.ServiceList(ctx, types.ServiceListOptions{
 		Filters: filters.NewArgs(filters.Arg("id", needle)),
 	})
 	if err != nil {
@@ -288,8 +1425,8 @@ func (syncer *Syncer) findServiceById(needle string) (string, error) {
 	return services[0].ID, nil
 }
 
-func (syncer *Syncer) findServiceByName(needle string) (string, error) {
-	services, err := syncer.client.ServiceList(context.Background(), types.ServiceListOptions{
+func (syncer *Syncer) findServiceByName(ctx context.Context, needle string) (string, error) {
+	services, err := syncer.client.ServiceList(ctx, types.ServiceListOptions{
 		Filters: filters.NewArgs(filters.Arg("name", needle)),
 	})
 	if err != nil {
@@ -301,242 +1438,684 @@ func (syncer *Syncer) findServiceByName(needle string) (string, error) {
 	return services[0].ID, nil
 }
 
-func (syncer *Syncer) findTargetService() (string, error) {
-	id, err := syncer.findServiceById(syncer.target)
+func (syncer *Syncer) findTargetService(ctx context.Context) (string, error) {
+	id, err := syncer.findServiceById(ctx, syncer.target)
 	if err != nil {
 		return "", fmt.Errorf("failed to find service by ID or name %s: %w", syncer.target, err)
 	}
 	if id != "" {
 		return id, nil
 	}
-	return syncer.findServiceByName(syncer.target)
+	id, err = syncer.findServiceByName(ctx, syncer.target)
+	if err != nil || id != "" {
+		return id, err
+	}
+
+	// <service>.<slot> targets a single replica of the service
+	name, slot, ok := splitTaskSlot(syncer.target)
+	if !ok {
+		return "", nil
+	}
+	id, err = syncer.findServiceByName(ctx, name)
+	if err != nil || id == "" {
+		return "", err
+	}
+	if syncer.taskSlot != 0 && syncer.taskSlot != slot {
+		return "", fmt.Errorf("target %s is in slot %d but slot %d is given as well", syncer.target, slot, syncer.taskSlot)
+	}
+	syncer.taskSlot = slot
+	return id, nil
 }
 
-func (syncer *Syncer) getFirstRunningTaskForTargetService() (string, error) {
-	tasks, err := syncer.client.TaskList(context.Background(), types.TaskListOptions{
-		Filters: filters.NewArgs(
-			filters.Arg("service", syncer.target),
-			filters.Arg("desired-state", "running"),
-		),
-	})
+// splitTaskSlot splits a target in the <service>.<slot> format
+func splitTaskSlot(target string) (string, int, bool) {
+	i := strings.LastIndex(target, ".")
+	if i <= 0 {
+		return "", 0, false
+	}
+	slot, err := strconv.Atoi(target[i+1:])
+	if err != nil || slot < 1 {
+		return "", 0, false
+	}
+	return target[:i], slot, true
+}
+
+// describeTaskSelection describes the slot and the node tasks of the target service are picked from
+func (syncer *Syncer) describeTaskSelection() string {
+	description := ""
+	if syncer.taskSlot != 0 {
+		description += fmt.Sprintf(" in slot %d", syncer.taskSlot)
+	}
+	if syncer.node != "" {
+		description += " on node " + syncer.node
+	}
+	return description
+}
+
+// getRunningTaskForTargetService returns the first running task of the target service,
+// in the slot and on the node if they are given
+func (syncer *Syncer) getRunningTaskForTargetService(ctx context.Context) (string, error) {
+	args := filters.NewArgs(
+		filters.Arg("service", syncer.target),
+		filters.Arg("desired-state", "running"),
+	)
+	if syncer.node != "" {
+		args.Add("node", syncer.node)
+	}
+	tasks, err := syncer.client.TaskList(ctx, types.TaskListOptions{Filters: args})
 	if err != nil {
 		return "", fmt.Errorf("failed to list tasks: %w", err)
 	}
-	if len(tasks) == 0 {
-		return "", nil
+
+	for _, task := range tasks {
+		if syncer.taskSlot == 0 || task.Slot == syncer.taskSlot {
+			return task.ID, nil
+		}
 	}
-	return tasks[0].ID, nil
+	return "", nil
 }
 
-func (syncer *Syncer) getTaskContainerId(task string) (string, error) {
-	taskInfo, _, err := syncer.client.TaskInspectWithRaw(context.Background(), task)
+func (syncer *Syncer) getTaskContainerId(ctx context.Context, task string) (string, error) {
+	taskInfo, _, err := syncer.client.TaskInspectWithRaw(ctx, task)
 	if err != nil {
 		return "", fmt.Errorf("failed to inspect task %s: %w", task, err)
 	}
 	return taskInfo.Status.ContainerStatus.ContainerID, nil
 }
 
-func (syncer *Syncer) getContainerIdForTargetService() (string, error) {
-	task, err := syncer.getFirstRunningTaskForTargetService()
+func (syncer *Syncer) getContainerIdForTargetService(ctx context.Context) (string, error) {
+	task, err := syncer.getRunningTaskForTargetService(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get first running task for service %s: %w", syncer.target, err)
+		return "", fmt.Errorf("failed to get a running task for service %s: %w", syncer.target, err)
 	}
 	if task == "" {
 		return "", nil
 	}
-	containerId, err := syncer.getTaskContainerId(task)
+	containerId, err := syncer.getTaskContainerId(ctx, task)
 	if err != nil {
 		return "", fmt.Errorf("failed to get container ID for task %s: %w", task, err)
 	}
 	return containerId, nil
 }
 
-func (syncer *Syncer) recreateTargetContainer(mountTemporaryVolume bool) error {
-	ctx := context.Background()
-
-	containerInfo, err := syncer.client.ContainerInspect(ctx, syncer.target)
-	if err != nil {
-		return fmt.Errorf("failed to inspect container %s: %w", syncer.target, err)
+// updateTargetService makes the target service mount the temporary volume or not, replacing its tasks
+// with ones running the new spec, and returns the revision of the spec set by bumpService. The service is
+// inspected again and the update retried if it changed in the meantime
+func (syncer *Syncer) updateTargetService(ctx context.Context, mountTemporaryVolume bool) (string, error) {
+	for attempt := 1; ; attempt++ {
+		revision, err := syncer.updateTargetServiceOnce(ctx, mountTemporaryVolume)
+		if !isOutOfSequence(err) || attempt >= serviceUpdateAttempts {
+			return revision, err
+		}
+		syncer.logger.Debug("Service {service} changed while updating it, retrying with its current version...", "service", syncer.target)
 	}
+}
 
-	syncer.logger.Printf("Stopping container %s...", syncer.target)
-	timeout := stopTimeoutInSeconds
-	err = syncer.client.ContainerStop(ctx, syncer.target, container.StopOptions{Timeout: &timeout})
+func (syncer *Syncer) updateTargetServiceOnce(ctx context.Context, mountTemporaryVolume bool) (string, error) {
+	serviceInfo, _, err := syncer.client.ServiceInspectWithRaw(ctx, syncer.target, types.ServiceInspectOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to stop container %s: %w", syncer.target, err)
+		return "", fmt.Errorf("failed to inspect service %s: %w", syncer.target, err)
 	}
 
-	newConfig := containerInfo.Config
-	newHostConfig := containerInfo.HostConfig
+	spec := serviceInfo.Spec
+	revision := syncer.bumpService(&spec.TaskTemplate)
 
 	mounts := []mount.Mount{}
-	for _, mount := range newHostConfig.Mounts {
-		if mount.Source != syncer.temporaryVolume {
+	hadTempVolume := false
+	for _, mount := range spec.TaskTemplate.ContainerSpec.Mounts {
+		if mount.Source == syncer.temporaryVolume {
+			hadTempVolume = true
+		} else {
 			mounts = append(mounts, mount)
 		}
 	}
 
 	if mountTemporaryVolume {
-		syncer.logger.Println("Creating a container with a temporary volume...")
+		syncer.logger.Debug("Updating service {service} with temporary volume...", "service", syncer.target)
+		// Nodes other than the one the volume was created on create it when starting a task
 		newMount := mount.Mount{
-			Type:   mount.TypeVolume,
-			Source: syncer.temporaryVolume,
-			Target: syncer.targetPath,
+			Type:          mount.TypeVolume,
+			Source:        syncer.temporaryVolume,
+			Target:        syncer.targetPath,
+			VolumeOptions: syncer.temporaryVolumeOptions(),
 		}
-		newHostConfig.Mounts = append(mounts, newMount)
+		spec.TaskTemplate.ContainerSpec.Mounts = append(mounts, newMount)
 	} else {
-		syncer.logger.Println("Creating a container without temporary volumes...")
-		newHostConfig.Mounts = mounts
+		syncer.logger.Debug("Updating service {service} without temporary volume...", "service", syncer.target)
+		spec.TaskTemplate.ContainerSpec.Mounts = mounts
 	}
 
-	newTarget, err := syncer.client.ContainerCreate(ctx, newConfig, newHostConfig, nil, nil, "")
-	if err != nil {
-		return fmt.Errorf("failed to create new container: %w", err)
+	containerId := ""
+	if hadTempVolume {
+		containerId, _ = syncer.getContainerIdForTargetService(ctx)
 	}
-	syncer.target = newTarget.ID
 
-	syncer.logger.Println("Removing the old container...", syncer.target)
-	err = syncer.client.ContainerRemove(ctx, syncer.target, container.RemoveOptions{})
+	syncer.markOwnEvents(syncer.target)
+	_, err = syncer.client.ServiceUpdate(ctx, syncer.target, serviceInfo.Version, spec, types.ServiceUpdateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to remove old container %s: %w", syncer.target, err)
+		return "", fmt.Errorf("failed to update service %s: %w", syncer.target, err)
 	}
 
-	syncer.logger.Println("Starting the new container...", syncer.target)
-	err = syncer.client.ContainerStart(ctx, newTarget.ID, container.StartOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to start new container: %w", err)
+	if hadTempVolume && containerId != "" {
+		syncer.logger.Debug("Removing old container {container} for service {service}...", "container", containerId, "service", syncer.target)
+		syncer.client.ContainerRemove(ctx, containerId, container.RemoveOptions{
+			Force: true,
+		})
 	}
 
-	return nil
+	return revision, nil
 }
 
-func (syncer *Syncer) updateTargetService(mountTemporaryVolume bool) error {
-	serviceInfo, _, err := syncer.client.ServiceInspectWithRaw(context.Background(), syncer.target, types.ServiceInspectOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to inspect service %s: %w", syncer.target, err)
+// normalizeContainerPath makes the path inside the target absolute with forward slashes,
+// rejecting Windows paths, which Git Bash makes out of container paths like /app
+func normalizeContainerPath(containerPath string) (string, error) {
+	if containerPath == "" {
+		return "", nil
 	}
 
-	spec := serviceInfo.Spec
-	spec.TaskTemplate.ForceUpdate++
+	containerPath = strings.ReplaceAll(containerPath, `\`, "/")
+	if len(containerPath) >= 2 && containerPath[1] == ':' {
+		return "", fmt.Errorf("path %s in the target looks like a Windows path. If Git Bash converted it, start it with // (e.g. //app) or set MSYS_NO_PATHCONV=1", containerPath)
+	}
 
-	mounts := []mount.Mount{}
-	hadTempVolume := false
-	for _, mount := range spec.TaskTemplate.ContainerSpec.Mounts {
-		if mount.Source == syncer.temporaryVolume {
-			hadTempVolume = true
-		} else {
-			mounts = append(mounts, mount)
+	return path.Clean("/" + containerPath), nil
+}
+
+// containerPathFor maps a local path onto the container at its location relative to the source root,
+// however deep it is. Without a source root, paths are placed by their name. Paths outside of
+// the source root are an error rather than being placed by their name, which could overwrite
+// a file of the same name at the top of the target path
+func (syncer *Syncer) containerPathFor(localPath, containerPath string) (string, error) {
+	if syncer.sourcePath == "" {
+		return path.Join(containerPath, syncer.normalizeName(filepath.Base(localPath))), nil
+	}
+
+	relPath, ok := hostpath.Inside(syncer.sourcePath, localPath)
+	if !ok {
+		return "", fmt.Errorf("%s is outside of the source %s", localPath, syncer.sourcePath)
+	}
+	if syncer.flatten && relPath != "." {
+		relPath = path.Base(relPath)
+	}
+	return path.Join(containerPath, syncer.normalizeName(relPath)), nil
+}
+
+// archiveEntry is a file, directory or symlink to be written into an archive
+type archiveEntry struct {
+	path       string
+	info       os.FileInfo
+	headerPath string
+	// linkTarget is the target of a preserved symlink
+	linkTarget string
+}
+
+// collectEntries lists the files and directories to archive, leaving out
+// ignored paths and files unchanged since the last copy
+func (syncer *Syncer) collectEntries(ctx context.Context, sourcePaths []string, containerPath string) ([]archiveEntry, map[string]index.Entry, error) {
+	var entries []archiveEntry
+	pending := make(map[string]index.Entry)
+	seen := make(map[string]bool)
+	counter := &limitCounter{syncer: syncer}
+	collisions := syncer.newCollisionChecker()
+	// flattened maps the paths in the target of flattened files to the files copied there
+	flattened := make(map[string]string)
+
+	// Directories missing in the target would be created by the extraction with permissions
+	// and an owner of its choosing, so the ones inside the source go before the paths in them
+	addParents := func(localPath string) error {
+		var parents []string
+		for dir := filepath.Dir(localPath); !seen[dir]; dir = filepath.Dir(dir) {
+			if _, ok := hostpath.Inside(syncer.sourcePath, dir); !ok {
+				break
+			}
+			parents = append(parents, dir)
+			if dir == syncer.sourcePath {
+				break
+			}
+		}
+
+		for i := len(parents) - 1; i >= 0; i-- {
+			info, err := os.Stat(parents[i])
+			if err != nil {
+				return fmt.Errorf("failed to stat %s: %w", parents[i], err)
+			}
+			headerPath, err := syncer.containerPathFor(parents[i], containerPath)
+			if err != nil {
+				return err
+			}
+			seen[parents[i]] = true
+			entries = append(entries, archiveEntry{path: parents[i], info: info, headerPath: headerPath})
 		}
+		return nil
 	}
 
-	if mountTemporaryVolume {
-		syncer.logger.Printf("Updating service %s with temporary volume...\n", syncer.target)
-		newMount := mount.Mount{
-			Type:   mount.TypeVolume,
-			Source: syncer.temporaryVolume,
-			Target: syncer.targetPath,
+	addEntry := func(entry archiveEntry) error {
+		// A batch can contain both a directory and files inside of it
+		if seen[entry.path] {
+			return nil
 		}
-		spec.TaskTemplate.ContainerSpec.Mounts = append(mounts, newMount)
-	} else {
-		syncer.logger.Printf("Updating service %s without temporary volume...\n", syncer.target)
-		spec.TaskTemplate.ContainerSpec.Mounts = mounts
+		seen[entry.path] = true
+
+		colliding, other, err := collisions.check(entry.path)
+		if err != nil {
+			return err
+		}
+		if colliding != "" {
+			copied, err := syncer.handleCollision(colliding, other)
+			if err != nil || !copied {
+				return err
+			}
+		}
+
+		if syncer.flatten && entry.path != syncer.sourcePath {
+			// Only files end up in the target path, the directories they're in are left out
+			if entry.info.IsDir() {
+				return nil
+			}
+			entry.headerPath = path.Join(containerPath, syncer.normalizeName(filepath.Base(entry.path)))
+			if other, ok := flattened[entry.headerPath]; ok {
+				syncer.logger.Warn("Skipping {path}, which has the same name as {other} copied to {target}", "path", entry.path, "other", other, "target", entry.headerPath)
+				return nil
+			}
+			flattened[entry.headerPath] = entry.path
+		}
+
+		if syncer.copiesParents() {
+			err = addParents(entry.path)
+			if err != nil {
+				return err
+			}
+		}
+
+		if entry.info.Mode().IsRegular() {
+			indexEntry, changed, err := syncer.index.Check(entry.path, entry.info)
+			if err != nil {
+				return fmt.Errorf("failed to check %s for changes: %w", entry.path, err)
+			}
+			if !changed {
+				return nil
+			}
+			pending[entry.path] = indexEntry
+
+			err = counter.add(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		entries = append(entries, entry)
+		return nil
 	}
 
-	containerId := ""
-	if hadTempVolume {
-		containerId, _ = syncer.getContainerIdForTargetService()
+	for _, sourcePath := range sourcePaths {
+		sourcePath, err := hostpath.Abs(sourcePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+
+		// The source itself is always followed, even if it's a symlink
+		statSource := os.Lstat
+		if sourcePath == syncer.sourcePath {
+			statSource = os.Stat
+		}
+		sourceInfo, err := statSource(sourcePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to stat source: %w", err)
+		}
+
+		sourceHeaderPath, err := syncer.containerPathFor(sourcePath, containerPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = syncer.walkEntries(ctx, sourcePath, sourceInfo, sourceHeaderPath, make(map[string]bool), addEntry)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	_, err = syncer.client.ServiceUpdate(context.Background(), syncer.target, serviceInfo.Version, spec, types.ServiceUpdateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to update service %s: %w", syncer.target, err)
+	return entries, pending, nil
+}
+
+// walkEntries adds the path and, if it's a directory, everything inside of it, handling
+// symlinks according to the links mode. ancestors holds the real paths of the directories
+// being walked to detect symlink loops when following links
+func (syncer *Syncer) walkEntries(ctx context.Context, filePath string, info os.FileInfo, headerPath string, ancestors map[string]bool, addEntry func(archiveEntry) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	if hadTempVolume && containerId != "" {
-		syncer.logger.Printf("Removing old container %s for service %s...\n", containerId, syncer.target)
-		syncer.client.ContainerRemove(context.Background(), containerId, container.RemoveOptions{
-			Force: true,
-		})
+	if syncer.ignore.Match(filePath, info.IsDir()) {
+		return nil
 	}
 
-	return nil
-}
+	if info.Mode()&os.ModeSymlink != 0 {
+		switch syncer.links {
+		case LinksSkip:
+			syncer.logger.Debug("Skipping symlink {path}", "path", filePath)
+			return nil
+		case LinksFollow:
+			targetInfo, err := os.Stat(filePath)
+			if err != nil {
+				syncer.logger.Debug("Skipping dangling symlink {path}", "path", filePath)
+				return nil
+			}
+			info = targetInfo
+		default:
+			linkTarget, err := os.Readlink(filePath)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", filePath, err)
+			}
+			return addEntry(archiveEntry{path: filePath, info: info, headerPath: headerPath, linkTarget: linkTarget})
+		}
+	}
+
+	if !info.IsDir() {
+		return addEntry(archiveEntry{path: filePath, info: info, headerPath: headerPath})
+	}
 
-func (syncer *Syncer) copyToContainer(sourcePath, container, containerPath string) error {
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
+	realPath, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to walk path %s: %w", filePath, err)
+	}
+	if ancestors[realPath] {
+		syncer.logger.Debug("Skipping symlink loop at {path}", "path", filePath)
+		return nil
+	}
+	ancestors[realPath] = true
+	defer delete(ancestors, realPath)
 
-	sourcePath, err := filepath.Abs(sourcePath)
+	err = addEntry(archiveEntry{path: filePath, info: info, headerPath: headerPath})
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return err
 	}
 
-	sourceInfo, err := os.Stat(sourcePath)
+	children, err := os.ReadDir(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to stat source: %w", err)
+		return fmt.Errorf("failed to walk path %s: %w", filePath, err)
+	}
+
+	for _, child := range children {
+		childPath := filepath.Join(filePath, child.Name())
+		childInfo, err := child.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+		err = syncer.walkEntries(ctx, childPath, childInfo, path.Join(headerPath, syncer.normalizeName(child.Name())), ancestors, addEntry)
+		if err != nil {
+			return err
+		}
 	}
 
-	addToArchive := func(path string, info os.FileInfo, headerPath string) error {
-		header, err := tar.FileInfoHeader(info, "")
+	return nil
+}
+
+// archiveOptions change how writeArchive writes the entries
+type archiveOptions struct {
+	// rewrite is passed each header, and files that transform returns contents for
+	// are written with those contents instead
+	rewrite   func(*tar.Header)
+	transform func(string) ([]byte, bool, error)
+	// sparse archives sparse files with only their data
+	sparse bool
+	// xattrs adds the extended attributes of the entries to their headers
+	xattrs bool
+}
+
+// writeArchive writes the entries as a tar stream according to the options.
+// Hard links to a file archived before are archived as links
+func writeArchive(w io.Writer, entries []archiveEntry, tracker *progressTracker, options archiveOptions) error {
+	tw := tar.NewWriter(w)
+	// links holds the names in the archive of the files with several hard links
+	links := make(map[fileKey]string)
+
+	writeEntry := func(entry archiveEntry) error {
+		header, err := tar.FileInfoHeader(entry.info, entry.linkTarget)
 		if err != nil {
 			return fmt.Errorf("failed to create tar header: %w", err)
 		}
 
-		header.Name = headerPath
+		header.Name = entry.headerPath
+		if options.rewrite != nil {
+			options.rewrite(header)
+		}
 
-		if err := tw.WriteHeader(header); err != nil {
-			return fmt.Errorf("failed to write tar header: %w", err)
+		if options.xattrs {
+			xattrs, err := readXattrs(entry.path)
+			if err != nil {
+				return err
+			}
+			for name, value := range xattrs {
+				if header.PAXRecords == nil {
+					header.PAXRecords = make(map[string]string)
+				}
+				header.PAXRecords["SCHILY.xattr."+name] = value
+			}
 		}
 
-		if !info.IsDir() {
-			file, err := os.Open(path)
+		// The size of transformed files is only known once they're transformed
+		var transformed []byte
+		isTransformed := false
+		if entry.info.Mode().IsRegular() && options.transform != nil {
+			transformed, isTransformed, err = options.transform(entry.path)
 			if err != nil {
-				return fmt.Errorf("failed to open file: %w", err)
+				return err
+			}
+			if isTransformed {
+				header.Size = int64(len(transformed))
 			}
-			defer file.Close()
+		}
 
-			if _, err := io.Copy(tw, file); err != nil {
-				return fmt.Errorf("failed to copy file contents: %w", err)
+		if !entry.info.Mode().IsRegular() {
+			if err := tw.WriteHeader(header); err != nil {
+				return fmt.Errorf("failed to write tar header: %w", err)
 			}
+			return nil
 		}
 
-		return nil
-	}
+		if isTransformed {
+			if err := tw.WriteHeader(header); err != nil {
+				return fmt.Errorf("failed to write tar header: %w", err)
+			}
+			var contents io.Reader = bytes.NewReader(transformed)
+			if tracker != nil {
+				contents = tracker.startFile(entry.path, header.Size, contents)
+			}
+			if _, err := io.Copy(tw, contents); err != nil {
+				return fmt.Errorf("failed to copy contents of %s: %w", entry.path, err)
+			}
+			return nil
+		}
 
-	if sourceInfo.IsDir() {
-		err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return fmt.Errorf("failed to walk path %s: %w", sourcePath, err)
+		if key, ok := hardLinkKey(entry.info); ok {
+			if name, ok := links[key]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = name
+				header.Size = 0
+				if err := tw.WriteHeader(header); err != nil {
+					return fmt.Errorf("failed to write tar header: %w", err)
+				}
+				return nil
 			}
+			links[key] = header.Name
+		}
+
+		file, err := os.Open(entry.path)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
 
-			relPath, err := filepath.Rel(sourcePath, path)
+		if options.sparse {
+			fragments, err := dataFragments(file, entry.info)
 			if err != nil {
-				return fmt.Errorf("failed to get relative path: %w", err)
+				return fmt.Errorf("failed to find holes in %s: %w", entry.path, err)
 			}
+			if len(fragments) > 0 {
+				// The tar writer is done with the previous entry once flushed
+				if err := tw.Flush(); err != nil {
+					return fmt.Errorf("failed to write tar archive: %w", err)
+				}
+				if err := writeSparseFile(w, header, file, fragments, tracker, entry.path); err != nil {
+					return fmt.Errorf("failed to copy contents of %s: %w", entry.path, err)
+				}
+				return nil
+			}
+		}
 
-			headerPath := filepath.Join(containerPath, relPath)
-			headerPath = filepath.ToSlash(headerPath)
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
 
-			return addToArchive(path, info, headerPath)
-		})
-	} else {
-		headerPath := filepath.Join(containerPath, sourceInfo.Name())
-		headerPath = filepath.ToSlash(headerPath)
+		var contents io.Reader = file
+		if tracker != nil {
+			contents = tracker.startFile(entry.path, header.Size, file)
+		}
+
+		// The file might have grown since it was listed
+		if _, err := io.CopyN(tw, contents, header.Size); err != nil {
+			return fmt.Errorf("failed to copy contents of %s: %w", entry.path, err)
+		}
 
-		err = addToArchive(sourcePath, sourceInfo, headerPath)
+		return nil
 	}
 
-	if err != nil {
-		return fmt.Errorf("failed to create tar archive: %w", err)
+	for _, entry := range entries {
+		err := writeEntry(entry)
+		if err != nil {
+			return err
+		}
 	}
 
 	if err := tw.Close(); err != nil {
 		return fmt.Errorf("failed to close tar writer: %w", err)
 	}
 
-	err = syncer.client.CopyToContainer(context.Background(), container, "/", &buf, types.CopyToContainerOptions{
+	return nil
+}
+
+// writeCompressedArchive writes the entries as a tar stream compressed with the syncer's algorithm
+func (syncer *Syncer) writeCompressedArchive(w io.Writer, entries []archiveEntry, tracker *progressTracker, sparse bool) error {
+	cw, err := syncer.compressWriter(w)
+	if err != nil {
+		return err
+	}
+
+	err = writeArchive(cw, entries, tracker, archiveOptions{
+		rewrite:   syncer.rewriteHeader,
+		transform: syncer.transformFile,
+		sparse:    sparse,
+		xattrs:    syncer.xattrs,
+	})
+	if err != nil {
+		cw.Close()
+		return err
+	}
+
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("failed to compress tar archive: %w", err)
+	}
+	return nil
+}
+
+// copyToContainer streams the paths to the container in a single archive and returns
+// the number of entries in it. Files unchanged since the last copy are left out
+func (syncer *Syncer) copyToContainer(ctx context.Context, sourcePaths []string, container, containerPath string) (int, error) {
+	return syncer.uploadArchive(ctx, sourcePaths, containerPath, syncer.containerChunkTarget(container), func(reader io.Reader) error {
+		return syncer.workers.Do(ctx, container, func() error {
+			err := syncer.uploadToContainer(ctx, container, reader)
+			if err != nil || !syncer.atomicFiles {
+				return err
+			}
+			return syncer.swapInContainer(ctx, container)
+		})
+	})
+}
+
+func (syncer *Syncer) uploadToContainer(ctx context.Context, container string, reader io.Reader) error {
+	if syncer.strategy == StrategyExecExtract {
+		return syncer.extractInContainer(ctx, container, reader)
+	}
+	return syncer.client.CopyToContainer(ctx, container, "/", reader, types.CopyToContainerOptions{
 		AllowOverwriteDirWithFile: true,
 	})
+}
+
+// uploadArchive archives the paths placed under containerPath and passes the archive
+// to upload as a stream, or uploads it in chunks into the chunk target if it's large enough
+// and there is one. It returns the number of entries in the archive
+func (syncer *Syncer) uploadArchive(ctx context.Context, sourcePaths []string, containerPath string, chunks *chunkTarget, upload func(io.Reader) error) (int, error) {
+	entries, pending, err := syncer.collectEntries(ctx, sourcePaths, containerPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create tar archive: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	entries = syncer.stageEntries(entries)
+
+	tracker := syncer.newTracker(entries, 1)
+	if chunks != nil && syncer.chunked(entries) {
+		err = syncer.uploadChunks(ctx, entries, tracker, chunks)
+	} else {
+		err = syncer.streamArchive(ctx, entries, tracker, upload)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if tracker != nil {
+		tracker.finish()
+	}
+
+	syncer.countCopied(entries)
+	for path, entry := range pending {
+		syncer.index.Record(path, entry)
+	}
+
+	return len(entries), nil
+}
+
+// newTracker returns a tracker of uploading the entries the given number of times,
+// or nil if progress isn't reported
+func (syncer *Syncer) newTracker(entries []archiveEntry, uploads int) *progressTracker {
+	if syncer.onProgress == nil {
+		return nil
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if entry.info.Mode().IsRegular() {
+			total += entry.info.Size()
+		}
+	}
+	tracker := newProgressTracker(total*int64(uploads), syncer.onProgress)
+	tracker.progress.Limit = syncer.bandwidth.Limit()
+	return tracker
+}
+
+// streamArchive writes the entries as an archive while passing it to upload as a stream
+func (syncer *Syncer) streamArchive(ctx context.Context, entries []archiveEntry, tracker *progressTracker, upload func(io.Reader) error) error {
+	// The archive is written while it's being uploaded, so that it never has to be held in memory
+	reader, writer := io.Pipe()
+	writeErr := make(chan error, 1)
+	go func() {
+		err := syncer.writeCompressedArchive(writer, entries, tracker, syncer.writesSparse())
+		writer.CloseWithError(err)
+		writeErr <- err
+	}()
+
+	err := upload(syncer.bandwidth.reader(ctx, reader, tracker))
+
+	// Unblock the writer if the upload stopped before reading everything
+	reader.CloseWithError(io.ErrClosedPipe)
+	archiveErr := <-writeErr
+
+	if archiveErr != nil && !errors.Is(archiveErr, io.ErrClosedPipe) {
+		return fmt.Errorf("failed to create tar archive: %w", archiveErr)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to copy to container: %w", err)
 	}
@@ -544,14 +2123,14 @@ func (syncer *Syncer) copyToContainer(sourcePath, container, containerPath strin
 	return nil
 }
 
-func (syncer *Syncer) createTemporaryContainerWithVolume() error {
+func (syncer *Syncer) createTemporaryContainerWithVolume(ctx context.Context) error {
 	volumeName := syncer.generateTemporaryName()
-	syncer.logger.Printf("Creating temporary volume %s...\n", volumeName)
-	vol, err := syncer.client.VolumeCreate(context.Background(), volume.CreateOptions{
-		Name: volumeName,
-		Labels: map[string]string{
-			syncer.identifier: "true",
-		},
+	syncer.logger.Debug("Creating temporary volume {volume}...", "volume", volumeName)
+	vol, err := syncer.client.VolumeCreate(ctx, volume.CreateOptions{
+		Name:       volumeName,
+		Driver:     syncer.volumeDriver,
+		DriverOpts: syncer.volumeOptions,
+		Labels:     syncer.resourceLabels(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create volume: %w", err)
@@ -559,23 +2138,29 @@ func (syncer *Syncer) createTemporaryContainerWithVolume() error {
 
 	syncer.temporaryVolume = vol.Name
 
+	err = syncer.ensureHelperImage(ctx)
+	if err != nil {
+		return err
+	}
+
 	containerName := syncer.generateTemporaryName()
-	syncer.logger.Printf("Creating temporary container %s...\n", containerName)
-	container, err := syncer.client.ContainerCreate(context.Background(),
+	syncer.logger.Debug("Creating temporary container {container}...", "container", containerName)
+	container, err := syncer.client.ContainerCreate(ctx,
 		&container.Config{
-			Image: TemporaryContainerImage,
+			Image:  syncer.helperImage,
+			Labels: syncer.resourceLabels(),
 		},
 		&container.HostConfig{
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeVolume,
 					Source: vol.Name,
-					Target: syncer.getTemporaryVolumePath(),
+					Target: syncer.helperMountPath(syncer.getTemporaryVolumePath()),
 				},
 			},
 			AutoRemove: true,
 		},
-		nil, nil, containerName)
+		nil, syncer.helperPlatform, containerName)
 	if err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
 	}
@@ -584,3 +2169,24 @@ func (syncer *Syncer) createTemporaryContainerWithVolume() error {
 
 	return nil
 }
+
+func (syncer *Syncer) removeTemporaryContainerWithVolume(ctx context.Context) error {
+	syncer.logger.Debug("Removing temporary container {container}...", "container", syncer.temporaryContainer)
+	err := syncer.client.ContainerRemove(ctx, syncer.temporaryContainer, container.RemoveOptions{
+		Force: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove temporary container %s: %w", syncer.temporaryContainer, err)
+	}
+
+	syncer.logger.Debug("Removing temporary volume {volume}...", "volume", syncer.temporaryVolume)
+	err = syncer.client.VolumeRemove(ctx, syncer.temporaryVolume, true)
+	if err != nil {
+		return fmt.Errorf("failed to remove temporary volume %s: %w", syncer.temporaryVolume, err)
+	}
+
+	syncer.temporaryContainer = ""
+	syncer.temporaryVolume = ""
+
+	return nil
+}
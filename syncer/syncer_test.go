@@ -0,0 +1,690 @@
+package syncer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// writeTree creates the files under root, with their parents
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// largeTree writes a tree of 16 MiB in 256 files under root
+func largeTree(t *testing.T, root string) {
+	t.Helper()
+	files := make(map[string]string)
+	content := strings.Repeat("0123456789abcdef", 4096)
+	for i := 0; i < 256; i++ {
+		files[fmt.Sprintf("dir%d/file%d.bin", i%16, i)] = content
+	}
+	writeTree(t, root, files)
+}
+
+// checkGoroutines fails the test if more goroutines are running than before it, once they had time to stop
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines are still running, %d were before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// archiveDaemon is a Docker API that reads the archives uploaded to containers. After reading
+// abortAfter bytes of an archive, if it's set, it drops the connection, after waiting for stall
+// to be closed if it's set
+type archiveDaemon struct {
+	*httptest.Server
+	abortAfter int64
+	stall      chan struct{}
+
+	mu      sync.Mutex
+	entries int
+	size    int64
+}
+
+func newArchiveDaemon(abortAfter int64, stall chan struct{}) *archiveDaemon {
+	daemon := &archiveDaemon{abortAfter: abortAfter, stall: stall}
+	daemon.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasSuffix(r.URL.Path, "/archive") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		body := io.Reader(r.Body)
+		if daemon.abortAfter > 0 {
+			io.CopyN(io.Discard, body, daemon.abortAfter)
+			if daemon.stall != nil {
+				<-daemon.stall
+			}
+			panic(http.ErrAbortHandler)
+		}
+
+		tr := tar.NewReader(body)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			written, err := io.Copy(io.Discard, tr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			daemon.mu.Lock()
+			daemon.entries++
+			if header.Typeflag == tar.TypeReg {
+				daemon.size += written
+			}
+			daemon.mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return daemon
+}
+
+// newArchiveSyncer returns a syncer of a temporary directory connected to the daemon
+func newArchiveSyncer(t *testing.T, daemon *archiveDaemon) (*Syncer, string) {
+	t.Helper()
+	source := t.TempDir()
+	syncer, err := New(Options{Target: "web", TargetPath: "/app", SourcePath: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer.client, err = client.NewClientWithOpts(client.WithHost("tcp://" + daemon.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return syncer, source
+}
+
+func TestCopyToContainerStreamsLargeTree(t *testing.T) {
+	before := runtime.NumGoroutine()
+	daemon := newArchiveDaemon(0, nil)
+	syncer, source := newArchiveSyncer(t, daemon)
+	largeTree(t, source)
+
+	n, err := syncer.copyToContainer(context.Background(), []string{source}, "web", "/app")
+	if err != nil {
+		t.Fatalf("copyToContainer() failed: %v", err)
+	}
+	if n != daemon.entries {
+		t.Errorf("copyToContainer() = %d, but the archive has %d entries", n, daemon.entries)
+	}
+	if want := int64(256 * 64 * 1024); daemon.size != want {
+		t.Errorf("archive has %d bytes of files, want %d", daemon.size, want)
+	}
+
+	syncer.client.Close()
+	daemon.Close()
+	checkGoroutines(t, before)
+}
+
+func TestCopyToContainerAbortedMidStream(t *testing.T) {
+	before := runtime.NumGoroutine()
+	daemon := newArchiveDaemon(1<<20, nil)
+	syncer, source := newArchiveSyncer(t, daemon)
+	largeTree(t, source)
+
+	_, err := syncer.copyToContainer(context.Background(), []string{source}, "web", "/app")
+	if err == nil {
+		t.Fatal("copyToContainer() succeeded though the upload was aborted")
+	}
+
+	// Nothing is recorded as copied
+	file := filepath.Join(source, "dir0", "file0.bin")
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, changed, err := syncer.index.Check(file, info); err != nil || !changed {
+		t.Errorf("%s is recorded as copied after the upload was aborted", file)
+	}
+
+	syncer.client.Close()
+	daemon.Close()
+	checkGoroutines(t, before)
+}
+
+func TestCopyToContainerCancelled(t *testing.T) {
+	before := runtime.NumGoroutine()
+	stall := make(chan struct{})
+	daemon := newArchiveDaemon(1<<20, stall)
+	syncer, source := newArchiveSyncer(t, daemon)
+	largeTree(t, source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := syncer.copyToContainer(ctx, []string{source}, "web", "/app")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("copyToContainer() = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("copyToContainer() didn't return after its context was cancelled")
+	}
+
+	close(stall)
+	syncer.client.Close()
+	daemon.Close()
+	checkGoroutines(t, before)
+}
+
+func TestWalkEntriesLinks(t *testing.T) {
+	tests := []struct {
+		links string
+		// want maps the header paths of the entries to their link targets
+		want map[string]string
+	}{
+		{LinksPreserve, map[string]string{
+			"app":           "",
+			"app/file.txt":  "",
+			"app/dir":       "",
+			"app/dir/loop":  "..",
+			"app/dangling":  "missing",
+			"app/self":      "self",
+			"app/file-link": "file.txt",
+		}},
+		// Dangling links, links to themselves and links back to an ancestor are skipped
+		{LinksFollow, map[string]string{
+			"app":           "",
+			"app/file.txt":  "",
+			"app/dir":       "",
+			"app/file-link": "",
+		}},
+		{LinksSkip, map[string]string{
+			"app":          "",
+			"app/file.txt": "",
+			"app/dir":      "",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.links, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, map[string]string{"file.txt": "text"})
+			if err := os.Mkdir(filepath.Join(root, "dir"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for link, target := range map[string]string{"dir/loop": "..", "dangling": "missing", "self": "self", "file-link": "file.txt"} {
+				if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+					t.Skipf("symlinks can't be created: %v", err)
+				}
+			}
+
+			syncer, err := New(Options{Target: "web", TargetPath: "/app", SourcePath: root, Links: test.links})
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Lstat(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]string)
+			err = syncer.walkEntries(context.Background(), root, info, "app", make(map[string]bool), func(entry archiveEntry) error {
+				got[entry.headerPath] = entry.linkTarget
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("walkEntries() failed: %v", err)
+			}
+			if !maps.Equal(got, test.want) {
+				t.Errorf("walkEntries() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCopyToContainerExtractsIntoContainer(t *testing.T) {
+	fake := newFakeClient()
+	fake.addContainer("web")
+	source := t.TempDir()
+	syncer, err := New(Options{Target: "web", TargetPath: "/app", SourcePath: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer.client = fake
+	writeTree(t, source, map[string]string{"index.html": "<h1>", "css/site.css": "body {}"})
+
+	_, err = syncer.copyToContainer(context.Background(), []string{source}, "web", "/app")
+	if err != nil {
+		t.Fatalf("copyToContainer() failed: %v", err)
+	}
+	for path, want := range map[string]string{"/app/index.html": "<h1>", "/app/css/site.css": "body {}"} {
+		file, ok := fake.file("web", path)
+		if !ok {
+			t.Fatalf("%s wasn't copied", path)
+		}
+		if file.content != want {
+			t.Errorf("%s = %q, want %q", path, file.content, want)
+		}
+	}
+	if calls := fake.recorded(); len(calls) != 1 || calls[0] != "copy web" {
+		t.Errorf("calls = %v, want [copy web]", calls)
+	}
+}
+
+// newTestSyncer returns an initialized syncer copying a temporary directory into /app of the target
+// container, with the options changed by configure
+func newTestSyncer(t *testing.T, dockerClient DockerClient, target string, configure func(*Options)) (*Syncer, string) {
+	t.Helper()
+	source := t.TempDir()
+	options := Options{
+		Client:     dockerClient,
+		Target:     target,
+		TargetPath: "/app",
+		SourcePath: source,
+	}
+	if configure != nil {
+		configure(&options)
+	}
+
+	syncer, err := New(options)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	err = syncer.Init(context.Background())
+	if err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+	return syncer, source
+}
+
+func TestCopyBatchCopiesIntoContainer(t *testing.T) {
+	fake := newFakeClient()
+	fake.addContainer("web")
+	syncer, source := newTestSyncer(t, fake, "web", nil)
+	writeTree(t, source, map[string]string{"index.html": "<h1>", "css/site.css": "body {}"})
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	for path, want := range map[string]string{"/app/index.html": "<h1>", "/app/css/site.css": "body {}"} {
+		file, ok := fake.file("web", path)
+		if !ok {
+			t.Fatalf("%s wasn't copied", path)
+		}
+		if file.content != want {
+			t.Errorf("%s = %q, want %q", path, file.content, want)
+		}
+	}
+
+	// Unchanged files are skipped
+	copies := len(fake.recorded())
+	err = syncer.CopyBatch(context.Background(), []string{filepath.Join(source, "index.html")})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	if calls := fake.recorded(); len(calls) != copies {
+		t.Errorf("unchanged file was copied again: %v", calls[copies:])
+	}
+}
+
+func TestRestartRestartsInPlace(t *testing.T) {
+	fake := newFakeClient()
+	id := fake.addContainer("web")
+	syncer, _ := newTestSyncer(t, fake, "web", nil)
+
+	err := syncer.Restart(context.Background())
+	if err != nil {
+		t.Fatalf("Restart() failed: %v", err)
+	}
+	want := []string{"restart web"}
+	if calls := fake.recorded(); !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if syncer.target != id {
+		t.Errorf("target = %s, want the same container %s", syncer.target, id)
+	}
+}
+
+func TestCopyBatchRestartsInPlace(t *testing.T) {
+	fake := newFakeClient()
+	id := fake.addContainer("web")
+	syncer, source := newTestSyncer(t, fake, "web", func(options *Options) {
+		options.RestartTarget = true
+		options.RestartMode = RestartModeRestart
+	})
+	writeTree(t, source, map[string]string{"main.go": "package main"})
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	want := []string{"copy web", "restart web"}
+	if calls := fake.recorded(); !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if syncer.target != id {
+		t.Errorf("target = %s, want the same container %s", syncer.target, id)
+	}
+
+	// Containers restarted in place are already in their original state
+	err = syncer.Cleanup(context.Background())
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+	if calls := fake.recorded(); !slices.Equal(calls, want) {
+		t.Errorf("Cleanup() made calls %v", calls[len(want):])
+	}
+}
+
+func TestCopyBatchRecreatesTarget(t *testing.T) {
+	fake := newFakeClient()
+	id := fake.addContainer("web")
+	fake.byName("web").info.HostConfig.RestartPolicy.Name = "unless-stopped"
+	syncer, source := newTestSyncer(t, fake, "web", func(options *Options) {
+		options.RestartTarget = true
+	})
+	writeTree(t, source, map[string]string{"main.go": "package main"})
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	want := []string{
+		"copy web",
+		"stop web",
+		"rename web web" + replacedSuffix,
+		"create web",
+		"remove web" + replacedSuffix,
+		"start web",
+	}
+	if calls := fake.recorded(); !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	replacement := fake.byName("web")
+	if replacement == nil {
+		t.Fatal("container web wasn't recreated")
+	}
+	if syncer.target == id || syncer.target != replacement.info.ID {
+		t.Errorf("target = %s, want the new container %s", syncer.target, replacement.info.ID)
+	}
+	if policy := replacement.info.HostConfig.RestartPolicy.Name; policy != "unless-stopped" {
+		t.Errorf("restart policy = %s, want unless-stopped", policy)
+	}
+	if !replacement.info.State.Running {
+		t.Error("the new container isn't running")
+	}
+}
+
+func TestCleanupRecreatesTarget(t *testing.T) {
+	fake := newFakeClient()
+	fake.addContainer("web")
+	syncer, source := newTestSyncer(t, fake, "web", func(options *Options) {
+		options.RestartTarget = true
+	})
+	writeTree(t, source, map[string]string{"main.go": "package main"})
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	copied := len(fake.recorded())
+
+	err = syncer.Cleanup(context.Background())
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+	want := []string{
+		"stop web",
+		"rename web web" + replacedSuffix,
+		"create web",
+		"remove web" + replacedSuffix,
+		"start web",
+	}
+	if calls := fake.recorded()[copied:]; !slices.Equal(calls, want) {
+		t.Errorf("Cleanup() made calls %v, want %v", calls, want)
+	}
+	if syncer.recreated {
+		t.Error("the target is still marked as recreated after cleaning up")
+	}
+
+	// Cleaning up again has nothing to do
+	cleaned := len(fake.recorded())
+	err = syncer.Cleanup(context.Background())
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+	if calls := fake.recorded(); len(calls) != cleaned {
+		t.Errorf("second Cleanup() made calls %v", calls[cleaned:])
+	}
+}
+
+func TestCopyBatchQueuesForStoppedTarget(t *testing.T) {
+	fake := newFakeClient()
+	fake.addContainer("web")
+	syncer, source := newTestSyncer(t, fake, "web", nil)
+	writeTree(t, source, map[string]string{"index.html": "<h1>"})
+
+	err := fake.ContainerStop(context.Background(), "web", container.StopOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syncer.CopyBatch(context.Background(), []string{source})
+	if !errors.Is(err, ErrTargetStopped) {
+		t.Fatalf("CopyBatch() = %v, want %v", err, ErrTargetStopped)
+	}
+	if _, ok := fake.file("web", "/app/index.html"); ok {
+		t.Fatal("file was copied into the stopped container")
+	}
+
+	// The queued paths are copied with the next batch once the container runs again
+	err = fake.ContainerStart(context.Background(), "web", container.StartOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syncer.CopyBatch(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	if _, ok := fake.file("web", "/app/index.html"); !ok {
+		t.Error("queued file wasn't copied once the container started")
+	}
+}
+
+func TestNormalizeContainerPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"/app", "/app", false},
+		{"app/web/", "/app/web", false},
+		{`\app\web`, "/app/web", false},
+		{`app\static\..\web`, "/app/web", false},
+		{"//app", "/app", false},
+		{"C:/Program Files/Git/app", "", true},
+		{`C:\app`, "", true},
+	}
+	for _, test := range tests {
+		got, err := normalizeContainerPath(test.path)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("normalizeContainerPath(%q) = %q, %v, want %q and an error: %v", test.path, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestCopyBatchModes(t *testing.T) {
+	fake := newFakeClient()
+	fake.addContainer("web")
+	syncer, source := newTestSyncer(t, fake, "web", nil)
+	writeTree(t, source, map[string]string{
+		"web/static/site.css": "body {}",
+		"bin/run.sh":          "#!/bin/sh",
+		"LICENSE":             "MIT",
+	})
+
+	// Windows has no permissions of its own, read-only files are the only ones that differ
+	modes := []struct {
+		name    string
+		mode    os.FileMode
+		want    os.FileMode
+		windows os.FileMode
+	}{
+		{"web/static/site.css", 0o640, 0o640, 0o644},
+		{"bin/run.sh", 0o750, 0o750, 0o644},
+		{"LICENSE", 0o444, 0o444, 0o444},
+		{"web/static", 0o750, 0o750, 0o755},
+		{"web", 0o755, 0o755, 0o755},
+		{"bin", 0o700, 0o700, 0o755},
+	}
+	for _, mode := range modes {
+		if err := os.Chmod(filepath.Join(source, filepath.FromSlash(mode.name)), mode.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { os.Chmod(filepath.Join(source, "LICENSE"), 0o644) })
+
+	err := syncer.CopyBatch(context.Background(), []string{source})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+
+	for name := range fake.byName("web").files {
+		if strings.Contains(name, `\`) {
+			t.Errorf("%q isn't a container path", name)
+		}
+	}
+	for _, mode := range modes {
+		name := path.Join("/app", mode.name)
+		file, ok := fake.file("web", name)
+		if !ok {
+			t.Errorf("%s wasn't copied", name)
+			continue
+		}
+		want := mode.want
+		if runtime.GOOS == "windows" {
+			want = mode.windows
+		}
+		if file.mode.Perm() != want {
+			t.Errorf("mode of %s = %o, want %o", name, file.mode.Perm(), want)
+		}
+	}
+}
+
+func TestContainerPathFor(t *testing.T) {
+	source := t.TempDir()
+	tests := []struct {
+		name      string
+		localPath string
+		flatten   bool
+		want      string
+	}{
+		{"source", source, false, "/app"},
+		{"top-level file", filepath.Join(source, "main.go"), false, "/app/main.go"},
+		{"nested file", filepath.Join(source, "web", "static", "css", "site.css"), false, "/app/web/static/css/site.css"},
+		{"nested directory", filepath.Join(source, "web", "static"), false, "/app/web/static"},
+		{"unclean path", filepath.Join(source, "web") + string(filepath.Separator) + ".." + string(filepath.Separator) + "lib", false, "/app/lib"},
+		{"flattened nested file", filepath.Join(source, "web", "static", "css", "site.css"), true, "/app/site.css"},
+		{"flattened source", source, true, "/app"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			syncer, err := New(Options{Client: newFakeClient(), Target: "web", TargetPath: "/app", SourcePath: source, Flatten: test.flatten})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := syncer.containerPathFor(test.localPath, syncer.targetPath)
+			if err != nil {
+				t.Fatalf("containerPathFor(%s) failed: %v", test.localPath, err)
+			}
+			if got != test.want {
+				t.Errorf("containerPathFor(%s) = %s, want %s", test.localPath, got, test.want)
+			}
+		})
+	}
+
+	syncer, err := New(Options{Client: newFakeClient(), Target: "web", TargetPath: "/app", SourcePath: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(filepath.Dir(source), filepath.Base(source)+"-other", "main.go")
+	if got, err := syncer.containerPathFor(outside, syncer.targetPath); err == nil {
+		t.Errorf("containerPathFor(%s) = %s for a path outside of the source, want an error", outside, got)
+	}
+}
+
+func TestCopyBatchPlacesChangedFiles(t *testing.T) {
+	fake := newFakeClient()
+	fake.addContainer("web")
+	syncer, source := newTestSyncer(t, fake, "web", nil)
+	writeTree(t, source, map[string]string{
+		"web/static/css/site.css": "body {}",
+		"web/static/js/app.js":    "main()",
+		"README.md":               "# web",
+	})
+
+	// Only the changed file is copied, to its path relative to the source under the target path
+	changed := filepath.Join(source, "web", "static", "css", "site.css")
+	err := syncer.CopyBatch(context.Background(), []string{changed})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	for _, want := range []string{"/app/web", "/app/web/static", "/app/web/static/css", "/app/web/static/css/site.css"} {
+		if _, ok := fake.file("web", want); !ok {
+			t.Errorf("%s wasn't copied", want)
+		}
+	}
+	for _, unwanted := range []string{"/app/site.css", "/app/web/static/js/app.js", "/app/README.md"} {
+		if _, ok := fake.file("web", unwanted); ok {
+			t.Errorf("%s was copied, though it didn't change", unwanted)
+		}
+	}
+
+	// A renamed file is copied to its new path, its old one is gone from the source
+	renamed := filepath.Join(source, "web", "site.css")
+	if err := os.Rename(changed, renamed); err != nil {
+		t.Fatal(err)
+	}
+	err = syncer.CopyBatch(context.Background(), []string{changed, renamed})
+	if err != nil {
+		t.Fatalf("CopyBatch() failed: %v", err)
+	}
+	if file, ok := fake.file("web", "/app/web/site.css"); !ok || file.content != "body {}" {
+		t.Errorf("the renamed file wasn't copied to /app/web/site.css")
+	}
+}
@@ -0,0 +1,77 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+// TargetInfo is a running container or a service files can be synced into
+type TargetInfo struct {
+	// Kind is KindContainer or KindService
+	Kind  string
+	Name  string
+	Image string
+	// Mounts are the paths volumes and bind mounts are mounted at, which keep synced files
+	// when the target is recreated
+	Mounts []string
+}
+
+// FindTargets lists the running containers and the services of the host, except the ones created
+// by syncers and the containers of services, which are synced through their service
+func (syncer *Syncer) FindTargets(ctx context.Context) ([]TargetInfo, error) {
+	containers, err := syncer.client.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var targets []TargetInfo
+	for _, info := range containers {
+		if _, ok := info.Labels[syncer.identifier]; ok || info.Labels["com.docker.swarm.service.name"] != "" || len(info.Names) == 0 {
+			continue
+		}
+		target := TargetInfo{
+			Kind:  KindContainer,
+			Name:  strings.TrimPrefix(info.Names[0], "/"),
+			Image: info.Image,
+		}
+		for _, mount := range info.Mounts {
+			target.Mounts = append(target.Mounts, mount.Destination)
+		}
+		sort.Strings(target.Mounts)
+		targets = append(targets, target)
+	}
+
+	if syncer.provider.supportsServices() {
+		services, err := syncer.client.ServiceList(ctx, types.ServiceListOptions{})
+		// Daemons outside of a swarm have no services
+		if err != nil && !errdefs.IsUnavailable(err) {
+			return nil, fmt.Errorf("failed to list services: %w", err)
+		}
+		for _, info := range services {
+			if _, ok := info.Spec.Labels[syncer.identifier]; ok {
+				continue
+			}
+			target := TargetInfo{Kind: KindService, Name: info.Spec.Name}
+			if containerSpec := info.Spec.TaskTemplate.ContainerSpec; containerSpec != nil {
+				// Images of services are pinned by digest, which doesn't help telling them apart
+				target.Image, _, _ = strings.Cut(containerSpec.Image, "@")
+				for _, mount := range containerSpec.Mounts {
+					target.Mounts = append(target.Mounts, mount.Target)
+				}
+			}
+			sort.Strings(target.Mounts)
+			targets = append(targets, target)
+		}
+	}
+
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i].Name < targets[j].Name
+	})
+	return targets, nil
+}
@@ -0,0 +1,57 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// withTrace makes the client log every request to the Docker API. The client needs its transport
+// to stay the *http.Transport configured by the other options to use TLS and to dial hijacked
+// connections, like the ones of exec, so the requests are routed through tracingTransport by
+// registering it for their schemes instead of replacing the transport. It has to come after
+// the options that configure the transport
+func withTrace(logger *slog.Logger) client.Opt {
+	return func(c *client.Client) error {
+		transport, ok := c.HTTPClient().Transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("can't trace requests sent through %T", c.HTTPClient().Transport)
+		}
+		tracing := &tracingTransport{transport: transport, logger: logger}
+		transport.RegisterProtocol("http", tracing)
+		transport.RegisterProtocol("https", tracing)
+		return nil
+	}
+}
+
+// tracedKey marks the context of requests already logged by tracingTransport
+type tracedKey struct{}
+
+// tracingTransport logs the method, the path with the query, the status and the duration of
+// requests. Streamed responses, like logs and events, are logged once their headers arrive.
+// Hijacked connections are dialed and upgraded without the transport, so they aren't logged
+type tracingTransport struct {
+	transport http.RoundTripper
+	logger    *slog.Logger
+}
+
+func (t *tracingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// The request is sent again through the transport, which hands it back here first
+	if request.Context().Value(tracedKey{}) != nil {
+		return nil, http.ErrSkipAltProtocol
+	}
+
+	start := time.Now()
+	response, err := t.transport.RoundTrip(request.WithContext(context.WithValue(request.Context(), tracedKey{}, true)))
+	duration := time.Since(start).Round(time.Microsecond).String()
+	if err != nil {
+		t.logger.Info("Docker API {method} {path} failed after {duration}: {error}", "method", request.Method, "path", request.URL.RequestURI(), "duration", duration, "error", err)
+		return nil, err
+	}
+	t.logger.Info("Docker API {method} {path}: {status} in {duration}", "method", request.Method, "path", request.URL.RequestURI(), "status", response.StatusCode, "duration", duration)
+	return response, nil
+}
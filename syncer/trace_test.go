@@ -0,0 +1,131 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/axtgr/docker-sync/logger"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// newTraceDaemon returns a Docker API answering pings and container lists, and streaming
+// "output" over the hijacked connection of exec starts
+func newTraceDaemon(t *testing.T) *httptest.Server {
+	t.Helper()
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Write([]byte("OK"))
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("[]"))
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			conn, buffered, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			buffered.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\noutput")
+			buffered.Flush()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(daemon.Close)
+	return daemon
+}
+
+func TestTraceLogsRequests(t *testing.T) {
+	daemon := newTraceDaemon(t)
+	var output bytes.Buffer
+	traceLogger, err := logger.New(logger.Options{Stdout: &output, Level: slog.LevelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The daemon is reached through a dialer of its own like over SSH, which hijacked connections have to use too
+	dialer := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", daemon.Listener.Addr().String())
+	}
+	dockerClient, err := client.NewClientWithOpts(
+		client.WithHost("http://docker.example"),
+		client.WithDialContext(dialer),
+		client.WithVersion("1.46"),
+		withTrace(traceLogger),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dockerClient.Close()
+
+	ctx := context.Background()
+	if _, err := dockerClient.Ping(ctx); err != nil {
+		t.Fatalf("Ping() failed: %v", err)
+	}
+	_, err = dockerClient.ContainerList(ctx, container.ListOptions{Filters: filters.NewArgs(filters.Arg("name", "web"))})
+	if err != nil {
+		t.Fatalf("ContainerList() failed: %v", err)
+	}
+	if _, err := dockerClient.ContainerInspect(ctx, "missing"); err == nil {
+		t.Fatal("ContainerInspect() of a missing container succeeded")
+	}
+
+	hijacked, err := dockerClient.ContainerExecAttach(ctx, "exec", container.ExecAttachOptions{})
+	if err != nil {
+		t.Fatalf("ContainerExecAttach() failed: %v", err)
+	}
+	streamed, err := io.ReadAll(hijacked.Reader)
+	hijacked.Close()
+	if err != nil || string(streamed) != "output" {
+		t.Errorf("hijacked connection streamed %q, %v, want %q", streamed, err, "output")
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	want := []*regexp.Regexp{
+		regexp.MustCompile(`^Docker API HEAD /_ping: 200 in \S+$`),
+		regexp.MustCompile(`^Docker API GET /v1\.46/containers/json\?filters=%7B%22name%22%3A%7B%22web%22%3Atrue%7D%7D: 200 in \S+$`),
+		regexp.MustCompile(`^Docker API GET /v1\.46/containers/missing/json: 404 in \S+$`),
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(want), output.String())
+	}
+	for i, pattern := range want {
+		if !pattern.MatchString(lines[i]) {
+			t.Errorf("line %d = %q, want it to match %s", i+1, lines[i], pattern)
+		}
+	}
+}
+
+func TestTraceLogsFailedRequests(t *testing.T) {
+	daemon := newTraceDaemon(t)
+	var output bytes.Buffer
+	traceLogger, err := logger.New(logger.Options{Stdout: &output, Level: slog.LevelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dockerClient, err := client.NewClientWithOpts(client.WithHost("tcp://"+daemon.Listener.Addr().String()), client.WithVersion("1.46"), withTrace(traceLogger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dockerClient.Close()
+	daemon.Close()
+
+	if _, err := dockerClient.ServerVersion(context.Background()); err == nil {
+		t.Fatal("ServerVersion() succeeded though the daemon is gone")
+	}
+	pattern := regexp.MustCompile(`^Docker API GET /v1\.46/version failed after \S+: .+$`)
+	if line := strings.TrimSpace(output.String()); !pattern.MatchString(line) {
+		t.Errorf("logged %q, want it to match %s", line, pattern)
+	}
+}
@@ -0,0 +1,134 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/axtgr/docker-sync/ignore"
+	"github.com/axtgr/docker-sync/index"
+)
+
+// Filters of transforms
+const (
+	// FilterEnvsubst replaces $VAR and ${VAR} with the environment variables of docker-sync,
+	// leaving references to unset variables as they are
+	FilterEnvsubst = "envsubst"
+	// FilterCRLF converts line endings to CRLF
+	FilterCRLF = "crlf"
+	// FilterLF converts line endings to LF
+	FilterLF = "lf"
+	// FilterStripSourceMaps removes sourceMappingURL comments from JavaScript and CSS files
+	FilterStripSourceMaps = "strip-source-maps"
+)
+
+var (
+	envReference     = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+	sourceMapComment = regexp.MustCompile(`(?m)^[ \t]*(?://[#@] sourceMappingURL=[^\r\n]*|/\*[#@] sourceMappingURL=[^\r\n]*\*/)[ \t]*(?:\r?\n|$)`)
+)
+
+// contentFilters are the functions transforming file contents by their names
+var contentFilters = map[string]func([]byte) []byte{
+	FilterEnvsubst: func(contents []byte) []byte {
+		return envReference.ReplaceAllFunc(contents, func(reference []byte) []byte {
+			match := envReference.FindSubmatch(reference)
+			name := match[1]
+			if len(name) == 0 {
+				name = match[2]
+			}
+			if value, ok := os.LookupEnv(string(name)); ok {
+				return []byte(value)
+			}
+			return reference
+		})
+	},
+	FilterCRLF: func(contents []byte) []byte {
+		contents = bytes.ReplaceAll(contents, []byte("\r\n"), []byte("\n"))
+		return bytes.ReplaceAll(contents, []byte("\n"), []byte("\r\n"))
+	},
+	FilterLF: func(contents []byte) []byte {
+		return bytes.ReplaceAll(contents, []byte("\r\n"), []byte("\n"))
+	},
+	FilterStripSourceMaps: func(contents []byte) []byte {
+		return sourceMapComment.ReplaceAll(contents, nil)
+	},
+}
+
+// Transform changes the contents of files matching its patterns before they're copied,
+// by passing them through its filters in order
+type Transform struct {
+	// Match are gitignore-style patterns relative to the source, e.g. *.conf.tmpl or dist/*.js
+	Match   []string
+	Filters []string
+}
+
+// compiledTransform is a transform with its patterns compiled
+type compiledTransform struct {
+	Transform
+	matcher *ignore.Matcher
+}
+
+// compileTransforms checks the transforms and compiles their patterns relative to the source
+func compileTransforms(transforms []Transform, sourcePath string) ([]compiledTransform, error) {
+	if len(transforms) > 0 && sourcePath == "" {
+		return nil, fmt.Errorf("transforms require a source path")
+	}
+
+	compiled := make([]compiledTransform, 0, len(transforms))
+	for i, transform := range transforms {
+		if len(transform.Match) == 0 {
+			return nil, fmt.Errorf("transform #%d has no patterns to match", i+1)
+		}
+		if len(transform.Filters) == 0 {
+			return nil, fmt.Errorf("transform #%d has no filters", i+1)
+		}
+		for _, name := range transform.Filters {
+			if _, ok := contentFilters[name]; !ok {
+				return nil, fmt.Errorf("transform #%d has unknown filter %s, expected %s, %s, %s or %s", i+1, name, FilterEnvsubst, FilterCRLF, FilterLF, FilterStripSourceMaps)
+			}
+		}
+
+		matcher, err := ignore.New(sourcePath, transform.Match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the patterns of transform #%d: %w", i+1, err)
+		}
+		compiled = append(compiled, compiledTransform{Transform: transform, matcher: matcher})
+	}
+	return compiled, nil
+}
+
+// transformFile returns the contents of the file as they're copied to the target,
+// or false if no transform matches it. The first matching transform applies
+func (syncer *Syncer) transformFile(localPath string) ([]byte, bool, error) {
+	for _, transform := range syncer.transforms {
+		if !transform.matcher.Match(localPath, false) {
+			continue
+		}
+
+		contents, err := os.ReadFile(localPath)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read file %s: %w", localPath, err)
+		}
+		for _, name := range transform.Filters {
+			contents = contentFilters[name](contents)
+		}
+		return contents, true, nil
+	}
+	return nil, false, nil
+}
+
+// hashFile returns the checksum of the file as it's copied to the target
+func (syncer *Syncer) hashFile(localPath string) (string, error) {
+	contents, ok, err := syncer.transformFile(localPath)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return index.Hash(localPath)
+	}
+	hash := sha256.Sum256(contents)
+	return hex.EncodeToString(hash[:]), nil
+}
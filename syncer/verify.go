@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// checksumScript prints the SHA-256 of every file under the path given as $1
+const checksumScript = `command -v sha256sum >/dev/null || { echo "sha256sum is not available" >&2; exit 1; }
+[ -e "$1" ] || exit 0
+find "$1" -type f -exec sha256sum {} +`
+
+// Verification is the result of comparing the files of the source with the ones in the target.
+// Paths are relative to the target path
+type Verification struct {
+	// Checked is how many files of the source were compared
+	Checked int
+	// Differing files have other contents in the target, Missing ones aren't in the target
+	// and Extra ones are only in the target
+	Differing []string
+	Missing   []string
+	Extra     []string
+}
+
+// OK reports whether the target has the same files as the source
+func (verification Verification) OK() bool {
+	return len(verification.Differing) == 0 && len(verification.Missing) == 0 && len(verification.Extra) == 0
+}
+
+// Verify compares the checksums of the files in the source with the ones of the files
+// in the running container of the target, which needs sha256sum and find. Ignored files
+// in the target aren't reported as extra
+func (syncer *Syncer) Verify(ctx context.Context) (Verification, error) {
+	localHashes, err := syncer.localChecksums(ctx)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	remoteHashes, err := syncer.remoteChecksums(ctx)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	verification := Verification{Checked: len(localHashes)}
+	for filePath, localHash := range localHashes {
+		remoteHash, ok := remoteHashes[filePath]
+		if !ok {
+			verification.Missing = append(verification.Missing, filePath)
+		} else if remoteHash != localHash {
+			verification.Differing = append(verification.Differing, filePath)
+		}
+	}
+
+	for filePath := range remoteHashes {
+		if _, ok := localHashes[filePath]; ok {
+			continue
+		}
+		if syncer.sourcePath != "" && syncer.ignore.Match(filepath.Join(syncer.sourcePath, filepath.FromSlash(filePath)), false) {
+			continue
+		}
+		verification.Extra = append(verification.Extra, filePath)
+	}
+
+	slices.Sort(verification.Differing)
+	slices.Sort(verification.Missing)
+	slices.Sort(verification.Extra)
+	return verification, nil
+}
+
+// localChecksums returns the checksums of the files that syncing the source copies,
+// by their paths relative to the target path
+func (syncer *Syncer) localChecksums(ctx context.Context) (map[string]string, error) {
+	sourceInfo, err := os.Stat(syncer.sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	hashes := make(map[string]string)
+	err = syncer.walkEntries(ctx, syncer.sourcePath, sourceInfo, syncer.targetPath, make(map[string]bool), func(entry archiveEntry) error {
+		if !entry.info.Mode().IsRegular() {
+			return nil
+		}
+		hash, err := syncer.hashFile(entry.path)
+		if err != nil {
+			return err
+		}
+		hashes[syncer.relativeTargetPath(entry.headerPath)] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// remoteChecksums returns the checksums of the files in the target path,
+// by their paths relative to it
+func (syncer *Syncer) remoteChecksums(ctx context.Context) (map[string]string, error) {
+	syncer.logger.Debug("Computing checksums in {target}...", "target", syncer.target)
+	output, err := syncer.output(ctx, checksumScript, syncer.targetPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute checksums in %s: %w", syncer.target, err)
+	}
+
+	hashes := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		// Lines are <hash>, two spaces (or a space and an asterisk) and the path
+		if len(line) < 66 {
+			continue
+		}
+		relPath := syncer.relativeTargetPath(line[66:])
+		if relPath == stagingDirName || strings.HasPrefix(relPath, stagingDirName+"/") {
+			continue
+		}
+		hashes[relPath] = line[:64]
+	}
+	return hashes, nil
+}
+
+// relativeTargetPath returns the path inside the target relative to the target path
+func (syncer *Syncer) relativeTargetPath(containerPath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(path.Clean(containerPath), syncer.targetPath), "/")
+}
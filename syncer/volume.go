@@ -0,0 +1,118 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// VolumeScheme is the prefix of destinations in a named volume
+const VolumeScheme = "volume://"
+
+// volumeMountPath is where the helper container mounts the volume
+const volumeMountPath = "/volume"
+
+// VolumeTarget is a named volume. Files are copied into it through a helper container
+// mounting it, so every container using the volume sees them without being touched
+type VolumeTarget struct {
+	Name string
+}
+
+// ParseVolumeDestination parses a destination in the volume://<volume>:<path> format
+func ParseVolumeDestination(destination string) (*VolumeTarget, string, error) {
+	name, targetPath, ok := strings.Cut(strings.TrimPrefix(destination, VolumeScheme), ":")
+	if !ok || name == "" || targetPath == "" || strings.Contains(name, "/") {
+		return nil, "", fmt.Errorf("destination %s must be in the following format: %s<volume>:<path>", destination, VolumeScheme)
+	}
+
+	return &VolumeTarget{Name: name}, targetPath, nil
+}
+
+func (target *VolumeTarget) String() string {
+	return VolumeScheme + target.Name
+}
+
+// volumeHelperName returns the name of the helper container of the volume, which is the same
+// for every syncer of the volume, so that they share it
+func (syncer *Syncer) volumeHelperName() string {
+	return syncer.identifier + "-volume-" + syncer.volume.Name
+}
+
+// volumeTargetPath returns where the target path is inside the helper container
+func (syncer *Syncer) volumeTargetPath() string {
+	return path.Join(volumeMountPath, syncer.targetPath)
+}
+
+// initVolume creates the volume unless it exists, and a helper container mounting it
+// unless another syncer has already created one
+func (syncer *Syncer) initVolume(ctx context.Context) error {
+	syncer.logger.Debug("Creating volume {volume} unless it exists...", "volume", syncer.volume.Name)
+	// Creating a volume that exists returns it as is
+	_, err := syncer.client.VolumeCreate(ctx, volume.CreateOptions{Name: syncer.volume.Name})
+	if err != nil {
+		return fmt.Errorf("failed to create volume %s: %w", syncer.volume.Name, err)
+	}
+
+	helperName := syncer.volumeHelperName()
+	info, err := syncer.client.ContainerInspect(ctx, helperName)
+	if err == nil {
+		syncer.logger.Debug("Reusing helper container {container}...", "container", helperName)
+		syncer.temporaryContainer = info.ID
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect container %s: %w", helperName, err)
+	}
+
+	err = syncer.ensureHelperImage(ctx)
+	if err != nil {
+		return err
+	}
+
+	syncer.logger.Debug("Creating helper container {container}...", "container", helperName)
+	response, err := syncer.client.ContainerCreate(ctx,
+		&container.Config{
+			Image:  syncer.helperImage,
+			Labels: syncer.resourceLabels(),
+		},
+		&container.HostConfig{
+			Mounts: []mount.Mount{
+				{
+					Type:   mount.TypeVolume,
+					Source: syncer.volume.Name,
+					Target: syncer.helperMountPath(volumeMountPath),
+				},
+			},
+		},
+		nil, syncer.helperPlatform, helperName)
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+
+	syncer.temporaryContainer = response.ID
+	syncer.createdVolumeHelper = true
+	return nil
+}
+
+// cleanupVolume removes the helper container if this syncer created it, the volume is kept
+func (syncer *Syncer) cleanupVolume(ctx context.Context) error {
+	if !syncer.createdVolumeHelper {
+		return nil
+	}
+
+	syncer.logger.Debug("Removing helper container {container}...", "container", syncer.temporaryContainer)
+	err := syncer.client.ContainerRemove(ctx, syncer.temporaryContainer, container.RemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to remove helper container %s: %w", syncer.temporaryContainer, err)
+	}
+
+	syncer.temporaryContainer = ""
+	syncer.createdVolumeHelper = false
+	return nil
+}
@@ -0,0 +1,100 @@
+package syncer
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultParallel is used when Options.Parallel is not set
+const DefaultParallel = 4
+
+// Workers limits how many operations on containers run at once, while running
+// the operations on each container one at a time to keep them in order.
+// Syncers sharing Workers share the limit
+type Workers struct {
+	slots chan struct{}
+	mu    sync.Mutex
+	locks map[string]*containerLock
+}
+
+// containerLock serializes the operations on a container, refs counts the operations
+// holding or waiting for it, so that it can be dropped when there are none
+type containerLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewWorkers creates workers running up to parallel operations at once
+func NewWorkers(parallel int) *Workers {
+	if parallel <= 0 {
+		parallel = DefaultParallel
+	}
+	return &Workers{
+		slots: make(chan struct{}, parallel),
+		locks: make(map[string]*containerLock),
+	}
+}
+
+// Do runs fn once the previous operations on the container are done and a worker is free
+func (workers *Workers) Do(ctx context.Context, containerId string, fn func() error) error {
+	lock := workers.acquire(containerId)
+	defer workers.release(containerId, lock)
+
+	select {
+	case workers.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-workers.slots }()
+
+	return fn()
+}
+
+// Each runs fn for all containers concurrently, as far as the limit allows,
+// and returns the first error after all of them are done
+func (workers *Workers) Each(ctx context.Context, containerIds []string, fn func(containerId string) error) error {
+	errs := make([]error, len(containerIds))
+	var wg sync.WaitGroup
+	for i, containerId := range containerIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = workers.Do(ctx, containerId, func() error {
+				return fn(containerId)
+			})
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (workers *Workers) acquire(containerId string) *containerLock {
+	workers.mu.Lock()
+	lock, ok := workers.locks[containerId]
+	if !ok {
+		lock = &containerLock{}
+		workers.locks[containerId] = lock
+	}
+	lock.refs++
+	workers.mu.Unlock()
+
+	lock.Lock()
+	return lock
+}
+
+func (workers *Workers) release(containerId string, lock *containerLock) {
+	lock.Unlock()
+
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(workers.locks, containerId)
+	}
+}
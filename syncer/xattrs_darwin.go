@@ -0,0 +1,6 @@
+package syncer
+
+import "golang.org/x/sys/unix"
+
+// errNoXattr is returned when reading an extended attribute the path doesn't have
+const errNoXattr = unix.ENOATTR
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package syncer
+
+// readXattrs returns nothing, since extended attributes are only read on Linux and macOS
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
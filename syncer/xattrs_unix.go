@@ -0,0 +1,62 @@
+//go:build linux || darwin
+
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of the path, without following symlinks.
+// File systems without them give none
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes of %s: %w", path, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(path, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes of %s: %w", path, err)
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := readXattr(path, string(name))
+		if errors.Is(err, errNoXattr) {
+			// Removed since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read extended attribute %s of %s: %w", name, path, err)
+		}
+		xattrs[string(name)] = value
+	}
+	return xattrs, nil
+}
+
+// readXattr returns the value of the extended attribute of the path
+func readXattr(path, name string) (string, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return "", err
+	}
+	value := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, value)
+	if err != nil {
+		return "", err
+	}
+	return string(value[:size]), nil
+}